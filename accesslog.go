@@ -0,0 +1,156 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat tells how an AccessLogger writes entries.
+type AccessLogFormat int
+
+const (
+	// AccessLogCombined writes lines in the combined log format, followed by
+	// the RPC method, the duration in seconds and the request id:
+	//
+	//	10.0.0.1 - - [14/Oct/2026:13:55:36 +0000] "POST /rpc/Get HTTP/1.1" 200 42 "-" "curl/8.0" "Get" 0.001234 "req-1"
+	AccessLogCombined AccessLogFormat = iota
+
+	// AccessLogJSON writes an AccessLogEntry as a JSON object per line.
+	AccessLogJSON
+)
+
+// AccessLogEntry describes a request served by the server.
+type AccessLogEntry struct {
+	Time       time.Time     `json:"time"`
+	Method     string        `json:"method"` // RPC method named by the path
+	HTTPMethod string        `json:"httpMethod"`
+	Path       string        `json:"path"`
+	Protocol   string        `json:"protocol"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration"`
+	RemoteAddr string        `json:"remoteAddr"`
+	UserAgent  string        `json:"userAgent,omitempty"`
+	Referer    string        `json:"referer,omitempty"`
+	RequestID  string        `json:"requestId,omitempty"`
+
+	// Error is the message of the error answered, transport-level or of the
+	// call, empty on success.
+	Error string `json:"error,omitempty"`
+}
+
+// AccessLogSink receives the entry of every request served by the server. Log
+// may be called concurrently.
+type AccessLogSink interface {
+	Log(entry AccessLogEntry)
+}
+
+// AccessLogger writes an entry per request to Writer. Each entry is written
+// with a single Write call, so Writer may reopen or rotate its files between
+// calls.
+type AccessLogger struct {
+	Writer io.Writer
+	Format AccessLogFormat
+
+	mu sync.Mutex // serializes writes
+}
+
+// NewAccessLogger creates an AccessLogger writing to w in the format.
+func NewAccessLogger(w io.Writer, format AccessLogFormat) *AccessLogger {
+	return &AccessLogger{Writer: w, Format: format}
+}
+
+// Log writes the entry.
+func (l *AccessLogger) Log(entry AccessLogEntry) {
+	var line []byte
+	if l.Format == AccessLogJSON {
+		line, _ = json.Marshal(entry)
+		line = append(line, '\n')
+	} else {
+		line = []byte(fmt.Sprintf("%s - - [%s] %s %d %d %s %s %s %.6f %s\n",
+			entry.RemoteAddr,
+			entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(entry.HTTPMethod+" "+entry.Path+" "+entry.Protocol),
+			entry.Status,
+			entry.Bytes,
+			quoteOrDash(entry.Referer),
+			quoteOrDash(entry.UserAgent),
+			quoteOrDash(entry.Method),
+			entry.Duration.Seconds(),
+			quoteOrDash(entry.RequestID)))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Writer.Write(line)
+}
+
+func quoteOrDash(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// logAccess logs the entry of a request served with w.
+func (s *Server) logAccess(w *recordingWriter, r *http.Request, start time.Time) {
+	status := w.status
+	if status == 0 {
+		status = 200 // nothing written
+	}
+	s.AccessLog.Log(AccessLogEntry{
+		Time:       start,
+		Method:     s.pathMethod(r.URL.Path),
+		HTTPMethod: r.Method,
+		Path:       r.URL.RequestURI(),
+		Protocol:   r.Proto,
+		Status:     status,
+		Bytes:      w.bytes,
+		Duration:   ClockOrSystem(s.Clock).Now().Sub(start),
+		RemoteAddr: newCaller(r, s.TrustedProxies).Addr,
+		UserAgent:  r.UserAgent(),
+		Referer:    r.Referer(),
+		RequestID:  r.Header.Get(RequestIDHeader),
+		Error:      w.err,
+	})
+}
+
+// recordingWriter records the status and the size of a response.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	err    string // message of the error answered
+}
+
+// noteError records the error answered with w, when w is logged.
+func noteError(w http.ResponseWriter, err error) {
+	if rec, ok := w.(*recordingWriter); ok {
+		rec.err = err.Error()
+	}
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
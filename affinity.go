@@ -0,0 +1,76 @@
+package rpcserver
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var affinityFields sync.Map // reflect.Type -> []int
+
+// AffinityOf returns the affinity key of the args of a call: the values of
+// the fields tagged rpc:"affinity", joined with slashes, or "" if there is none
+// or they are all zero. Calls of stateful workflows share a key, e.g. the id of
+// a cart:
+//
+//	type AddItemArgs struct {
+//		CartID string `rpc:"affinity"`
+//		Item   string
+//	}
+//
+// The server answers with the key in the AffinityHeader, clients send it back
+// for load balancers to route the calls of a key to the same instance, see
+// the rpcbalancer package.
+func AffinityOf(args interface{}) string {
+	v := reflect.ValueOf(args)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	var parts []string
+	zero := true
+	for _, i := range affinityFieldsOf(v.Type()) {
+		field := v.Field(i)
+		zero = zero && field.IsZero()
+		parts = append(parts, fmt.Sprint(field.Interface()))
+	}
+	if zero {
+		return ""
+	}
+	return strings.Join(parts, "/")
+}
+
+// affinityFieldsOf returns the indexes of the affinity fields of a struct type.
+func affinityFieldsOf(t reflect.Type) []int {
+	if fields, ok := affinityFields.Load(t); ok {
+		return fields.([]int)
+	}
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" && ParseFieldTag(field.Tag.Get("rpc")).Affinity {
+			fields = append(fields, i)
+		}
+	}
+	affinityFields.Store(t, fields)
+	return fields
+}
+
+// writeAffinity adds the routing hints of the affinity key of the args to the
+// response.
+func (s *Server) writeAffinity(w http.ResponseWriter, args interface{}) {
+	key := AffinityOf(args)
+	if key == "" {
+		return
+	}
+	w.Header().Set(AffinityHeader, key)
+	if s.AffinityCookie != "" {
+		http.SetCookie(w, &http.Cookie{Name: s.AffinityCookie, Value: key, Path: "/", HttpOnly: true})
+	}
+}
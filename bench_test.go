@@ -0,0 +1,41 @@
+package rpcserver_test
+
+import (
+	"bytes"
+	"github.com/datalinkE/rpcserver"
+	"net/http/httptest"
+	"testing"
+)
+
+func benchmarkServe(b *testing.B, server *rpcserver.Server, method string, params string) {
+	body := []byte(`{"jsonrpc": "2.0", "method": "` + method + `", "id": 1, "params": ` + params + `}`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest("POST", "/rpc/"+method, bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != 200 {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkServeByName(b *testing.B) {
+	benchmarkServe(b, newServer(b), "Multiply", `{"A": 3, "B": 4}`)
+}
+
+func BenchmarkServeByPosition(b *testing.B) {
+	benchmarkServe(b, newServer(b), "Multiply", `[3, 4]`)
+}
+
+func BenchmarkServeScalarParams(b *testing.B) {
+	benchmarkServe(b, newServer(b), "Add", `[3, 4]`)
+}
+
+func BenchmarkServeFormats(b *testing.B) {
+	server := newServer(b)
+	server.Formats = rpcserver.Formats{Time: rpcserver.TimeUnix, Duration: rpcserver.DurationString}
+	benchmarkServe(b, server, "Reschedule", `{"At": 1483326245, "every": "1m"}`)
+}
@@ -0,0 +1,68 @@
+package rpcserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// writeBuffered writes the response encoded by encode once buffered whole,
+// with a Server-Timing header listing the durations of the phases of the
+// call when Server.ServerTiming is set, e.g.
+//
+//	Server-Timing: decode;dur=0.210, handler;dur=12.400, encode;dur=0.051
+//
+// in milliseconds, and with the signature of the body when Server.Signer is
+// set.
+func (s *Server) writeBuffered(w http.ResponseWriter, r *http.Request, codec Codec, clock Clock, decode, handler time.Duration, encode func(w http.ResponseWriter)) {
+	buffered := &bufferedWriter{header: w.Header()}
+	encodeStart := clock.Now()
+	encode(buffered)
+	encoded := clock.Now().Sub(encodeStart)
+	if s.Signer != nil {
+		signature, err := s.Signer.Sign(buffered.body.Bytes())
+		if err != nil {
+			s.writeTransportError(w, r, codec, 500, fmt.Errorf("rpc: cannot sign response: %v", err))
+			return
+		}
+		w.Header().Set(SignatureHeader, signature)
+	}
+	if s.ServerTiming {
+		w.Header().Add("Server-Timing", fmt.Sprintf("decode;dur=%.3f, handler;dur=%.3f, encode;dur=%.3f",
+			milliseconds(decode), milliseconds(handler), milliseconds(encoded)))
+	}
+	if buffered.status != 0 {
+		w.WriteHeader(buffered.status)
+	}
+	w.Write(buffered.body.Bytes())
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// bufferedWriter holds a response until it is written, sharing the headers
+// of the actual writer.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.body.Write(p)
+}
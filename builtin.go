@@ -0,0 +1,57 @@
+package rpcserver
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RegisterBuiltin serves the method of receiver under name beside the methods
+// of the service, as packages extending the server do with names such as
+// "rpc.cancel". The method follows the rules of NewServer, its receiver need
+// not be exported. Builtins are kept by RegisterService, and the methods of
+// the service take precedence over the builtins of the same name.
+//
+// Builtins may be registered while the server is handling requests.
+func (s *Server) RegisterBuiltin(name string, receiver interface{}, method string) error {
+	rcvr := reflect.ValueOf(receiver)
+	spec, ok := rcvr.Type().MethodByName(method)
+	if !ok {
+		return fmt.Errorf("rpc: %s has no method %q", rcvr.Type(), method)
+	}
+	m, reason := newRpcServiceMethod(spec)
+	if m == nil {
+		return fmt.Errorf("rpc: method %q of %s is not of suitable type: %s", method, rcvr.Type(), reason)
+	}
+	m.numIn, m.variadic = spec.Type.NumIn(), spec.Type.IsVariadic()
+	m.rcvr = rcvr
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	for _, codec := range reg.codecs {
+		precompileMethod(codec, m)
+	}
+	builtins := make(map[string]*RpcServiceMethod, len(reg.builtins)+1)
+	for key, b := range reg.builtins {
+		builtins[key] = b
+	}
+	builtins[name] = m
+	reg.builtins = builtins
+	s.registry.Store(&reg)
+	return nil
+}
+
+// method returns the method of the service, the builtin or the method of a
+// group of the name.
+func (reg *registry) method(name string) (*RpcServiceMethod, error) {
+	m, err := reg.service.Get(name)
+	if err != nil {
+		if b, ok := reg.builtins[name]; ok {
+			return b, nil
+		}
+		if g, ok := reg.groups[name]; ok {
+			return g, nil
+		}
+	}
+	return m, err
+}
@@ -0,0 +1,53 @@
+package rpcserver
+
+// CacheController is implemented by service receivers declaring the
+// Cache-Control header of the successful replies of their methods, by method
+// name:
+//
+//	func (s *Catalog) CacheControl() map[string]string {
+//		return map[string]string{
+//			"ListProducts": "public, max-age=300",
+//			"GetCart":      "private, max-age=10",
+//		}
+//	}
+//
+// Only idempotent methods should be made cacheable. Server.SetCacheControl
+// overrides the declared values.
+type CacheController interface {
+	CacheControl() map[string]string
+}
+
+// SetCacheControl sets the Cache-Control header of the successful replies of
+// the method, overriding the value declared by the receiver. An empty value
+// restores the declared one.
+//
+// Cache directives may be set while the server is handling requests.
+func (s *Server) SetCacheControl(method, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if _, err := reg.method(method); err != nil {
+		return err
+	}
+	cacheControl := make(map[string]string, len(reg.cacheControl)+1)
+	for key, v := range reg.cacheControl {
+		cacheControl[key] = v
+	}
+	if value == "" {
+		delete(cacheControl, method)
+	} else {
+		cacheControl[method] = value
+	}
+	reg.cacheControl = cacheControl
+	s.registry.Store(&reg)
+	return nil
+}
+
+// cacheControlOf returns the Cache-Control header of the replies of m called
+// as method, the name SetCacheControl takes, empty if none.
+func (reg *registry) cacheControlOf(method string, m *RpcServiceMethod) string {
+	if value, ok := reg.cacheControl[method]; ok {
+		return value
+	}
+	return m.cacheControl
+}
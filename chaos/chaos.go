@@ -0,0 +1,131 @@
+// Package chaos injects faults into RPC calls, to validate client retries and
+// downstream resilience in staging environments.
+//
+//	injector := chaos.NewInjector()
+//	server.Use(injector.Middleware())
+//	router.Any("/chaos", gin.WrapH(injector)) // GET shows, PUT replaces the Config
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Config describes the faults to inject. The zero Config injects nothing.
+type Config struct {
+	// ErrorRate is the fraction of calls failing with an injected error, from 0 to 1.
+	ErrorRate float64 `json:"errorRate"`
+
+	// Latency is added to every affected call.
+	Latency time.Duration `json:"latency"`
+
+	// LatencyJitter randomizes Latency by up to this duration in both directions.
+	LatencyJitter time.Duration `json:"latencyJitter"`
+
+	// Methods limits the faults to the listed methods, all methods are affected when empty.
+	Methods []string `json:"methods,omitempty"`
+
+	// Code is the JSON-RPC error code of injected errors, jsonrpc2.E_SERVER when 0.
+	Code int `json:"code,omitempty"`
+}
+
+// Injector injects faults described by a Config replaceable at runtime.
+type Injector struct {
+	// Clock times the injected latency, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu      sync.RWMutex
+	config  Config
+	methods map[string]bool
+	rand    *rand.Rand
+}
+
+// NewInjector creates an Injector with the zero Config.
+func NewInjector() *Injector {
+	return &Injector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Config returns the current configuration.
+func (i *Injector) Config() Config {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.config
+}
+
+// SetConfig atomically replaces the configuration.
+func (i *Injector) SetConfig(config Config) {
+	methods := make(map[string]bool, len(config.Methods))
+	for _, method := range config.Methods {
+		methods[method] = true
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.config = config
+	i.methods = methods
+}
+
+// plan decides the faults for a call of the method.
+func (i *Injector) plan(method string) (delay time.Duration, fail bool, code int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.methods) > 0 && !i.methods[method] {
+		return 0, false, 0
+	}
+	delay = i.config.Latency
+	if i.config.LatencyJitter > 0 {
+		delay += time.Duration(i.rand.Int63n(int64(2*i.config.LatencyJitter))) - i.config.LatencyJitter
+	}
+	code = i.config.Code
+	if code == 0 {
+		code = jsonrpc2.E_SERVER
+	}
+	return delay, i.rand.Float64() < i.config.ErrorRate, code
+}
+
+// Middleware returns the middleware delaying and failing calls.
+func (i *Injector) Middleware() rpcserver.Middleware {
+	return func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			delay, fail, code := i.plan(call.Method)
+			if delay > 0 {
+				timer := rpcserver.ClockOrSystem(i.Clock).NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C():
+				}
+			}
+			if fail {
+				return jsonrpc2.NewError(code, "chaos: injected fault", call.Method)
+			}
+			return next(ctx, call)
+		}
+	}
+}
+
+// ServeHTTP exposes the configuration as JSON: GET returns it, PUT and POST
+// replace it.
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT", "POST":
+		var config Config
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			rpcserver.WriteError(w, 400, "chaos: "+err.Error())
+			return
+		}
+		i.SetConfig(config)
+	default:
+		rpcserver.WriteError(w, 405, "chaos: GET or PUT method required, received "+r.Method)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(i.Config())
+}
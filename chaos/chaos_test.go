@@ -0,0 +1,41 @@
+package chaos
+
+import (
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (t *Arith) Multiply(r *http.Request, args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Add(r *http.Request, args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func TestInjector(t *testing.T) {
+	srv := rpcservertest.NewServer(t, new(Arith))
+	defer srv.Close()
+	injector := NewInjector()
+	srv.RPC.Use(injector.Middleware())
+
+	var reply int
+	srv.MustCall("Multiply", &Args{A: 2, B: 3}, &reply)
+
+	injector.SetConfig(Config{ErrorRate: 1, Methods: []string{"Multiply"}})
+	rpcservertest.AssertError(t, srv.Call("Multiply", &Args{A: 2, B: 3}, &reply), jsonrpc2.E_SERVER)
+	srv.MustCall("Add", &Args{A: 2, B: 3}, &reply)
+	if reply != 5 {
+		t.Fatalf("unexpected reply %d", reply)
+	}
+}
@@ -0,0 +1,178 @@
+package rpcserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned for request bodies which are not valid UTF-8
+// once transcoded from their charset.
+var ErrInvalidUTF8 = errors.New("rpc: invalid UTF-8 in request body")
+
+// CharsetDecoder transcodes a body in a charset to UTF-8.
+type CharsetDecoder func(body []byte) ([]byte, error)
+
+var charsets = struct {
+	sync.RWMutex
+	decoders map[string]CharsetDecoder
+}{decoders: map[string]CharsetDecoder{
+	"iso-8859-1": decodeLatin1,
+	"latin1":     decodeLatin1,
+	"utf-16":     decodeUTF16(false),
+	"utf-16be":   decodeUTF16(false),
+	"utf-16le":   decodeUTF16(true),
+}}
+
+// RegisterCharset adds the decoder of the request bodies of a charset named
+// in the Content-Type, for instance:
+//
+//	rpcserver.RegisterCharset("shift_jis", japanese.ShiftJIS.NewDecoder().Bytes)
+//
+// of golang.org/x/text/encoding/japanese. UTF-8 and US-ASCII bodies are read
+// as they are, ISO-8859-1 and UTF-16 ones are transcoded, other charsets
+// are answered with 415 unless registered. Names are case-insensitive.
+func RegisterCharset(name string, decoder CharsetDecoder) {
+	charsets.Lock()
+	defer charsets.Unlock()
+	charsets.decoders[strings.ToLower(name)] = decoder
+}
+
+// requestCharset returns the decoder of the charset of the Content-Type of
+// the request, nil for UTF-8.
+func requestCharset(r *http.Request) (CharsetDecoder, error) {
+	value := r.Header.Get("Content-Type")
+	if value == "" {
+		return nil, nil
+	}
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return nil, fmt.Errorf("rpc: invalid Content-Type: %s", value)
+	}
+	name := strings.ToLower(params["charset"])
+	switch name {
+	case "", "utf-8", "utf8", "us-ascii":
+		return nil, nil
+	}
+	charsets.RLock()
+	defer charsets.RUnlock()
+	if decoder := charsets.decoders[name]; decoder != nil {
+		return decoder, nil
+	}
+	return nil, fmt.Errorf("rpc: unsupported charset: %s", name)
+}
+
+// textReader reads a request body as UTF-8, transcoding it with decoder when
+// set. Bodies to transcode are read whole on the first read. Reads fail once
+// invalid UTF-8 is read.
+type textReader struct {
+	io.ReadCloser
+	decoder CharsetDecoder
+
+	decoded io.Reader // transcoded body, once read
+	pending []byte    // incomplete rune at the end of the last read
+	err     error
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	src := io.Reader(t.ReadCloser)
+	if t.decoder != nil {
+		if t.decoded == nil {
+			body, err := ioutil.ReadAll(t.ReadCloser)
+			if err != nil {
+				return 0, err
+			}
+			if body, err = t.decoder(body); err != nil {
+				t.err = fmt.Errorf("%w: %v", ErrInvalidUTF8, err)
+				return 0, t.err
+			}
+			t.decoded = bytes.NewReader(body)
+		}
+		src = t.decoded
+	}
+	n := copy(p, t.pending)
+	m, err := src.Read(p[n:])
+	n += m
+	// Hold back an incomplete rune until the next read.
+	complete := n
+	for k := 1; k <= utf8.UTFMax-1 && k <= n; k++ {
+		if utf8.RuneStart(p[n-k]) {
+			if !utf8.FullRune(p[n-k : n]) {
+				complete = n - k
+			}
+			break
+		}
+	}
+	if err == io.EOF {
+		complete = n
+	}
+	if !utf8.Valid(p[:complete]) {
+		t.err = ErrInvalidUTF8
+		return 0, t.err
+	}
+	t.pending = append(t.pending[:0], p[complete:n]...)
+	if complete == 0 && err == nil && n > 0 {
+		// Only an incomplete rune was read, its continuation follows.
+		return t.Read(p)
+	}
+	return complete, err
+}
+
+func decodeLatin1(body []byte) ([]byte, error) {
+	decoded := make([]byte, 0, len(body))
+	for _, b := range body {
+		decoded = append(decoded, string(rune(b))...)
+	}
+	return decoded, nil
+}
+
+// decodeUTF16 returns the decoder of UTF-16 in the byte order, which a byte
+// order mark overrides.
+func decodeUTF16(littleEndian bool) CharsetDecoder {
+	return func(body []byte) ([]byte, error) {
+		if len(body)%2 != 0 {
+			return nil, errors.New("odd number of bytes of UTF-16")
+		}
+		little := littleEndian
+		if len(body) >= 2 {
+			switch {
+			case body[0] == 0xFE && body[1] == 0xFF:
+				little, body = false, body[2:]
+			case body[0] == 0xFF && body[1] == 0xFE:
+				little, body = true, body[2:]
+			}
+		}
+		unit := func(i int) rune {
+			if little {
+				return rune(body[2*i]) | rune(body[2*i+1])<<8
+			}
+			return rune(body[2*i])<<8 | rune(body[2*i+1])
+		}
+		decoded := make([]byte, 0, len(body))
+		for i := 0; i < len(body)/2; i++ {
+			r := unit(i)
+			if utf16.IsSurrogate(r) {
+				if i+1 == len(body)/2 {
+					return nil, errors.New("invalid UTF-16")
+				}
+				i++
+				if r = utf16.DecodeRune(r, unit(i)); r == utf8.RuneError {
+					return nil, errors.New("invalid UTF-16")
+				}
+			}
+			decoded = append(decoded, string(r)...)
+		}
+		return decoded, nil
+	}
+}
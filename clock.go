@@ -0,0 +1,120 @@
+package rpcserver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the time-based features of the server and of
+// the other packages: deadlines, latencies, SLO windows, retries. Tests give
+// them a fake clock, such as the one of rpcservertest, to advance time
+// deterministically.
+type Clock interface {
+	Now() time.Time
+
+	// NewTimer creates a Timer sending the current time on its channel after
+	// at least d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event of a Clock, as a time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing, it returns false if the timer
+	// already fired or was stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package, used when no Clock is set.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// ClockOrSystem returns c, or SystemClock when c is nil.
+func ClockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// WithClockTimeout is context.WithTimeout measuring the timeout with the clock.
+func WithClockTimeout(ctx context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if clock == SystemClock {
+		return context.WithTimeout(ctx, d)
+	}
+	c := &clockContext{
+		Context:  ctx,
+		deadline: clock.Now().Add(d),
+		done:     make(chan struct{}),
+	}
+	if parent, ok := ctx.Deadline(); ok && parent.Before(c.deadline) {
+		c.deadline = parent
+	}
+	timer := clock.NewTimer(d)
+	stop := make(chan struct{})
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			c.cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+			c.cancel(ctx.Err())
+		case <-stop:
+			c.cancel(context.Canceled)
+		}
+	}()
+	var once sync.Once
+	return c, func() { once.Do(func() { close(stop) }) }
+}
+
+// clockContext is a context done at a deadline of a Clock.
+type clockContext struct {
+	context.Context // parent, for the values
+	deadline        time.Time
+	done            chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *clockContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *clockContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *clockContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *clockContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
@@ -0,0 +1,175 @@
+// Command rpccall invokes methods of an rpcserver.Server from the command line.
+//
+// Single call, params are given as JSON:
+//
+//	rpccall http://localhost:8080/jsonrpc/v2 Divide '{"A": 10, "B": 2}'
+//
+// Batch of calls, one {"method": ..., "params": ...} object per line or a JSON
+// array of such objects, "-" reads the batch from stdin:
+//
+//	rpccall -batch smoke.jsonl http://localhost:8080/jsonrpc/v2
+//
+// The reply or the error is pretty-printed to stdout. The exit status is 1 if
+// any call failed.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// headers collects repeated -H flags.
+type headers []string
+
+func (h *headers) String() string     { return strings.Join(*h, ", ") }
+func (h *headers) Set(v string) error { *h = append(*h, v); return nil }
+
+// batchCall is a single entry of a batch file.
+type batchCall struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+func main() {
+	var extra headers
+	flag.Var(&extra, "H", "extra request header as 'Key: Value', may be repeated")
+	codec := flag.String("codec", "jsonrpc2", "codec used for the calls, only jsonrpc2 is supported")
+	batch := flag.String("batch", "", "file with a batch of calls, - for stdin")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of every call")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: rpccall [flags] URL METHOD [PARAMS]\n       rpccall [flags] -batch FILE URL\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rpccall: ")
+	if *codec != "jsonrpc2" {
+		log.Fatalf("unsupported codec %q", *codec)
+	}
+
+	var calls []batchCall
+	var err error
+	switch {
+	case *batch != "" && flag.NArg() == 1:
+		calls, err = readBatch(*batch)
+	case *batch == "" && (flag.NArg() == 2 || flag.NArg() == 3):
+		call := batchCall{Method: flag.Arg(1)}
+		if flag.NArg() == 3 {
+			call.Params = json.RawMessage(flag.Arg(2))
+		}
+		calls = []batchCall{call}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := rpcclient.NewClient(flag.Arg(0))
+	var opts []rpcclient.CallOption
+	for _, h := range extra {
+		idx := strings.Index(h, ":")
+		if idx == -1 {
+			log.Fatalf("malformed header %q", h)
+		}
+		opts = append(opts, rpcclient.WithHeader(strings.TrimSpace(h[:idx]), strings.TrimSpace(h[idx+1:])))
+	}
+
+	failed := false
+	for _, call := range calls {
+		if len(calls) > 1 {
+			fmt.Printf("# %s\n", call.Method)
+		}
+		if !invoke(client, call, *timeout, opts) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// invoke performs a call and prints its outcome, it returns false on failure.
+func invoke(client *rpcclient.Client, call batchCall, timeout time.Duration, opts []rpcclient.CallOption) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var params interface{}
+	if len(call.Params) > 0 {
+		params = &call.Params
+	}
+	var reply json.RawMessage
+	err := client.Call(ctx, call.Method, params, &reply, opts...)
+	if err != nil {
+		if rpcErr, ok := err.(*jsonrpc2.Error); ok {
+			printJSON(rpcErr)
+		} else {
+			fmt.Printf("error: %v\n", err)
+		}
+		return false
+	}
+	printJSON(reply)
+	return true
+}
+
+// readBatch reads calls from a JSON array or from JSON objects, one per line.
+func readBatch(path string) ([]batchCall, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []batchCall
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &calls); err != nil {
+			return nil, err
+		}
+		return calls, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		var call batchCall
+		if err := json.Unmarshal(line, &call); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, scanner.Err()
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Printf("%v\n", v)
+		return
+	}
+	fmt.Printf("%s\n", data)
+}
@@ -0,0 +1,65 @@
+// Command rpccompat reports the breaking changes between two introspection
+// documents produced by introspect.Describe, e.g. the one recorded for the
+// last release and the one of the current build:
+//
+//	rpccompat -old api/arith.json -new build/arith.json
+//
+// Each change is printed on a line. The exit status is 1 if any change breaks
+// the clients of the old document, so the command may gate CI.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/datalinkE/rpcserver/introspect"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	oldPath := flag.String("old", "", "introspection document of the previous version (required)")
+	newPath := flag.String("new", "", "introspection document of the new version (required)")
+	jsonOutput := flag.Bool("json", false, "print the changes as a JSON array")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rpccompat: ")
+	if *oldPath == "" || *newPath == "" {
+		flag.Usage()
+		log.Fatal("-old and -new are required")
+	}
+
+	oldDoc, newDoc := new(introspect.Document), new(introspect.Document)
+	if err := readJSON(*oldPath, oldDoc); err != nil {
+		log.Fatal(err)
+	}
+	if err := readJSON(*newPath, newDoc); err != nil {
+		log.Fatal(err)
+	}
+	changes := introspect.Compare(oldDoc, newDoc)
+	if *jsonOutput {
+		if changes == nil {
+			changes = []introspect.Change{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(changes)
+	} else {
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+)
+
+// generateDocs emits a Describe method of the receiver of svc, implementing
+// rpcserver.Describer with the doc comments of the type and of its methods.
+func generateDocs(svc *service) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rpcgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", svc.Package)
+	fmt.Fprintf(&buf, "// Describe returns the doc comments of %s and of its methods, see\n", svc.Name)
+	fmt.Fprintf(&buf, "// rpcserver.Describer.\n")
+	fmt.Fprintf(&buf, "func (%s) Describe() map[string]string {\n", svc.Name)
+	fmt.Fprintf(&buf, "\treturn map[string]string{\n")
+	if svc.Doc != "" {
+		fmt.Fprintf(&buf, "\t\t\"\": %q,\n", svc.Doc)
+	}
+	for _, m := range svc.Methods {
+		if m.Doc != "" {
+			fmt.Fprintf(&buf, "\t\t%q: %q,\n", m.Name, m.Doc)
+		}
+	}
+	fmt.Fprintf(&buf, "\t}\n}\n")
+	return format.Source(buf.Bytes())
+}
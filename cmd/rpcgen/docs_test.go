@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDocs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := strings.Replace(arithSource, "type Arith int", "// Arith does \"arithmetic\".\ntype Arith int", 1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "arith.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := parseService(dir, "Arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generateDocs(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	for _, expected := range []string{
+		"package arith",
+		"func (Arith) Describe() map[string]string {",
+		`"":       "Arith does \"arithmetic\".",`,
+		`"Divide": "Divide returns the quotient and the remainder of A/B.",`,
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("generated code misses %q:\n%s", expected, code)
+		}
+	}
+	if strings.Contains(code, `"Multiply"`) {
+		t.Errorf("expected no description of the undocumented methods:\n%s", code)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// goOptions controls the generated Go client.
+type goOptions struct {
+	// Package is the package name of the generated file. The client is placed
+	// next to the service when it is empty or equal to the service package.
+	Package string
+
+	// Import is the import path of the service package, needed when the client
+	// is placed in a different package.
+	Import string
+}
+
+// generateGo emits a typed client of svc, with a method per RPC.
+func generateGo(svc *service, opts goOptions) ([]byte, error) {
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = svc.Package
+	}
+	qualifier := ""
+	imports := map[string]string{
+		"context":   "context",
+		"rpcclient": "github.com/datalinkE/rpcserver/rpcclient",
+	}
+	if pkg != svc.Package {
+		if opts.Import == "" {
+			return nil, fmt.Errorf("rpcgen: -import is required to generate into package %q", pkg)
+		}
+		qualifier = svc.Package
+		imports[svc.Package] = opts.Import
+	}
+	for _, m := range svc.Methods {
+		for name, path := range m.Imports {
+			imports[name] = path
+		}
+	}
+
+	client := svc.Name + "Client"
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rpcgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	writeImports(&buf, imports)
+
+	fmt.Fprintf(&buf, "// %s is a typed client of the %s service.\n", client, svc.Name)
+	fmt.Fprintf(&buf, "//\n// Errors returned by the remote methods are of type *jsonrpc2.Error.\n")
+	fmt.Fprintf(&buf, "type %s struct {\n\tClient *rpcclient.Client\n}\n\n", client)
+	fmt.Fprintf(&buf, "// New%s creates a %s calling the server mounted at url.\n", client, client)
+	fmt.Fprintf(&buf, "func New%s(url string) *%s {\n", client, client)
+	fmt.Fprintf(&buf, "\treturn &%s{Client: rpcclient.NewClient(url)}\n}\n", client)
+
+	for _, m := range svc.Methods {
+		args := typeString(qualify(m.Args, qualifier))
+		fmt.Fprintf(&buf, "\n")
+		if m.Doc != "" {
+			for _, line := range strings.Split(m.Doc, "\n") {
+				fmt.Fprintf(&buf, "// %s\n", line)
+			}
+		} else {
+			fmt.Fprintf(&buf, "// %s calls the remote %s.%s method.\n", m.Name, svc.Name, m.Name)
+		}
+		if m.Reply == nil {
+			fmt.Fprintf(&buf, "func (c *%s) %s(ctx context.Context, args *%s, opts ...rpcclient.CallOption) error {\n",
+				client, m.Name, args)
+			fmt.Fprintf(&buf, "\treturn c.Client.Call(ctx, %q, args, nil, opts...)\n}\n", m.Name)
+			continue
+		}
+		reply := typeString(qualify(m.Reply, qualifier))
+		fmt.Fprintf(&buf, "func (c *%s) %s(ctx context.Context, args *%s, opts ...rpcclient.CallOption) (*%s, error) {\n",
+			client, m.Name, args, reply)
+		fmt.Fprintf(&buf, "\treply := new(%s)\n", reply)
+		fmt.Fprintf(&buf, "\tif err := c.Client.Call(ctx, %q, args, reply, opts...); err != nil {\n", m.Name)
+		fmt.Fprintf(&buf, "\t\treturn nil, err\n\t}\n\treturn reply, nil\n}\n")
+	}
+	return format.Source(buf.Bytes())
+}
+
+// writeImports writes an import block sorted by path.
+func writeImports(buf *bytes.Buffer, imports map[string]string) {
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return imports[names[i]] < imports[names[j]]
+	})
+
+	fmt.Fprintf(buf, "import (\n")
+	for _, name := range names {
+		path := imports[name]
+		if path == name || strings.HasSuffix(path, "/"+name) {
+			fmt.Fprintf(buf, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(buf, "\t%s %q\n", name, path)
+		}
+	}
+	fmt.Fprintf(buf, ")\n\n")
+}
+
+// qualify prefixes the exported identifiers declared in the service package
+// with the package name, so the type can be referenced from another package.
+func qualify(expr ast.Expr, pkg string) ast.Expr {
+	if pkg == "" {
+		return expr
+	}
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.IsExported() {
+			return &ast.SelectorExpr{X: ast.NewIdent(pkg), Sel: ast.NewIdent(e.Name)}
+		}
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: qualify(e.X, pkg)}
+	case *ast.ArrayType:
+		return &ast.ArrayType{Len: e.Len, Elt: qualify(e.Elt, pkg)}
+	case *ast.MapType:
+		return &ast.MapType{Key: qualify(e.Key, pkg), Value: qualify(e.Value, pkg)}
+	}
+	return expr
+}
+
+// typeString prints a type expression.
+func typeString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
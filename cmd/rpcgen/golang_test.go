@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const arithSource = `package arith
+
+import (
+	"net/http"
+	"time"
+)
+
+type Args struct {
+	A, B int
+	At   time.Time
+}
+
+type Quotient struct {
+	Quo, Rem int
+}
+
+type Arith int
+
+// Divide returns the quotient and the remainder of A/B.
+func (t *Arith) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	return nil
+}
+
+func (t *Arith) Multiply(r *http.Request, args *Args, reply *int) error {
+	return nil
+}
+
+func (t *Arith) Reset(r *http.Request, args *Args) error {
+	return nil
+}
+
+func (t *Arith) Sum(r *http.Request, args *Args) (int, error) {
+	return 0, nil
+}
+
+func (t *Arith) Helper(a int) error {
+	return nil
+}
+`
+
+func TestGenerateGo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "arith.go"), []byte(arithSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := parseService(dir, "Arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Methods) != 4 {
+		t.Fatalf("expected Divide, Multiply, Reset and Sum, got %d methods", len(svc.Methods))
+	}
+
+	src, err := generateGo(svc, goOptions{Package: "arithclient", Import: "example.com/arith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	for _, expected := range []string{
+		"package arithclient",
+		`"example.com/arith"`,
+		"// Divide returns the quotient and the remainder of A/B.",
+		"func (c *ArithClient) Divide(ctx context.Context, args *arith.Args, opts ...rpcclient.CallOption) (*arith.Quotient, error) {",
+		"func (c *ArithClient) Multiply(ctx context.Context, args *arith.Args, opts ...rpcclient.CallOption) (*int, error) {",
+		`c.Client.Call(ctx, "Multiply", args, reply, opts...)`,
+		"func (c *ArithClient) Reset(ctx context.Context, args *arith.Args, opts ...rpcclient.CallOption) error {",
+		"func (c *ArithClient) Sum(ctx context.Context, args *arith.Args, opts ...rpcclient.CallOption) (*int, error) {",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("generated code misses %q:\n%s", expected, code)
+		}
+	}
+}
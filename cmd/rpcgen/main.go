@@ -0,0 +1,65 @@
+// Command rpcgen generates a typed Go client for an RPC service.
+//
+// It reads the Go source of the package declaring the service receiver and
+// emits a client with one method per RPC, so callers don't spell method names
+// as strings:
+//
+//	//go:generate rpcgen -type Arith
+//
+// With -package and -import the client is generated into another package.
+//
+// With -docs it generates a Describe method of the receiver instead, returning
+// the doc comments of the type and of its methods, so the introspection, the
+// OpenRPC document and the explorer describe the service with the
+// documentation of its code:
+//
+//	//go:generate rpcgen -type Arith -docs
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "name of the service receiver type (required)")
+	dir := flag.String("dir", ".", "directory of the package declaring the service")
+	output := flag.String("output", "", "output file, defaults to <type>_client.go in -dir")
+	pkg := flag.String("package", "", "package name of the generated client, defaults to the service package")
+	importPath := flag.String("import", "", "import path of the service package, required with -package")
+	docs := flag.Bool("docs", false, "generate a Describe method returning the doc comments, rather than a client")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rpcgen: ")
+	if *typeName == "" {
+		flag.Usage()
+		log.Fatal("-type is required")
+	}
+
+	svc, err := parseService(*dir, *typeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	suffix := "_client.go"
+	var src []byte
+	if *docs {
+		suffix = "_docs.go"
+		src, err = generateDocs(svc)
+	} else {
+		src, err = generateGo(svc, goOptions{Package: *pkg, Import: *importPath})
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "" {
+		*output = filepath.Join(*dir, strings.ToLower(*typeName)+suffix)
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+)
+
+// service describes an RPC receiver found in the source code.
+type service struct {
+	Package string
+	Name    string
+	Doc     string // the doc comment of the receiver type
+	Methods []*method
+}
+
+// method describes an RPC method of the receiver.
+//
+// Args and Reply are the element types of the argument pointers, as written in
+// the source code. Reply is nil for methods without a reply.
+type method struct {
+	Name  string
+	Doc   string
+	Args  ast.Expr
+	Reply ast.Expr
+
+	// Imports maps package names used by Args and Reply to import paths.
+	Imports map[string]string
+}
+
+// parseService scans the Go files in dir for exported methods of typeName having
+// the signature accepted by rpcserver.NewServer.
+func parseService(dir string, typeName string) (*service, error) {
+	fset := token.NewFileSet()
+	notTest := func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}
+	pkgs, err := parser.ParseDir(fset, dir, notTest, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range pkgs {
+		svc := &service{Package: pkg.Name, Name: typeName}
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if doc := typeDoc(decl, typeName); doc != "" {
+					svc.Doc = doc
+				}
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || !fn.Name.IsExported() || receiverName(fn) != typeName {
+					continue
+				}
+				if m := rpcMethod(fn); m != nil {
+					m.Imports = usedImports(file, m.Args, m.Reply)
+					svc.Methods = append(svc.Methods, m)
+				}
+			}
+		}
+		if len(svc.Methods) > 0 {
+			sort.Slice(svc.Methods, func(i, j int) bool {
+				return svc.Methods[i].Name < svc.Methods[j].Name
+			})
+			return svc, nil
+		}
+	}
+	return nil, fmt.Errorf("rpcgen: %q has no exported methods of suitable type in %s", typeName, dir)
+}
+
+// typeDoc returns the doc comment of typeName if decl declares it.
+func typeDoc(decl ast.Decl, typeName string) string {
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok || gen.Tok != token.TYPE {
+		return ""
+	}
+	for _, spec := range gen.Specs {
+		if ts := spec.(*ast.TypeSpec); ts.Name.Name == typeName {
+			if ts.Doc != nil {
+				return strings.TrimSpace(ts.Doc.Text())
+			}
+			if len(gen.Specs) == 1 {
+				return strings.TrimSpace(gen.Doc.Text())
+			}
+		}
+	}
+	return ""
+}
+
+// receiverName returns the name of the receiver type of fn, without the pointer.
+func receiverName(fn *ast.FuncDecl) string {
+	expr := fn.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// rpcMethod returns the method description if fn has one of the signatures
+// accepted by rpcserver.NewServer:
+//
+//	func (t *T) Method(r *http.Request, args *Args, reply *Reply) error
+//	func (t *T) Method(r *http.Request, args *Args) error
+//	func (t *T) Method(r *http.Request, args *Args) (*Reply, error)
+func rpcMethod(fn *ast.FuncDecl) *method {
+	params := fieldTypes(fn.Type.Params)
+	var results []ast.Expr
+	if fn.Type.Results != nil {
+		results = fieldTypes(fn.Type.Results)
+	}
+	if len(params) < 2 || len(params) > 3 || len(results) < 1 || len(results) > 2 {
+		return nil
+	}
+	if result, ok := results[len(results)-1].(*ast.Ident); !ok || result.Name != "error" {
+		return nil
+	}
+	if !isHTTPRequest(params[0]) {
+		return nil
+	}
+	args, ok := params[1].(*ast.StarExpr)
+	if !ok {
+		return nil
+	}
+	m := &method{
+		Name: fn.Name.Name,
+		Doc:  strings.TrimSpace(fn.Doc.Text()),
+		Args: args.X,
+	}
+	switch {
+	case len(params) == 3 && len(results) == 1:
+		reply, ok := params[2].(*ast.StarExpr)
+		if !ok {
+			return nil
+		}
+		m.Reply = reply.X
+	case len(params) == 2 && len(results) == 2:
+		m.Reply = results[0]
+		if reply, ok := m.Reply.(*ast.StarExpr); ok {
+			m.Reply = reply.X
+		}
+	case len(params) != 2:
+		return nil
+	}
+	return m
+}
+
+// fieldTypes returns the type of every parameter, repeating shared types as in
+// func(a, b *T).
+func fieldTypes(list *ast.FieldList) []ast.Expr {
+	var types []ast.Expr
+	for _, field := range list.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+// isHTTPRequest returns true for the *http.Request type expression.
+func isHTTPRequest(expr ast.Expr) bool {
+	star, ok := expr.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Request" {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "http"
+}
+
+// usedImports returns the imports of file referenced by the type expressions.
+func usedImports(file *ast.File, exprs ...ast.Expr) map[string]string {
+	paths := make(map[string]string)
+	for _, spec := range file.Imports {
+		path := strings.Trim(spec.Path.Value, `"`)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		paths[name] = path
+	}
+
+	used := make(map[string]string)
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		ast.Inspect(expr, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if pkg, ok := sel.X.(*ast.Ident); ok && paths[pkg.Name] != "" {
+					used[pkg.Name] = paths[pkg.Name]
+				}
+			}
+			return true
+		})
+	}
+	return used
+}
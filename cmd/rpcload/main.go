@@ -0,0 +1,59 @@
+// Command rpcload calls a method at a target rate and reports latencies:
+//
+//	rpcload -rps 200 -c 16 -d 30s http://localhost:8080/jsonrpc/v2 Divide '{"A": 10, "B": 2}'
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"github.com/datalinkE/rpcserver/rpcload"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	rps := flag.Float64("rps", 0, "target calls per second, 0 for as fast as possible")
+	concurrency := flag.Int("c", 8, "number of concurrent workers")
+	duration := flag.Duration("d", 10*time.Second, "duration of the run")
+	requests := flag.Int("n", 0, "stop after this many calls when positive")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout of every call")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: rpcload [flags] URL METHOD [PARAMS]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rpcload: ")
+	if flag.NArg() != 2 && flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := &rpcload.Config{
+		Client:      rpcclient.NewClient(flag.Arg(0)),
+		Method:      flag.Arg(1),
+		RPS:         *rps,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Requests:    *requests,
+		Timeout:     *timeout,
+	}
+	if flag.NArg() == 3 {
+		params := json.RawMessage(flag.Arg(2))
+		if !json.Valid(params) {
+			log.Fatal("PARAMS must be valid JSON")
+		}
+		cfg.Args = &params
+	}
+
+	report, err := rpcload.Run(context.Background(), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report.WriteTo(os.Stdout)
+}
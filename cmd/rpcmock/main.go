@@ -0,0 +1,61 @@
+// Command rpcmock serves mock replies for the methods of an introspection
+// document produced by introspect.Describe:
+//
+//	rpcmock -doc arith.json -random -addr :8080
+//
+// Canned replies are read from a JSON object of method name to reply.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/rpcmock"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+func main() {
+	docPath := flag.String("doc", "", "introspection document (required)")
+	cannedPath := flag.String("replies", "", "JSON object with canned replies by method name")
+	random := flag.Bool("random", false, "generate random replies instead of zero values")
+	addr := flag.String("addr", ":8080", "listen address")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rpcmock: ")
+	if *docPath == "" {
+		flag.Usage()
+		log.Fatal("-doc is required")
+	}
+
+	doc := new(introspect.Document)
+	if err := readJSON(*docPath, doc); err != nil {
+		log.Fatal(err)
+	}
+	server := rpcmock.NewServer(doc)
+	server.Random = *random
+	if *cannedPath != "" {
+		var canned map[string]json.RawMessage
+		if err := readJSON(*cannedPath, &canned); err != nil {
+			log.Fatal(err)
+		}
+		for method, reply := range canned {
+			if err := server.SetReply(method, reply); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	log.Printf("serving %d methods of %s on %s", len(doc.Methods), doc.Service, *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
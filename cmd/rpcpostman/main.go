@@ -0,0 +1,52 @@
+// Command rpcpostman exports the methods of an introspection document
+// produced by introspect.Describe as a Postman collection, which Insomnia
+// imports too:
+//
+//	rpcpostman -doc arith.json -url http://localhost:8080/rpc > arith.postman.json
+//
+// The requests carry sample params made of the zero values of the args and of
+// the example tags of their fields.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/postman"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	docPath := flag.String("doc", "", "introspection document (required)")
+	url := flag.String("url", "http://localhost:8080/rpc", "endpoint the methods are called at")
+	output := flag.String("o", "", "output file, defaults to stdout")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("rpcpostman: ")
+	if *docPath == "" {
+		flag.Usage()
+		log.Fatal("-doc is required")
+	}
+
+	data, err := ioutil.ReadFile(*docPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	doc := new(introspect.Document)
+	if err := json.Unmarshal(data, doc); err != nil {
+		log.Fatal(err)
+	}
+	out, err := json.MarshalIndent(postman.Export(doc, *url), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	out = append(out, '\n')
+	if *output == "" {
+		os.Stdout.Write(out)
+	} else if err := ioutil.WriteFile(*output, out, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,7 +1,9 @@
 package rpcserver
 
 import (
+	"context"
 	"net/http"
+	"reflect"
 )
 
 // ----------------------------------------------------------------------------
@@ -27,3 +29,34 @@ type CodecRequest interface {
 	// Writes an error produced by the server.
 	WriteError(w http.ResponseWriter, status int, err error)
 }
+
+// Precompiler is implemented by codecs preparing per-type decoding and
+// encoding plans. The server calls Precompile with the args and reply types of
+// every method when the codec is registered, instead of the codec paying for
+// it on the first requests.
+type Precompiler interface {
+	Precompile(t reflect.Type)
+}
+
+// ParamsMember is implemented by codecs whose request bodies are objects
+// holding the params in a member, e.g. "params" for JSON-RPC. Server.StrictJSON
+// compares the keys of the params decoded into structs case-insensitively
+// with it, see ErrDuplicateKey.
+type ParamsMember interface {
+	ParamsMember() string
+}
+
+type codecKey struct{}
+
+type chosenCodec struct {
+	codec Codec
+	name  string
+}
+
+// WithCodec returns a copy of ctx choosing the codec of the request, instead
+// of the registered codec matching its Content-Type. The name stands for the
+// Content-Type in the stats and the context of the call. Handlers in front of
+// the server use it to serve other shapes of requests, as the rest package.
+func WithCodec(ctx context.Context, codec Codec, name string) context.Context {
+	return context.WithValue(ctx, codecKey{}, chosenCodec{codec, name})
+}
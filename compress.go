@@ -0,0 +1,70 @@
+package rpcserver
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptedEncodings is the value of the Accept-Encoding header of the
+// responses refusing the Content-Encoding of a request.
+const acceptedEncodings = "gzip, deflate"
+
+// requestEncodings returns the content codings applied to the body of the
+// request in order, without identity. Unsupported codings are an error.
+func requestEncodings(r *http.Request) ([]string, error) {
+	var encodings []string
+	for _, value := range r.Header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			switch coding = strings.ToLower(strings.TrimSpace(coding)); coding {
+			case "", "identity":
+			case "gzip", "x-gzip", "deflate":
+				encodings = append(encodings, coding)
+			default:
+				return nil, fmt.Errorf("rpc: unsupported Content-Encoding: %s", coding)
+			}
+		}
+	}
+	return encodings, nil
+}
+
+// decompressReader decompresses a request body with the content codings
+// applied to it, starting on the first read so the errors are read by the
+// codec.
+type decompressReader struct {
+	io.ReadCloser
+	encodings []string
+
+	decoded io.Reader
+	err     error
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.decoded == nil {
+		d.decoded = d.ReadCloser
+		for i := len(d.encodings) - 1; i >= 0; i-- {
+			var err error
+			if d.encodings[i] == "deflate" {
+				d.decoded, err = zlib.NewReader(d.decoded)
+			} else {
+				d.decoded, err = gzip.NewReader(d.decoded)
+			}
+			if err != nil {
+				d.err = fmt.Errorf("rpc: invalid %s request body: %v", d.encodings[i], err)
+				return 0, d.err
+			}
+		}
+	}
+	n, err := d.decoded.Read(p)
+	if err != nil && err != io.EOF {
+		d.err = fmt.Errorf("rpc: invalid %s request body: %v", strings.Join(d.encodings, ", "), err)
+		return n, d.err
+	}
+	return n, err
+}
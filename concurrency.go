@@ -0,0 +1,95 @@
+package rpcserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+type connKey struct{}
+
+// ConnContext tags the context of the requests of a connection, so the
+// MaxConcurrentCallsPerConn limit applies to the connection. Set it as the
+// ConnContext of the http.Server serving the server:
+//
+//	srv := &http.Server{Handler: server, ConnContext: rpcserver.ConnContext}
+//
+// Requests multiplexed on an HTTP/2 connection share its limit.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// connSlots holds the calls in flight of a connection.
+type connSlots struct {
+	slots chan struct{}
+	users int // requests holding or waiting for a slot
+}
+
+// acquireCall takes a slot for a call of the request within the concurrency
+// limits, waiting up to QueueTimeout for one. The slot of the connection is
+// taken first, so the calls queued behind the limit of their connection
+// don't hold global slots. It returns the function releasing the slots, or
+// the status and the error refusing the call.
+func (l *limits) acquireCall(ctx context.Context, clock Clock) (func(), int, error) {
+	release := func() {}
+	if conn := ctx.Value(connKey{}); conn != nil && l.MaxConcurrentCallsPerConn > 0 {
+		l.connsMu.Lock()
+		slots := l.conns[conn]
+		if slots == nil {
+			slots = &connSlots{slots: make(chan struct{}, l.MaxConcurrentCallsPerConn)}
+			l.conns[conn] = slots
+		}
+		slots.users++
+		l.connsMu.Unlock()
+		leave := func() {
+			l.connsMu.Lock()
+			if slots.users--; slots.users == 0 {
+				delete(l.conns, conn)
+			}
+			l.connsMu.Unlock()
+		}
+		if !acquire(ctx, slots.slots, l.QueueTimeout, clock) {
+			leave()
+			return nil, 429, fmt.Errorf("rpc: too many concurrent calls on the connection, the limit is %d", l.MaxConcurrentCallsPerConn)
+		}
+		release = func() {
+			<-slots.slots
+			leave()
+		}
+	}
+	if l.calls == nil {
+		return release, 0, nil
+	}
+	if !acquire(ctx, l.calls, l.QueueTimeout, clock) {
+		release()
+		return nil, 503, fmt.Errorf("rpc: too many concurrent calls, the limit is %d", l.MaxConcurrentCalls)
+	}
+	perConn := release
+	return func() {
+		<-l.calls
+		perConn()
+	}, 0, nil
+}
+
+// acquire takes a slot of the semaphore, waiting up to timeout for one.
+func acquire(ctx context.Context, semaphore chan struct{}, timeout time.Duration, clock Clock) bool {
+	select {
+	case semaphore <- struct{}{}:
+		return true
+	default:
+	}
+	if timeout <= 0 {
+		return false
+	}
+	timer := clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case semaphore <- struct{}{}:
+		return true
+	case <-timer.C():
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
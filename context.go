@@ -0,0 +1,83 @@
+package rpcserver
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Caller describes the client of a request.
+type Caller struct {
+	// Addr is the client address: the host of the remote address, or the
+	// address it forwarded the request for when it is one of the
+	// Server.TrustedProxies.
+	Addr string
+
+	// UserAgent is the User-Agent header of the request.
+	UserAgent string
+
+	// RequestID is the value of the RequestIDHeader, if any.
+	RequestID string
+}
+
+// callInfo holds the values ServeHTTP stores in the request context.
+type callInfo struct {
+	method string
+	codec  string
+	start  time.Time
+	caller Caller
+	guard  *jsonGuard // guard of the body, see GuardJSON
+
+	mu       sync.Mutex
+	labels   map[string]string // metric labels, see SetMetricLabel
+	trailers http.Header       // see SetTrailer
+}
+
+type callInfoKey struct{}
+
+// MethodFromContext returns the name of the method being called.
+func MethodFromContext(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return "", false
+	}
+	return info.method, true
+}
+
+// CodecNameFromContext returns the Content-Type the codec of the request is
+// registered with.
+func CodecNameFromContext(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return "", false
+	}
+	return info.codec, true
+}
+
+// StartTimeFromContext returns the time the server started serving the request.
+func StartTimeFromContext(ctx context.Context) (time.Time, bool) {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return time.Time{}, false
+	}
+	return info.start, true
+}
+
+// CallerFromContext returns the client of the request.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return Caller{}, false
+	}
+	return info.caller, true
+}
+
+// newCaller describes the client of r, see clientAddr.
+func newCaller(r *http.Request, trusted Networks) Caller {
+	return Caller{
+		Addr:      clientAddr(r, trusted),
+		UserAgent: r.UserAgent(),
+		RequestID: r.Header.Get(RequestIDHeader),
+	}
+}
@@ -0,0 +1,71 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrDeadlineExceeded is returned for calls not done within the deadline the
+// client set with the DeadlineHeader, or within the Server.CallTimeout.
+var ErrDeadlineExceeded = errors.New("rpc: deadline exceeded")
+
+// timeoutUnits lists the units of the DeadlineHeader from the finest.
+var timeoutUnits = []struct {
+	unit byte
+	size time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// maxTimeoutValue is the largest value of the DeadlineHeader, 8 digits.
+const maxTimeoutValue = 1e8 - 1
+
+// FormatTimeout returns d in the format of the DeadlineHeader, in the finest
+// unit holding it and rounded up so the deadline isn't shortened further.
+func FormatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "0n"
+	}
+	for _, u := range timeoutUnits {
+		if n := (d + u.size - 1) / u.size; n <= maxTimeoutValue {
+			return strconv.FormatInt(int64(n), 10) + string(u.unit)
+		}
+	}
+	return strconv.Itoa(maxTimeoutValue) + "H"
+}
+
+// ParseTimeout reads a value of the DeadlineHeader.
+func ParseTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("rpc: invalid timeout %q", s)
+	}
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("rpc: invalid timeout %q", s)
+	}
+	for _, u := range timeoutUnits {
+		if u.unit == s[len(s)-1] {
+			if max := time.Duration(1<<63-1) / u.size; time.Duration(n) > max {
+				return 1<<63 - 1, nil
+			}
+			return time.Duration(n) * u.size, nil
+		}
+	}
+	return 0, fmt.Errorf("rpc: invalid timeout unit in %q", s)
+}
+
+// contextError returns the error of a call abandoned because ctx is done.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrDeadlineExceeded
+	}
+	return fmt.Errorf("rpc: call cancelled: %v", ctx.Err())
+}
@@ -0,0 +1,231 @@
+// Package decimal provides an arbitrary-precision decimal number for money and
+// other values that must not round-trip through float64 in args and replies.
+//
+// A Decimal is written on the wire as a JSON string, "12.50", and read from a
+// string or a number without loss of precision:
+//
+//	type Transfer struct {
+//		Amount decimal.Decimal `json:"amount"`
+//	}
+//
+// Codecs leave types implementing json.Marshaler and json.Unmarshaler alone,
+// so other decimal types such as github.com/shopspring/decimal work the same.
+package decimal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is the number unscaled * 10^-scale. The zero Decimal is 0.
+//
+// Decimal values are immutable, operations return new values.
+type Decimal struct {
+	unscaled *big.Int // nil is 0
+	scale    int32
+}
+
+var ten = big.NewInt(10)
+
+// MaxScale bounds the scale of the parsed decimals, and its opposite their
+// negative scale: "1e2000000000" would take gigabytes to compute with.
+const MaxScale = 4096
+
+// New returns value * 10^-scale, New(1250, 2) is 12.50.
+func New(value int64, scale int32) Decimal {
+	return Decimal{unscaled: big.NewInt(value), scale: scale}
+}
+
+// NewFromString parses a decimal number such as "-12.50" or "1.2e3", of a scale
+// within MaxScale.
+func NewFromString(s string) (Decimal, error) {
+	orig := s
+	exp := int64(0)
+	if idx := strings.IndexAny(s, "eE"); idx != -1 {
+		var err error
+		exp, err = strconv.ParseInt(s[idx+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("decimal: invalid exponent in %q", orig)
+		}
+		s = s[:idx]
+	}
+	digits := s
+	scale := int64(0)
+	if idx := strings.IndexByte(s, '.'); idx != -1 {
+		digits = s[:idx] + s[idx+1:]
+		scale = int64(len(s) - idx - 1)
+	}
+	body := strings.TrimLeft(digits, "+-")
+	if len(digits)-len(body) > 1 || body == "" || strings.Trim(body, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("decimal: cannot parse %q", orig)
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("decimal: cannot parse %q", orig)
+	}
+	scale -= exp
+	if scale < -MaxScale || scale > MaxScale {
+		return Decimal{}, fmt.Errorf("decimal: exponent out of range in %q", orig)
+	}
+	return Decimal{unscaled: unscaled, scale: int32(scale)}, nil
+}
+
+// RequireFromString is like NewFromString but panics on error. It simplifies
+// the initialization of constants.
+func RequireFromString(s string) Decimal {
+	d, err := NewFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d Decimal) int() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+// rescale returns the unscaled value of d at a larger scale.
+func (d Decimal) rescale(scale int32) *big.Int {
+	if scale <= d.scale {
+		return d.int()
+	}
+	factor := new(big.Int).Exp(ten, big.NewInt(int64(scale-d.scale)), nil)
+	return factor.Mul(factor, d.int())
+}
+
+// align returns the unscaled values of a and b at the same scale.
+func align(a, b Decimal) (*big.Int, *big.Int, int32) {
+	scale := a.scale
+	if b.scale > scale {
+		scale = b.scale
+	}
+	return a.rescale(scale), b.rescale(scale), scale
+}
+
+// Add returns d + other.
+func (d Decimal) Add(other Decimal) Decimal {
+	a, b, scale := align(d, other)
+	return Decimal{unscaled: new(big.Int).Add(a, b), scale: scale}
+}
+
+// Sub returns d - other.
+func (d Decimal) Sub(other Decimal) Decimal {
+	a, b, scale := align(d, other)
+	return Decimal{unscaled: new(big.Int).Sub(a, b), scale: scale}
+}
+
+// Mul returns d * other.
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{unscaled: new(big.Int).Mul(d.int(), other.int()), scale: d.scale + other.scale}
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Cmp compares d and other, returning -1, 0 or +1.
+func (d Decimal) Cmp(other Decimal) int {
+	a, b, _ := align(d, other)
+	return a.Cmp(b)
+}
+
+// Equal tells if d and other are the same number, 1.5 equals 1.50.
+func (d Decimal) Equal(other Decimal) bool {
+	return d.Cmp(other) == 0
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d.
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// IsZero tells if d is 0.
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Round rounds d to places decimal places, halves away from zero.
+func (d Decimal) Round(places int32) Decimal {
+	if places >= d.scale {
+		return Decimal{unscaled: d.rescale(places), scale: places}
+	}
+	factor := new(big.Int).Exp(ten, big.NewInt(int64(d.scale-places)), nil)
+	quo, rem := new(big.Int).QuoRem(d.int(), factor, new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(factor) >= 0 {
+		if d.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return Decimal{unscaled: quo, scale: places}
+}
+
+// Float64 returns the nearest float64 of d.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String returns d in plain notation keeping its scale, such as "12.50".
+func (d Decimal) String() string {
+	if d.scale <= 0 {
+		return d.rescale(0).String()
+	}
+	digits := new(big.Int).Abs(d.int()).String()
+	if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.scale)
+	sign := ""
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// MarshalJSON writes d as a JSON string.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+// UnmarshalJSON reads d from a JSON string or number, null leaves d unchanged.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// MarshalText writes d in plain notation.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText parses d from text.
+func (d *Decimal) UnmarshalText(text []byte) error {
+	v, err := NewFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
@@ -0,0 +1,74 @@
+package decimal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		in, expected string
+	}{
+		{"0", "0"},
+		{"12.50", "12.50"},
+		{"-0.05", "-0.05"},
+		{"+7", "7"},
+		{"1.2e3", "1200"},
+		{"15e-3", "0.015"},
+		{"123456789012345678901234567890.123456789", "123456789012345678901234567890.123456789"},
+		{"1e4096", "1" + strings.Repeat("0", 4096)},
+	} {
+		d, err := NewFromString(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if d.String() != tc.expected {
+			t.Errorf("%s: expected %.40s, got %.40s", tc.in, tc.expected, d.String())
+		}
+	}
+	for _, in := range []string{"", "-", "1.2.3", "1e", "abc", "--1", "0x10", "1e2000000000", "1e-2000000000", "1e4097", "0." + strings.Repeat("0", 4097)} {
+		if _, err := NewFromString(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a, b := RequireFromString("0.1"), RequireFromString("0.2")
+	if sum := a.Add(b); sum.String() != "0.3" {
+		t.Errorf("0.1 + 0.2: got %s", sum)
+	}
+	if diff := a.Sub(RequireFromString("1.25")); diff.String() != "-1.15" {
+		t.Errorf("0.1 - 1.25: got %s", diff)
+	}
+	if prod := RequireFromString("19.99").Mul(New(3, 0)); prod.String() != "59.97" {
+		t.Errorf("19.99 * 3: got %s", prod)
+	}
+	if !RequireFromString("1.5").Equal(RequireFromString("1.50")) || a.Cmp(b) != -1 {
+		t.Errorf("unexpected comparison")
+	}
+	for in, expected := range map[string]string{"2.345": "2.35", "-2.345": "-2.35", "2.344": "2.34", "2": "2.00"} {
+		if r := RequireFromString(in).Round(2); r.String() != expected {
+			t.Errorf("round %s: expected %s, got %s", in, expected, r)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		A, B Decimal
+		C    *Decimal
+	}
+	if err := json.Unmarshal([]byte(`{"A": 9007199254740993.01, "B": "0.10", "C": null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(v)
+	if string(data) != `{"A":"9007199254740993.01","B":"0.10","C":null}` {
+		t.Errorf("unexpected encoding %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"A": true}`), &v); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,38 @@
+package rpcserver
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// JSONEngine marshals and unmarshals JSON. Set Server.JSON to have the codecs
+// use a faster implementation than encoding/json, such as json-iterator or
+// sonic:
+//
+//	server.JSON = jsoniter.ConfigCompatibleWithStandardLibrary
+type JSONEngine interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// StdJSON is the JSONEngine of encoding/json.
+var StdJSON JSONEngine = stdJSON{}
+
+type stdJSON struct{}
+
+func (stdJSON) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (stdJSON) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type engineKey struct{}
+
+// WithJSONEngine returns a copy of ctx carrying the JSON engine.
+func WithJSONEngine(ctx context.Context, engine JSONEngine) context.Context {
+	return context.WithValue(ctx, engineKey{}, engine)
+}
+
+// JSONEngineFromContext returns the JSON engine set by the server serving the
+// request, nil if codecs should use encoding/json.
+func JSONEngineFromContext(ctx context.Context) JSONEngine {
+	engine, _ := ctx.Value(engineKey{}).(JSONEngine)
+	return engine
+}
@@ -0,0 +1,83 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var enums sync.Map // reflect.Type -> []interface{}
+
+// RegisterEnum registers the values, of a same type, as the only ones the
+// params of the type accept, typically its constants:
+//
+//	type Order string
+//
+//	const (
+//		Asc  Order = "asc"
+//		Desc Order = "desc"
+//	)
+//
+//	func init() {
+//		rpcserver.RegisterEnum(Asc, Desc)
+//	}
+//
+// Enums are registered before the params of the type are first decoded. Struct
+// fields list their values with an enum tag instead, see ParamsRules.
+func RegisterEnum(values ...interface{}) {
+	if len(values) == 0 {
+		panic("rpc: RegisterEnum needs values")
+	}
+	t := reflect.TypeOf(values[0])
+	generic := make([]interface{}, len(values))
+	for i, v := range values {
+		if reflect.TypeOf(v) != t {
+			panic(fmt.Sprintf("rpc: RegisterEnum values of types %s and %T", t, v))
+		}
+		generic[i] = jsonGeneric(v)
+	}
+	enums.Store(t, generic)
+}
+
+// jsonGeneric returns v in its JSON generic form, nil if v can't be encoded.
+func jsonGeneric(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	json.Unmarshal(data, &generic)
+	return generic
+}
+
+// EnumOf returns the values accepted by a struct field in their JSON generic
+// form, nil if it accepts any value: the values of its enum tag, separated by
+// commas, or the values registered for its type. The values are those of the
+// elements of slice and array fields.
+func EnumOf(field reflect.StructField) []interface{} {
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	if tag, ok := field.Tag.Lookup("enum"); ok {
+		var values []interface{}
+		for _, v := range strings.Split(tag, ",") {
+			var generic interface{}
+			json.Unmarshal(defaultValue(strings.TrimSpace(v), t), &generic)
+			values = append(values, generic)
+		}
+		return values
+	}
+	if values, ok := enums.Load(t); ok {
+		return values.([]interface{})
+	}
+	return nil
+}
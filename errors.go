@@ -0,0 +1,81 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------------
+// ErrorResponse
+// ----------------------------------------------------------------------------
+
+// ErrorResponse describes a transport-level error, occurring before a codec
+// took over the request: wrong HTTP method, unknown Content-Type or method
+// path, too large body and such.
+type ErrorResponse struct {
+	// Status is the HTTP status of the response.
+	Status int `json:"status"`
+
+	// Code is an error code in the scheme of the writer, 0 lets the writer
+	// derive one from Status.
+	Code int `json:"code,omitempty"`
+
+	// Message is a concise single sentence describing the error.
+	Message string `json:"message"`
+
+	// Details holds additional structured information about the error.
+	Details interface{} `json:"details,omitempty"`
+
+	// RequestID is copied from the X-Request-Id header of the request.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
+// NewErrorResponse creates an ErrorResponse for the request.
+func NewErrorResponse(r *http.Request, status int, err error) *ErrorResponse {
+	res := &ErrorResponse{
+		Status:    status,
+		Message:   err.Error(),
+		RequestID: r.Header.Get(RequestIDHeader),
+	}
+	if detailed, ok := err.(*ErrorResponse); ok {
+		res.Code = detailed.Code
+		res.Details = detailed.Details
+	}
+	return res
+}
+
+// ErrorWriter writes transport-level errors. Codecs implementing it write the
+// transport-level errors of the requests they match.
+type ErrorWriter interface {
+	WriteErrorResponse(w http.ResponseWriter, r *http.Request, res *ErrorResponse)
+}
+
+// TextErrorWriter writes the message of errors as text/plain.
+type TextErrorWriter struct{}
+
+// WriteErrorResponse writes the error message.
+func (TextErrorWriter) WriteErrorResponse(w http.ResponseWriter, r *http.Request, res *ErrorResponse) {
+	WriteError(w, res.Status, res.Message)
+}
+
+// JSONErrorWriter writes errors as JSON encoded ErrorResponse objects.
+type JSONErrorWriter struct{}
+
+// WriteErrorResponse writes the error as JSON.
+func (JSONErrorWriter) WriteErrorResponse(w http.ResponseWriter, r *http.Request, res *ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// WriteError writes msg as a text/plain response with the status.
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, msg)
+}
@@ -0,0 +1,204 @@
+// Package explorer serves an interactive page to exercise the methods of a
+// service from a browser: the methods are listed with a form generated from
+// their params, and the "Try it" button calls them through a JSON-RPC 2.0
+// endpoint and shows the response.
+//
+//	doc := introspect.Describe(server.Service())
+//	http.Handle("/explorer", explorer.Handler(doc, "/rpc/"))
+//	http.Handle("/rpc/", server)
+package explorer
+
+import (
+	"github.com/datalinkE/rpcserver/introspect"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Handler serves the explorer page of the document, calling the methods at
+// the endpoint followed by the method name.
+func Handler(doc *introspect.Document, endpoint string) http.Handler {
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	data := struct {
+		Doc      *introspect.Document
+		Endpoint string
+	}{doc, endpoint}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "explorer: GET method required", 405)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page.Execute(w, data)
+	})
+}
+
+var page = template.Must(template.New("explorer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Doc.Service}} explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 60em; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: .5em 0; padding: .5em 1em; }
+summary { cursor: pointer; font-family: monospace; font-size: 1.1em; }
+label { display: block; margin: .4em 0; font-family: monospace; }
+label span { display: inline-block; min-width: 12em; }
+textarea { width: 100%; font-family: monospace; }
+pre { background: #f6f6f6; padding: .5em; overflow: auto; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{.Doc.Service}}</h1>
+{{with .Doc.Description}}<p>{{.}}</p>
+{{end}}<p>Methods called at <code>{{.Endpoint}}</code>.</p>
+<div id="methods"></div>
+<script>
+const doc = {{.Doc}};
+const endpoint = {{.Endpoint}};
+
+function resolve(s) {
+	while (s && s.$ref) {
+		s = doc.definitions[s.$ref.replace("#/definitions/", "")];
+	}
+	return s || {};
+}
+
+function members(s) {
+	return s["x-order"] || Object.keys(s.properties || {}).sort();
+}
+
+// sample returns a sample value of a schema, for the JSON fields.
+function sample(s, depth) {
+	if (s && s.example !== undefined) return s.example;
+	s = resolve(s);
+	if (depth > 3) return null;
+	switch (s.type) {
+	case "boolean": return false;
+	case "integer": case "number": return 0;
+	case "string": return s.format === "date-time" ? new Date(0).toISOString() : "";
+	case "array": return [];
+	case "object":
+		const v = {};
+		for (const name of members(s)) v[name] = sample(s.properties[name], depth + 1);
+		return v;
+	}
+	return null;
+}
+
+// input returns the form input of a member, read back by value.
+function input(s) {
+	const example = s && s.example;
+	s = resolve(s);
+	let el;
+	if (s.type === "boolean") {
+		el = document.createElement("input");
+		el.type = "checkbox";
+		el.checked = example === true;
+		el.read = () => el.checked;
+	} else if (s.type === "integer" || s.type === "number") {
+		el = document.createElement("input");
+		el.type = "number";
+		el.step = s.type === "integer" ? "1" : "any";
+		el.value = example !== undefined ? String(example) : "0";
+		el.read = () => Number(el.value);
+	} else if (s.type === "string") {
+		el = document.createElement("input");
+		el.value = example !== undefined ? example : sample(s, 0);
+		el.read = () => el.value;
+	} else {
+		el = document.createElement("textarea");
+		el.rows = 3;
+		el.value = JSON.stringify(example !== undefined ? example : sample(s, 0), null, 2);
+		el.read = () => JSON.parse(el.value);
+	}
+	return el;
+}
+
+function render(m) {
+	const box = document.createElement("details");
+	const summary = document.createElement("summary");
+	summary.textContent = m.name;
+	box.appendChild(summary);
+	if (m.description) {
+		const p = document.createElement("p");
+		p.textContent = m.description;
+		box.appendChild(p);
+	}
+
+	const params = resolve(m.params);
+	const byName = params.type === "object" && params.properties;
+	const inputs = {};
+	if (byName) {
+		for (const name of members(params)) {
+			const label = document.createElement("label");
+			const span = document.createElement("span");
+			span.textContent = name;
+			label.appendChild(span);
+			const desc = params.properties[name].description;
+			if (desc) label.title = desc;
+			inputs[name] = input(params.properties[name]);
+			label.appendChild(inputs[name]);
+			if (desc) {
+				const small = document.createElement("small");
+				small.textContent = " " + desc;
+				label.appendChild(small);
+			}
+			box.appendChild(label);
+		}
+	} else if (params.type !== "null") {
+		inputs[""] = input(m.params);
+		box.appendChild(inputs[""]);
+	}
+
+	const button = document.createElement("button");
+	button.textContent = "Try it";
+	const out = document.createElement("pre");
+	button.onclick = async () => {
+		out.className = "";
+		let p;
+		try {
+			if (byName) {
+				p = {};
+				for (const name in inputs) p[name] = inputs[name].read();
+			} else if (inputs[""]) {
+				p = inputs[""].read();
+			}
+		} catch (e) {
+			out.className = "error";
+			out.textContent = "Invalid params: " + e.message;
+			return;
+		}
+		const body = {jsonrpc: "2.0", method: m.name, id: 1};
+		if (p !== undefined) body.params = p;
+		try {
+			const res = await fetch(endpoint + m.name, {
+				method: "POST",
+				headers: {"Content-Type": "application/json"},
+				body: JSON.stringify(body),
+			});
+			const text = await res.text();
+			let shown = text;
+			try { shown = JSON.stringify(JSON.parse(text), null, 2); } catch (e) {}
+			out.className = res.ok && !/"error"/.test(text) ? "" : "error";
+			out.textContent = res.status + " " + res.statusText + "\n\n" + shown;
+		} catch (e) {
+			out.className = "error";
+			out.textContent = e.message;
+		}
+	};
+	box.appendChild(button);
+	box.appendChild(out);
+	return box;
+}
+
+const list = document.getElementById("methods");
+for (const m of doc.methods) list.appendChild(render(m));
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,46 @@
+package explorer
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, reply *int) error {
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Handler(introspect.Describe(service), "/rpc")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/explorer", nil))
+	body := w.Body.String()
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	for _, expected := range []string{"<title>Arith explorer</title>", `"name":"Divide"`, `const endpoint = "/rpc/"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %s in the page", expected)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/explorer", nil))
+	if w.Code != 405 {
+		t.Errorf("expected 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+// FallbackHandler serves the calls of the methods the server lacks, with the
+// name of the method and its raw params. It returns the reply to encode.
+type FallbackHandler func(method string, raw json.RawMessage) (interface{}, error)
+
+// fallback is the receiver of the method calling a FallbackHandler.
+type fallback struct {
+	handler FallbackHandler
+}
+
+// Call calls the handler with the method of the request.
+func (f fallback) Call(r *http.Request, raw json.RawMessage) (interface{}, error) {
+	method, _ := MethodFromContext(r.Context())
+	return f.handler(method, raw)
+}
+
+// SetFallbackHandler sets the handler serving the calls of unknown methods,
+// which are answered with 404 without one, e.g. to dispatch the calls to
+// plugins or to proxy them to another server. The calls pass through the
+// middleware, the nil handler removes it.
+//
+// The fallback handler may be set while the server is handling requests.
+func (s *Server) SetFallbackHandler(handler FallbackHandler) {
+	var m *RpcServiceMethod
+	if handler != nil {
+		rcvr := reflect.ValueOf(fallback{handler})
+		spec, _ := rcvr.Type().MethodByName("Call")
+		m, _ = newRpcServiceMethod(spec)
+		m.numIn, m.rcvr = spec.Type.NumIn(), rcvr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if m != nil {
+		for _, codec := range reg.codecs {
+			precompileMethod(codec, m)
+		}
+	}
+	reg.fallback = m
+	s.registry.Store(&reg)
+}
+
+// callable returns the method of the name, or the fallback handler of the
+// server when it has one.
+func (reg *registry) callable(name string) (*RpcServiceMethod, error) {
+	m, err := reg.method(name)
+	if err != nil && reg.fallback != nil {
+		return reg.fallback, nil
+	}
+	return m, err
+}
@@ -0,0 +1,41 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrFeatureDisabled is returned for calls of the methods the Server.Flags
+// disabled for the caller.
+var ErrFeatureDisabled = errors.New("rpc: feature disabled")
+
+// FlagProvider gates methods behind feature flags, to roll them out gradually
+// or disable them per tenant, backed by LaunchDarkly, Unleash or flags of
+// your own. Enabled is called concurrently for every call, after the
+// middleware, so ctx holds their values such as the tenant of an
+// authentication middleware.
+type FlagProvider interface {
+	Enabled(ctx context.Context, method string, caller Caller) bool
+}
+
+// FlagFunc is a function used as a FlagProvider.
+type FlagFunc func(ctx context.Context, method string, caller Caller) bool
+
+// Enabled returns f(ctx, method, caller).
+func (f FlagFunc) Enabled(ctx context.Context, method string, caller Caller) bool {
+	return f(ctx, method, caller)
+}
+
+// checkFlags returns an error wrapping ErrFeatureDisabled when the flags
+// disabled the method for the caller of ctx.
+func (s *Server) checkFlags(ctx context.Context, method string) error {
+	if s.Flags == nil {
+		return nil
+	}
+	caller, _ := CallerFromContext(ctx)
+	if !s.Flags.Enabled(ctx, method, caller) {
+		return fmt.Errorf("%w: %s", ErrFeatureDisabled, method)
+	}
+	return nil
+}
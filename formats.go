@@ -0,0 +1,146 @@
+package rpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat tells how time.Time values are written on the wire.
+type TimeFormat int
+
+const (
+	// TimeRFC3339 writes times as RFC 3339 strings, as encoding/json does.
+	TimeRFC3339 TimeFormat = iota
+
+	// TimeUnix writes times as seconds since the Unix epoch.
+	TimeUnix
+
+	// TimeUnixMillis writes times as milliseconds since the Unix epoch.
+	TimeUnixMillis
+)
+
+// DurationFormat tells how time.Duration values are written on the wire.
+type DurationFormat int
+
+const (
+	// DurationNanos writes durations as nanoseconds, as encoding/json does.
+	DurationNanos DurationFormat = iota
+
+	// DurationMillis writes durations as milliseconds.
+	DurationMillis
+
+	// DurationString writes durations as strings like "1h30m".
+	DurationString
+)
+
+// Formats describes the wire formats of values needing a convention between
+// the server and its clients. The zero Formats matches encoding/json.
+//
+// Codecs encode replies in the configured formats and accept args in any of
+// them: a time is read from an RFC 3339 string or from a number of seconds,
+// milliseconds with TimeUnixMillis; a duration from a string like "1h30m" or
+// from a number of nanoseconds, milliseconds with DurationMillis.
+type Formats struct {
+	Time     TimeFormat
+	Duration DurationFormat
+
+	// Int64AsString writes int64 and uint64 values as strings, which
+	// JavaScript clients read without losing precision above 2^53. Args of
+	// these types are then read from both strings and numbers.
+	Int64AsString bool
+}
+
+// IsDefault tells if f is the zero Formats.
+func (f Formats) IsDefault() bool {
+	return f == Formats{}
+}
+
+// EncodeTime returns t in the time format.
+func (f Formats) EncodeTime(t time.Time) interface{} {
+	switch f.Time {
+	case TimeUnix:
+		return t.Unix()
+	case TimeUnixMillis:
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// DecodeTime reads a time given as a string or a number, see Formats.
+func (f Formats) DecodeTime(v interface{}) (time.Time, error) {
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		v = s // may be a quoted number
+	}
+	n, err := number(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("rpc: cannot read time from %v", v)
+	}
+	if f.Time == TimeUnixMillis {
+		return time.Unix(0, int64(n*float64(time.Millisecond))).UTC(), nil
+	}
+	sec, frac := math.Modf(n)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
+
+// EncodeDuration returns d in the duration format.
+func (f Formats) EncodeDuration(d time.Duration) interface{} {
+	switch f.Duration {
+	case DurationMillis:
+		return float64(d) / float64(time.Millisecond)
+	case DurationString:
+		return d.String()
+	}
+	return int64(d)
+}
+
+// DecodeDuration reads a duration given as a string or a number, see Formats.
+func (f Formats) DecodeDuration(v interface{}) (time.Duration, error) {
+	if s, ok := v.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+	}
+	n, err := number(v)
+	if err != nil {
+		return 0, fmt.Errorf("rpc: cannot read duration from %v", v)
+	}
+	if f.Duration == DurationMillis {
+		return time.Duration(n * float64(time.Millisecond)), nil
+	}
+	return time.Duration(n), nil
+}
+
+// number reads a decoded JSON number, possibly quoted.
+func number(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, fmt.Errorf("rpc: %v is not a number", v)
+}
+
+type formatsKey struct{}
+
+// WithFormats returns a copy of ctx carrying the formats.
+func WithFormats(ctx context.Context, f Formats) context.Context {
+	return context.WithValue(ctx, formatsKey{}, f)
+}
+
+// FormatsFromContext returns the formats set by the server serving the
+// request, the zero Formats if none.
+func FormatsFromContext(ctx context.Context) Formats {
+	f, _ := ctx.Value(formatsKey{}).(Formats)
+	return f
+}
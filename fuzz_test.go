@@ -0,0 +1,71 @@
+package rpcserver_test
+
+import (
+	"bytes"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type FuzzArgs struct {
+	A, B int
+}
+
+type FuzzReply struct {
+	Value int
+}
+
+type FuzzService struct{}
+
+func (s *FuzzService) Action(r *http.Request, args *FuzzArgs, reply *FuzzReply) error {
+	reply.Value = args.A - args.B
+	return nil
+}
+
+func FuzzLastPart(f *testing.F) {
+	for _, seed := range []string{"", "/", "/jsonrpc/v1/Action", "Action", "//", "/a/b/"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		part := rpcserver.LastPart(path)
+		if strings.Contains(part, "/") {
+			t.Fatalf("last part %q of %q contains a separator", part, path)
+		}
+		if !rpcserver.PathHasMethod(path, part) {
+			t.Fatalf("%q does not end with its last part %q", path, part)
+		}
+	})
+}
+
+func FuzzServeHTTP(f *testing.F) {
+	server, err := rpcserver.NewServer(new(FuzzService))
+	if err != nil {
+		f.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+
+	for _, seed := range []struct{ path, contentType, body string }{
+		{"/jsonrpc/v1/Action", "application/json", `{"jsonrpc": "2.0", "method": "Action", "id": 1, "params": {"A": 5, "B": 2}}`},
+		{"/jsonrpc/v1/Action", "application/json; charset=utf-8", `{"jsonrpc": "2.0", "method": "Action", "id": 1, "params": [{"A": 5}]}`},
+		{"/jsonrpc/v1/Action", "", `{"jsonrpc": "2.0", "method": "Action", "id": 1, "params": {"A": "5"}}`},
+		{"/jsonrpc/v1/Action", "text/xml;;;", `<xml/>`},
+		{"/jsonrpc/v1/Wrong", "APPLICATION/JSON", `{"jsonrpc": "2.0", "method": "Wrong"}`},
+		{"/", ";", ``},
+	} {
+		f.Add(seed.path, seed.contentType, seed.body)
+	}
+
+	f.Fuzz(func(t *testing.T, path string, contentType string, body string) {
+		r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+		r.URL.Path = path
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code == 0 {
+			t.Fatal("no status written")
+		}
+	})
+}
@@ -0,0 +1,289 @@
+// Package graphql serves the methods of an rpcserver.Server as a GraphQL API,
+// for teams standardizing on GraphQL gateways. Each method is a field of the
+// Query or the Mutation type, named like the method with a lower case first
+// letter, with an argument per member of its args struct and the type of its
+// reply:
+//
+//	schema := graphql.NewSchema(introspect.Describe(server.Service()), nil)
+//	http.Handle("/graphql", graphql.Handler(server, schema))
+//
+//	mutation { divide(A: 10, B: 3) { Quo Rem } }
+//
+// Methods with other args, such as a map or a slice, take them whole from an
+// "input" argument. Methods without a reply answer true. GET requests are
+// answered with the schema in the schema definition language.
+//
+// Queries are executed with the selections of their fields: aliases, the
+// arguments and variables. Fragments, directives and introspection queries
+// are not supported.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/rest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// Request is a GraphQL request posted as JSON.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a GraphQL request.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is an error of a GraphQL request, Path locates the field it occurred
+// at.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// maxRequestBytes bounds the requests of the servers without MaxBodyBytes.
+const maxRequestBytes = 1 << 20
+
+// Handler serves the schema, calling the methods of the server through its
+// middleware, limits and stats, as the rest package does. Requests are
+// limited to the MaxBodyBytes of the server, 1 MiB when it has none.
+func Handler(server *rpcserver.Server, schema *Schema) http.Handler {
+	codec := new(rest.Codec)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(schema.SDL()))
+			return
+		case "POST":
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			rpcserver.WriteError(w, 405, "rpc: GET or POST method required")
+			return
+		}
+		limit := server.MaxBodyBytes
+		if limit <= 0 {
+			limit = maxRequestBytes
+		}
+		var req Request
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			writeResponse(w, 400, &Response{Errors: []*Error{{Message: "invalid request: " + err.Error()}}})
+			return
+		}
+		op, err := parseDocument(req.Query, req.OperationName)
+		if err != nil {
+			writeResponse(w, 400, &Response{Errors: []*Error{{Message: err.Error()}}})
+			return
+		}
+		e := &executor{server: server, schema: schema, codec: codec, request: r, variables: req.Variables}
+		data := e.execute(op)
+		writeResponse(w, 200, &Response{Data: data, Errors: e.errors})
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, res *Response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// executor executes an operation.
+type executor struct {
+	server    *rpcserver.Server
+	schema    *Schema
+	codec     *rest.Codec
+	request   *http.Request
+	variables map[string]interface{}
+	errors    []*Error
+}
+
+func (e *executor) fail(path []interface{}, format string, args ...interface{}) {
+	e.errors = append(e.errors, &Error{Message: fmt.Sprintf(format, args...), Path: path})
+}
+
+// execute calls the methods of the root fields in order and returns the data.
+func (e *executor) execute(op *operation) map[string]interface{} {
+	data := make(map[string]interface{}, len(op.selections))
+	for _, sel := range op.selections {
+		path := []interface{}{sel.key()}
+		if sel.name == "_service" && op.kind == "query" {
+			data[sel.key()] = e.schema.doc.Service
+			continue
+		}
+		f := e.schema.fields[sel.name]
+		if f == nil || f.query != (op.kind == "query") {
+			e.fail(path, "unknown field %s of %s", sel.name, rootType(op.kind))
+			data[sel.key()] = nil
+			continue
+		}
+		data[sel.key()] = e.call(path, f, sel)
+	}
+	return data
+}
+
+func rootType(kind string) string {
+	if kind == "mutation" {
+		return "Mutation"
+	}
+	return "Query"
+}
+
+// call calls the method of a root field and returns its selected reply.
+func (e *executor) call(path []interface{}, f *field, sel *selection) interface{} {
+	params, err := e.params(f, sel)
+	if err != nil {
+		e.fail(path, "%v", err)
+		return nil
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		e.fail(path, "%v", err)
+		return nil
+	}
+	r := e.request.WithContext(rpcserver.WithCodec(e.request.Context(), e.codec, "graphql"))
+	u := *e.request.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + f.method.Name
+	r.URL = &u
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	w := httptest.NewRecorder()
+	e.server.ServeHTTP(w, r)
+
+	if w.Code == 204 {
+		return true
+	}
+	dec := json.NewDecoder(w.Body)
+	dec.UseNumber()
+	if w.Code != 200 {
+		var res rpcserver.ErrorResponse
+		if dec.Decode(&res) != nil || res.Message == "" {
+			res.Message = fmt.Sprintf("%s failed with status %d", f.method.Name, w.Code)
+		}
+		e.fail(path, "%s", res.Message)
+		return nil
+	}
+	var reply interface{}
+	if err := dec.Decode(&reply); err != nil {
+		e.fail(path, "invalid reply: %v", err)
+		return nil
+	}
+	return e.project(path, reply, f.method.Result, sel.selections)
+}
+
+// params returns the params of the method from the arguments of the field.
+func (e *executor) params(f *field, sel *selection) (interface{}, error) {
+	if f.args == nil {
+		for name := range sel.args {
+			if name != "input" {
+				return nil, fmt.Errorf("unknown argument %s of %s", name, f.name)
+			}
+		}
+		return e.resolve(sel.args["input"])
+	}
+	params := make(map[string]interface{}, len(sel.args))
+	for _, name := range sel.argOrder {
+		if !contains(f.args, name) {
+			return nil, fmt.Errorf("unknown argument %s of %s", name, f.name)
+		}
+		v, err := e.resolve(sel.args[name])
+		if err != nil {
+			return nil, err
+		}
+		params[name] = v
+	}
+	return params, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve replaces the variables of a value with their values.
+func (e *executor) resolve(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case variable:
+		value, ok := e.variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", v)
+		}
+		return value, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = e.resolve(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var err error
+			if object[key], err = e.resolve(item); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+	return v, nil
+}
+
+// project returns the selections of a reply value of the schema.
+func (e *executor) project(path []interface{}, v interface{}, s *introspect.Schema, selections []*selection) interface{} {
+	resolved := e.schema.doc.Resolve(s)
+	object := resolved != nil && resolved.Type == "object" && resolved.AdditionalProperties == nil && len(resolved.Properties) > 0
+	if resolved != nil && resolved.Type == "array" {
+		list, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		projected := make([]interface{}, len(list))
+		for i, item := range list {
+			projected[i] = e.project(append(path[:len(path):len(path)], i), item, resolved.Items, selections)
+		}
+		return projected
+	}
+	if !object {
+		if len(selections) > 0 {
+			e.fail(path, "field %v of a scalar type has no subselection", path[len(path)-1])
+			return nil
+		}
+		return v
+	}
+	if len(selections) == 0 {
+		e.fail(path, "field %v of an object type requires a subselection", path[len(path)-1])
+		return nil
+	}
+	members, ok := v.(map[string]interface{})
+	if !ok {
+		return nil // null
+	}
+	projected := make(map[string]interface{}, len(selections))
+	for _, sel := range selections {
+		member, known := resolved.Properties[sel.name]
+		if !known {
+			e.fail(append(path[:len(path):len(path)], sel.key()), "unknown field %s", sel.name)
+			continue
+		}
+		projected[sel.key()] = e.project(append(path[:len(path):len(path)], sel.key()), members[sel.name], member, sel.selections)
+	}
+	return projected
+}
@@ -0,0 +1,135 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+	Next     *Quotient
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*quo = Quotient{Quo: args.A / args.B, Rem: args.A % args.B, Next: &Quotient{Quo: args.B}}
+	return nil
+}
+
+func (t *Arith) GetLimits(r *http.Request, args *struct{}) ([]int, error) {
+	return []int{1, 2}, nil
+}
+
+func (t *Arith) Check(r *http.Request, args *Args) error {
+	return nil
+}
+
+func (t *Arith) Count(r *http.Request, values []string) (int, error) {
+	return len(values), nil
+}
+
+func newHandler(t *testing.T) (*Schema, http.Handler) {
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := NewSchema(introspect.Describe(server.Service()), nil)
+	return schema, Handler(server, schema)
+}
+
+func post(handler http.Handler, query string, variables map[string]interface{}) string {
+	body, _ := json.Marshal(&Request{Query: query, Variables: variables})
+	r := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return strings.TrimSpace(w.Body.String())
+}
+
+func TestSDL(t *testing.T) {
+	schema, _ := newHandler(t)
+	expected := `type Query {
+  getLimits: [Int!]
+}
+
+type Mutation {
+  check(A: Int, B: Int): Boolean!
+  count(input: [String!]): Int!
+  divide(A: Int, B: Int): Quotient!
+}
+
+type Quotient {
+  Quo: Int!
+  Rem: Int!
+  Next: Quotient
+}
+`
+	if sdl := schema.SDL(); sdl != expected {
+		t.Errorf("unexpected SDL:\n%s", sdl)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	_, handler := newHandler(t)
+	for _, tc := range []struct {
+		query     string
+		variables map[string]interface{}
+		expected  string
+	}{
+		{`mutation { divide(A: 10, B: 3) { Quo Rem } }`, nil,
+			`{"data":{"divide":{"Quo":3,"Rem":1}}}`},
+		{`mutation Div($a: Int) { d: divide(A: $a, B: 4) { Quo next: Next { Quo } } c: check }`, map[string]interface{}{"a": 9},
+			`{"data":{"c":true,"d":{"Quo":2,"next":{"Quo":4}}}}`},
+		{`{ getLimits }`, nil,
+			`{"data":{"getLimits":[1,2]}}`},
+		{`mutation { count(input: ["a", "b", "c"]) }`, nil,
+			`{"data":{"count":3}}`},
+		{`mutation { divide(A: 1, B: 0) { Quo } }`, nil,
+			`{"data":{"divide":null},"errors":[{"message":"divide by zero","path":["divide"]}]}`},
+		{`mutation { divide(A: 1, B: 1) { Other } }`, nil,
+			`{"data":{"divide":{}},"errors":[{"message":"unknown field Other","path":["divide","Other"]}]}`},
+		{`mutation { divide(A: 1, B: 1) }`, nil,
+			`{"data":{"divide":null},"errors":[{"message":"field divide of an object type requires a subselection","path":["divide"]}]}`},
+		{`{ divide(A: 1, B: 1) { Quo } }`, nil,
+			`{"data":{"divide":null},"errors":[{"message":"unknown field divide of Query","path":["divide"]}]}`},
+		{`{ divide(A: 1 `, nil,
+			`{"data":null,"errors":[{"message":"unexpected end of document"}]}`},
+	} {
+		if got := post(handler, tc.query, tc.variables); got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	_, handler := newHandler(t)
+	const levels = 100000
+	for _, query := range []string{
+		strings.Repeat("{a", levels) + strings.Repeat("}", levels),
+		"{ getLimits(x: " + strings.Repeat("[", levels) + strings.Repeat("]", levels) + ") }",
+		"{ getLimits(x: " + strings.Repeat("{a:", levels) + "1" + strings.Repeat("}", levels) + ") }",
+	} {
+		if got := post(handler, query, nil); !strings.Contains(got, "nested deeper than 64 levels") {
+			t.Errorf("expected the nesting to be refused, got %.200s", got)
+		}
+	}
+
+	query := "{ getLimits(x: \"" + strings.Repeat("x", 2<<20) + "\") }"
+	if got := post(handler, query, nil); !strings.Contains(got, "invalid request: http: request body too large") {
+		t.Errorf("expected the body to be refused, got %.200s", got)
+	}
+}
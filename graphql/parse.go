@@ -0,0 +1,407 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// operation is a parsed GraphQL operation.
+type operation struct {
+	kind       string // "query" or "mutation"
+	name       string
+	selections []*selection
+}
+
+// selection is a field of a selection set.
+type selection struct {
+	alias      string
+	name       string
+	args       map[string]interface{} // values, variables being *variable
+	argOrder   []string
+	selections []*selection
+}
+
+// key returns the name of the field in the response.
+func (s *selection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type variable string
+
+// parseDocument parses a GraphQL document and returns the named operation, or
+// the only one when name is empty. Fragments and directives are not supported.
+func parseDocument(query, name string) (*operation, error) {
+	p := &parser{lex: lexer{src: query}}
+	p.next()
+	var ops []*operation
+	for p.tok.kind != tokEOF {
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	for _, op := range ops {
+		if op.name == name || name == "" && len(ops) == 1 {
+			return op, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("the operationName is required with %d operations", len(ops))
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// lexer splits a GraphQL document into tokens, skipping the ignored commas,
+// white space and comments.
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+		} else if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		} else {
+			break
+		}
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{tokPunct, string(c), start}, nil
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		return token{}, fmt.Errorf("fragments are not supported, at %d", start)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for l.pos < len(l.src) && isNameByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{tokName, l.src[start:l.pos], start}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		kind := tokInt
+		l.pos++
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			if c == '.' || c == 'e' || c == 'E' || (c == '+' || c == '-') && kind == tokFloat {
+				kind = tokFloat
+			} else if c < '0' || c > '9' {
+				break
+			}
+			l.pos++
+		}
+		return token{kind, l.src[start:l.pos], start}, nil
+	case c == '"':
+		return l.string()
+	}
+	return token{}, fmt.Errorf("unexpected character %q at %d", c, start)
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// string reads a string value, a block string when it starts with """.
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			return token{}, fmt.Errorf("unterminated string at %d", start)
+		}
+		l.pos += end + 6
+		return token{tokString, l.src[start+3 : l.pos-3], start}, nil
+	}
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\n':
+			return token{}, fmt.Errorf("unterminated string at %d", start)
+		case '"':
+			l.pos++
+			// The escapes of GraphQL strings are those of JSON.
+			var s string
+			if err := json.Unmarshal([]byte(l.src[start:l.pos]), &s); err != nil || !utf8.ValidString(s) {
+				return token{}, fmt.Errorf("invalid string at %d", start)
+			}
+			return token{tokString, s, start}, nil
+		}
+		l.pos++
+	}
+	return token{}, fmt.Errorf("unterminated string at %d", start)
+}
+
+// maxDepth bounds the nesting of the selection sets and values of a
+// document, the parser and the executor recursing into them.
+const maxDepth = 64
+
+// parser is a recursive descent parser of the executable documents.
+type parser struct {
+	lex   lexer
+	tok   token
+	err   error
+	depth int // of the selection sets and values being parsed
+}
+
+// enter enters a selection set or a value, failing beyond maxDepth.
+func (p *parser) enter() error {
+	if p.depth++; p.depth > maxDepth {
+		return fmt.Errorf("document nested deeper than %d levels at %d", maxDepth, p.tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF}
+	}
+}
+
+func (p *parser) is(value string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == value
+}
+
+func (p *parser) expect(value string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if !p.is(value) {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at %d", p.tok.value, p.tok.pos)
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	p.next()
+	return name, nil
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: "query"}
+	if p.tok.kind == tokName {
+		switch p.tok.value {
+		case "query", "mutation":
+			op.kind = p.tok.value
+		case "subscription":
+			return nil, fmt.Errorf("subscriptions are not supported")
+		default:
+			return nil, fmt.Errorf("fragments are not supported")
+		}
+		p.next()
+		if p.tok.kind == tokName {
+			op.name = p.tok.value
+			p.next()
+		}
+		if p.is("(") {
+			if err := p.skipVariableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.is("@") {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+// skipVariableDefinitions skips the variable definitions, their values being
+// checked against the params when the methods are called.
+func (p *parser) skipVariableDefinitions() error {
+	p.next()
+	for !p.is(")") {
+		if p.tok.kind == tokEOF {
+			return p.unexpected()
+		}
+		if p.is("=") {
+			return fmt.Errorf("default values of variables are not supported")
+		}
+		p.next()
+	}
+	p.next()
+	return p.err
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*selection
+	for !p.is("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	p.next()
+	return selections, p.err
+}
+
+func (p *parser) selection() (*selection, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	sel := &selection{name: name}
+	if p.is(":") {
+		p.next()
+		if sel.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		sel.alias = name
+	}
+	if p.is("(") {
+		p.next()
+		sel.args = make(map[string]interface{})
+		for !p.is(")") {
+			arg, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if sel.args[arg], err = p.value(); err != nil {
+				return nil, err
+			}
+			sel.argOrder = append(sel.argOrder, arg)
+		}
+		p.next()
+	}
+	if p.is("@") {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+	if p.is("{") {
+		if sel.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, p.err
+}
+
+// value parses a value as its JSON generic form, numbers being json.Number
+// and enum values strings.
+func (p *parser) value() (interface{}, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	tok := p.tok
+	switch {
+	case p.is("$"):
+		p.next()
+		name, err := p.name()
+		return variable(name), err
+	case p.is("["):
+		p.next()
+		list := []interface{}{}
+		for !p.is("]") {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.next()
+		return list, p.err
+	case p.is("{"):
+		p.next()
+		object := make(map[string]interface{})
+		for !p.is("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		p.next()
+		return object, p.err
+	case tok.kind == tokInt || tok.kind == tokFloat:
+		if _, err := strconv.ParseFloat(tok.value, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %s at %d", tok.value, tok.pos)
+		}
+		p.next()
+		return json.Number(tok.value), p.err
+	case tok.kind == tokString:
+		p.next()
+		return tok.value, p.err
+	case tok.kind == tokName:
+		p.next()
+		switch tok.value {
+		case "true":
+			return true, p.err
+		case "false":
+			return false, p.err
+		case "null":
+			return nil, p.err
+		}
+		return tok.value, p.err
+	}
+	return nil, p.unexpected()
+}
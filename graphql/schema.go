@@ -0,0 +1,185 @@
+package graphql
+
+import (
+	"fmt"
+	"github.com/datalinkE/rpcserver/introspect"
+	"strings"
+)
+
+// Schema maps the methods of an introspection document to the fields of the
+// GraphQL root types.
+type Schema struct {
+	doc     *introspect.Document
+	queries map[string]bool
+	fields  map[string]*field // by GraphQL field name
+}
+
+// field is a root field calling a method.
+type field struct {
+	name   string
+	method *introspect.Method
+	query  bool
+
+	// args names the arguments mapped to the members of the params, none
+	// when the params are read whole from the "input" argument.
+	args []string
+}
+
+// queryPrefixes starts the names of the methods served as queries by default.
+var queryPrefixes = []string{"Get", "List", "Find", "Search", "Count", "Is", "Has"}
+
+// NewSchema creates the Schema of a document. The methods named in queries
+// are fields of the Query type, the others of the Mutation type. When queries
+// is nil, the methods named like GetUser, ListUsers,
+// FindUser, SearchUsers, CountUsers, IsAdmin or HasRole are queries.
+func NewSchema(doc *introspect.Document, queries []string) *Schema {
+	s := &Schema{doc: doc, queries: make(map[string]bool), fields: make(map[string]*field)}
+	for _, name := range queries {
+		s.queries[name] = true
+	}
+	for _, m := range doc.Methods {
+		f := &field{name: fieldName(m.Name), method: m, query: s.isQuery(queries, m.Name)}
+		if params := doc.Resolve(m.Params); params != nil && params.Type == "object" && params.Properties != nil {
+			f.args = append([]string{}, params.Members()...)
+		}
+		s.fields[f.name] = f
+	}
+	return s
+}
+
+func (s *Schema) isQuery(queries []string, method string) bool {
+	if queries != nil {
+		return s.queries[method]
+	}
+	for _, prefix := range queryPrefixes {
+		if strings.HasPrefix(method, prefix) && len(method) > len(prefix) && method[len(prefix)] >= 'A' && method[len(prefix)] <= 'Z' {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName returns the GraphQL field name of a method, "multiply" for
+// Multiply.
+func fieldName(method string) string {
+	return strings.ToLower(method[:1]) + method[1:]
+}
+
+// SDL returns the schema in the GraphQL schema definition language.
+func (s *Schema) SDL() string {
+	w := &sdlWriter{doc: s.doc, emitted: make(map[string]bool)}
+	var queries, mutations []string
+	for _, m := range s.doc.Methods {
+		f := s.fields[fieldName(m.Name)]
+		line := "  " + f.name + w.arguments(f) + ": " + w.typeOf(m.Result, false)
+		if f.query {
+			queries = append(queries, line)
+		} else {
+			mutations = append(mutations, line)
+		}
+	}
+	if len(queries) == 0 {
+		queries = append(queries, "  _service: String!")
+	}
+	var b strings.Builder
+	b.WriteString("type Query {\n" + strings.Join(queries, "\n") + "\n}\n")
+	if len(mutations) > 0 {
+		b.WriteString("\ntype Mutation {\n" + strings.Join(mutations, "\n") + "\n}\n")
+	}
+	for len(w.pending) > 0 {
+		def := w.pending[0]
+		w.pending = w.pending[1:]
+		b.WriteString("\n" + w.definition(def))
+	}
+	if w.json {
+		b.WriteString("\nscalar JSON\n")
+	}
+	return b.String()
+}
+
+// sdlWriter collects the types referenced by the fields.
+type sdlWriter struct {
+	doc     *introspect.Document
+	emitted map[string]bool
+	pending []definition
+	json    bool // the JSON scalar is used
+}
+
+// definition is a named struct of the document, as an output or input type.
+type definition struct {
+	name  string
+	input bool
+}
+
+func (w *sdlWriter) arguments(f *field) string {
+	params := w.doc.Resolve(f.method.Params)
+	if params == nil || params.Type == "null" || f.args != nil && len(f.args) == 0 {
+		return ""
+	}
+	if f.args == nil {
+		return "(input: " + w.typeOf(f.method.Params, true) + ")"
+	}
+	args := make([]string, 0, len(f.args))
+	for _, name := range f.args {
+		// Members left out are zero, arguments are all optional.
+		args = append(args, name+": "+strings.TrimSuffix(w.typeOf(params.Properties[name], true), "!"))
+	}
+	return "(" + strings.Join(args, ", ") + ")"
+}
+
+// typeOf returns the GraphQL type of a schema, of an input value when input is
+// set.
+func (w *sdlWriter) typeOf(s *introspect.Schema, input bool) string {
+	t := w.baseType(s, input)
+	if s != nil && !s.Nullable && t != "" {
+		t += "!"
+	}
+	return t
+}
+
+func (w *sdlWriter) baseType(s *introspect.Schema, input bool) string {
+	if s == nil {
+		return "JSON"
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		def := definition{name: name, input: input}
+		if input {
+			name += "Input"
+		}
+		if !w.emitted[name] {
+			w.emitted[name] = true
+			w.pending = append(w.pending, def)
+		}
+		return name
+	}
+	switch s.Type {
+	case "boolean", "null":
+		return "Boolean"
+	case "integer":
+		return "Int"
+	case "number":
+		return "Float"
+	case "string":
+		return "String"
+	case "array":
+		return "[" + w.typeOf(s.Items, input) + "]"
+	}
+	w.json = true
+	return "JSON"
+}
+
+func (w *sdlWriter) definition(def definition) string {
+	s := w.doc.Definitions[def.name]
+	kind, name := "type", def.name
+	if def.input {
+		kind, name = "input", def.name+"Input"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s {\n", kind, name)
+	for _, member := range s.Members() {
+		fmt.Fprintf(&b, "  %s: %s\n", member, w.typeOf(s.Properties[member], def.input))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
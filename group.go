@@ -0,0 +1,124 @@
+package rpcserver
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RegisterGroup serves the methods of receiver in the group of the name,
+// beside the methods of the service: as "name.Method", e.g. "arith.Multiply",
+// called at the path /rpc/arith.Multiply, or /rpc/arith/Multiply with the
+// PathTemplate "/rpc/{method}". Names of groups may be nested, such as
+// "billing.invoices". The receiver follows the rules of NewServer, its name
+// is the one of the group. Groups are kept by RegisterService, and a group
+// registered again replaces the previous one.
+//
+// Groups may be registered while the server is handling requests.
+func (s *Server) RegisterGroup(name string, receiver interface{}) error {
+	service, err := NewRpcService(receiver)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	groups := make(map[string]*RpcServiceMethod, len(reg.groups)+len(service.methods))
+	for key, m := range reg.groups {
+		if !strings.HasPrefix(key, name+".") || strings.Contains(key[len(name)+1:], ".") {
+			groups[key] = m
+		}
+	}
+	for methodName, m := range service.methods {
+		m.rcvr = service.rcvr
+		for _, codec := range reg.codecs {
+			precompileMethod(codec, m)
+		}
+		groups[name+"."+methodName] = m
+	}
+	reg.groups = groups
+	s.registry.Store(&reg)
+	return nil
+}
+
+// GroupMethodNames returns the names of the methods of the groups in sorted
+// order, see RegisterGroup.
+func (s *Server) GroupMethodNames() []string {
+	reg := s.current()
+	names := make([]string, 0, len(reg.groups))
+	for name := range reg.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pathMethod returns the name of the method called at the path, see
+// PathTemplate. It is empty when the path does not match the template.
+func (s *Server) pathMethod(path string) string {
+	method, _ := s.matchPath(path)
+	return method
+}
+
+// matchPath returns the name of the method called at the path and the values
+// of the {name} segments of the PathTemplate. The method is empty when the
+// path does not match the template.
+func (s *Server) matchPath(path string) (string, map[string]string) {
+	if !strings.Contains(s.PathTemplate, "{method}") {
+		return LastPart(path), nil
+	}
+	template := strings.Split(s.PathTemplate, "/")
+	segments := strings.Split(path, "/")
+	k := 0
+	for !strings.Contains(template[k], "{method}") {
+		k++
+	}
+	after := len(template) - k - 1
+	if len(segments) < len(template) {
+		return "", nil
+	}
+	var params map[string]string
+	match := func(t, segment string) bool {
+		if len(t) < 3 || t[0] != '{' || t[len(t)-1] != '}' {
+			return t == segment
+		}
+		if segment == "" {
+			return false
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[t[1:len(t)-1]] = segment
+		return true
+	}
+	for i := 0; i < k; i++ {
+		if !match(template[i], segments[i]) {
+			return "", nil
+		}
+	}
+	for i := 0; i < after; i++ {
+		if !match(template[k+1+i], segments[len(segments)-after+i]) {
+			return "", nil
+		}
+	}
+	// The method spans the segments between, with the prefix and the suffix
+	// of its own segment.
+	method := strings.Join(segments[k:len(segments)-after], "/")
+	i := strings.Index(template[k], "{method}")
+	prefix, suffix := template[k][:i], template[k][i+len("{method}"):]
+	if len(method) <= len(prefix)+len(suffix) || !strings.HasPrefix(method, prefix) || !strings.HasSuffix(method, suffix) {
+		return "", nil
+	}
+	return strings.Replace(method[len(prefix):len(method)-len(suffix)], "/", ".", -1), params
+}
+
+// PathMethod returns the name of the method called by r, the one resolved
+// from its path by the PathTemplate of the server serving it, or the last
+// segment of its path outside of servers. Codecs check the method of the
+// requests against it.
+func PathMethod(r *http.Request) string {
+	if method, ok := MethodFromContext(r.Context()); ok {
+		return method
+	}
+	return LastPart(r.URL.Path)
+}
@@ -0,0 +1,50 @@
+package rpcserver
+
+// HTTP headers shared by the server, the client and the middleware packages.
+const (
+	// DeadlineHeader carries the time left to the client for the call, in the
+	// grpc-timeout format: a positive integer of at most 8 digits followed by
+	// a unit, H, M, S, m, u or n, e.g. "250m" for 250 milliseconds.
+	DeadlineHeader = "X-RPC-Deadline"
+
+	// IdempotencyKeyHeader carries a client generated key identifying a logical
+	// call, so retried attempts of the same call can be deduplicated.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// TraceparentHeader carries the W3C trace context of the request,
+	// "00-<trace id>-<parent span id>-<flags>".
+	TraceparentHeader = "traceparent"
+
+	// B3Header carries the B3 trace context of the request in the single
+	// header encoding, "<trace id>-<span id>-<sampled>-<parent span id>". The
+	// multiple header encoding, X-B3-TraceId and such, is accepted too.
+	B3Header = "b3"
+
+	// SchemaVersionHeader declares the version of the args of the request,
+	// e.g. "1" for the params of a method before its first Migrator. See
+	// Server.RegisterMigrators.
+	SchemaVersionHeader = "X-RPC-Schema-Version"
+
+	// SignatureHeader carries the signature of a response body, see
+	// Server.Signer.
+	SignatureHeader = "X-RPC-Signature"
+
+	// TimestampHeader and NonceHeader carry the time a signed request was
+	// sent, in Unix seconds, and a value unique to the request, see the
+	// rpcsign package.
+	TimestampHeader = "X-RPC-Timestamp"
+	NonceHeader     = "X-RPC-Nonce"
+
+	// CallbackHeader carries the URL the result of an asynchronous call is
+	// posted to, see the rpcjobs package.
+	CallbackHeader = "X-RPC-Callback"
+
+	// RequestIDHeader carries an identifier of the request, echoed in errors
+	// and logs.
+	RequestIDHeader = "X-Request-Id"
+
+	// AffinityHeader carries the affinity key of a call, in responses as a
+	// routing hint and in requests for the load balancer to honor, see
+	// AffinityOf.
+	AffinityHeader = "X-RPC-Affinity"
+)
@@ -0,0 +1,101 @@
+package rpcserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// RegisterImplementation registers receiver as an implementation of the
+// service under name: a receiver of another type serving the same methods,
+// such as a mock of the receiver of the service. The calls whose
+// Call.Implementation is set to name by a middleware, e.g. by
+// SelectImplementation from a header, the tenant or the version of the API of
+// the request, are served by the implementation.
+//
+// Every method of the service must be a method of receiver of the same
+// signature. RegisterService drops the implementations of the previous
+// service. Implementations may be registered while the server is handling
+// requests.
+func (s *Server) RegisterImplementation(name string, receiver interface{}) error {
+	rcvr := reflect.ValueOf(receiver)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	methods := make(map[string]*RpcServiceMethod, len(reg.service.methods))
+	for methodName, m := range reg.service.methods {
+		spec, ok := rcvr.Type().MethodByName(methodName)
+		if !ok {
+			return fmt.Errorf("rpc: implementation %q of %s lacks method %q", name, reg.service.name, methodName)
+		}
+		if !sameSignature(spec.Type, m.method.Type) {
+			return fmt.Errorf("rpc: method %q of implementation %q is %s rather than %s", methodName, name, spec.Type, m.method.Type)
+		}
+		impl := *m
+		impl.method, impl.rcvr = spec, rcvr
+		methods[methodName] = &impl
+	}
+	implementations := make(map[string]map[string]*RpcServiceMethod, len(reg.implementations)+1)
+	for key, impl := range reg.implementations {
+		implementations[key] = impl
+	}
+	implementations[name] = methods
+	reg.implementations = implementations
+	s.registry.Store(&reg)
+	return nil
+}
+
+// sameSignature returns true if the method types take and return the same
+// types, besides their receiver.
+func sameSignature(a, b reflect.Type) bool {
+	if a.NumIn() != b.NumIn() || a.NumOut() != b.NumOut() || a.IsVariadic() != b.IsVariadic() {
+		return false
+	}
+	for i := 1; i < a.NumIn(); i++ {
+		if a.In(i) != b.In(i) {
+			return false
+		}
+	}
+	for i := 0; i < a.NumOut(); i++ {
+		if a.Out(i) != b.Out(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// implementation returns the method of the call: the one of its
+// implementation when it names one, the one of the service otherwise.
+// Builtins have no other implementation.
+func (reg *registry) implementation(call *Call, m *RpcServiceMethod) (*RpcServiceMethod, error) {
+	if call.Implementation == "" || m.rcvr.IsValid() {
+		return m, nil
+	}
+	methods, ok := reg.implementations[call.Implementation]
+	if !ok {
+		return nil, fmt.Errorf("rpc: unknown implementation %q", call.Implementation)
+	}
+	return methods[call.Method], nil
+}
+
+// SelectImplementation returns the middleware serving the calls with the
+// implementation named by choose for their request, the receiver of the
+// service when it returns an empty name. For instance, to serve the calls of
+// a test tenant with a mock:
+//
+//	server.RegisterImplementation("mock", new(MockArith))
+//	server.Use(rpcserver.SelectImplementation(func(r *http.Request) string {
+//		if r.Header.Get("X-Tenant") == "test" {
+//			return "mock"
+//		}
+//		return ""
+//	}))
+func SelectImplementation(choose func(r *http.Request) string) Middleware {
+	return func(next CallFunc) CallFunc {
+		return func(ctx context.Context, call *Call) error {
+			call.Implementation = choose(call.Request)
+			return next(ctx, call)
+		}
+	}
+}
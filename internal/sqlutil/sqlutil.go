@@ -0,0 +1,28 @@
+// Package sqlutil holds the helpers shared by the SQL stores of the module.
+package sqlutil
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Query formats the statement with the name of the table, replacing its ?
+// placeholders with the $1, $2... ones of PostgreSQL when numbered.
+func Query(format, table string, numbered bool) string {
+	stmt := fmt.Sprintf(format, table)
+	if !numbered {
+		return stmt
+	}
+	var b []byte
+	n := 0
+	for i := 0; i < len(stmt); i++ {
+		if stmt[i] == '?' {
+			n++
+			b = append(b, '$')
+			b = strconv.AppendInt(b, int64(n), 10)
+			continue
+		}
+		b = append(b, stmt[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,15 @@
+package sqlutil
+
+import (
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	format := "UPDATE %s SET record = ?, expires = ? WHERE id = ?"
+	if q := Query(format, "records", false); q != "UPDATE records SET record = ?, expires = ? WHERE id = ?" {
+		t.Errorf("unexpected query %q", q)
+	}
+	if q := Query(format, "records", true); q != "UPDATE records SET record = $1, expires = $2 WHERE id = $3" {
+		t.Errorf("unexpected query %q", q)
+	}
+}
@@ -0,0 +1,146 @@
+package introspect
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Change is a breaking change between two versions of a Document.
+type Change struct {
+	Method string `json:"method"`
+
+	// Path locates the changed value in the params or the result of the
+	// method, e.g. "params.Items[].ID". It is empty for removed methods.
+	Path string `json:"path,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (c Change) String() string {
+	if c.Path == "" {
+		return c.Method + ": " + c.Message
+	}
+	return c.Method + ": " + c.Path + ": " + c.Message
+}
+
+// Compare returns the changes of newDoc breaking the clients of oldDoc, sorted
+// by method: removed methods, removed or renamed members, type changes and
+// results becoming nullable. Added methods and params members are compatible,
+// as clients sending no members get their zero values.
+func Compare(oldDoc, newDoc *Document) []Change {
+	var changes []Change
+	for _, old := range oldDoc.Methods {
+		m := newDoc.Method(old.Name)
+		if m == nil {
+			changes = append(changes, Change{Method: old.Name, Message: "method removed"})
+			continue
+		}
+		c := &comparer{oldDoc: oldDoc, newDoc: newDoc, method: old.Name, seen: make(map[[2]string]bool)}
+		c.compare("params", old.Params, m.Params, false)
+		c.compare("result", old.Result, m.Result, true)
+		changes = append(changes, c.changes...)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Method < changes[j].Method
+	})
+	return changes
+}
+
+// comparer compares the schemas of a method.
+type comparer struct {
+	oldDoc, newDoc *Document
+	method         string
+	seen           map[[2]string]bool // compared definitions, for recursive types
+	changes        []Change
+}
+
+func (c *comparer) report(path, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{Method: c.method, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// compare compares the schemas at path, of a result when reply is set.
+func (c *comparer) compare(path string, old, new *Schema, reply bool) {
+	var key [2]string
+	if old != nil && new != nil {
+		key = [2]string{old.Ref, new.Ref}
+	}
+	oldNullable := old != nil && old.Nullable
+	newNullable := new != nil && new.Nullable
+	old, new = c.oldDoc.Resolve(old), c.newDoc.Resolve(new)
+	if old == nil || new == nil {
+		return
+	}
+	if old.Type != new.Type || old.Format != new.Format {
+		c.report(path, "type changed from %s to %s", typeName(old), typeName(new))
+		return
+	}
+	if reply && newNullable && !oldNullable {
+		c.report(path, "may now be null")
+	}
+	if key[0] != "" && key[1] != "" {
+		if c.seen[key] {
+			return
+		}
+		c.seen[key] = true
+	}
+	if old.Items != nil {
+		c.compare(path+"[]", old.Items, new.Items, reply)
+	}
+	if old.AdditionalProperties != nil {
+		c.compare(path+"{}", old.AdditionalProperties, new.AdditionalProperties, reply)
+	}
+	var removed, added []string
+	for _, name := range old.Members() {
+		if _, ok := new.Properties[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for _, name := range new.Members() {
+		if _, ok := old.Properties[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	if renamed := c.renamed(removed, added, old, new); renamed != "" {
+		c.report(path+"."+removed[0], "member renamed to %s", renamed)
+	} else {
+		for _, name := range removed {
+			c.report(path+"."+name, "member removed")
+		}
+	}
+	for _, name := range old.Members() {
+		if s, ok := new.Properties[name]; ok {
+			c.compare(path+"."+name, old.Properties[name], s, reply)
+		}
+	}
+}
+
+// renamed returns the added member of new a single removed member of old was
+// likely renamed to: the only added member of the same type.
+func (c *comparer) renamed(removed, added []string, old, new *Schema) string {
+	if len(removed) != 1 {
+		return ""
+	}
+	from := c.oldDoc.Resolve(old.Properties[removed[0]])
+	var to string
+	for _, name := range added {
+		s := c.newDoc.Resolve(new.Properties[name])
+		if from != nil && s != nil && s.Type == from.Type && s.Format == from.Format {
+			if to != "" {
+				return ""
+			}
+			to = name
+		}
+	}
+	return to
+}
+
+func typeName(s *Schema) string {
+	name := s.Type
+	if name == "" {
+		name = "any"
+	}
+	if s.Format != "" {
+		name += " (" + s.Format + ")"
+	}
+	return name
+}
@@ -0,0 +1,81 @@
+package introspect
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ArgsV1 struct {
+	A, B int
+}
+
+type QuotientV1 struct {
+	Quo, Rem int
+	At       time.Time
+	Next     *QuotientV1
+}
+
+type ArithV1 int
+
+func (t *ArithV1) Divide(r *http.Request, args *ArgsV1, quo *QuotientV1) error {
+	return nil
+}
+
+func (t *ArithV1) Multiply(r *http.Request, args *ArgsV1, reply *int) error {
+	return nil
+}
+
+type ArgsV2 struct {
+	A, Divisor int
+	Round      bool
+}
+
+type QuotientV2 struct {
+	Quo  string
+	Rem  int
+	At   *time.Time
+	Next *QuotientV2
+	Tags []string
+}
+
+type ArithV2 int
+
+func (t *ArithV2) Divide(r *http.Request, args *ArgsV2, quo *QuotientV2) error {
+	return nil
+}
+
+func (t *ArithV2) Add(r *http.Request, args *ArgsV2, reply *int) error {
+	return nil
+}
+
+func describe(t *testing.T, receiver interface{}) *Document {
+	service, err := rpcserver.NewRpcService(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Describe(service)
+}
+
+func TestCompare(t *testing.T) {
+	v1, v2 := describe(t, new(ArithV1)), describe(t, new(ArithV2))
+	if changes := Compare(v1, v1); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	var got []string
+	for _, change := range Compare(v1, v2) {
+		got = append(got, change.String())
+	}
+	expected := []string{
+		"Divide: params.B: member renamed to Divisor",
+		"Divide: result.Quo: type changed from integer to string",
+		"Divide: result.At: may now be null",
+		"Multiply: method removed",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,283 @@
+// Package introspect describes the methods of an rpcserver.RpcService and the
+// shapes of their args and replies as a JSON document, for tooling such as
+// mock servers, explorers and compatibility checks.
+package introspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/decimal"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	typeOfTime       = reflect.TypeOf(time.Time{})
+	typeOfDecimal    = reflect.TypeOf(decimal.Decimal{})
+	typeOfRawMessage = reflect.TypeOf(json.RawMessage{})
+	typeOfMarshaler  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// Document describes a service.
+type Document struct {
+	// Service is the name of the service.
+	Service string `json:"service"`
+
+	Description string `json:"description,omitempty"`
+
+	// Methods are sorted by name.
+	Methods []*Method `json:"methods"`
+
+	// Definitions holds the schemas of named struct types, referenced by
+	// Schema.Ref as "#/definitions/<name>".
+	Definitions map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// Method describes a method of the service.
+type Method struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Params      *Schema `json:"params"`
+	Result      *Schema `json:"result"`
+}
+
+// Schema is the subset of JSON Schema needed to describe Go types encoded
+// with encoding/json.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Order                []string           `json:"x-order,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	// Description and Example document struct fields, from their rpc tag,
+	// see rpcserver.FieldTag. Example is read as JSON unless the field is a
+	// string, the example tag sets it too.
+	Description string      `json:"description,omitempty"`
+	Example     interface{} `json:"example,omitempty"`
+
+	// Enum lists the values accepted by a struct field or its elements, see
+	// rpcserver.EnumOf.
+	Enum []interface{} `json:"enum,omitempty"`
+}
+
+// Method returns the described method or nil.
+func (doc *Document) Method(name string) *Method {
+	for _, m := range doc.Methods {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Resolve follows the reference of a schema into the definitions.
+func (doc *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = doc.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+	}
+	return s
+}
+
+// Members returns the member names of an object schema in their order, the
+// Order of the fields when complete, sorted otherwise.
+func (s *Schema) Members() []string {
+	if len(s.Order) == len(s.Properties) {
+		return s.Order
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns the Document of a service.
+func Describe(service *rpcserver.RpcService) *Document {
+	doc := &Document{
+		Service:     service.Name(),
+		Description: service.Description(),
+		Definitions: make(map[string]*Schema),
+	}
+	d := &describer{doc: doc, names: make(map[reflect.Type]string)}
+	for _, name := range service.MethodNames() {
+		m, _ := service.Get(name)
+		doc.Methods = append(doc.Methods, &Method{
+			Name:        name,
+			Description: m.Description(),
+			Params:      d.schema(m.ArgsType()),
+			Result:      d.schema(m.ReplyType()),
+		})
+	}
+	return doc
+}
+
+// Handler serves the Document as JSON.
+func Handler(doc *Document) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(doc)
+	})
+}
+
+// describer builds schemas, collecting named structs into the definitions.
+type describer struct {
+	doc   *Document
+	names map[reflect.Type]string
+}
+
+func (d *describer) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{Type: "null"}
+	}
+	if t == typeOfTime {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if t == typeOfDecimal {
+		return &Schema{Type: "string", Format: "decimal"}
+	}
+	if t.Kind() != reflect.Ptr && (t == typeOfRawMessage || t.Implements(typeOfMarshaler) || reflect.PtrTo(t).Implements(typeOfMarshaler)) {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Ptr:
+		s := d.schema(t.Elem())
+		if s.Ref != "" {
+			return &Schema{Ref: s.Ref, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		return d.structSchema(t)
+	}
+	return &Schema{}
+}
+
+// structSchema describes a struct inline, or as a definition for named types.
+func (d *describer) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		d.fields(s, t)
+		return s
+	}
+	if name, ok := d.names[t]; ok {
+		return &Schema{Ref: "#/definitions/" + name}
+	}
+
+	name := t.Name()
+	for i := 2; d.doc.Definitions[name] != nil; i++ {
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	d.names[t] = name
+	d.doc.Definitions[name] = s
+	d.fields(s, t)
+	return &Schema{Ref: "#/definitions/" + name}
+}
+
+// fields adds the struct members following the encoding/json rules.
+func (d *describer) fields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		ft := field.Type
+		if field.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				d.fields(s, ft)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fs := d.schema(field.Type)
+		if strings.Contains(opts, "string") {
+			fs = &Schema{Type: "string"}
+		}
+		if enum := rpcserver.EnumOf(field); enum != nil {
+			fs = withEnum(fs, enum)
+		}
+		if example, ok := field.Tag.Lookup("example"); ok {
+			fs = withExample(fs, example)
+		}
+		if tag := rpcserver.ParseFieldTag(field.Tag.Get("rpc")); tag.Description != "" || tag.Example != "" {
+			if tag.Example != "" {
+				fs = withExample(fs, tag.Example)
+			}
+			if tag.Description != "" {
+				c := *fs
+				c.Description = tag.Description
+				fs = &c
+			}
+		}
+		s.Properties[name] = fs
+		s.Order = append(s.Order, name)
+	}
+}
+
+// withEnum returns a copy of s accepting the values, or of its items.
+func withEnum(s *Schema, enum []interface{}) *Schema {
+	c := *s
+	if c.Type == "array" && c.Items != nil {
+		c.Items = withEnum(c.Items, enum)
+	} else {
+		c.Enum = enum
+	}
+	return &c
+}
+
+// withExample returns a copy of s with the example.
+func withExample(s *Schema, example string) *Schema {
+	c := *s
+	c.Example = example
+	var v interface{}
+	if s.Type != "string" && json.Unmarshal([]byte(example), &v) == nil {
+		c.Example = v
+	}
+	return &c
+}
+
+// jsonName splits the json tag of a field into the name and the options.
+func jsonName(field reflect.StructField) (string, string) {
+	tag := field.Tag.Get("json")
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tag[idx+1:]
+	}
+	return tag, ""
+}
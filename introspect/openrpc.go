@@ -0,0 +1,131 @@
+package introspect
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// OpenRPCVersion is the version of the OpenRPC specification of OpenRPC
+// documents.
+const OpenRPCVersion = "1.2.6"
+
+// OpenRPC is an OpenRPC document, see https://spec.open-rpc.org.
+type OpenRPC struct {
+	OpenRPC    string            `json:"openrpc"`
+	Info       OpenRPCInfo       `json:"info"`
+	Methods    []*OpenRPCMethod  `json:"methods"`
+	Components OpenRPCComponents `json:"components"`
+}
+
+// OpenRPCInfo describes the service of an OpenRPC document.
+type OpenRPCInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenRPCMethod describes a method of an OpenRPC document.
+type OpenRPCMethod struct {
+	Name           string                      `json:"name"`
+	Description    string                      `json:"description,omitempty"`
+	ParamStructure string                      `json:"paramStructure,omitempty"`
+	Params         []*OpenRPCContentDescriptor `json:"params"`
+	Result         *OpenRPCContentDescriptor   `json:"result,omitempty"`
+}
+
+// OpenRPCContentDescriptor describes a param or a result.
+type OpenRPCContentDescriptor struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// OpenRPCComponents holds the schemas referenced by the methods, as
+// "#/components/schemas/<name>".
+type OpenRPCComponents struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// OpenRPC returns the OpenRPC document of the document, of the version of
+// the service. Methods taking an args struct have a param per member, by
+// name; methods taking several params have them by position.
+func (doc *Document) OpenRPC(version string) *OpenRPC {
+	o := &OpenRPC{
+		OpenRPC: OpenRPCVersion,
+		Info:    OpenRPCInfo{Title: doc.Service, Description: doc.Description, Version: version},
+		Methods: make([]*OpenRPCMethod, 0, len(doc.Methods)),
+	}
+	if len(doc.Definitions) > 0 {
+		o.Components.Schemas = make(map[string]*Schema, len(doc.Definitions))
+		for name, s := range doc.Definitions {
+			o.Components.Schemas[name] = openRPCSchema(s)
+		}
+	}
+	for _, m := range doc.Methods {
+		om := &OpenRPCMethod{Name: m.Name, Description: m.Description, Params: []*OpenRPCContentDescriptor{}}
+		params := doc.Resolve(m.Params)
+		switch {
+		case params == nil || params.Type == "null":
+		case params.Type == "object" && params.Properties != nil:
+			om.ParamStructure = "by-name"
+			if isPositional(params) {
+				om.ParamStructure = "by-position"
+			}
+			for _, name := range params.Members() {
+				p := params.Properties[name]
+				om.Params = append(om.Params, &OpenRPCContentDescriptor{
+					Name:        name,
+					Description: p.Description,
+					Schema:      openRPCSchema(p),
+				})
+			}
+		default:
+			om.ParamStructure = "by-position"
+			om.Params = append(om.Params, &OpenRPCContentDescriptor{Name: "params", Required: true, Schema: openRPCSchema(m.Params)})
+		}
+		if m.Result != nil && m.Result.Type != "null" {
+			om.Result = &OpenRPCContentDescriptor{Name: "result", Schema: openRPCSchema(m.Result)}
+		}
+		o.Methods = append(o.Methods, om)
+	}
+	return o
+}
+
+// isPositional tells if an args schema is the struct of a method taking
+// several params, of members P0, P1 and so on.
+func isPositional(s *Schema) bool {
+	return s.Ref == "" && len(s.Order) > 0 && s.Order[0] == "P0"
+}
+
+// openRPCSchema returns a copy of s referencing the components.
+func openRPCSchema(s *Schema) *Schema {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	if c.Ref != "" {
+		c.Ref = "#/components/schemas/" + strings.TrimPrefix(c.Ref, "#/definitions/")
+	}
+	c.Items = openRPCSchema(s.Items)
+	c.AdditionalProperties = openRPCSchema(s.AdditionalProperties)
+	if s.Properties != nil {
+		c.Properties = make(map[string]*Schema, len(s.Properties))
+		for name, p := range s.Properties {
+			c.Properties[name] = openRPCSchema(p)
+		}
+	}
+	return &c
+}
+
+// OpenRPCHandler serves the OpenRPC document as JSON, as the rpc.discover
+// method of the OpenRPC service discovery would.
+func OpenRPCHandler(doc *OpenRPC) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(doc)
+	})
+}
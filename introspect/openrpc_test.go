@@ -0,0 +1,55 @@
+package introspect
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"testing"
+)
+
+type DivideArgs struct {
+	A int `rpc:"desc=Dividend,example=10"`
+	B int `rpc:"desc='Divisor, not zero'"`
+}
+
+type Calc int
+
+func (c *Calc) Describe() map[string]string {
+	return map[string]string{"": "A calculator", "Divide": "Divides A by B"}
+}
+
+func (c *Calc) Divide(r *http.Request, args *DivideArgs, reply *int) error {
+	return nil
+}
+
+func (c *Calc) Add(r *http.Request, a, b int) (int, error) {
+	return a + b, nil
+}
+
+func TestOpenRPC(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Calc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := Describe(service)
+	args := doc.Definitions["DivideArgs"]
+	if doc.Description != "A calculator" || doc.Method("Divide").Description != "Divides A by B" ||
+		args.Properties["A"].Description != "Dividend" || args.Properties["A"].Example != 10.0 {
+		t.Fatalf("expected the descriptions and the examples, got %+v %+v", doc, args.Properties["A"])
+	}
+
+	o := doc.OpenRPC("1.0.0")
+	if o.OpenRPC != OpenRPCVersion || o.Info.Title != "Calc" || o.Info.Description != "A calculator" || len(o.Methods) != 2 {
+		t.Fatalf("unexpected document %+v", o)
+	}
+	add, divide := o.Methods[0], o.Methods[1]
+	if add.ParamStructure != "by-position" || len(add.Params) != 2 || add.Result.Schema.Type != "integer" {
+		t.Errorf("unexpected Add %+v", add)
+	}
+	if divide.ParamStructure != "by-name" || len(divide.Params) != 2 ||
+		divide.Params[1].Name != "B" || divide.Params[1].Description != "Divisor, not zero" {
+		t.Errorf("unexpected Divide %+v", divide)
+	}
+	if o.Components.Schemas["DivideArgs"] == nil {
+		t.Errorf("expected the components, got %+v", o.Components)
+	}
+}
@@ -0,0 +1,113 @@
+package rpcserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Networks is a list of IP networks, such as the trusted proxies or the
+// allowed clients of a server.
+type Networks []*net.IPNet
+
+// ParseNetworks parses networks in CIDR notation, e.g. "10.0.0.0/8", or
+// single addresses.
+func ParseNetworks(values ...string) (Networks, error) {
+	networks := make(Networks, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("rpc: invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("rpc: invalid network %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains tells if the address is in one of the networks.
+func (n Networks) Contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client of r: the host of its remote
+// address, unless it is one of the trusted proxies. The addresses forwarded
+// by the Forwarded header, or else X-Forwarded-For, are then read from the
+// last one: the client is the first address not trusted. The forwarding
+// headers of the clients not trusted are ignored, they could forge them.
+func clientAddr(r *http.Request, trusted Networks) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !trusted.Contains(addr) {
+		return addr
+	}
+	chain := forwardedFor(r.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr = chain[i]
+		if !trusted.Contains(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// forwardedFor returns the addresses of the Forwarded header of RFC 7239, or
+// of the X-Forwarded-For header when there isn't one, from the client.
+func forwardedFor(h http.Header) []string {
+	var chain []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				if host, _, err := net.SplitHostPort(value); err == nil {
+					value = host
+				}
+				chain = append(chain, strings.Trim(value, "[]"))
+			}
+		}
+		return chain
+	}
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			chain = append(chain, strings.TrimSpace(addr))
+		}
+	}
+	return chain
+}
+
+// admitAddr returns the error refusing a client address, nil if it is
+// allowed.
+func (s *Server) admitAddr(addr string) error {
+	if s.DeniedIPs.Contains(addr) || s.AllowedIPs != nil && !s.AllowedIPs.Contains(addr) {
+		return fmt.Errorf("rpc: client address %s is not allowed", addr)
+	}
+	return nil
+}
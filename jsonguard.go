@@ -0,0 +1,328 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrPayloadTooComplex is returned for request bodies over the MaxDepth,
+// MaxArrayLen, MaxStringLen or MaxKeys limits, see Limits.
+var ErrPayloadTooComplex = errors.New("rpc: payload too complex")
+
+// ErrDuplicateKey is returned for request bodies with an object holding a key
+// twice, when Server.StrictJSON is set. The keys of the objects decoded into
+// structs are compared case-insensitively, as encoding/json matches them to
+// the fields: "Name" and "name" set the same field.
+var ErrDuplicateKey = errors.New("rpc: duplicate key")
+
+// jsonGuard checks the JSON read from a request body against the limits and
+// for duplicate keys as it is read, so the codecs decode nothing past a
+// violation. The first violation fails the reads.
+type jsonGuard struct {
+	io.ReadCloser
+	limits *limits
+	strict bool // reject duplicate keys
+
+	// args is the args type of the method and params the member of the
+	// top-level object holding the params, see ParamsMember, when strict.
+	args   reflect.Type
+	params string
+
+	stack   []jsonFrame // open arrays and objects
+	quoted  bool        // in a string
+	key     bool        // the string is an object key
+	escaped bool        // after a backslash in a string
+	hex     int         // hex digits of a \u escape left
+	runes   int         // length of the string
+	raw     []byte      // quoted key, when strict
+	err     error
+}
+
+// jsonFrame is an open array or object.
+type jsonFrame struct {
+	object bool
+	count  int             // number of items or keys
+	key    bool            // a key is expected next
+	name   string          // last key, when strict
+	keys   map[string]bool // keys seen, when strict
+
+	typ    reflect.Type      // type the value is decoded into, nil if unknown, when strict
+	fields map[string]string // keys seen by folded field name, in struct objects
+}
+
+// GuardJSON returns body checked as the body of r is, against the limits of
+// the JSON structure and for duplicate keys when Server.StrictJSON is set,
+// for the codecs decoding the JSON they extract from the body, such as
+// decrypted params. The violations fail the reads, and are answered as the
+// ones of the body. It returns body as is unless the server guards the body
+// of r.
+func GuardJSON(r *http.Request, body io.ReadCloser) io.ReadCloser {
+	info, ok := r.Context().Value(callInfoKey{}).(*callInfo)
+	if !ok || info.guard == nil {
+		return body
+	}
+	g := info.guard
+	*g = jsonGuard{ReadCloser: body, limits: g.limits, strict: g.strict, args: g.args, params: g.params, err: g.err}
+	return g
+}
+
+// guardsJSON tells if any limit of the JSON structure is set.
+func (l *limits) guardsJSON() bool {
+	return l.MaxDepth > 0 || l.MaxArrayLen > 0 || l.MaxStringLen > 0 || l.MaxKeys > 0
+}
+
+func (g *jsonGuard) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	n, err := g.ReadCloser.Read(p)
+	for _, b := range p[:n] {
+		if g.scan(b); g.err != nil {
+			return 0, g.err
+		}
+	}
+	return n, err
+}
+
+// scan advances the state with the next byte of the body.
+func (g *jsonGuard) scan(b byte) {
+	if g.quoted {
+		g.scanString(b)
+		return
+	}
+	switch b {
+	case ' ', '\t', '\n', '\r':
+	case ':':
+		if len(g.stack) > 0 {
+			g.stack[len(g.stack)-1].key = false
+		}
+	case '"':
+		g.value()
+		g.quoted, g.runes = true, 0
+		g.key = len(g.stack) > 0 && g.stack[len(g.stack)-1].key
+		if g.key && g.strict {
+			g.raw = append(g.raw[:0], b)
+		}
+	case '{', '[':
+		g.value()
+		var typ reflect.Type
+		if g.strict {
+			typ = g.valueType()
+		}
+		g.stack = append(g.stack, jsonFrame{object: b == '{', key: b == '{', typ: typ})
+		if g.limits.MaxDepth > 0 && len(g.stack) > g.limits.MaxDepth {
+			g.fail(ErrPayloadTooComplex, "nesting depth exceeds %d", g.limits.MaxDepth)
+		}
+	case '}', ']':
+		if len(g.stack) > 0 {
+			g.stack = g.stack[:len(g.stack)-1]
+		}
+	case ',':
+		if len(g.stack) > 0 {
+			top := &g.stack[len(g.stack)-1]
+			top.key = top.object
+			g.count(1)
+		}
+	default:
+		g.value()
+	}
+}
+
+// scanString advances the state with the next byte of a string.
+func (g *jsonGuard) scanString(b byte) {
+	if g.key && g.strict {
+		g.raw = append(g.raw, b)
+	}
+	switch {
+	case g.hex > 0:
+		g.hex--
+		return
+	case g.escaped:
+		g.escaped = false
+		if b == 'u' {
+			g.hex = 4
+		}
+	case b == '\\':
+		g.escaped = true
+		return
+	case b == '"':
+		g.quoted = false
+		if g.key && g.strict {
+			g.addKey()
+		}
+		return
+	case b&0xC0 == 0x80:
+		return // continuation byte of a rune
+	}
+	if g.runes++; g.limits.MaxStringLen > 0 && g.runes > g.limits.MaxStringLen {
+		if g.key {
+			g.fail(ErrPayloadTooComplex, "key length exceeds %d", g.limits.MaxStringLen)
+		} else {
+			g.fail(ErrPayloadTooComplex, "string length exceeds %d", g.limits.MaxStringLen)
+		}
+	}
+}
+
+// value notes the start of a value, or of a key.
+func (g *jsonGuard) value() {
+	if len(g.stack) > 0 && g.stack[len(g.stack)-1].count == 0 {
+		g.count(1)
+	}
+}
+
+// count adds n items or keys to the innermost array or object.
+func (g *jsonGuard) count(n int) {
+	top := &g.stack[len(g.stack)-1]
+	top.count += n
+	if top.object && g.limits.MaxKeys > 0 && top.count > g.limits.MaxKeys {
+		g.fail(ErrPayloadTooComplex, "object key count exceeds %d", g.limits.MaxKeys)
+	} else if !top.object && g.limits.MaxArrayLen > 0 && top.count > g.limits.MaxArrayLen {
+		g.fail(ErrPayloadTooComplex, "array length exceeds %d", g.limits.MaxArrayLen)
+	}
+}
+
+// addKey records the key just read in the innermost object. Keys are
+// compared unescaped, so "\u0061" and "a" are the same key.
+func (g *jsonGuard) addKey() {
+	var name string
+	if json.Unmarshal(g.raw, &name) != nil {
+		return // the decoding reports the invalid string
+	}
+	top := &g.stack[len(g.stack)-1]
+	if top.keys[name] {
+		g.fail(ErrDuplicateKey, "%s", g.path(name))
+		return
+	}
+	if top.keys == nil {
+		top.keys = make(map[string]bool)
+	}
+	top.keys[name] = true
+	top.name = name
+	if top.typ == nil || top.typ.Kind() != reflect.Struct {
+		return
+	}
+	folded := foldName(name)
+	if _, ok := structFields(top.typ)[folded]; !ok {
+		return
+	}
+	if first, ok := top.fields[folded]; ok {
+		g.fail(ErrDuplicateKey, "%s", g.path(name)+", the field of "+strconv.Quote(first))
+		return
+	}
+	if top.fields == nil {
+		top.fields = make(map[string]string)
+	}
+	top.fields[folded] = name
+}
+
+// valueType returns the type the value starting is decoded into, nil if it
+// is unknown.
+func (g *jsonGuard) valueType() reflect.Type {
+	if len(g.stack) == 0 {
+		return nil
+	}
+	top := &g.stack[len(g.stack)-1]
+	if len(g.stack) == 1 && top.object && g.params != "" && top.name == g.params {
+		return indirect(g.args)
+	}
+	if top.typ == nil {
+		return nil
+	}
+	switch top.typ.Kind() {
+	case reflect.Struct:
+		if top.object {
+			return indirect(structFields(top.typ)[foldName(top.name)])
+		}
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return indirect(top.typ.Elem())
+	}
+	return nil
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+var jsonFields sync.Map // reflect.Type -> map[string]reflect.Type
+
+// structFields returns the types of the fields of the struct type t decoded
+// from JSON, promoted fields included, by folded name.
+func structFields(t reflect.Type) map[string]reflect.Type {
+	if fields, ok := jsonFields.Load(t); ok {
+		return fields.(map[string]reflect.Type)
+	}
+	fields := make(map[string]reflect.Type)
+	addStructFields(t, fields)
+	jsonFields.Store(t, fields)
+	return fields
+}
+
+func addStructFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("json")
+		if idx := strings.Index(name, ","); idx != -1 {
+			name = name[:idx]
+		}
+		if name == "-" {
+			continue
+		}
+		if ft := indirect(field.Type); field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addStructFields(ft, fields) // promoted fields
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if _, ok := fields[foldName(name)]; !ok {
+			fields[foldName(name)] = field.Type
+		}
+	}
+}
+
+// foldName folds the case of a key as encoding/json does to match it to the
+// name of a field.
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToUpper(unicode.ToLower(r))
+	}, name)
+}
+
+// path returns the path of the key in the innermost object, e.g.
+// params.items[1].name.
+func (g *jsonGuard) path(key string) string {
+	var path strings.Builder
+	for _, frame := range g.stack[:len(g.stack)-1] {
+		if !frame.object {
+			path.WriteString("[" + strconv.Itoa(frame.count-1) + "]")
+			continue
+		}
+		if path.Len() > 0 {
+			path.WriteByte('.')
+		}
+		path.WriteString(frame.name)
+	}
+	if path.Len() > 0 {
+		path.WriteByte('.')
+	}
+	path.WriteString(key)
+	return strconv.Quote(key) + " at " + path.String()
+}
+
+func (g *jsonGuard) fail(err error, format string, arg interface{}) {
+	g.err = fmt.Errorf("%w: "+format, err, arg)
+}
@@ -7,10 +7,18 @@
 package jsonrpc2
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/datalinkE/rpcserver"
+	"io"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 var null = json.RawMessage([]byte("null"))
@@ -63,6 +71,14 @@ type serverResponse struct {
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
 	RespectNotifyMessages bool
+
+	// EmbeddedStructs tells how embedded structs of replies are encoded,
+	// flattened by default.
+	EmbeddedStructs EmbedMode
+
+	// NilFields tells how nil pointer and interface fields of replies are
+	// encoded, as null by default.
+	NilFields NilMode
 }
 
 // NewCodec creates a Codec object.
@@ -72,14 +88,105 @@ func NewCodec() *Codec {
 	}
 }
 
+// ParamsMember returns "params", the member of the requests holding the
+// params.
+func (c *Codec) ParamsMember() string {
+	return "params"
+}
+
+// WriteErrorResponse writes a transport-level error as a JSON-RPC response
+// with a null id, keeping the HTTP status.
+func (c *Codec) WriteErrorResponse(w http.ResponseWriter, r *http.Request, res *rpcserver.ErrorResponse) {
+	code := res.Code
+	if code == 0 {
+		code = transportErrorCode(res.Status)
+	}
+	var data interface{}
+	if res.Details != nil || res.RequestID != "" {
+		data = map[string]interface{}{
+			"status":    res.Status,
+			"details":   res.Details,
+			"requestId": res.RequestID,
+		}
+	}
+	response := &serverResponse{
+		Version: Version,
+		Error:   &Error{Code: code, Message: res.Message, Data: data},
+		Id:      &null,
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Precompile prepares the decoding and encoding plans of the struct types
+// reachable from t.
+func (c *Codec) Precompile(t reflect.Type) {
+	precompile(t)
+}
+
+// transportErrorCode maps an HTTP status to the closest JSON-RPC error code.
+func transportErrorCode(status int) int {
+	switch status {
+	case 404:
+		return E_NO_METHOD
+	case 405, 413, 415:
+		return E_INVALID_REQ
+	}
+	return E_SERVER
+}
+
 // ----------------------------------------------------------------------------
 // CodecRequest
 // ----------------------------------------------------------------------------
 
 // NewRequest returns a CodecRequest. Decode the request body and check if RPC signature is valid.
+//
+// The body is decoded as a stream: when the jsonrpc and method members come
+// first, the params are decoded from the body straight into the args by
+// ReadRequest, instead of being buffered.
 func (c *Codec) NewRequest(r *http.Request) rpcserver.CodecRequest {
+	req, dec, err := decodeRequest(r.URL.Path, rpcserver.PathMethod(r), r.Body)
+	var body io.Closer = r.Body
+	if dec == nil {
+		r.Body.Close()
+		body = nil
+	}
+	formats := rpcserver.FormatsFromContext(r.Context())
+	engine := rpcserver.JSONEngineFromContext(r.Context())
+	return &CodecRequest{
+		request:               req,
+		err:                   err,
+		dec:                   dec,
+		body:                  body,
+		respectNotifyMessages: c.RespectNotifyMessages,
+		decoding:              decoding{formats: formats, engine: engine},
+		encoder:               replyEncoder{embed: c.EmbeddedStructs, nils: c.NilFields, formats: formats, engine: engine},
+		ctx:                   r.Context(),
+	}
+}
+
+// Decode parses and checks a request body sent to path the same way as
+// NewRequest, returning the method name. It is an entry point for fuzzing.
+func Decode(path string, body []byte) (string, error) {
+	req, dec, err := decodeRequest(path, rpcserver.LastPart(path), bytes.NewReader(body))
+	if dec != nil {
+		var params json.RawMessage
+		if err = dec.Decode(&params); err == nil {
+			_, err = readMembers(dec, req, false)
+		}
+	}
+	return req.Method, err
+}
+
+// decodeRequest reads a request from body and checks its RPC signature, and
+// that it calls the method of its path. The returned decoder is positioned at
+// the params value when they're left to be read, it is nil when the whole
+// request has been read.
+func decodeRequest(path, pathMethod string, body io.Reader) (*serverRequest, *json.Decoder, error) {
 	req := new(serverRequest)
-	err := json.NewDecoder(r.Body).Decode(req)
+	dec := json.NewDecoder(body)
+	pending, err := readObject(dec, req)
 	if err != nil {
 		err = NewError(E_PARSE, err.Error(), req)
 	} else if req.Version != Version {
@@ -87,20 +194,76 @@ func (c *Codec) NewRequest(r *http.Request) rpcserver.CodecRequest {
 	} else if req.Method == "" {
 		err = NewError(E_NO_METHOD, "method field empty or missing", req)
 	} else {
-		pathMethod := rpcserver.LastPart(r.URL.Path)
 		if pathMethod != req.Method {
-			err = NewError(E_NO_METHOD, fmt.Sprintf("rpc: URL.Path '%v' does not end with method Name '%v'", r.URL.Path, req.Method), req)
+			err = NewError(E_NO_METHOD, fmt.Sprintf("rpc: URL.Path '%v' does not end with method Name '%v'", path, req.Method), req)
 		}
 	}
-	r.Body.Close()
-	return &CodecRequest{request: req, err: err, respectNotifyMessages: c.RespectNotifyMessages}
+	if err != nil || !pending {
+		return req, nil, err
+	}
+	return req, dec, nil
+}
+
+// readObject reads the opening of the request object and its members, see
+// readMembers.
+func readObject(dec *json.Decoder, req *serverRequest) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	if tok != json.Delim('{') {
+		return false, fmt.Errorf("rpc: request must be a JSON object, received %v", tok)
+	}
+	return readMembers(dec, req, true)
+}
+
+// readMembers reads the request members up to the end of the object. Member
+// names match case-insensitively, as with encoding/json. When stream is set and
+// the version and the method are known, it stops before the params value and
+// returns true.
+func readMembers(dec *json.Decoder, req *serverRequest, stream bool) (bool, error) {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		key, _ := tok.(string)
+		switch {
+		case strings.EqualFold(key, "jsonrpc"):
+			err = dec.Decode(&req.Version)
+		case strings.EqualFold(key, "method"):
+			err = dec.Decode(&req.Method)
+		case strings.EqualFold(key, "id"):
+			req.Id = nil
+			err = dec.Decode(&req.Id)
+		case strings.EqualFold(key, "params"):
+			if stream && req.Version != "" && req.Method != "" {
+				return true, nil
+			}
+			req.Params = nil
+			err = dec.Decode(&req.Params)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err := dec.Token()
+	return false, err
 }
 
 // CodecRequest decodes and encodes a single request.
 type CodecRequest struct {
 	request               *serverRequest
 	err                   error
+	dec                   *json.Decoder // positioned at the params until they are read
+	body                  io.Closer     // request body, closed once read
 	respectNotifyMessages bool
+	decoding              decoding
+	encoder               replyEncoder
+	ctx                   context.Context // request context, for the migrations
 }
 
 // Error returns if request was valid or incorrect.
@@ -129,33 +292,275 @@ func (c *CodecRequest) Method() (string, error) {
 // absence of expected names MAY result in an error being
 // generated. The names MUST match exactly, including
 // case, to the method's expected parameters.
+//
+// By-position values are assigned to the exported fields of the args struct
+// in declaration order, a last field tagged rpc:"variadic" collects the
+// remaining values. An array holding a single object is decoded as the
+// by-name params.
 func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil {
+		migrate := rpcserver.MigrationFromContext(c.ctx, c.request.Method)
+		rules := rpcserver.ParamsRulesOf(reflect.TypeOf(args).Elem())
+		if migrate != nil || rules != nil {
+			return c.readBuffered(args, migrate, rules)
+		}
+	}
+	if c.err == nil && c.dec != nil {
+		err := readParams(c.dec, args, c.decoding)
+		c.err = c.finish(err)
+		return c.err
+	}
 	if c.err == nil && c.request.Params != nil {
 		// Note: if c.request.Params is nil it's not an error, it's an optional member.
-		// JSON params structured object. Unmarshal to the args object.
-		if err := json.Unmarshal(*c.request.Params, args); err != nil {
-			// Clearly JSON params is not a structured object,
-			// fallback and attempt an unmarshal with JSON params as
-			// array value and RPC params is struct. Unmarshal into
-			// array containing the request struct.
-			params := [1]interface{}{args}
-			if err = json.Unmarshal(*c.request.Params, &params); err != nil {
-				c.err = &Error{
-					Code:    E_INVALID_REQ,
-					Message: err.Error(),
-					Data:    c.request.Params,
-				}
+		if err := readRaw(*c.request.Params, args, c.decoding); err != nil {
+			c.err = &Error{
+				Code:    E_INVALID_REQ,
+				Message: err.Error(),
+				Data:    c.request.Params,
 			}
 		}
 	}
 	return c.err
 }
 
+// readBuffered buffers the params to upgrade them to the current version of
+// the args with migrate and check them against the rules, when set, before
+// decoding them.
+func (c *CodecRequest) readBuffered(args interface{}, migrate func(json.RawMessage) (json.RawMessage, error), rules *rpcserver.ParamsRules) error {
+	var params json.RawMessage
+	if c.dec != nil {
+		if c.err = c.finish(c.dec.Decode(&params)); c.err != nil {
+			return c.err
+		}
+	} else if c.request.Params != nil {
+		params = *c.request.Params
+	} else if rules == nil {
+		return nil
+	}
+	var err error
+	if migrate != nil && params != nil {
+		params, err = migrate(params)
+	}
+	if err == nil && rules != nil && len(params) > 0 && params[0] == '[' {
+		params, err = preparePositional(params, args, rules)
+	} else if err == nil && rules != nil {
+		params, err = rules.Prepare(params)
+	}
+	if err == nil && len(params) > 0 {
+		err = readRaw(params, args, c.decoding)
+	}
+	if err != nil {
+		c.err = paramsError(err)
+	}
+	return c.err
+}
+
+// paramsError returns the JSON-RPC error of params which can't be read.
+func paramsError(err error) *Error {
+	if !errors.Is(err, rpcserver.ErrInvalidParams) {
+		return &Error{Code: E_INVALID_REQ, Message: err.Error()}
+	}
+	res := &Error{Code: E_BAD_PARAMS, Message: err.Error()}
+	var invalid *rpcserver.ParamsError
+	if errors.As(err, &invalid) {
+		res.Data = invalid.Errors
+	}
+	return res
+}
+
+// finish reads the members following the params once they have been read and
+// closes the body. It returns the JSON-RPC error of err, the error reading the
+// params, or of the error reading the remaining members.
+func (c *CodecRequest) finish(err error) error {
+	if c.dec == nil {
+		return nil
+	}
+	dec := c.dec
+	c.dec = nil
+	defer c.body.Close()
+
+	syntax := isSyntaxError(err)
+	if !syntax {
+		// The params value has been consumed, read on to the id.
+		if _, errRead := readMembers(dec, c.request, false); errRead != nil && err == nil {
+			err, syntax = errRead, true
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	code := E_INVALID_REQ
+	if syntax {
+		code = E_PARSE
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// skip reads the rest of the request when the params haven't been read.
+func (c *CodecRequest) skip() {
+	if c.dec != nil {
+		var params json.RawMessage
+		c.finish(c.dec.Decode(&params))
+	}
+}
+
+func isSyntaxError(err error) bool {
+	_, ok := err.(*json.SyntaxError)
+	return ok || err == io.ErrUnexpectedEOF
+}
+
+// readParams decodes the params value the decoder is positioned at into
+// args. The params are decoded straight from the stream when the first byte
+// of the value is buffered, telling how to decode them.
+func readParams(dec *json.Decoder, args interface{}, d decoding) error {
+	kind := reflect.ValueOf(args).Elem().Kind()
+	switch peek(dec) {
+	case '[':
+		if kind == reflect.Slice || kind == reflect.Array || kind == reflect.Interface {
+			return decodeValue(dec, args, d)
+		}
+		var values []json.RawMessage
+		if err := dec.Decode(&values); err != nil {
+			return err
+		}
+		return assignPositional(values, args, d)
+	case 0:
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		return readRaw(raw, args, d)
+	}
+	return decodeValue(dec, args, d)
+}
+
+// peek returns the first byte of the next value buffered by the decoder, or 0
+// if it hasn't been read from the body yet.
+func peek(dec *json.Decoder) byte {
+	buffered, ok := dec.Buffered().(io.ByteReader)
+	if !ok {
+		return 0
+	}
+	for {
+		b, err := buffered.ReadByte()
+		if err != nil {
+			return 0
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', ':':
+			continue
+		}
+		return b
+	}
+}
+
+// decodeValue decodes the next value of the decoder into v.
+func decodeValue(dec *json.Decoder, v interface{}, d decoding) error {
+	if d.isDefault() {
+		return dec.Decode(v)
+	}
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	return d.unmarshal(raw, v)
+}
+
+// readRaw decodes buffered params into args.
+func readRaw(data json.RawMessage, args interface{}, d decoding) error {
+	// JSON params structured object. Unmarshal to the args object.
+	if err := d.unmarshal(data, args); err != nil {
+		// Clearly JSON params is not a structured object,
+		// fallback and attempt an unmarshal with JSON params as
+		// array value.
+		return readPositional(data, args, d)
+	}
+	return nil
+}
+
+// readPositional decodes by-position params into args.
+func readPositional(data json.RawMessage, args interface{}, d decoding) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	return assignPositional(values, args, d)
+}
+
+// preparePositional applies the rules to the by-position params of args, the
+// ones of an array holding a single object being the by-name params.
+func preparePositional(params json.RawMessage, args interface{}, rules *rpcserver.ParamsRules) (json.RawMessage, error) {
+	var values []json.RawMessage
+	if json.Unmarshal(params, &values) != nil {
+		return params, nil // the decoding reports the mismatch
+	}
+	t := reflect.TypeOf(args).Elem()
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		return rules.Prepare(params) // the array is the value of args
+	}
+	if len(values) == 1 && (t.Kind() != reflect.Struct || bytes.HasPrefix(bytes.TrimSpace(values[0]), []byte("{"))) {
+		return rules.Prepare(values[0])
+	}
+	if t.Kind() != reflect.Struct {
+		return params, nil
+	}
+	plan := planOf(t)
+	names := make([]string, len(plan.fields))
+	for i, fp := range plan.fields {
+		if fp.flatten {
+			return nil, fmt.Errorf("%w: by-position params can't be checked against the rules of the fields of %s, send them by name", rpcserver.ErrInvalidParams, t.Field(fp.index).Name)
+		}
+		names[i] = fp.name
+	}
+	values, err := rules.PreparePositional(values, names, plan.variadic)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(values)
+}
+
+// assignPositional decodes the by-position values into args.
+func assignPositional(values []json.RawMessage, args interface{}, d decoding) error {
+	v := reflect.ValueOf(args).Elem()
+	if len(values) == 1 && (v.Kind() != reflect.Struct || bytes.HasPrefix(bytes.TrimSpace(values[0]), []byte("{"))) {
+		// Array containing the request struct.
+		return d.unmarshal(values[0], args)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("rpc: expected 1 param, received %d", len(values))
+	}
+
+	plan := planOf(v.Type())
+	fields, variadic := plan.positional, plan.variadic
+	if len(values) > len(fields) && !variadic {
+		return fmt.Errorf("rpc: expected at most %d params, received %d", len(fields), len(values))
+	}
+	for i, value := range values {
+		if variadic && i >= len(fields)-1 {
+			rest := v.Field(fields[len(fields)-1])
+			elem := reflect.New(rest.Type().Elem())
+			if err := d.unmarshal(value, elem.Interface()); err != nil {
+				return fmt.Errorf("rpc: param %d: %v", i, err)
+			}
+			rest.Set(reflect.Append(rest, elem.Elem()))
+			continue
+		}
+		if err := d.unmarshal(value, v.Field(fields[i]).Addr().Interface()); err != nil {
+			return fmt.Errorf("rpc: param %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
 // WriteResponse encodes the response and writes it to the ResponseWriter.
 func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	if reply == nil {
+		// The result member is required on success.
+		reply = null
+	}
 	res := &serverResponse{
 		Version: Version,
-		Result:  reply,
+		Result:  c.encoder.encode(reply),
 		Id:      c.request.Id,
 	}
 	c.writeServerResponse(w, res)
@@ -163,7 +568,18 @@ func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
 
 func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
 	jsonErr, ok := err.(*Error)
-	if !ok {
+	var regionErr *rpcserver.RegionError
+	if !ok && errors.As(err, &regionErr) {
+		jsonErr = &Error{Code: E_WRONG_REGION, Message: err.Error(), Data: regionErr}
+	} else if !ok && errors.Is(err, rpcserver.ErrDeadlineExceeded) {
+		jsonErr = &Error{Code: E_DEADLINE_EXCEEDED, Message: err.Error()}
+	} else if !ok && errors.Is(err, rpcserver.ErrFeatureDisabled) {
+		jsonErr = &Error{Code: E_FEATURE_DISABLED, Message: err.Error()}
+	} else if !ok && errors.Is(err, rpcserver.ErrPreconditionFailed) {
+		jsonErr = &Error{Code: E_PRECONDITION_FAILED, Message: err.Error()}
+	} else if !ok && errors.Is(err, rpcserver.ErrInvalidParams) {
+		jsonErr = paramsError(err)
+	} else if !ok {
 		jsonErr = &Error{
 			Code:    status,
 			Message: err.Error(),
@@ -178,17 +594,60 @@ func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error)
 }
 
 func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, res *serverResponse) {
+	// The id may follow params left unread.
+	c.skip()
+	res.Id = c.request.Id
+
 	// Id is null for notifications and they don't have a response.
 	if c.request.Id == nil && c.respectNotifyMessages {
 		return
 	}
 
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+	if err := c.encode(buf, res); err != nil {
+		// The reply can't be encoded, answer with an error instead.
+		buf.Reset()
+		res = &serverResponse{
+			Version: Version,
+			Error:   &Error{Code: E_INTERNAL, Message: "rpc: cannot encode response: " + err.Error()},
+			Id:      res.Id,
+		}
+		if err := c.encode(buf, res); err != nil {
+			rpcserver.WriteError(w, 500, err.Error())
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	encoder := json.NewEncoder(w)
-	err := encoder.Encode(res)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
 
-	// Not sure in which case will this happen. But seems harmless.
-	if err != nil {
-		rpcserver.WriteError(w, 400, err.Error())
+// encode writes the response to buf, followed by a newline.
+func (c *CodecRequest) encode(buf *bytes.Buffer, res *serverResponse) error {
+	if engine := c.encoder.engine; engine != nil {
+		data, err := engine.Marshal(res)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return buf.WriteByte('\n')
+	}
+	return json.NewEncoder(buf).Encode(res)
+}
+
+// bufferPool holds the buffers responses are encoded into.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer is the capacity above which buffers are left to the garbage
+// collector, so a few large responses don't pin memory.
+const maxPooledBuffer = 64 << 10
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buf.Reset()
+		bufferPool.Put(buf)
 	}
 }
@@ -0,0 +1,79 @@
+package jsonrpc2
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type streamArgs struct {
+	A, B int
+}
+
+func TestStreamingRequest(t *testing.T) {
+	for _, tc := range []struct {
+		body, expected string
+	}{
+		{`{"jsonrpc": "2.0", "method": "Add", "params": {"A": 1, "B": 2}, "id": 7}`, `{"jsonrpc":"2.0","result":{"A":1,"B":2},"id":7}`},
+		{`{"jsonrpc": "2.0", "method": "Add", "params": [1, 2], "id": "x"}`, `{"jsonrpc":"2.0","result":{"A":1,"B":2},"id":"x"}`},
+		{`{"params": {"A": 1}, "id": 1, "method": "Add", "jsonrpc": "2.0"}`, `{"jsonrpc":"2.0","result":{"A":1,"B":0},"id":1}`},
+		{`{"jsonrpc": "2.0", "method": "Add", "params": {"A": "x"}, "id": 2}`, `"code":-32600`},
+		{`{"jsonrpc": "2.0", "method": "Add", "params": {"A": 1,,}, "id": 3}`, `"code":-32700`},
+		{`{"jsonrpc": "2.0", "method": "Add", "params": {"A": 1}, "id": 4`, `"code":-32700`},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Add", strings.NewReader(tc.body))
+		codecReq := NewCodec().NewRequest(r)
+		w := httptest.NewRecorder()
+		args := new(streamArgs)
+		if err := codecReq.ReadRequest(args); err != nil {
+			codecReq.WriteError(w, 400, err)
+		} else {
+			codecReq.WriteResponse(w, args)
+		}
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.body, tc.expected, w.Body.String())
+		}
+	}
+}
+
+// endless is a body whose params never end.
+type endless struct {
+	prefix io.Reader
+}
+
+func (e *endless) Read(p []byte) (int, error) {
+	if n, err := e.prefix.Read(p); err != io.EOF {
+		return n, err
+	}
+	for i := range p {
+		p[i] = '1'
+	}
+	return len(p), nil
+}
+
+func TestEarlySyntaxError(t *testing.T) {
+	body := &endless{strings.NewReader(`{"jsonrpc": "2.0", "method": "Add", "params": {"A": ]`)}
+	r := httptest.NewRequest("POST", "/rpc/Add", body)
+	codecReq := NewCodec().NewRequest(r)
+	err := codecReq.ReadRequest(new(streamArgs))
+	if e, ok := err.(*Error); !ok || e.Code != E_PARSE {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+}
+
+func TestUnencodableReply(t *testing.T) {
+	r := httptest.NewRequest("POST", "/rpc/Add", strings.NewReader(`{"jsonrpc": "2.0", "method": "Add", "id": 1}`))
+	codecReq := NewCodec().NewRequest(r)
+	w := httptest.NewRecorder()
+	codecReq.WriteResponse(w, map[string]interface{}{"ch": make(chan int)})
+
+	expected := `{"jsonrpc":"2.0","error":{"code":-32603,"message":"rpc: cannot encode response: json: unsupported type: chan int"},"id":1}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(len(expected)) {
+		t.Errorf("unexpected Content-Length %q", w.Header().Get("Content-Length"))
+	}
+}
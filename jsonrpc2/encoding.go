@@ -0,0 +1,370 @@
+package jsonrpc2
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmbedMode tells how the fields of embedded structs are encoded in replies.
+type EmbedMode int
+
+const (
+	// EmbedFlatten promotes the fields of embedded structs into the outer
+	// object, as encoding/json does.
+	EmbedFlatten EmbedMode = iota
+
+	// EmbedNest encodes an embedded struct as a member named after its type,
+	// or after its json tag.
+	EmbedNest
+)
+
+// NilMode tells how nil pointer and interface fields are encoded in replies.
+type NilMode int
+
+const (
+	// NilNull encodes nil fields as null, as encoding/json does.
+	NilNull NilMode = iota
+
+	// NilOmit leaves nil fields out of the object.
+	NilOmit
+)
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfDuration      = reflect.TypeOf(time.Duration(0))
+	typeOfUnmarshaler   = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	typeOfMarshaler     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// replyEncoder rewrites replies according to the encoding options of a Codec
+// and the rpcserver.Formats of the request.
+type replyEncoder struct {
+	embed   EmbedMode
+	nils    NilMode
+	formats rpcserver.Formats
+	engine  rpcserver.JSONEngine // nil for encoding/json
+}
+
+// isDefault tells if replies can be given to encoding/json as they are.
+func (e replyEncoder) isDefault() bool {
+	return e.embed == EmbedFlatten && e.nils == NilNull && e.formats.IsDefault()
+}
+
+// encode returns reply converted into values encoding/json encodes with the
+// options applied.
+func (e replyEncoder) encode(reply interface{}) interface{} {
+	if e.isDefault() {
+		return reply
+	}
+	return e.value(reflect.ValueOf(reply))
+}
+
+func (e replyEncoder) value(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Type() {
+	case typeOfTime:
+		return e.formats.EncodeTime(v.Interface().(time.Time))
+	case typeOfDuration:
+		return e.formats.EncodeDuration(time.Duration(v.Int()))
+	}
+	if marshaler(v.Type()) {
+		return v.Interface()
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() && marshaler(v.Elem().Type()) {
+		// Keep the pointer, the methods may have a pointer receiver.
+		return v.Interface()
+	}
+	if v.Kind() == reflect.Struct && marshaler(reflect.PtrTo(v.Type())) {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Int64:
+		if e.formats.Int64AsString {
+			return strconv.FormatInt(v.Int(), 10)
+		}
+	case reflect.Uint64:
+		if e.formats.Int64AsString {
+			return strconv.FormatUint(v.Uint(), 10)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return e.value(v.Elem())
+	case reflect.Struct:
+		return e.object(v)
+	case reflect.Map:
+		if v.IsNil() || !stringKeys(v.Type().Key()) {
+			return v.Interface()
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			m[fmt.Sprint(key.Interface())] = e.value(v.MapIndex(key))
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = e.value(v.Index(i))
+		}
+		return items
+	}
+	return v.Interface()
+}
+
+// object encodes a struct following the encoding/json field rules: fields of
+// the outer struct win over promoted fields, conflicting promoted fields at the
+// same depth are left out.
+func (e replyEncoder) object(v reflect.Value) object {
+	members := e.members(v, 0, nil)
+	depth := make(map[string]int, len(members))
+	count := make(map[string]int, len(members))
+	for _, m := range members {
+		if d, ok := depth[m.name]; !ok || m.depth < d {
+			depth[m.name], count[m.name] = m.depth, 0
+		}
+		if m.depth == depth[m.name] {
+			count[m.name]++
+		}
+	}
+	obj := make(object, 0, len(members))
+	for _, m := range members {
+		if m.depth == depth[m.name] && count[m.name] == 1 {
+			obj = append(obj, m)
+		}
+	}
+	return obj
+}
+
+// members appends the encoded fields of a struct found at depth to obj.
+func (e replyEncoder) members(v reflect.Value, depth int, obj object) object {
+	for _, fp := range planOf(v.Type()).fields {
+		fv := v.Field(fp.index)
+		if fp.flatten && e.embed == EmbedFlatten {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			obj = e.members(fv, depth+1, obj)
+			continue
+		}
+		if fp.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		if e.nils == NilOmit && (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface) && fv.IsNil() {
+			continue
+		}
+		var value interface{}
+		if fp.quoted && quotable(fv) {
+			data, _ := json.Marshal(fv.Interface())
+			value = string(data)
+		} else {
+			value = e.value(fv)
+		}
+		obj = append(obj, member{name: fp.name, value: value, depth: depth})
+	}
+	return obj
+}
+
+// member is a named value of an object.
+type member struct {
+	name  string
+	value interface{}
+	depth int // depth of the field among embedded structs
+}
+
+// object is a JSON object keeping the order of its members.
+type object []member
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(m.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decoding holds the options of decoding args.
+type decoding struct {
+	formats rpcserver.Formats
+	engine  rpcserver.JSONEngine // nil for encoding/json
+}
+
+// isDefault tells if args can be decoded by encoding/json as they are.
+func (d decoding) isDefault() bool {
+	return d.engine == nil && d.formats.IsDefault()
+}
+
+// unmarshal decodes data into v accepting the rpcserver.Formats of times,
+// durations and large integers.
+func (d decoding) unmarshal(data []byte, v interface{}) error {
+	engine := d.engine
+	if engine == nil {
+		engine = rpcserver.StdJSON
+	}
+	if d.formats.IsDefault() {
+		return engine.Unmarshal(data, v)
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return err
+	}
+	normalized, err := json.Marshal(normalize(generic, reflect.TypeOf(v), d.formats))
+	if err != nil {
+		return err
+	}
+	return engine.Unmarshal(normalized, v)
+}
+
+// normalize rewrites a generic JSON value to be decoded into type t, reading
+// times and durations in the formats accepted by formats. Values that can't be
+// read are kept, for encoding/json to report them. Strings are accepted for
+// int64 and uint64 values with Int64AsString.
+func normalize(x interface{}, t reflect.Type, formats rpcserver.Formats) interface{} {
+	switch t {
+	case typeOfTime:
+		if tm, err := formats.DecodeTime(x); err == nil {
+			return tm.Format(time.RFC3339Nano)
+		}
+		return x
+	case typeOfDuration:
+		if d, err := formats.DecodeDuration(x); err == nil {
+			return int64(d)
+		}
+		return x
+	}
+	if reflect.PtrTo(t).Implements(typeOfUnmarshaler) {
+		return x
+	}
+
+	switch t.Kind() {
+	case reflect.Int64, reflect.Uint64:
+		if s, ok := x.(string); ok && formats.Int64AsString {
+			return json.Number(strings.TrimSpace(s))
+		}
+	case reflect.Ptr:
+		return normalize(x, t.Elem(), formats)
+	case reflect.Struct:
+		obj, ok := x.(map[string]interface{})
+		if !ok {
+			return x
+		}
+		for key, value := range obj {
+			if ft := planOf(t).memberType(key); ft != nil {
+				obj[key] = normalize(value, ft, formats)
+			}
+		}
+	case reflect.Map:
+		if obj, ok := x.(map[string]interface{}); ok {
+			for key, value := range obj {
+				obj[key] = normalize(value, t.Elem(), formats)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if items, ok := x.([]interface{}); ok {
+			for i, item := range items {
+				items[i] = normalize(item, t.Elem(), formats)
+			}
+		}
+	}
+	return x
+}
+
+func marshaler(t reflect.Type) bool {
+	return t.Implements(typeOfMarshaler) || t.Implements(typeOfTextMarshaler)
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// stringKeys tells if the map keys are encoded as strings by fmt.Sprint the way
+// encoding/json encodes them.
+func stringKeys(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return !marshaler(t)
+	}
+	return false
+}
+
+func hasOption(opts string, option string) bool {
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// quotable tells if the ",string" json option applies to the value.
+func quotable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isEmpty reports the values left out by the ",omitempty" json option.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
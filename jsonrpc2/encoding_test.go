@@ -0,0 +1,66 @@
+package jsonrpc2
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Base struct {
+	ID      int
+	Created time.Time
+}
+
+type Item struct {
+	Base
+	*Extra
+	Name  string
+	Owner *Item       `json:"owner"`
+	Value interface{} `json:"value"`
+	Note  string      `json:"note,omitempty"`
+}
+
+type Extra struct {
+	Name  string
+	Flags []string
+}
+
+func TestReplyEncoder(t *testing.T) {
+	created := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	item := &Item{Base: Base{ID: 1, Created: created}, Extra: &Extra{Name: "hidden", Flags: []string{"a"}}, Name: "x"}
+	for _, tc := range []struct {
+		encoder  replyEncoder
+		expected string
+	}{
+		{replyEncoder{}, `{"ID":1,"Created":"2017-01-02T03:04:05Z","Flags":["a"],"Name":"x","owner":null,"value":null}`},
+		{replyEncoder{nils: NilOmit}, `{"ID":1,"Created":"2017-01-02T03:04:05Z","Flags":["a"],"Name":"x"}`},
+		{replyEncoder{embed: EmbedNest}, `{"Base":{"ID":1,"Created":"2017-01-02T03:04:05Z"},"Extra":{"Name":"hidden","Flags":["a"]},"Name":"x","owner":null,"value":null}`},
+		{replyEncoder{embed: EmbedNest, nils: NilOmit}, `{"Base":{"ID":1,"Created":"2017-01-02T03:04:05Z"},"Extra":{"Name":"hidden","Flags":["a"]},"Name":"x"}`},
+	} {
+		data, err := json.Marshal(tc.encoder.encode(item))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.expected {
+			t.Errorf("%+v: expected %s, got %s", tc.encoder, tc.expected, data)
+		}
+	}
+}
+
+type Cycle struct {
+	*Cycle
+	V int `json:"v,string"`
+}
+
+func TestPlanCycle(t *testing.T) {
+	precompile(reflect.TypeOf(Cycle{}))
+	plan := planOf(reflect.TypeOf(Cycle{}))
+	if plan.memberType("V") == nil || len(plan.positional) != 2 || !plan.fields[1].quoted {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	data, _ := json.Marshal(replyEncoder{nils: NilOmit}.encode(&Cycle{Cycle: &Cycle{V: 2}, V: 1}))
+	if string(data) != `{"v":"1"}` {
+		t.Errorf("unexpected encoding %s", data)
+	}
+}
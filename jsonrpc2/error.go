@@ -17,6 +17,28 @@ const (
 	E_BAD_PARAMS  = -32602
 	E_INTERNAL    = -32603
 	E_SERVER      = -32000
+
+	// E_DEADLINE_EXCEEDED is the code of calls not done within the deadline
+	// the client set with the rpcserver.DeadlineHeader.
+	E_DEADLINE_EXCEEDED = -32001
+
+	// E_FEATURE_DISABLED is the code of calls of methods disabled by the
+	// rpcserver.Server.Flags.
+	E_FEATURE_DISABLED = -32002
+
+	// E_PRECONDITION_FAILED is the code of calls whose If-Match header matches
+	// no current version of the resource, see
+	// rpcserver.Server.RegisterPrecondition.
+	E_PRECONDITION_FAILED = -32003
+
+	// E_CONFLICT is the code of calls whose rpcserver.IdempotencyKeyHeader
+	// belongs to a call in progress or with other args, see the rpcdedup
+	// package.
+	E_CONFLICT = -32004
+
+	// E_WRONG_REGION is the code of calls of data homed in another region
+	// than the one of the server, with the rpcserver.RegionError as data.
+	E_WRONG_REGION = -32005
 )
 
 var ErrNullResult = errors.New("result is null")
@@ -0,0 +1,47 @@
+package jsonrpc2
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+type fuzzArgs struct {
+	A, B int
+	S    string
+	L    []float64
+	M    map[string]*fuzzArgs
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		`{"jsonrpc": "2.0", "method": "Action", "id": 1, "params": {"A": 5, "B": 2}}`,
+		`{"jsonrpc": "2.0", "method": "Action", "id": "x", "params": [{"A": 5, "M": {"k": {"S": "s"}}}]}`,
+		`{"jsonrpc": "2.0", "method": "Action", "params": [1, 2]}`,
+		`{"jsonrpc": "2.0", "method": "Action", "id": null, "params": null}`,
+		`{"jsonrpc": "2.0", "method": "Action", "params": {"A": 1e400}`,
+		`{"jsonrpc": "1.0"}`,
+		`{}`,
+		`wtf`,
+		``,
+	} {
+		f.Add("/jsonrpc/v1/Action", seed)
+	}
+
+	f.Fuzz(func(t *testing.T, path string, body string) {
+		method, err := Decode(path, []byte(body))
+		if err == nil && method == "" {
+			t.Fatal("valid request without a method")
+		}
+
+		r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+		r.URL.Path = path
+		codecReq := NewCodec().NewRequest(r)
+		args := new(fuzzArgs)
+		if codecReq.ReadRequest(args) == nil {
+			codecReq.WriteResponse(httptest.NewRecorder(), args)
+		}
+		codecReq.WriteError(httptest.NewRecorder(), 400, errors.New("fuzz"))
+	})
+}
@@ -0,0 +1,149 @@
+package jsonrpc2
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structPlan is the precompiled decoding and encoding plan of a struct type,
+// sparing the parsing of struct tags on every request.
+type structPlan struct {
+	// fields lists the fields in declaration order.
+	fields []fieldPlan
+
+	// positional lists the indexes of the fields filled by by-position
+	// params, the last one collects the remaining params when variadic.
+	positional []int
+	variadic   bool
+
+	// byName and byFold map the exact and the lower-cased member names,
+	// promoted ones included, to the field types args are decoded into.
+	byName map[string]reflect.Type
+	byFold map[string]reflect.Type
+}
+
+// fieldPlan describes how a struct field is encoded.
+type fieldPlan struct {
+	index     int
+	name      string // member name, the type name for embedded structs
+	flatten   bool   // embedded struct promoting its fields
+	omitEmpty bool
+	quoted    bool // ",string" option
+}
+
+var plans sync.Map // reflect.Type -> *structPlan
+
+// precompile builds the plans of the struct types reachable from t.
+func precompile(t reflect.Type) {
+	seen := make(map[reflect.Type]bool)
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			walk(t.Elem())
+		case reflect.Struct:
+			for _, field := range planOf(t).fields {
+				walk(t.Field(field.index).Type)
+			}
+		}
+	}
+	walk(t)
+}
+
+// planOf returns the plan of struct type t, building it on first use.
+func planOf(t reflect.Type) *structPlan {
+	if plan, ok := plans.Load(t); ok {
+		return plan.(*structPlan)
+	}
+	plan, _ := plans.LoadOrStore(t, newStructPlan(t))
+	return plan.(*structPlan)
+}
+
+func newStructPlan(t reflect.Type) *structPlan {
+	plan := &structPlan{
+		byName: make(map[string]reflect.Type),
+		byFold: make(map[string]reflect.Type),
+	}
+	plan.fields = fieldPlans(t)
+	for _, fp := range plan.fields {
+		plan.positional = append(plan.positional, fp.index)
+	}
+	plan.addMembers(t, map[reflect.Type]bool{t: true})
+	if n := len(plan.positional); n > 0 {
+		last := t.Field(plan.positional[n-1])
+		plan.variadic = last.Tag.Get("rpc") == "variadic" && last.Type.Kind() == reflect.Slice
+	}
+	return plan
+}
+
+// fieldPlans parses the json tags of the fields of struct type t.
+func fieldPlans(t reflect.Type) []fieldPlan {
+	var fields []fieldPlan
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" {
+			// Values reached through unexported embedded structs can't be
+			// read with reflection, unlike encoding/json they are left out.
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx != -1 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		fp := fieldPlan{
+			index:     i,
+			name:      name,
+			flatten:   field.Anonymous && name == "" && indirect(field.Type).Kind() == reflect.Struct,
+			omitEmpty: hasOption(opts, "omitempty"),
+			quoted:    hasOption(opts, "string"),
+		}
+		if fp.name == "" {
+			fp.name = field.Name
+		}
+		fields = append(fields, fp)
+	}
+	return fields
+}
+
+// addMembers maps the members of struct type t, then the members promoted by
+// its embedded structs not visited yet.
+func (plan *structPlan) addMembers(t reflect.Type, visited map[reflect.Type]bool) {
+	var promoted []reflect.Type
+	for _, fp := range fieldPlans(t) {
+		ft := t.Field(fp.index).Type
+		if !fp.flatten {
+			plan.addMember(fp.name, ft)
+		} else if !visited[indirect(ft)] {
+			visited[indirect(ft)] = true
+			promoted = append(promoted, indirect(ft))
+		}
+	}
+	for _, embedded := range promoted {
+		plan.addMembers(embedded, visited)
+	}
+}
+
+// addMember maps a member name to its type unless a shallower field has it.
+func (plan *structPlan) addMember(name string, t reflect.Type) {
+	if _, ok := plan.byName[name]; !ok {
+		plan.byName[name] = t
+	}
+	if folded := strings.ToLower(name); plan.byFold[folded] == nil {
+		plan.byFold[folded] = t
+	}
+}
+
+// memberType returns the type of the field encoding/json decodes the member
+// into, or nil.
+func (plan *structPlan) memberType(key string) reflect.Type {
+	if t, ok := plan.byName[key]; ok {
+		return t
+	}
+	return plan.byFold[strings.ToLower(key)]
+}
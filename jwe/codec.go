@@ -0,0 +1,164 @@
+package jwe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+)
+
+// Codec decrypts the params of the requests of the codec it wraps and
+// encrypts their results. Requests with plain params are rejected, errors are
+// written in the clear.
+type Codec struct {
+	codec rpcserver.Codec
+
+	decryptKey interface{}
+	encryptKey interface{}
+}
+
+// NewCodec wraps codec, a JSON codec reading the params from the "params"
+// member of a request object such as the one of jsonrpc2. Params are
+// decrypted with decryptKey, the private key of the server. Results are
+// encrypted for encryptKey, the public key of the clients, when set, and
+// written in the clear otherwise.
+func NewCodec(codec rpcserver.Codec, decryptKey, encryptKey interface{}) *Codec {
+	return &Codec{codec: codec, decryptKey: decryptKey, encryptKey: encryptKey}
+}
+
+// NewRequest decrypts the params of the request and passes it to the wrapped
+// codec, the decrypted request being checked as the request bodies are, see
+// rpcserver.GuardJSON.
+func (c *Codec) NewRequest(r *http.Request) rpcserver.CodecRequest {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err == nil {
+		var plain []byte
+		if plain, err = c.decryptParams(body); err == nil {
+			body = plain
+		}
+	}
+	r.Body = rpcserver.GuardJSON(r, ioutil.NopCloser(bytes.NewReader(body)))
+	return &CodecRequest{CodecRequest: c.codec.NewRequest(r), codec: c, err: err}
+}
+
+// errPlainParams is returned for params not sent as a token.
+var errPlainParams = errors.New("jwe: params must be encrypted")
+
+// decryptParams returns the request object of body with its params decrypted,
+// the other members left as is.
+func (c *Codec) decryptParams(body []byte) ([]byte, error) {
+	start, end, ok := paramsSpan(body)
+	params := body[start:end]
+	if !ok || string(params) == "null" {
+		return body, nil // the wrapped codec reports the invalid request
+	}
+	var token string
+	if json.Unmarshal(params, &token) != nil {
+		return nil, errPlainParams
+	}
+	plain, err := Decrypt(token, c.decryptKey)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(plain) {
+		return nil, errors.New("jwe: the decrypted params are not JSON")
+	}
+	decrypted := make([]byte, 0, len(body)-len(params)+len(plain))
+	decrypted = append(decrypted, body[:start]...)
+	decrypted = append(decrypted, plain...)
+	return append(decrypted, body[end:]...), nil
+}
+
+// paramsSpan returns the offsets of the value of the last params member of
+// the request object of body, the one decoded, false if there is none or the
+// object is invalid.
+func paramsSpan(body []byte) (int, int, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return 0, 0, false
+	}
+	start, end := 0, 0
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, 0, false
+		}
+		if key == "params" {
+			end = int(dec.InputOffset())
+			start = end - len(value)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return 0, 0, false
+	}
+	return start, end, end > 0
+}
+
+// ParamsMember returns the member holding the params of the wrapped codec,
+// when it is an rpcserver.ParamsMember, for the checks of the decrypted
+// params.
+func (c *Codec) ParamsMember() string {
+	if p, ok := c.codec.(rpcserver.ParamsMember); ok {
+		return p.ParamsMember()
+	}
+	return ""
+}
+
+// WriteErrorResponse writes transport errors with the wrapped codec when it is
+// an rpcserver.ErrorWriter.
+func (c *Codec) WriteErrorResponse(w http.ResponseWriter, r *http.Request, res *rpcserver.ErrorResponse) {
+	if writer, ok := c.codec.(rpcserver.ErrorWriter); ok {
+		writer.WriteErrorResponse(w, r, res)
+		return
+	}
+	rpcserver.TextErrorWriter{}.WriteErrorResponse(w, r, res)
+}
+
+// Precompile passes t to the wrapped codec when it is an
+// rpcserver.Precompiler.
+func (c *Codec) Precompile(t reflect.Type) {
+	if p, ok := c.codec.(rpcserver.Precompiler); ok {
+		p.Precompile(t)
+	}
+}
+
+// CodecRequest is a request of the wrapped codec with decrypted params.
+type CodecRequest struct {
+	rpcserver.CodecRequest
+	codec *Codec
+	err   error // decryption error
+}
+
+// Error returns the error of the wrapped request, or of the decryption of its
+// params.
+func (c *CodecRequest) Error() error {
+	if err := c.CodecRequest.Error(); err != nil {
+		return err
+	}
+	return c.err
+}
+
+// WriteResponse writes the reply encrypted as a token.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	if c.codec.encryptKey == nil {
+		c.CodecRequest.WriteResponse(w, reply)
+		return
+	}
+	plain, err := json.Marshal(reply)
+	if err == nil {
+		var token string
+		if token, err = Encrypt(plain, c.codec.encryptKey); err == nil {
+			c.CodecRequest.WriteResponse(w, token)
+			return
+		}
+	}
+	c.CodecRequest.WriteError(w, 500, err)
+}
@@ -0,0 +1,146 @@
+// Package jwe encrypts the params and the replies of JSON-RPC calls end to end
+// as JSON Web Encryption compact tokens (RFC 7516), for deployments where TLS
+// terminates at an untrusted edge:
+//
+//	codec := jwe.NewCodec(jsonrpc2.NewCodec(), serverKey, &clientKey.PublicKey)
+//	server.RegisterCodec(codec, "application/json")
+//
+// Clients send the params as a token, "params": "<JWE>", made with Encrypt,
+// and read the result with Decrypt. Keys are an *rsa.PrivateKey or
+// *rsa.PublicKey for the RSA-OAEP-256 key management, or a []byte of 32
+// bytes shared by both sides for the direct one. The content is encrypted
+// with A256GCM.
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDecrypt is returned for tokens which cannot be decrypted with the key.
+var ErrDecrypt = errors.New("jwe: cannot decrypt")
+
+// header is the protected header of a token.
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+}
+
+// Encrypt returns the compact token of the plaintext for the recipient key,
+// an *rsa.PublicKey or a []byte of 32 bytes.
+func Encrypt(plaintext []byte, key interface{}) (string, error) {
+	var h header
+	var cek, encryptedKey []byte
+	switch key := key.(type) {
+	case []byte:
+		if len(key) != 32 {
+			return "", fmt.Errorf("jwe: direct keys must be 32 bytes, got %d", len(key))
+		}
+		h.Alg, cek = "dir", key
+	case *rsa.PublicKey:
+		h.Alg, cek = "RSA-OAEP-256", make([]byte, 32)
+		if _, err := rand.Read(cek); err != nil {
+			return "", err
+		}
+		var err error
+		if encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, key, cek, nil); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("jwe: unsupported key %T", key)
+	}
+	h.Enc = "A256GCM"
+	rawHeader, _ := json.Marshal(h)
+	protected := encode(rawHeader)
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{protected, encode(encryptedKey), encode(iv), encode(ciphertext), encode(tag)}, "."), nil
+}
+
+// Decrypt returns the plaintext of a compact token with the key, an
+// *rsa.PrivateKey or a []byte of 32 bytes.
+func Decrypt(token string, key interface{}) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("%w: not a compact JWE", ErrDecrypt)
+	}
+	raw := make([][]byte, 5)
+	for i, part := range parts {
+		var err error
+		if raw[i], err = decode(part); err != nil {
+			return nil, fmt.Errorf("%w: invalid encoding", ErrDecrypt)
+		}
+	}
+	var h header
+	if json.Unmarshal(raw[0], &h) != nil {
+		return nil, fmt.Errorf("%w: invalid header", ErrDecrypt)
+	}
+	if h.Enc != "A256GCM" {
+		return nil, fmt.Errorf("%w: unsupported enc %q", ErrDecrypt, h.Enc)
+	}
+	var cek []byte
+	switch key := key.(type) {
+	case []byte:
+		if h.Alg != "dir" || len(raw[1]) != 0 {
+			return nil, fmt.Errorf("%w: alg %q, expected dir", ErrDecrypt, h.Alg)
+		}
+		cek = key
+	case *rsa.PrivateKey:
+		if h.Alg != "RSA-OAEP-256" {
+			return nil, fmt.Errorf("%w: alg %q, expected RSA-OAEP-256", ErrDecrypt, h.Alg)
+		}
+		var err error
+		if cek, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key, raw[1], nil); err != nil {
+			return nil, fmt.Errorf("%w: invalid encrypted key", ErrDecrypt)
+		}
+	default:
+		return nil, fmt.Errorf("jwe: unsupported key %T", key)
+	}
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw[2]) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid iv", ErrDecrypt)
+	}
+	plaintext, err := gcm.Open(nil, raw[2], append(raw[3], raw[4]...), []byte(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: authentication failed", ErrDecrypt)
+	}
+	return plaintext, nil
+}
+
+func newGCM(cek []byte) (cipher.AEAD, error) {
+	if len(cek) != 32 {
+		return nil, fmt.Errorf("%w: content keys must be 32 bytes", ErrDecrypt)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,108 @@
+package jwe
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := []byte("0123456789abcdef0123456789abcdef")
+	for _, keys := range [][2]interface{}{{&rsaKey.PublicKey, rsaKey}, {shared, shared}} {
+		token, err := Encrypt([]byte(`{"a":1}`), keys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err := Decrypt(token, keys[1]); err != nil || string(plain) != `{"a":1}` {
+			t.Errorf("expected the plaintext back, got %s %v", plain, err)
+		}
+		tampered := token[:len(token)-2] + "AA"
+		if _, err := Decrypt(tampered, keys[1]); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("expected a tampered token to fail, got %v", err)
+		}
+	}
+	token, _ := Encrypt([]byte(`{}`), shared)
+	if _, err := Decrypt(token, rsaKey); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected a mismatched algorithm to fail, got %v", err)
+	}
+}
+
+type Arith struct{}
+
+type Args struct {
+	A, B int
+}
+
+func (a *Arith) Multiply(r *http.Request, args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(NewCodec(jsonrpc2.NewCodec(), serverKey, &clientKey.PublicKey), "application/json")
+	call := func(params string) map[string]json.RawMessage {
+		r := httptest.NewRequest("POST", "/rpc/Multiply", strings.NewReader(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": `+params+`}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		var res map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("unexpected response %s", w.Body)
+		}
+		return res
+	}
+
+	token, _ := Encrypt([]byte(`{"A": 3, "B": 4}`), &serverKey.PublicKey)
+	res := call(`"` + token + `"`)
+	var result string
+	json.Unmarshal(res["result"], &result)
+	if plain, err := Decrypt(result, clientKey); err != nil || string(plain) != "12" {
+		t.Errorf("expected an encrypted result, got %s %v", res["result"], err)
+	}
+	if res := call(`{"A": 3, "B": 4}`); !strings.Contains(string(res["error"]), "params must be encrypted") {
+		t.Errorf("expected plain params to be rejected, got %s", res["error"])
+	}
+	other, _ := Encrypt([]byte(`{"A": 3, "B": 4}`), &clientKey.PublicKey)
+	if res := call(`"` + other + `"`); !strings.Contains(string(res["error"]), "cannot decrypt") || string(res["id"]) != "1" {
+		t.Errorf("expected a decryption error of the call, got %v", res)
+	}
+
+	server.StrictJSON = true
+	server.SetLimits(rpcserver.Limits{MaxDepth: 4})
+	for _, tc := range []struct {
+		params, expected string
+	}{
+		{`{"A": 3, "B": 4}`, `"result":`},
+		{`{"A": 3, "A": 4}`, `rpc: duplicate key: \"A\" at params.A`},
+		{`{"A": 3, "a": 4}`, `rpc: duplicate key: \"a\" at params.a, the field of \"A\"`},
+		{`{"A": 3, "B": {"x": [[[[1]]]]}}`, `rpc: payload too complex: nesting depth exceeds 4`},
+	} {
+		token, _ := Encrypt([]byte(tc.params), &serverKey.PublicKey)
+		if res, _ := json.Marshal(call(`"` + token + `"`)); !strings.Contains(string(res), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, res)
+		}
+	}
+}
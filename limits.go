@@ -0,0 +1,164 @@
+package rpcserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits holds the settings of a server which can change while it serves
+// requests, see Server.SetLimits.
+type Limits struct {
+	// CallTimeout bounds the duration of calls when positive, see
+	// Server.CallTimeout.
+	CallTimeout time.Duration
+
+	// MaxBodyBytes limits the size of request bodies when positive, see
+	// Server.MaxBodyBytes.
+	MaxBodyBytes int64
+
+	// MaxDecompressedBytes limits the size of request bodies once
+	// decompressed from their Content-Encoding, larger requests are rejected
+	// with 413. MaxBodyBytes applies when zero.
+	MaxDecompressedBytes int64
+
+	// DisabledMethods lists the methods answered with 503.
+	DisabledMethods []string
+
+	// RateLimit is the number of calls per second each method accepts when
+	// positive, calls over the limit are answered with 429. RateBurst is the
+	// number of calls accepted at once, 1 when zero.
+	RateLimit float64
+	RateBurst int
+
+	// MaxDepth, MaxArrayLen, MaxStringLen and MaxKeys limit the nesting
+	// depth, the length of the arrays, the length in runes of the string
+	// values and of the keys, and the number of keys of the objects of JSON
+	// request bodies when positive. The depth and the keys count the
+	// envelope of the codec, such as the JSON-RPC request object. Bodies
+	// over a limit are rejected with 400 and an ErrPayloadTooComplex, before
+	// they are decoded past it.
+	MaxDepth     int
+	MaxArrayLen  int
+	MaxStringLen int
+	MaxKeys      int
+
+	// MaxConcurrentCalls limits the calls in flight when positive, calls
+	// over the limit are answered with 503. MaxConcurrentCallsPerConn
+	// limits the calls in flight of a connection tagged by ConnContext,
+	// calls over it are answered with 429, so a single client cannot take
+	// every slot. Calls wait up to QueueTimeout for a slot.
+	MaxConcurrentCalls        int
+	MaxConcurrentCallsPerConn int
+	QueueTimeout              time.Duration
+
+	// MinBodyRate is the rate in bytes per second request bodies must be
+	// sent at when positive, once BodyGracePeriod elapsed, 5s when zero.
+	// Slower requests are answered with 408 and ErrBodyTooSlow and their
+	// connection closed, so clients trickling bodies cannot hold connections.
+	MinBodyRate     int64
+	BodyGracePeriod time.Duration
+}
+
+// limits is a published Limits, never modified.
+type limits struct {
+	Limits
+	disabled map[string]bool
+	buckets  sync.Map // method -> *tokenBucket
+
+	calls   chan struct{} // slots of the calls in flight, nil if unlimited
+	connsMu sync.Mutex
+	conns   map[interface{}]*connSlots // by connection
+}
+
+// SetLimits atomically replaces the limits of the server, calls in flight
+// complete with the previous ones. Once set, the limits take precedence over
+// the CallTimeout and MaxBodyBytes fields of the server. Rate limits restart
+// from a full burst, and the concurrency limits count the calls admitted
+// from then on.
+func (s *Server) SetLimits(l Limits) {
+	published := &limits{
+		Limits:   l,
+		disabled: make(map[string]bool, len(l.DisabledMethods)),
+		conns:    make(map[interface{}]*connSlots),
+	}
+	if l.MaxConcurrentCalls > 0 {
+		published.calls = make(chan struct{}, l.MaxConcurrentCalls)
+	}
+	for _, method := range l.DisabledMethods {
+		published.disabled[method] = true
+	}
+	s.limits.Store(published)
+}
+
+// Limits returns the limits of the server, made of its CallTimeout and
+// MaxBodyBytes fields until SetLimits is called.
+func (s *Server) Limits() Limits {
+	return s.currentLimits().Limits
+}
+
+func (s *Server) currentLimits() *limits {
+	if l, ok := s.limits.Load().(*limits); ok {
+		return l
+	}
+	return &limits{Limits: Limits{CallTimeout: s.CallTimeout, MaxBodyBytes: s.MaxBodyBytes}}
+}
+
+// maxDecompressedBytes returns the limit of the size of decompressed request
+// bodies, 0 if none.
+func (l *limits) maxDecompressedBytes() int64 {
+	if l.MaxDecompressedBytes > 0 {
+		return l.MaxDecompressedBytes
+	}
+	return l.MaxBodyBytes
+}
+
+// admit returns the status and the error answering a call of the method
+// refused by the limits, or 0.
+func (l *limits) admit(method string, clock Clock) (int, error) {
+	if l.disabled[method] {
+		return 503, fmt.Errorf("rpc: method %s is disabled", method)
+	}
+	if l.RateLimit <= 0 {
+		return 0, nil
+	}
+	b, ok := l.buckets.Load(method)
+	if !ok {
+		burst := float64(l.RateBurst)
+		if burst < 1 {
+			burst = 1
+		}
+		b, _ = l.buckets.LoadOrStore(method, &tokenBucket{tokens: burst, burst: burst, last: clock.Now()})
+	}
+	if !b.(*tokenBucket).take(l.RateLimit, clock.Now()) {
+		return 429, fmt.Errorf("rpc: rate limit of %s exceeded", method)
+	}
+	return 0, nil
+}
+
+// tokenBucket accepts calls at a rate, with bursts.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	burst  float64
+	last   time.Time
+}
+
+// take tells if a call is accepted at now, refilling the bucket at rate
+// tokens per second.
+func (b *tokenBucket) take(rate float64, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/datalinkE/rpcserver"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JournalSocket is the socket of the native protocol of systemd-journald.
+const JournalSocket = "/run/systemd/journal/socket"
+
+// Journal logs the access log entries to systemd-journald with structured
+// fields: RPC_METHOD, HTTP_STATUS, DURATION_USEC, REMOTE_ADDR and REQUEST_ID,
+// beside MESSAGE and PRIORITY.
+//
+// Entries are sent as single datagrams, entries larger than the datagram size
+// limit of the socket are dropped.
+type Journal struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// DialJournal connects to journald at the socket, JournalSocket usually,
+// logging entries with the SYSLOG_IDENTIFIER.
+func DialJournal(socket, identifier string) (*Journal, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{conn: conn, identifier: identifier}, nil
+}
+
+// Log logs the entry with the priority of its outcome.
+func (j *Journal) Log(entry rpcserver.AccessLogEntry) {
+	var buf bytes.Buffer
+	field := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(key + "=" + value + "\n")
+			return
+		}
+		// Values spanning lines are written with their length.
+		buf.WriteString(key + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value + "\n")
+	}
+	field("MESSAGE", Message(entry))
+	field("PRIORITY", strconv.Itoa(int(PriorityOf(entry))))
+	field("SYSLOG_IDENTIFIER", j.identifier)
+	field("RPC_METHOD", entry.Method)
+	field("HTTP_STATUS", strconv.Itoa(entry.Status))
+	field("DURATION_USEC", strconv.FormatInt(entry.Duration.Microseconds(), 10))
+	field("REMOTE_ADDR", entry.RemoteAddr)
+	field("REQUEST_ID", entry.RequestID)
+	field("RPC_ERROR", entry.Error)
+	j.conn.Write(buf.Bytes())
+}
+
+// Close closes the connection to journald.
+func (j *Journal) Close() error {
+	return j.conn.Close()
+}
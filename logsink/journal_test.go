@@ -0,0 +1,39 @@
+//go:build linux
+
+package logsink
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	journal, err := DialJournal(socket, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	journal.Log(rpcserver.AccessLogEntry{Method: "Get", Status: 503, Error: "down\nfor maintenance"})
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := string(buf[:n])
+	for _, expected := range []string{"PRIORITY=3\n", "SYSLOG_IDENTIFIER=test\n", "RPC_METHOD=Get\n", "HTTP_STATUS=503\n", "RPC_ERROR\n\x14\x00\x00\x00\x00\x00\x00\x00down\nfor maintenance\n"} {
+		if !strings.Contains(fields, expected) {
+			t.Errorf("expected %q in %q", expected, fields)
+		}
+	}
+}
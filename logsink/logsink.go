@@ -0,0 +1,54 @@
+// Package logsink provides access log sinks writing to syslog and to the
+// systemd journal, so bare-metal deployments log without a sidecar:
+//
+//	sink, err := logsink.DialJournal(logsink.JournalSocket, "billing")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	server.AccessLog = sink
+//
+// Entries are logged with the priority of their outcome, see PriorityOf. The
+// syslog sink is not available on Windows and Plan 9, the journal sink is
+// available on Linux only.
+package logsink
+
+import (
+	"bytes"
+	"github.com/datalinkE/rpcserver"
+	"strings"
+)
+
+// Priority is a syslog severity.
+type Priority int
+
+const (
+	Emergency Priority = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+// PriorityOf returns the priority of an entry: Error for server errors, the
+// 5xx statuses, Warning for the other errors, of the client or of the call,
+// and Info for the successful calls.
+func PriorityOf(entry rpcserver.AccessLogEntry) Priority {
+	switch {
+	case entry.Status >= 500:
+		return Error
+	case entry.Status >= 400 || entry.Error != "":
+		return Warning
+	}
+	return Info
+}
+
+// Message returns the entry as a line of the combined log format, without the
+// trailing newline.
+func Message(entry rpcserver.AccessLogEntry) string {
+	var buf bytes.Buffer
+	rpcserver.NewAccessLogger(&buf, rpcserver.AccessLogCombined).Log(entry)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,41 @@
+package logsink
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"testing"
+	"time"
+)
+
+func TestPriorityOf(t *testing.T) {
+	for _, c := range []struct {
+		entry    rpcserver.AccessLogEntry
+		priority Priority
+	}{
+		{rpcserver.AccessLogEntry{Status: 200}, Info},
+		{rpcserver.AccessLogEntry{Status: 200, Error: "B must not be zero"}, Warning},
+		{rpcserver.AccessLogEntry{Status: 404}, Warning},
+		{rpcserver.AccessLogEntry{Status: 504}, Error},
+	} {
+		if p := PriorityOf(c.entry); p != c.priority {
+			t.Errorf("PriorityOf(%+v) = %d, expected %d", c.entry, p, c.priority)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	entry := rpcserver.AccessLogEntry{
+		Time:       time.Date(2026, 10, 14, 13, 55, 36, 0, time.UTC),
+		Method:     "Get",
+		HTTPMethod: "POST",
+		Path:       "/rpc/Get",
+		Protocol:   "HTTP/1.1",
+		Status:     200,
+		Bytes:      42,
+		Duration:   1234 * time.Microsecond,
+		RemoteAddr: "10.0.0.1",
+	}
+	expected := `10.0.0.1 - - [14/Oct/2026:13:55:36 +0000] "POST /rpc/Get HTTP/1.1" 200 42 "-" "-" "Get" 0.001234 "-"`
+	if msg := Message(entry); msg != expected {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
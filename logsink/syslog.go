@@ -0,0 +1,42 @@
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"log/syslog"
+)
+
+// Syslog logs the access log entries to a syslog daemon.
+type Syslog struct {
+	writer *syslog.Writer
+}
+
+// DialSyslog connects to the syslog daemon at raddr over network, the local
+// daemon when network is empty, logging entries with the tag and the daemon
+// facility.
+func DialSyslog(network, raddr, tag string) (*Syslog, error) {
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{writer: writer}, nil
+}
+
+// Log logs the entry with the priority of its outcome.
+func (s *Syslog) Log(entry rpcserver.AccessLogEntry) {
+	msg := Message(entry)
+	switch PriorityOf(entry) {
+	case Error:
+		s.writer.Err(msg)
+	case Warning:
+		s.writer.Warning(msg)
+	default:
+		s.writer.Info(msg)
+	}
+}
+
+// Close closes the connection to the daemon.
+func (s *Syslog) Close() error {
+	return s.writer.Close()
+}
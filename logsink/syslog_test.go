@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := DialSyslog("udp", conn.LocalAddr().String(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.Log(rpcserver.AccessLogEntry{Method: "Get", Status: 404})
+
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The daemon facility is 3, the warning severity 4: 3*8+4.
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<28>") || !strings.Contains(msg, `"Get"`) {
+		t.Errorf("unexpected syslog message %q", msg)
+	}
+}
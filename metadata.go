@@ -0,0 +1,156 @@
+package rpcserver
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// metadataField is a field of args bound to the metadata of the request.
+type metadataField struct {
+	index  int
+	source string // the tag, e.g. "header"
+	name   string
+}
+
+// metadataSources are the tags binding fields to the metadata of the request.
+var metadataSources = []string{"header", "cookie", "path"}
+
+var metadataFields sync.Map // reflect.Type -> []metadataField
+
+// BindMetadata sets the fields of args tagged with the metadata of the request
+// they are bound to, the server binds the args of every call once decoded:
+//
+//	type ListArgs struct {
+//		Tenant   string   `path:"tenant" json:"-"`
+//		DeviceID string   `header:"X-Device-Id" json:"-"`
+//		Session  string   `cookie:"session" json:"-"`
+//		Accepted []string `header:"Accept-Language" json:"-"`
+//		Page     int
+//	}
+//
+// The path parameters are the ones of PathParamsFromContext, see
+// Server.PathTemplate and Server.PathParams. The fields are strings, bools,
+// numbers, encoding.TextUnmarshaler values, pointers to them, or slices of
+// them receiving every value of a header. The fields are zeroed before they
+// are bound, so the params can't set them: they are zero when their metadata
+// is absent from the request.
+func BindMetadata(r *http.Request, args interface{}) error {
+	v := reflect.ValueOf(args)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, field := range metadataFieldsOf(v.Type()) {
+		zero(v.Field(field.index))
+		var values []string
+		switch field.source {
+		case "header":
+			values = r.Header.Values(field.name)
+		case "cookie":
+			if cookie, err := r.Cookie(field.name); err == nil {
+				values = []string{cookie.Value}
+			}
+		case "path":
+			if value, ok := PathParamsFromContext(r.Context())[field.name]; ok {
+				values = []string{value}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := setMetadata(v.Field(field.index), values); err != nil {
+			return fmt.Errorf("rpc: invalid %s %s: %v", field.source, field.name, err)
+		}
+	}
+	return nil
+}
+
+// zero sets the field v to its zero value.
+func zero(v reflect.Value) {
+	v.Set(reflect.Zero(v.Type()))
+}
+
+// metadataFieldsOf returns the metadata fields of a struct type.
+func metadataFieldsOf(t reflect.Type) []metadataField {
+	if fields, ok := metadataFields.Load(t); ok {
+		return fields.([]metadataField)
+	}
+	var fields []metadataField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		for _, source := range metadataSources {
+			if name, ok := field.Tag.Lookup(source); ok && name != "" {
+				fields = append(fields, metadataField{index: i, source: source, name: name})
+				break
+			}
+		}
+	}
+	metadataFields.Store(t, fields)
+	return fields
+}
+
+// setMetadata sets the field v to the values, the first one unless it is a
+// slice.
+func setMetadata(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setMetadata(slice.Index(i), []string{value}); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	value := values[0]
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package rpcserver
+
+import (
+	"context"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------------
+// Middleware
+// ----------------------------------------------------------------------------
+
+// Call holds a method invocation passing through the middleware.
+type Call struct {
+	// Request is the HTTP request carrying the call.
+	Request *http.Request
+
+	// Method is the name of the called method.
+	Method string
+
+	// Args points to the decoded method args.
+	Args interface{}
+
+	// Reply points to the method reply, filled by the method. It is nil for
+	// methods without a reply and set to the returned value for methods
+	// returning the reply.
+	Reply interface{}
+
+	// Implementation is the name of the implementation serving the call,
+	// see Server.RegisterImplementation. The receiver of the service serves
+	// it when empty.
+	Implementation string
+}
+
+// CallFunc performs a call. The context passed to the final CallFunc becomes
+// the context of the *http.Request given to the method.
+type CallFunc func(ctx context.Context, call *Call) error
+
+// Middleware wraps a CallFunc to run code around every method invocation.
+// Errors returned by the middleware are written by the codec like errors of
+// the method itself.
+type Middleware func(next CallFunc) CallFunc
+
+// Use adds middleware to the server. The first added middleware is the
+// outermost one: it sees the call before and the result after all the others.
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	reg.middleware = append(reg.middleware[:len(reg.middleware):len(reg.middleware)], middleware...)
+	s.registry.Store(&reg)
+}
+
+// chain returns the CallFunc invoking the method through the middleware.
+func (reg *registry) chain(invoke CallFunc) CallFunc {
+	for i := len(reg.middleware) - 1; i >= 0; i-- {
+		invoke = reg.middleware[i](invoke)
+	}
+	return invoke
+}
@@ -0,0 +1,120 @@
+package rpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersionField is the member of by-name params declaring their version,
+// taking precedence over the SchemaVersionHeader. It is removed from the
+// params before they are decoded.
+const SchemaVersionField = "_version"
+
+// Migrator upgrades the JSON params of a method from a version to the next.
+type Migrator func(params json.RawMessage) (json.RawMessage, error)
+
+// RegisterMigrators sets the migrators of the method: migrators[i] upgrades
+// the params of version i+1 to version i+2, the current version of the args
+// being len(migrators)+1. Params declaring no version, in the
+// SchemaVersionField nor the SchemaVersionHeader, are of version 1, the
+// shape of the args before the first migrator. They are upgraded through the
+// following migrators before being decoded into the current args.
+//
+// Migrators may be registered while the server is handling requests.
+func (s *Server) RegisterMigrators(method string, migrators ...Migrator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if _, err := reg.method(method); err != nil {
+		return err
+	}
+	migrations := make(map[string][]Migrator, len(reg.migrations)+1)
+	for key, m := range reg.migrations {
+		migrations[key] = m
+	}
+	if len(migrators) == 0 {
+		delete(migrations, method)
+	} else {
+		migrations[method] = append([]Migrator(nil), migrators...)
+	}
+	reg.migrations = migrations
+	s.registry.Store(&reg)
+	return nil
+}
+
+// migrations holds the migrators of the server and the version declared by
+// the SchemaVersionHeader of a request, 0 if none.
+type migrations struct {
+	migrators map[string][]Migrator
+	header    int
+}
+
+type migrationsKey struct{}
+
+// withMigrations returns a copy of ctx carrying the migrators, or an error if
+// the header, the SchemaVersionHeader of the request, is invalid.
+func withMigrations(ctx context.Context, header string, migrators map[string][]Migrator) (context.Context, error) {
+	m := &migrations{migrators: migrators}
+	if header != "" {
+		v, err := strconv.Atoi(header)
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("rpc: invalid %s %q", SchemaVersionHeader, header)
+		}
+		m.header = v
+	}
+	return context.WithValue(ctx, migrationsKey{}, m), nil
+}
+
+// MigrationFromContext returns the function upgrading the params of the method
+// to its current version, nil if the method has no migrators. Codecs call it
+// with the context of the request before decoding the args.
+func MigrationFromContext(ctx context.Context, method string) func(params json.RawMessage) (json.RawMessage, error) {
+	m, _ := ctx.Value(migrationsKey{}).(*migrations)
+	if m == nil || len(m.migrators[method]) == 0 {
+		return nil
+	}
+	migrators := m.migrators[method]
+	return func(params json.RawMessage) (json.RawMessage, error) {
+		version, params, err := schemaVersion(params)
+		if err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			version = m.header
+		}
+		if version == 0 {
+			version = 1
+		}
+		if version > len(migrators)+1 {
+			return nil, fmt.Errorf("rpc: unknown schema version %d of %s", version, method)
+		}
+		for _, migrate := range migrators[version-1:] {
+			if params, err = migrate(params); err != nil {
+				return nil, err
+			}
+		}
+		return params, nil
+	}
+}
+
+// schemaVersion returns the version declared by the SchemaVersionField of the
+// params, 0 if none, and the params without it.
+func schemaVersion(params json.RawMessage) (int, json.RawMessage, error) {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(params, &members) != nil {
+		return 0, params, nil // not an object
+	}
+	raw, ok := members[SchemaVersionField]
+	if !ok {
+		return 0, params, nil
+	}
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil || v < 1 {
+		return 0, nil, fmt.Errorf("rpc: invalid %s %s", SchemaVersionField, raw)
+	}
+	delete(members, SchemaVersionField)
+	params, err := json.Marshal(members)
+	return v, params, err
+}
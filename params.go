@@ -0,0 +1,357 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidParams is wrapped by the errors of params breaking the rules of
+// their fields, see ParamsError.
+var ErrInvalidParams = errors.New("rpc: invalid params")
+
+// FieldError is a field of the params breaking a rule.
+type FieldError struct {
+	// Field is the path of the member in the params, e.g. "Filter.From" or
+	// "Items[2].ID".
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ParamsError lists all the fields of the params breaking their rules.
+type ParamsError struct {
+	Errors []FieldError
+}
+
+func (e *ParamsError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = strings.TrimPrefix(fe.Field+" "+fe.Message, " ")
+	}
+	return ErrInvalidParams.Error() + ": " + strings.Join(msgs, ", ")
+}
+
+// Is tells errors.Is that e is an ErrInvalidParams.
+func (e *ParamsError) Is(target error) bool {
+	return target == ErrInvalidParams
+}
+
+// ParamsRules are the rules of the fields of an args type applied to the raw
+// JSON params, before they are decoded: the fields tagged rpc:"required"
+// must be present, the fields missing are filled with the value of their
+// default tag and the fields with an enum tag, or of a type registered with
+// RegisterEnum, only accept the listed values:
+//
+//	type Query struct {
+//		Text  string `rpc:"required"`
+//		Limit int    `default:"10"`
+//		Sort  string `default:"asc" enum:"asc,desc"`
+//	}
+//
+// Defaults and enum values are JSON values, but for strings. Null members are missing. The
+// rules apply to the members of nested structs and slices of structs which
+// are present, and the params of a type registered with RegisterEnum, or
+// slices of them, only accept its values too. Codecs decoding JSON apply them
+// to by-name params with Prepare and to by-position params with
+// PreparePositional.
+type ParamsRules struct {
+	fields []fieldRules
+	value  *fieldRules // rules of the params not decoded into a struct
+}
+
+// fieldRules are the rules of a struct field.
+type fieldRules struct {
+	name     string
+	required bool
+	def      json.RawMessage // default value, nil if none
+	enum     []string        // accepted values as canonical JSON, see EnumOf
+	nested   *ParamsRules    // rules of the struct values of the field
+	list     bool            // the field holds a slice or an array of the nested values
+}
+
+var paramsRules sync.Map // reflect.Type -> *ParamsRules
+
+// ParamsRulesOf returns the rules of the params decoded into type t, nil if it
+// has none.
+func ParamsRulesOf(t reflect.Type) *ParamsRules {
+	if rules, ok := paramsRules.Load(t); ok {
+		return rules.(*ParamsRules)
+	}
+	rules, _ := paramsRules.LoadOrStore(t, newTopRules(t))
+	return rules.(*ParamsRules)
+}
+
+// newTopRules returns the rules of the params decoded into type t: the rules
+// of its fields for a struct, else the enum of its values or of its elements.
+func newTopRules(t reflect.Type) *ParamsRules {
+	if rules := newParamsRules(t, make(map[reflect.Type]bool)); rules != nil {
+		return rules
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		return nil
+	}
+	fr := fieldRules{list: t.Kind() == reflect.Slice || t.Kind() == reflect.Array}
+	for _, v := range EnumOf(reflect.StructField{Type: t}) {
+		canonical, _ := json.Marshal(v)
+		fr.enum = append(fr.enum, string(canonical))
+	}
+	if fr.enum == nil {
+		return nil
+	}
+	return &ParamsRules{value: &fr}
+}
+
+func newParamsRules(t reflect.Type, visiting map[reflect.Type]bool) *ParamsRules {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	rules := new(ParamsRules)
+	rules.addFields(t, visiting)
+	if len(rules.fields) == 0 {
+		return nil
+	}
+	return rules
+}
+
+func (rules *ParamsRules) addFields(t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("json")
+		if idx := strings.Index(name, ","); idx != -1 {
+			name = name[:idx]
+		}
+		if name == "-" {
+			continue
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			rules.addFields(ft, visiting) // promoted fields
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fr := fieldRules{name: name, required: ParseFieldTag(field.Tag.Get("rpc")).Required}
+		if def, ok := field.Tag.Lookup("default"); ok {
+			fr.def = defaultValue(def, ft)
+		}
+		for _, v := range EnumOf(field) {
+			canonical, _ := json.Marshal(v)
+			fr.enum = append(fr.enum, string(canonical))
+		}
+		fr.list = ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array
+		if fr.list {
+			fr.nested = newParamsRules(ft.Elem(), visiting)
+		} else {
+			fr.nested = newParamsRules(ft, visiting)
+		}
+		if fr.required || fr.def != nil || fr.enum != nil || fr.nested != nil {
+			rules.fields = append(rules.fields, fr)
+		}
+	}
+}
+
+// defaultValue returns the JSON value of the default tag of a field of type t.
+func defaultValue(def string, t reflect.Type) json.RawMessage {
+	if t.Kind() != reflect.String && json.Valid([]byte(def)) {
+		return json.RawMessage(def)
+	}
+	quoted, _ := json.Marshal(def)
+	return quoted
+}
+
+// Prepare applies the rules to the by-name params, or to the params
+// themselves when they are not decoded into a struct. It returns the params
+// with the defaults of the missing fields, or a ParamsError listing all the
+// fields breaking the rules. Missing or null params of a struct have all
+// their fields missing, the other ones are left as is.
+func (rules *ParamsRules) Prepare(params json.RawMessage) (json.RawMessage, error) {
+	var errs []FieldError
+	if rules.value != nil {
+		if len(params) > 0 && string(params) != "null" {
+			rules.value.checkEnum("", params, &errs)
+		}
+	} else {
+		if len(params) == 0 || string(params) == "null" {
+			params = json.RawMessage("{}")
+		}
+		params, _ = rules.prepare("", params, &errs)
+	}
+	if len(errs) > 0 {
+		return nil, &ParamsError{Errors: errs}
+	}
+	return params, nil
+}
+
+// PreparePositional applies the rules to the by-position params, the values of
+// the fields of the member names in order; the last name collects the
+// remaining values when variadic. It returns the values with the defaults of
+// the missing fields, null for the missing fields before them, or a
+// ParamsError listing all the fields breaking the rules. The values past the
+// names are left as is, for the decoding to report them.
+func (rules *ParamsRules) PreparePositional(values []json.RawMessage, names []string, variadic bool) ([]json.RawMessage, error) {
+	members := make(map[string]json.RawMessage, len(names))
+	for i, value := range values {
+		if i >= len(names) {
+			break
+		}
+		if variadic && i == len(names)-1 {
+			members[names[i]], _ = json.Marshal(values[i:])
+			break
+		}
+		members[names[i]] = value
+	}
+	params, _ := json.Marshal(members)
+	var errs []FieldError
+	params, changed := rules.prepare("", params, &errs)
+	if len(errs) > 0 {
+		return nil, &ParamsError{Errors: errs}
+	}
+	if !changed {
+		return values, nil
+	}
+	json.Unmarshal(params, &members)
+	prepared := append([]json.RawMessage(nil), values...)
+	for i, name := range names {
+		value, ok := members[name]
+		if !ok {
+			continue
+		}
+		for len(prepared) < i {
+			prepared = append(prepared, json.RawMessage("null"))
+		}
+		if variadic && i == len(names)-1 {
+			var rest []json.RawMessage
+			json.Unmarshal(value, &rest)
+			return append(prepared[:i], rest...), nil
+		}
+		if len(prepared) == i {
+			prepared = append(prepared, value)
+		} else {
+			prepared[i] = value
+		}
+	}
+	return prepared, nil
+}
+
+// prepare applies the rules to the object at path, appending the errors to
+// errs. It returns the object and true if defaults were added.
+func (rules *ParamsRules) prepare(path string, params json.RawMessage, errs *[]FieldError) (json.RawMessage, bool) {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(params, &members) != nil || members == nil {
+		return params, false // not an object, the decoding reports the mismatch
+	}
+	changed := false
+	for _, fr := range rules.fields {
+		key, value, ok := member(members, fr.name)
+		if !ok {
+			if fr.def != nil {
+				delete(members, key) // null
+				members[fr.name], changed = fr.def, true
+			} else if fr.required {
+				*errs = append(*errs, FieldError{Field: path + fr.name, Message: "is required"})
+			}
+			continue
+		}
+		if fr.enum != nil {
+			fr.checkEnum(path+fr.name, value, errs)
+		}
+		if fr.nested == nil {
+			continue
+		}
+		if !fr.list {
+			if value, ok = fr.nested.prepare(path+fr.name+".", value, errs); ok {
+				members[key], changed = value, true
+			}
+			continue
+		}
+		var items []json.RawMessage
+		json.Unmarshal(value, &items)
+		itemsChanged := false
+		for i, item := range items {
+			if item, ok = fr.nested.prepare(path+fr.name+"["+strconv.Itoa(i)+"].", item, errs); ok {
+				items[i], itemsChanged = item, true
+			}
+		}
+		if itemsChanged {
+			members[key], _ = json.Marshal(items)
+			changed = true
+		}
+	}
+	if !changed {
+		return params, false
+	}
+	prepared, _ := json.Marshal(members)
+	return prepared, true
+}
+
+// checkEnum appends an error to errs if the value, or an element of the list,
+// is not one of the enum values.
+func (fr *fieldRules) checkEnum(path string, value json.RawMessage, errs *[]FieldError) {
+	var values []json.RawMessage
+	if !fr.list {
+		values = []json.RawMessage{value}
+	} else if json.Unmarshal(value, &values) != nil {
+		return
+	}
+	for i, v := range values {
+		var generic interface{}
+		if json.Unmarshal(v, &generic) != nil {
+			return
+		}
+		canonical, _ := json.Marshal(generic)
+		if !containsString(fr.enum, string(canonical)) {
+			field := path
+			if fr.list {
+				field += "[" + strconv.Itoa(i) + "]"
+			}
+			*errs = append(*errs, FieldError{Field: field, Message: "must be one of " + strings.Join(fr.enum, ", ")})
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// member returns the key and the non-null value of the member matching the
+// name as encoding/json does, exactly or else case-insensitively. The key of
+// a null member is returned too.
+func member(members map[string]json.RawMessage, name string) (string, json.RawMessage, bool) {
+	key := name
+	value, ok := members[name]
+	if !ok {
+		for k, v := range members {
+			if strings.EqualFold(k, name) {
+				key, value, ok = k, v, true
+				break
+			}
+		}
+	}
+	if !ok || string(value) == "null" {
+		return key, nil, false
+	}
+	return key, value, true
+}
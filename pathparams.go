@@ -0,0 +1,52 @@
+package rpcserver
+
+import (
+	"context"
+	"net/http"
+)
+
+// PathParamsFunc returns the path parameters of a request, by name.
+type PathParamsFunc func(r *http.Request) map[string]string
+
+type pathParamsKey struct{}
+
+// WithPathParams returns a copy of ctx carrying the path parameters, e.g. the
+// ones of a gin route mounting the server:
+//
+//	router.POST("/tenants/:tenant/jsonrpc/:method", func(c *gin.Context) {
+//		params := map[string]string{"tenant": c.Param("tenant")}
+//		r := c.Request.WithContext(rpcserver.WithPathParams(c.Request.Context(), params))
+//		server.ServeHTTP(c.Writer, r)
+//	})
+//
+// The server adds the ones of its PathParams and PathTemplate to them.
+func WithPathParams(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, pathParamsKey{}, params)
+}
+
+// PathParamsFromContext returns the path parameters of the request.
+func PathParamsFromContext(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(pathParamsKey{}).(map[string]string)
+	return params
+}
+
+// pathParams returns the path parameters of the request: the ones of its
+// context, of the PathParams of the server, then of its PathTemplate. It is
+// nil when there is none beside the ones of the context.
+func (s *Server) pathParams(r *http.Request, template map[string]string) map[string]string {
+	var extracted map[string]string
+	if s.PathParams != nil {
+		extracted = s.PathParams(r)
+	}
+	if len(extracted) == 0 && len(template) == 0 {
+		return nil
+	}
+	inherited := PathParamsFromContext(r.Context())
+	params := make(map[string]string, len(inherited)+len(extracted)+len(template))
+	for _, from := range []map[string]string{inherited, extracted, template} {
+		for name, value := range from {
+			params[name] = value
+		}
+	}
+	return params
+}
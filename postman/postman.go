@@ -0,0 +1,178 @@
+// Package postman exports the methods of an introspection document as a
+// Postman collection, which Insomnia imports too. Each method is a request
+// posting a JSON-RPC 2.0 call with sample params: the zero values of the args,
+// or the values of the example tags of their fields:
+//
+//	type Args struct {
+//		A int `example:"10"`
+//		B int `example:"3"`
+//	}
+package postman
+
+import (
+	"encoding/json"
+	"github.com/datalinkE/rpcserver/introspect"
+	"strings"
+)
+
+// SchemaURL identifies the version 2.1 of the collection format.
+const SchemaURL = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// Collection is a Postman collection.
+type Collection struct {
+	Info     Info       `json:"info"`
+	Item     []Item     `json:"item"`
+	Variable []Variable `json:"variable,omitempty"`
+}
+
+// Info describes a collection.
+type Info struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// Item is a request of a collection.
+type Item struct {
+	Name    string  `json:"name"`
+	Request Request `json:"request"`
+}
+
+// Request is an HTTP request of an Item.
+type Request struct {
+	Method string   `json:"method"`
+	Header []Header `json:"header"`
+	Body   Body     `json:"body"`
+	URL    URL      `json:"url"`
+}
+
+// Header is a header of a Request.
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Body is the body of a Request, raw JSON for the requests of Export.
+type Body struct {
+	Mode    string      `json:"mode"`
+	Raw     string      `json:"raw"`
+	Options interface{} `json:"options,omitempty"`
+}
+
+// URL is the URL of a Request.
+type URL struct {
+	Raw  string   `json:"raw"`
+	Host []string `json:"host"`
+	Path []string `json:"path"`
+}
+
+// Variable is a variable of a collection.
+type Variable struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Export returns the collection of the methods of the document. They are
+// called at endpoint followed by the method name, the endpoint being the
+// baseUrl variable of the collection so it can be changed in Postman.
+func Export(doc *introspect.Document, endpoint string) *Collection {
+	c := &Collection{
+		Info:     Info{Name: doc.Service, Schema: SchemaURL},
+		Item:     []Item{},
+		Variable: []Variable{{Key: "baseUrl", Value: strings.TrimSuffix(endpoint, "/")}},
+	}
+	for _, m := range doc.Methods {
+		call := map[string]interface{}{"jsonrpc": "2.0", "method": m.Name, "id": 1}
+		if params := doc.Resolve(m.Params); params != nil && params.Type != "null" {
+			call["params"] = sample(doc, m.Params, 0)
+		}
+		raw, _ := json.MarshalIndent(call, "", "  ")
+		c.Item = append(c.Item, Item{
+			Name: m.Name,
+			Request: Request{
+				Method: "POST",
+				Header: []Header{{Key: "Content-Type", Value: "application/json"}},
+				Body: Body{
+					Mode:    "raw",
+					Raw:     string(raw),
+					Options: map[string]interface{}{"raw": map[string]string{"language": "json"}},
+				},
+				URL: URL{
+					Raw:  "{{baseUrl}}/" + m.Name,
+					Host: []string{"{{baseUrl}}"},
+					Path: []string{m.Name},
+				},
+			},
+		})
+	}
+	return c
+}
+
+// maxDepth bounds the samples of recursive types.
+const maxDepth = 4
+
+// sample returns the example or the zero value of a schema.
+func sample(doc *introspect.Document, s *introspect.Schema, depth int) interface{} {
+	if s == nil {
+		return nil
+	}
+	if s.Example != nil {
+		return s.Example
+	}
+	if s.Nullable && depth > 0 || depth > maxDepth {
+		return nil
+	}
+	resolved := doc.Resolve(s)
+	if resolved == nil {
+		return nil
+	}
+	switch resolved.Type {
+	case "boolean":
+		return false
+	case "integer", "number":
+		return 0
+	case "string":
+		if resolved.Format == "date-time" {
+			return "0001-01-01T00:00:00Z"
+		}
+		return ""
+	case "array":
+		if resolved.Nullable {
+			return nil
+		}
+		return []interface{}{}
+	case "object":
+		if resolved.AdditionalProperties != nil {
+			return nil // nil map
+		}
+		obj := make(object, 0, len(resolved.Properties))
+		for _, name := range resolved.Members() {
+			obj = append(obj, member{name, sample(doc, resolved.Properties[name], depth+1)})
+		}
+		return obj
+	}
+	return nil
+}
+
+// object is a JSON object keeping the order of its members.
+type object []member
+
+type member struct {
+	name  string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, m := range o {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(m.name)
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, name...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
@@ -0,0 +1,61 @@
+package postman
+
+import (
+	"encoding/json"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	B    int    `example:"3"`
+	A    int    `example:"10"`
+	Name string `example:"42"`
+	At   time.Time
+	Next *Args
+	Tags []string
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, reply *int) error {
+	return nil
+}
+
+func (t *Arith) Ping(r *http.Request, args *struct{}) error {
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Export(introspect.Describe(service), "http://localhost:8080/rpc/")
+	if c.Info.Name != "Arith" || c.Info.Schema != SchemaURL || len(c.Item) != 2 {
+		t.Fatalf("unexpected collection %+v", c)
+	}
+	if c.Variable[0] != (Variable{Key: "baseUrl", Value: "http://localhost:8080/rpc"}) {
+		t.Errorf("unexpected variables %+v", c.Variable)
+	}
+	divide := c.Item[0].Request
+	if divide.Method != "POST" || divide.URL.Raw != "{{baseUrl}}/Divide" {
+		t.Errorf("unexpected request %+v", divide)
+	}
+	var call struct {
+		Params json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(divide.Body.Raw), &call); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"B":3,"A":10,"Name":"42","At":"0001-01-01T00:00:00Z","Next":null,"Tags":null}`
+	var compact []byte
+	if compact, err = json.Marshal(call.Params); err != nil || string(compact) != expected {
+		t.Errorf("expected params %s, got %s", expected, compact)
+	}
+	if ping := c.Item[1].Request.Body.Raw; ping != "{\n  \"id\": 1,\n  \"jsonrpc\": \"2.0\",\n  \"method\": \"Ping\",\n  \"params\": {}\n}" {
+		t.Errorf("unexpected body %s", ping)
+	}
+}
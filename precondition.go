@@ -0,0 +1,75 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned for calls whose If-Match header matches
+// no current version of the resource they modify, answered with 412. Methods
+// detecting a version mismatch themselves may return it too.
+var ErrPreconditionFailed = errors.New("rpc: precondition failed")
+
+// Precondition returns the current version of the resource modified by a
+// call, from its decoded args, for optimistic concurrency:
+//
+//	server.RegisterPrecondition("UpdateUser", func(ctx context.Context, args interface{}) (string, error) {
+//		user, err := store.Get(ctx, args.(*UpdateUserArgs).ID)
+//		if err != nil {
+//			return "", err
+//		}
+//		return strconv.Itoa(user.Version), nil
+//	})
+//
+// An empty version stands for a missing resource.
+type Precondition func(ctx context.Context, args interface{}) (string, error)
+
+// RegisterPrecondition sets the precondition of the method. Calls with an
+// If-Match header, e.g. `If-Match: "3"`, fail with ErrPreconditionFailed
+// unless one of its entity tags is the current version, or it is "*" and
+// the resource exists. Calls without If-Match are not checked.
+//
+// Preconditions may be registered while the server is handling requests.
+func (s *Server) RegisterPrecondition(method string, p Precondition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if _, err := reg.method(method); err != nil {
+		return err
+	}
+	preconditions := make(map[string]Precondition, len(reg.preconditions)+1)
+	for key, value := range reg.preconditions {
+		preconditions[key] = value
+	}
+	preconditions[method] = p
+	reg.preconditions = preconditions
+	s.registry.Store(&reg)
+	return nil
+}
+
+// checkPrecondition checks the If-Match header of the request against the
+// version of the resource modified by the call.
+func (reg *registry) checkPrecondition(r *http.Request, method string, args interface{}) error {
+	p := reg.preconditions[method]
+	header := r.Header.Get("If-Match")
+	if p == nil || header == "" {
+		return nil
+	}
+	version, err := p(r.Context(), args)
+	if err != nil {
+		return err
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if version != "" && (tag == "*" || tag == `"`+version+`"`) {
+			return nil
+		}
+	}
+	if version == "" {
+		return fmt.Errorf("%w: If-Match %s, the resource does not exist", ErrPreconditionFailed, header)
+	}
+	return fmt.Errorf("%w: If-Match %s, the current version is %q", ErrPreconditionFailed, header, version)
+}
@@ -0,0 +1,47 @@
+package rpcserver
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+)
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying the metadata of the call, the
+// headers the calls it makes to other services may forward, see
+// Server.PropagateHeaders. rpcclient.Client forwards the ones of its
+// ForwardMetadata.
+func WithMetadata(ctx context.Context, md http.Header) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the metadata of the call, nil if it has none.
+// It must not be modified, a copy is passed to WithMetadata instead.
+func MetadataFromContext(ctx context.Context) http.Header {
+	md, _ := ctx.Value(metadataKey{}).(http.Header)
+	return md
+}
+
+// propagate returns the context of the request carrying the metadata of its
+// PropagateHeaders, added to the metadata of its context.
+func (s *Server) propagate(r *http.Request) context.Context {
+	var md http.Header
+	for _, name := range s.PropagateHeaders {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if md == nil {
+			md = MetadataFromContext(r.Context()).Clone()
+			if md == nil {
+				md = make(http.Header, len(s.PropagateHeaders))
+			}
+		}
+		md[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	if md == nil {
+		return r.Context()
+	}
+	return WithMetadata(r.Context(), md)
+}
@@ -0,0 +1,182 @@
+package rpcserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+var (
+	typeOfRawRequest  = reflect.TypeOf(RawRequest{})
+	typeOfRawResponse = reflect.TypeOf(RawResponse{})
+)
+
+// RawRequest is the args of the passthrough methods, which serve the body of
+// the request as is rather than decoded by a codec, e.g. to proxy it, to
+// verify its signature or to read a binary protocol:
+//
+//	func (t *T) Forward(r *http.Request, req *rpcserver.RawRequest, res *rpcserver.RawResponse) error
+//	func (t *T) Forward(r *http.Request, req *rpcserver.RawRequest) (*rpcserver.RawResponse, error)
+//
+// Passthrough methods are called at the path naming them, see PathTemplate,
+// with the Content-Type of a codec or one set with SetRawContentTypes; the
+// others, such as the text/plain of the cross-site forms, are refused with
+// 415. The calls pass through the middleware and the validators, the body is
+// only decompressed and limited as the one of other calls.
+type RawRequest struct {
+	// ContentType is the Content-Type header of the request.
+	ContentType string
+
+	// Body is the body of the request.
+	Body []byte
+}
+
+// RawResponse is the reply of the passthrough methods, written as is, see
+// RawRequest. The errors of the methods are written as the transport errors.
+type RawResponse struct {
+	// ContentType is the Content-Type header of the response, none when
+	// empty.
+	ContentType string
+
+	// Status is the status of the response, 200 when zero.
+	Status int
+
+	// Body is the body of the response.
+	Body []byte
+}
+
+// raw returns true if the method is a passthrough method, see RawRequest.
+func (m *RpcServiceMethod) raw() bool {
+	return m.argsType == typeOfRawRequest
+}
+
+// rawReason returns why the passthrough method is unsuitable, empty if it is
+// not.
+func rawReason(m *RpcServiceMethod) string {
+	if m.raw() && m.replyType != typeOfRawResponse && m.replyType != reflect.PtrTo(typeOfRawResponse) {
+		return "it takes a *rpcserver.RawRequest without replying a *rpcserver.RawResponse"
+	}
+	return ""
+}
+
+// rawMethod returns the passthrough method of the name, nil if it is not one.
+func (reg *registry) rawMethod(name string) *RpcServiceMethod {
+	if m, err := reg.method(name); err == nil && m.raw() {
+		return m
+	}
+	return nil
+}
+
+// SetRawContentTypes sets the Content-Types the passthrough method of the
+// name accepts besides the ones of the codecs, e.g.
+// "application/octet-stream", replacing the ones set before. It may be
+// called while the server is handling requests.
+func (s *Server) SetRawContentTypes(method string, contentTypes ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if reg.rawMethod(method) == nil {
+		return fmt.Errorf("rpc: method %s is not a passthrough method", method)
+	}
+	rawContentTypes := make(map[string][]string, len(reg.rawContentTypes)+1)
+	for key, v := range reg.rawContentTypes {
+		rawContentTypes[key] = v
+	}
+	if len(contentTypes) == 0 {
+		delete(rawContentTypes, method)
+	} else {
+		accepted := make([]string, len(contentTypes))
+		for i, contentType := range contentTypes {
+			accepted[i] = mediaType(contentType)
+		}
+		rawContentTypes[method] = accepted
+	}
+	reg.rawContentTypes = rawContentTypes
+	s.registry.Store(&reg)
+	return nil
+}
+
+// acceptsRaw tells if the passthrough method of the name accepts the
+// Content-Type of no codec, see SetRawContentTypes.
+func (reg *registry) acceptsRaw(method, contentType string) bool {
+	for _, accepted := range reg.rawContentTypes[method] {
+		if accepted == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRaw serves the call of a passthrough method, done reading the body once
+// bodyFailed answered the failures of its readers.
+func (s *Server) serveRaw(w http.ResponseWriter, r *http.Request, reg *registry, codec Codec, methodSpec *RpcServiceMethod, info *callInfo, clock Clock, bodyFailed func() bool) {
+	decodeStart := clock.Now()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if !bodyFailed() {
+			s.writeTransportError(w, r, codec, 400, err)
+		}
+		return
+	}
+	args := &RawRequest{ContentType: r.Header.Get("Content-Type"), Body: body}
+	if err := reg.validate(r.Context(), info.method, args); err != nil {
+		s.writeTransportError(w, r, codec, 400, err)
+		return
+	}
+	call := &Call{
+		Request: r,
+		Method:  info.method,
+		Args:    args,
+		Reply:   methodSpec.newReply(),
+	}
+	called := clock.Now()
+	errResult := reg.chain(s.invoker(reg, methodSpec))(r.Context(), call)
+	returned := clock.Now()
+	elapsed := returned.Sub(info.start)
+	slow := s.SlowCalls != nil && s.SlowCalls.observe(call, elapsed, errResult)
+	s.record(info.method, info, elapsed, errResult, slow)
+	if s.SLO != nil {
+		s.SLO.observe(info.method, errResult, clock.Now())
+	}
+
+	encode := func(w http.ResponseWriter) {
+		if errResult != nil {
+			s.writeTransportError(w, r, codec, callStatus(errResult), errResult)
+			return
+		}
+		if cacheControl := reg.cacheControlOf(info.method, methodSpec); cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		writeRaw(w, call.Reply)
+	}
+	info.writeResponse(w, r, func(w http.ResponseWriter) {
+		if s.ServerTiming || s.Signer != nil {
+			s.writeBuffered(w, r, codec, clock, called.Sub(decodeStart), returned.Sub(called), encode)
+		} else {
+			encode(w)
+		}
+	})
+}
+
+// writeRaw writes the RawResponse of a passthrough method.
+func writeRaw(w http.ResponseWriter, reply interface{}) {
+	res := &RawResponse{}
+	switch reply := reply.(type) {
+	case *RawResponse:
+		if reply != nil {
+			res = reply
+		}
+	case RawResponse:
+		res = &reply
+	}
+	if res.ContentType != "" {
+		w.Header().Set("Content-Type", res.ContentType)
+	}
+	status := res.Status
+	if status == 0 {
+		status = 200
+	}
+	w.WriteHeader(status)
+	w.Write(res.Body)
+}
@@ -0,0 +1,149 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrWrongRegion is wrapped by the RegionError of the calls of data homed in
+// another region than the one of the server.
+var ErrWrongRegion = errors.New("rpc: data homed in another region")
+
+// RegionError is the error of the calls of data homed in another region, see
+// ResidencyPolicy. The calls are redirected to the Endpoint of the region
+// with 421, or rejected with 403 when it is empty. The JSON-RPC 2.0 codec
+// sends it as the data of a jsonrpc2.E_WRONG_REGION error.
+type RegionError struct {
+	// Region is the home region of the data of the call.
+	Region string `json:"region"`
+
+	// Endpoint serves the region, empty when the call is rejected.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Caller is the region of the caller, if known.
+	Caller string `json:"caller,omitempty"`
+}
+
+func (e *RegionError) Error() string {
+	if e.Endpoint != "" {
+		return fmt.Sprintf("%v: %s, call %s", ErrWrongRegion, e.Region, e.Endpoint)
+	}
+	if e.Caller != "" {
+		return fmt.Sprintf("%v: %s, not reachable from %s", ErrWrongRegion, e.Region, e.Caller)
+	}
+	return fmt.Sprintf("%v: %s", ErrWrongRegion, e.Region)
+}
+
+// Unwrap returns ErrWrongRegion.
+func (e *RegionError) Unwrap() error {
+	return ErrWrongRegion
+}
+
+// ResidencyPolicy keeps the calls of data homed in a region within the servers
+// of the region, for compliance-constrained deployments: data residency laws
+// or contracts. The home region of the data of a call is the value of the
+// field of its args tagged rpc:"region":
+//
+//	type GetCustomerArgs struct {
+//		ID     string
+//		Region string `rpc:"region"`
+//	}
+//
+// The calls of data homed in the Region of the policy are served, the others
+// fail with a RegionError before the method is called.
+type ResidencyPolicy struct {
+	// Region is the region of the server.
+	Region string
+
+	// Endpoints are the endpoints of the regions, such as
+	// "https://eu.api.example.com/rpc", by region. The calls of data homed
+	// in a region without endpoint are rejected.
+	Endpoints map[string]string
+
+	// HomeRegion returns the home region of the data of a call when set,
+	// rather than the field of its args tagged rpc:"region". Calls of no
+	// home region are served.
+	HomeRegion func(ctx context.Context, method string, args interface{}) string
+
+	// CallerRegion returns the region of the caller when set, e.g. from a
+	// header of the edge proxy or the claims of its token, empty if unknown.
+	CallerRegion func(ctx context.Context) string
+
+	// AllowCrossRegion tells whether the callers of a region may reach the
+	// data homed in another, they are redirected then. They are rejected when
+	// nil.
+	AllowCrossRegion func(caller, home string) bool
+}
+
+// check returns the RegionError of the call when its data is homed in another
+// region.
+func (p *ResidencyPolicy) check(ctx context.Context, call *Call) error {
+	var home string
+	if p.HomeRegion != nil {
+		home = p.HomeRegion(ctx, call.Method, call.Args)
+	} else {
+		home = RegionOf(call.Args)
+	}
+	if home == "" || home == p.Region {
+		return nil
+	}
+	err := &RegionError{Region: home}
+	if p.CallerRegion != nil {
+		err.Caller = p.CallerRegion(ctx)
+	}
+	if err.Caller != "" && err.Caller != home && (p.AllowCrossRegion == nil || !p.AllowCrossRegion(err.Caller, home)) {
+		return err
+	}
+	err.Endpoint = p.Endpoints[home]
+	return err
+}
+
+var regionFields sync.Map // reflect.Type -> int, -1 if none
+
+// RegionOf returns the home region of the data of the args of a call: the
+// value of the field tagged rpc:"region", or "" if there is none. See
+// ResidencyPolicy.
+func RegionOf(args interface{}) string {
+	v := reflect.ValueOf(args)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	i := regionFieldOf(v.Type())
+	if i < 0 {
+		return ""
+	}
+	field := v.Field(i)
+	for field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return ""
+		}
+		field = field.Elem()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// regionFieldOf returns the index of the region field of a struct type, -1 if
+// none.
+func regionFieldOf(t reflect.Type) int {
+	if i, ok := regionFields.Load(t); ok {
+		return i.(int)
+	}
+	index := -1
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" && ParseFieldTag(field.Tag.Get("rpc")).Region {
+			index = i
+			break
+		}
+	}
+	regionFields.Store(t, index)
+	return index
+}
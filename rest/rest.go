@@ -0,0 +1,214 @@
+// Package rest exposes the methods of an rpcserver.Server as REST-ish
+// endpoints taking the args as a plain JSON body and answering the reply,
+// without the JSON-RPC envelope, for clients and API gateways unaware of RPC:
+//
+//	http.Handle("/api/", rest.Handler("/api", server))
+//
+//	POST /api/arith/multiply
+//	{"A": 3, "B": 4}
+//
+//	200 OK
+//	12
+//
+// The service and the method names of the path match case-insensitively.
+// Methods taking several params read them from a JSON array. Methods without
+// a reply answer 204, errors are answered as rpcserver.ErrorResponse objects
+// with their HTTP status.
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ContentType names the codec serving the REST requests in the stats of the
+// server.
+const ContentType = "rest"
+
+// Handler serves the REST endpoints of the server under the path prefix.
+func Handler(prefix string, server *rpcserver.Server) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	codec := new(Codec)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(rpcserver.WithCodec(r.Context(), codec, ContentType))
+		service := server.Service()
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix+"/"), "/")
+		if len(parts) != 2 || !strings.EqualFold(parts[0], service.Name()) {
+			res := rpcserver.NewErrorResponse(r, 404, fmt.Errorf("rpc: no endpoint %s", r.URL.Path))
+			codec.WriteErrorResponse(w, r, res)
+			return
+		}
+		for _, name := range service.MethodNames() {
+			if strings.EqualFold(parts[1], name) {
+				// The server reads the method from the last part of the path.
+				u := *r.URL
+				u.Path = prefix + "/" + parts[0] + "/" + name
+				r2 := *r
+				r2.URL = &u
+				r = &r2
+				break
+			}
+		}
+		server.ServeHTTP(w, r)
+	})
+}
+
+// Codec reads the args from a plain JSON body and writes the reply as is.
+type Codec struct{}
+
+// NewRequest returns the CodecRequest of r.
+func (c *Codec) NewRequest(r *http.Request) rpcserver.CodecRequest {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	engine := rpcserver.JSONEngineFromContext(r.Context())
+	if engine == nil {
+		engine = rpcserver.StdJSON
+	}
+	return &CodecRequest{request: r, body: bytes.TrimSpace(body), err: err, engine: engine}
+}
+
+// WriteErrorResponse writes transport-level errors as JSON.
+func (c *Codec) WriteErrorResponse(w http.ResponseWriter, r *http.Request, res *rpcserver.ErrorResponse) {
+	rpcserver.JSONErrorWriter{}.WriteErrorResponse(w, r, res)
+}
+
+// CodecRequest is the request of a REST endpoint.
+type CodecRequest struct {
+	request *http.Request
+	body    []byte
+	err     error
+	engine  rpcserver.JSONEngine
+}
+
+// Error returns the error reading the body.
+func (c *CodecRequest) Error() error {
+	return c.err
+}
+
+// Method returns the method called at the path, see rpcserver.PathMethod.
+func (c *CodecRequest) Method() (string, error) {
+	return rpcserver.PathMethod(c.request), nil
+}
+
+// ReadRequest decodes the body into args, leaving them zero for an empty body.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	rules := rpcserver.ParamsRulesOf(reflect.TypeOf(args).Elem())
+	if rules != nil && (len(c.body) == 0 || c.body[0] != '[' || !isPositional(args)) {
+		prepared, err := rules.Prepare(c.body)
+		if err != nil {
+			return invalidParams(err)
+		}
+		c.body = prepared
+	}
+	if len(c.body) == 0 {
+		return nil
+	}
+	if c.body[0] == '[' && isPositional(args) {
+		return c.readPositional(args, rules)
+	}
+	if err := c.engine.Unmarshal(c.body, args); err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+// isPositional tells if args is the struct of a method taking several params,
+// see rpcserver.RpcService.
+func isPositional(args interface{}) bool {
+	t := reflect.TypeOf(args).Elem()
+	return t.Kind() == reflect.Struct && t.Name() == "" && t.NumField() > 0 && t.Field(0).Name == "P0"
+}
+
+// readPositional decodes a JSON array into the fields of args in order, the
+// variadic last field collecting the remaining values, once the rules of the
+// fields, if any, are applied.
+func (c *CodecRequest) readPositional(args interface{}, rules *rpcserver.ParamsRules) error {
+	var values []json.RawMessage
+	if err := c.engine.Unmarshal(c.body, &values); err != nil {
+		return badRequest(err)
+	}
+	v := reflect.ValueOf(args).Elem()
+	n := v.NumField()
+	variadic := rpcserver.ParseFieldTag(v.Type().Field(n - 1).Tag.Get("rpc")).Variadic
+	if rules != nil {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = v.Type().Field(i).Name
+		}
+		var err error
+		if values, err = rules.PreparePositional(values, names, variadic); err != nil {
+			return invalidParams(err)
+		}
+	}
+	if len(values) > n && !variadic {
+		return badRequest(fmt.Errorf("expected at most %d params, got %d", n, len(values)))
+	}
+	for i, value := range values {
+		if i >= n-1 && variadic {
+			field := v.Field(n - 1)
+			elem := reflect.New(field.Type().Elem())
+			if err := c.engine.Unmarshal(value, elem.Interface()); err != nil {
+				return badRequest(err)
+			}
+			field.Set(reflect.Append(field, elem.Elem()))
+			continue
+		}
+		if err := c.engine.Unmarshal(value, v.Field(i).Addr().Interface()); err != nil {
+			return badRequest(err)
+		}
+	}
+	return nil
+}
+
+// invalidParams returns the error of params breaking their rules, listing
+// the fields in the details.
+func invalidParams(err error) error {
+	res := &rpcserver.ErrorResponse{Status: 400, Message: err.Error()}
+	var invalid *rpcserver.ParamsError
+	if errors.As(err, &invalid) {
+		res.Details = invalid.Errors
+	}
+	return res
+}
+
+func badRequest(err error) error {
+	return &rpcserver.ErrorResponse{Status: 400, Message: "rpc: invalid body: " + err.Error()}
+}
+
+// WriteResponse writes the reply as JSON, or 204 for methods without a reply.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	if reply == nil {
+		w.WriteHeader(204)
+		return
+	}
+	data, err := c.engine.Marshal(reply)
+	if err != nil {
+		c.WriteError(w, 500, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(append(data, '\n'))
+}
+
+// WriteError writes the error as an rpcserver.ErrorResponse with the status,
+// or with the status of err when it is an rpcserver.ErrorResponse.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	var detailed *rpcserver.ErrorResponse
+	var invalid *rpcserver.ParamsError
+	if !errors.As(err, &detailed) && errors.As(err, &invalid) {
+		err = invalidParams(err)
+		errors.As(err, &detailed)
+	}
+	if detailed != nil && detailed.Status != 0 {
+		status = detailed.Status
+	}
+	res := rpcserver.NewErrorResponse(c.request, status, err)
+	rpcserver.JSONErrorWriter{}.WriteErrorResponse(w, c.request, res)
+}
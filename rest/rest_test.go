@@ -0,0 +1,82 @@
+package rest
+
+import (
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Range struct {
+	From int `rpc:"required"`
+	To   int `rpc:"required"`
+}
+
+type Arith int
+
+func (t *Arith) Span(r *http.Request, args *Range) (int, error) {
+	return args.To - args.From, nil
+}
+
+func (t *Arith) Multiply(r *http.Request, args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Check(r *http.Request, args *Args) error {
+	if args.B == 0 {
+		return errors.New("B must not be zero")
+	}
+	return nil
+}
+
+func (t *Arith) Max(r *http.Request, first int, rest ...int) (int, error) {
+	for _, v := range rest {
+		if v > first {
+			first = v
+		}
+	}
+	return first, nil
+}
+
+func TestHandler(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Handler("/api", server)
+
+	for _, tc := range []struct {
+		path, body string
+		status     int
+		expected   string
+	}{
+		{"/api/arith/multiply", `{"A": 3, "B": 4}`, 200, "12\n"},
+		{"/api/Arith/Multiply", ``, 200, "0\n"},
+		{"/api/arith/max", `[3, 7, 5]`, 200, "7\n"},
+		{"/api/arith/check", `{"A": 1, "B": 1}`, 204, ""},
+		{"/api/arith/check", `{"A": 1}`, 400, `"message":"B must not be zero"`},
+		{"/api/arith/multiply", `{"A": "x"}`, 400, `"message":"rpc: invalid body: `},
+		{"/api/arith/span", `{"From": 0, "To": 3}`, 200, "3\n"},
+		{"/api/arith/span", ``, 400, `"details":[{"field":"From","message":"is required"},{"field":"To","message":"is required"}]`},
+		{"/api/arith/divide", `{}`, 404, `"status":404`},
+		{"/api/other/multiply", `{}`, 404, `"status":404`},
+	} {
+		r := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s %s: expected %d %q, got %d %q", tc.path, tc.body, tc.status, tc.expected, w.Code, w.Body)
+		}
+	}
+	if stats := server.Stats(); stats.Codecs[ContentType] != 9 {
+		t.Errorf("expected the calls counted under %q, got %v", ContentType, stats.Codecs)
+	}
+}
@@ -0,0 +1,76 @@
+package rpcserver
+
+import (
+	"sort"
+	"strings"
+)
+
+// routeCodecs restricts the codecs of the requests under a URL prefix.
+type routeCodecs struct {
+	prefix       string
+	contentTypes []string
+	codecs       map[string]Codec // the registered codecs of contentTypes
+}
+
+// SetRouteCodecs restricts the requests whose URL path starts with prefix to
+// the registered codecs of the content types, e.g. to accept msgpack and
+// protobuf on an internal route but only JSON on the public one:
+//
+//	server.SetRouteCodecs("/internal/", "application/x-msgpack", "application/x-protobuf")
+//	server.SetRouteCodecs("/public/", "application/json")
+//
+// The requests of other Content-Types are rejected as if no codec was
+// registered for them. The longest prefix matching the path applies, the
+// requests of no prefix use every codec. The codecs registered later are
+// included once their content type is, no content types removes the
+// restriction of the prefix.
+//
+// Routes may be restricted while the server is handling requests.
+func (s *Server) SetRouteCodecs(prefix string, contentTypes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	routes := make([]routeCodecs, 0, len(reg.routeCodecs)+1)
+	for _, route := range reg.routeCodecs {
+		if route.prefix != prefix {
+			routes = append(routes, route)
+		}
+	}
+	if len(contentTypes) > 0 {
+		route := routeCodecs{prefix: prefix}
+		for _, contentType := range contentTypes {
+			route.contentTypes = append(route.contentTypes, strings.ToLower(contentType))
+		}
+		routes = append(routes, route)
+	}
+	// The longest prefixes first.
+	sort.Slice(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+	reg.routeCodecs = routes
+	reg.restrictRoutes()
+	s.registry.Store(&reg)
+}
+
+// restrictRoutes computes the codecs of the routes from the registered ones.
+func (reg *registry) restrictRoutes() {
+	routes := make([]routeCodecs, len(reg.routeCodecs))
+	for i, route := range reg.routeCodecs {
+		route.codecs = make(map[string]Codec, len(route.contentTypes))
+		for _, contentType := range route.contentTypes {
+			if codec, ok := reg.codecs[contentType]; ok {
+				route.codecs[contentType] = codec
+			}
+		}
+		routes[i] = route
+	}
+	reg.routeCodecs = routes
+}
+
+// codecsOf returns the codecs of the requests of the path.
+func (reg *registry) codecsOf(path string) map[string]Codec {
+	for _, route := range reg.routeCodecs {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.codecs
+		}
+	}
+	return reg.codecs
+}
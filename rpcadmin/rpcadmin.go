@@ -0,0 +1,104 @@
+// Package rpcadmin serves the counters of an rpcserver.Server and, optionally,
+// the net/http/pprof profiles, for environments without a metrics stack:
+//
+//	server.PublishExpvar("rpc")
+//	http.Handle("/admin/", http.StripPrefix("/admin", rpcadmin.Handler(server, rpcadmin.Options{Pprof: true})))
+//
+// The handler serves:
+//
+//	/stats          the rpcserver.Stats of the server as JSON
+//	/vars           the published expvar variables, as expvar.Handler does
+//	/slo            the methods burning their error budget, when the server has an SLO
+//	/reload         reloads the configuration on POST, with Options.Reload
+//	/postman        a Postman collection of the methods, with Options.Endpoint
+//	/debug/pprof/   the profiles, with Options.Pprof
+//
+// Mount it on an internal listener or behind authentication, profiles and
+// counters are not meant for clients. The profiles are served by the handlers
+// of net/http/pprof registered on the mux of the handler only; importing the
+// package still registers them on http.DefaultServeMux too, as importing
+// net/http/pprof does, so the servers serving http.DefaultServeMux to clients
+// should not import it.
+package rpcadmin
+
+import (
+	"encoding/json"
+	"expvar"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/postman"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+)
+
+// Options selects the optional endpoints of the handler.
+type Options struct {
+	// Pprof serves the net/http/pprof profiles under /debug/pprof/.
+	Pprof bool
+
+	// Reload, when set, is called by POST requests to /reload, e.g. a
+	// closure calling the ReloadFile method of an rpcconfig.Server. Its error
+	// is answered with 500.
+	Reload func() error
+
+	// Endpoint is the URL the requests of the Postman collection call the
+	// methods at, e.g. "https://api.example.com/rpc".
+	Endpoint string
+}
+
+// Handler returns the admin handler of the server.
+func Handler(server *rpcserver.Server, opts Options) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, server.Stats())
+	})
+	mux.Handle("/vars", expvar.Handler())
+	mux.HandleFunc("/slo", func(w http.ResponseWriter, r *http.Request) {
+		if server.SLO == nil {
+			http.NotFound(w, r)
+			return
+		}
+		burning := server.SLO.Burning()
+		if burning == nil {
+			burning = []string{}
+		}
+		writeJSON(w, map[string]interface{}{"burning": burning})
+	})
+	if opts.Reload != nil {
+		mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				w.Header().Set("Allow", "POST")
+				http.Error(w, "rpcadmin: POST required", 405)
+				return
+			}
+			if err := opts.Reload(); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.WriteHeader(204)
+		})
+	}
+	if opts.Endpoint != "" {
+		mux.HandleFunc("/postman", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", `attachment; filename="`+server.Service().Name()+`.postman_collection.json"`)
+			writeJSON(w, postman.Export(introspect.Describe(server.Service()), opts.Endpoint))
+		})
+	}
+	if opts.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		for _, profile := range runtimepprof.Profiles() {
+			mux.Handle("/debug/pprof/"+profile.Name(), pprof.Handler(profile.Name()))
+		}
+	}
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,125 @@
+package rpcadmin
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/postman"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Echo struct{}
+
+func (e *Echo) Say(r *http.Request, args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	server.SLO = &rpcserver.SLOPolicy{Objective: 0.99}
+	server.PublishExpvar("rpcadmin_test")
+	r := httptest.NewRequest("POST", "/rpc/Say", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Say", "id": 1, "params": "hi"}`))
+	r.Header.Set("Content-Type", "application/json")
+	server.ServeHTTP(httptest.NewRecorder(), r)
+
+	admin := httptest.NewServer(http.StripPrefix("/admin", Handler(server, Options{Pprof: true})))
+	defer admin.Close()
+	get := func(path string) *http.Response {
+		resp, err := http.Get(admin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var stats rpcserver.Stats
+	resp := get("/admin/stats")
+	json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	if stats.Requests != 1 || stats.Codecs["application/json"] != 1 || len(stats.Methods) != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	var vars map[string]json.RawMessage
+	resp = get("/admin/vars")
+	json.NewDecoder(resp.Body).Decode(&vars)
+	resp.Body.Close()
+	if _, ok := vars["rpcadmin_test"]; !ok {
+		t.Errorf("expected the published stats in %v", vars)
+	}
+
+	var slo struct{ Burning []string }
+	resp = get("/admin/slo")
+	json.NewDecoder(resp.Body).Decode(&slo)
+	resp.Body.Close()
+	if slo.Burning == nil || len(slo.Burning) != 0 {
+		t.Errorf("unexpected burning methods %v", slo.Burning)
+	}
+
+	if resp = get("/admin/debug/pprof/"); resp.StatusCode != 200 {
+		t.Errorf("expected the pprof index, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+	resp = get("/admin/debug/pprof/goroutine?debug=1")
+	profile, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(string(profile), "goroutine profile") {
+		t.Errorf("expected the goroutine profile, got %d %q", resp.StatusCode, profile)
+	}
+	w := httptest.NewRecorder()
+	Handler(server, Options{}).ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if w.Code != 404 {
+		t.Errorf("expected no pprof without Options.Pprof, got %d", w.Code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := 0
+	handler := Handler(server, Options{Reload: func() error {
+		reloads++
+		return nil
+	}})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != 204 || reloads != 1 {
+		t.Errorf("unexpected reload response %d after %d reloads", w.Code, reloads)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/reload", nil))
+	if w.Code != 405 || reloads != 1 {
+		t.Errorf("expected GET to be refused, got %d", w.Code)
+	}
+}
+
+func TestPostman(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Handler(server, Options{Endpoint: "https://api.example.com/rpc"}).ServeHTTP(w, httptest.NewRequest("GET", "/postman", nil))
+	var c postman.Collection
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil || len(c.Item) == 0 || c.Variable[0].Value != "https://api.example.com/rpc" {
+		t.Errorf("unexpected collection %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	Handler(server, Options{}).ServeHTTP(w, httptest.NewRequest("GET", "/postman", nil))
+	if w.Code != 404 {
+		t.Errorf("expected no collection without an endpoint, got %d", w.Code)
+	}
+}
@@ -0,0 +1,89 @@
+// Package rpcbalancer balances the calls of clients between the instances of
+// an rpcserver.Server, honoring their routing hints: the calls with an
+// rpcserver.AffinityHeader, or an affinity cookie, go to the instance chosen
+// for their key, the others are spread round-robin.
+//
+//	balancer := &rpcbalancer.Balancer{Backends: []*url.URL{a, b, c}}
+//	http.ListenAndServe(":8080", balancer)
+//
+// Keys are mapped to instances with rendezvous hashing: adding or removing an
+// instance only moves the keys of that instance. Sticky rpcclient.Clients send
+// the keys the servers answer with, see rpcserver.AffinityOf.
+package rpcbalancer
+
+import (
+	"github.com/datalinkE/rpcserver"
+	"hash/fnv"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer is a reverse proxy to the instances of a server.
+type Balancer struct {
+	// Backends are the URLs of the instances.
+	Backends []*url.URL
+
+	// Cookie names the cookie holding the affinity key of the requests
+	// without an rpcserver.AffinityHeader, the AffinityCookie of the
+	// servers. Cookies are ignored when empty.
+	Cookie string
+
+	// Transport performs the proxied requests, http.DefaultTransport when
+	// nil.
+	Transport http.RoundTripper
+
+	once    sync.Once
+	proxies []*httputil.ReverseProxy
+	next    uint64 // round-robin counter of the requests without a key
+}
+
+// ServeHTTP proxies the request to the instance of its affinity key.
+func (b *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.once.Do(func() {
+		for _, backend := range b.Backends {
+			proxy := httputil.NewSingleHostReverseProxy(backend)
+			proxy.Transport = b.Transport
+			b.proxies = append(b.proxies, proxy)
+		}
+	})
+	if len(b.proxies) == 0 {
+		rpcserver.WriteError(w, 503, "rpcbalancer: no backends")
+		return
+	}
+	b.proxies[b.Pick(b.keyOf(r))].ServeHTTP(w, r)
+}
+
+// keyOf returns the affinity key of the request, "" if it has none.
+func (b *Balancer) keyOf(r *http.Request) string {
+	if key := r.Header.Get(rpcserver.AffinityHeader); key != "" {
+		return key
+	}
+	if b.Cookie != "" {
+		if cookie, err := r.Cookie(b.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Pick returns the index of the backend of the affinity key, the next one
+// round-robin when the key is empty.
+func (b *Balancer) Pick(key string) int {
+	if key == "" {
+		return int(atomic.AddUint64(&b.next, 1) % uint64(len(b.Backends)))
+	}
+	best, bestScore := 0, uint64(0)
+	for i, backend := range b.Backends {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(backend.String()))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
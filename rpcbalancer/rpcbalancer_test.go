@@ -0,0 +1,88 @@
+package rpcbalancer
+
+import (
+	"context"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type AddArgs struct {
+	CartID string `rpc:"affinity"`
+	Item   string
+}
+
+type Carts struct {
+	name  string
+	items map[string][]string
+}
+
+func (c *Carts) Add(r *http.Request, args *AddArgs, reply *string) error {
+	c.items[args.CartID] = append(c.items[args.CartID], args.Item)
+	*reply = c.name
+	return nil
+}
+
+func TestBalancer(t *testing.T) {
+	balancer := &Balancer{Cookie: "rpc-affinity"}
+	for _, name := range []string{"a", "b", "c"} {
+		srv := rpcservertest.NewServer(t, &Carts{name: name, items: make(map[string][]string)})
+		defer srv.Close()
+		srv.RPC.AffinityCookie = "rpc-affinity"
+		u, _ := url.Parse(srv.URL)
+		balancer.Backends = append(balancer.Backends, u)
+	}
+	front := httptest.NewServer(balancer)
+	defer front.Close()
+
+	client := rpcclient.NewClient(front.URL + rpcservertest.Path)
+	client.Sticky = true
+	var first, instance string
+	if err := client.Call(context.Background(), "Add", &AddArgs{CartID: "cart-1", Item: "apple"}, &first); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "b", "c"}[balancer.Pick("cart-1")]
+	for i := 0; i < 5; i++ {
+		if err := client.Call(context.Background(), "Add", &AddArgs{CartID: "cart-1", Item: "pear"}, &instance); err != nil {
+			t.Fatal(err)
+		}
+		if instance != expected {
+			t.Errorf("expected the calls of the cart on %s, got %s", expected, instance)
+		}
+	}
+
+	if balancer.Pick("cart-1") != balancer.Pick("cart-1") {
+		t.Errorf("expected the picks to be stable")
+	}
+	seen := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		seen[balancer.Pick("")] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected the calls without key to be spread, got %v", seen)
+	}
+}
+
+func TestHints(t *testing.T) {
+	srv := rpcservertest.NewServer(t, &Carts{name: "a", items: make(map[string][]string)})
+	defer srv.Close()
+	srv.RPC.AffinityCookie = "rpc-affinity"
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/rpc/Add", strings.NewReader(`{"jsonrpc": "2.0", "method": "Add", "id": 1, "params": {"CartID": "cart-2"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	srv.RPC.ServeHTTP(w, r)
+	if w.Header().Get(rpcserver.AffinityHeader) != "cart-2" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "rpc-affinity" || cookies[0].Value != "cart-2" {
+		t.Errorf("unexpected cookies %v", cookies)
+	}
+	if key := rpcserver.AffinityOf(&AddArgs{Item: "apple"}); key != "" {
+		t.Errorf("expected no key for zero fields, got %q", key)
+	}
+}
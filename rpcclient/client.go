@@ -0,0 +1,320 @@
+package rpcclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// Request and Response
+// ----------------------------------------------------------------------------
+
+// clientRequest represents a JSON-RPC request sent by the client.
+type clientRequest struct {
+	// JSON-RPC protocol.
+	Version string `json:"jsonrpc"`
+
+	// A String containing the name of the method to be invoked.
+	Method string `json:"method"`
+
+	// Object to pass as request parameter to the method.
+	Params interface{} `json:"params"`
+
+	// The request id. This can be of any type. It is used to match the
+	// response with the request that it is replying to.
+	Id uint64 `json:"id"`
+}
+
+// clientResponse represents a JSON-RPC response returned to the client.
+type clientResponse struct {
+	Version string           `json:"jsonrpc"`
+	Result  *json.RawMessage `json:"result"`
+	Error   *jsonrpc2.Error  `json:"error"`
+	Id      *json.RawMessage `json:"id"`
+}
+
+// StatusError is returned when the server replies with a non-JSON-RPC response,
+// e.g. an error page of a proxy or a text/plain transport-level error.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("rpc: unexpected HTTP status %d: %s", e.StatusCode, e.Body)
+}
+
+// ----------------------------------------------------------------------------
+// Client
+// ----------------------------------------------------------------------------
+
+// Client calls methods of a remote rpcserver.Server using the JSON-RPC 2.0 codec.
+// Calls forward the deadline, the trace and the metadata of their context, see
+// rpcserver.MetadataFromContext.
+type Client struct {
+	// URL is the endpoint prefix the server is mounted on, e.g.
+	// "http://localhost:8080/jsonrpc/v2". The method name is appended as the last
+	// path element, as the server requires.
+	URL string
+
+	// HTTPClient performs the requests. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+
+	// Retry configures retries of failed calls. Calls are not retried when nil.
+	Retry *RetryPolicy
+
+	// Sticky clients send the affinity key of the last response having one
+	// with their calls, in the rpcserver.AffinityHeader, for the load
+	// balancer to route them to the same instance. WithAffinity sets the
+	// key of a call.
+	Sticky bool
+
+	// Resolver, when set, resolves the host of the URL as the name of a
+	// service, e.g. "http://orders/rpc", into the addresses of its
+	// instances, which the calls and their attempts use in turn. The
+	// service is watched from the first call until Close.
+	Resolver Resolver
+
+	// Clock times the watches of the Resolver after their failures,
+	// rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	// ForwardMetadata lists the headers of the metadata of the context, see
+	// rpcserver.MetadataFromContext, sent with the calls unless set for the
+	// call. No metadata is sent when empty: list credentials such as
+	// Authorization only for the services trusted with them.
+	ForwardMetadata []string
+
+	mu           sync.RWMutex
+	idempotent   map[string]bool
+	interceptors []Interceptor
+	affinity     string // the affinity key of the last response, see Sticky
+	lastId       uint64
+
+	targets      map[string]*target // the services watched, see Resolver
+	watching     context.Context
+	stopWatching context.CancelFunc
+}
+
+// Call describes a single outbound method call passing through the interceptors.
+type Call struct {
+	// Method is the name of the remote method.
+	Method string
+
+	// Args is the value sent as the method params.
+	Args interface{}
+
+	// Reply is the value the result is decoded into.
+	Reply interface{}
+
+	// Header is sent with every attempt of the call.
+	Header http.Header
+}
+
+// CallFunc performs a call.
+type CallFunc func(ctx context.Context, call *Call) error
+
+// CallOption adjusts a single call before it passes through the interceptors.
+type CallOption func(call *Call)
+
+// WithHeader returns a CallOption adding a header to the call.
+func WithHeader(key, value string) CallOption {
+	return func(call *Call) {
+		call.Header.Add(key, value)
+	}
+}
+
+// WithAffinity returns a CallOption sending the affinity key with the call,
+// see rpcserver.AffinityOf.
+func WithAffinity(key string) CallOption {
+	return func(call *Call) {
+		call.Header.Set(rpcserver.AffinityHeader, key)
+	}
+}
+
+// Interceptor wraps a CallFunc to run code around every outbound call, e.g. to
+// inject auth headers, trace, count or log calls.
+type Interceptor func(next CallFunc) CallFunc
+
+// NewClient creates a Client for the server mounted at url.
+func NewClient(url string) *Client {
+	return &Client{
+		URL:        strings.TrimRight(url, "/"),
+		idempotent: make(map[string]bool),
+	}
+}
+
+// SetIdempotent marks methods as safe to execute more than once.
+//
+// Calls of idempotent methods carry an Idempotency-Key header, which stays the
+// same across retried attempts, and are retried by the RetryPolicy.
+func (c *Client) SetIdempotent(methods ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, method := range methods {
+		c.idempotent[method] = true
+	}
+}
+
+// IsIdempotent returns true if the method was marked with SetIdempotent.
+func (c *Client) IsIdempotent(method string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idempotent[method]
+}
+
+// Use adds interceptors to the client. The first added interceptor is the
+// outermost one: it sees the call before and the result after all the others.
+//
+// Interceptors run once per call, retried attempts share the call Header.
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// Call invokes the named method, waits for it to complete and decodes the
+// result into reply.
+//
+// Errors returned by the remote method are of type *jsonrpc2.Error.
+func (c *Client) Call(ctx context.Context, method string, args interface{}, reply interface{}, opts ...CallOption) error {
+	call := &Call{
+		Method: method,
+		Args:   args,
+		Reply:  reply,
+		Header: make(http.Header),
+	}
+	if c.IsIdempotent(method) {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return err
+		}
+		call.Header.Set(rpcserver.IdempotencyKeyHeader, key)
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+
+	c.mu.RLock()
+	invoke := CallFunc(c.invoke)
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		invoke = c.interceptors[i](invoke)
+	}
+	c.mu.RUnlock()
+	return invoke(ctx, call)
+}
+
+// invoke performs the call, retrying it according to the RetryPolicy.
+func (c *Client) invoke(ctx context.Context, call *Call) error {
+	if c.Retry == nil {
+		return c.do(ctx, call)
+	}
+	return c.Retry.run(ctx, c.IsIdempotent(call.Method), func() error {
+		return c.do(ctx, call)
+	})
+}
+
+// do performs a single attempt of the call.
+func (c *Client) do(ctx context.Context, call *Call) error {
+	body, err := json.Marshal(&clientRequest{
+		Version: jsonrpc2.Version,
+		Method:  call.Method,
+		Params:  call.Args,
+		Id:      atomic.AddUint64(&c.lastId, 1),
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := c.resolve(ctx, call.Method)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range call.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if md := rpcserver.MetadataFromContext(ctx); md != nil {
+		for _, name := range c.ForwardMetadata {
+			// The metadata of the calling server, unless set for the call.
+			key := textproto.CanonicalMIMEHeaderKey(name)
+			if _, ok := req.Header[key]; !ok && len(md[key]) > 0 {
+				req.Header[key] = md[key]
+			}
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok && req.Header.Get(rpcserver.DeadlineHeader) == "" {
+		// Pass the time left on, a server forwarding the call passes less.
+		req.Header.Set(rpcserver.DeadlineHeader, rpcserver.FormatTimeout(time.Until(deadline)))
+	}
+	if t, ok := rpcserver.TraceFromContext(ctx); ok && req.Header.Get(rpcserver.TraceparentHeader) == "" {
+		// Each attempt is a span of the trace of the calling server.
+		t.Child().Inject(req.Header)
+	}
+	if c.Sticky && req.Header.Get(rpcserver.AffinityHeader) == "" {
+		c.mu.RLock()
+		if c.affinity != "" {
+			req.Header.Set(rpcserver.AffinityHeader, c.affinity)
+		}
+		c.mu.RUnlock()
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if key := resp.Header.Get(rpcserver.AffinityHeader); key != "" && c.Sticky {
+		c.mu.Lock()
+		c.affinity = key
+		c.mu.Unlock()
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	res := new(clientResponse)
+	if err := json.Unmarshal(data, res); err != nil || res.Version != jsonrpc2.Version {
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.Result == nil || call.Reply == nil {
+		return nil
+	}
+	return json.Unmarshal(*res.Result, call.Reply)
+}
+
+// newIdempotencyKey returns a random key for the Idempotency-Key header.
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
@@ -0,0 +1,298 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	quo.Quo = args.A / args.B
+	quo.Rem = args.A % args.B
+	return nil
+}
+
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	return httptest.NewServer(wrap(server))
+}
+
+func TestCall(t *testing.T) {
+	ts := newTestServer(t, func(h http.Handler) http.Handler { return h })
+	defer ts.Close()
+
+	client := NewClient(ts.URL + "/jsonrpc/v2/")
+	var quo Quotient
+	if err := client.Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo); err != nil {
+		t.Fatal(err)
+	}
+	if quo.Quo != 3 || quo.Rem != 1 {
+		t.Fatalf("unexpected reply %+v", quo)
+	}
+
+	err := client.Call(context.Background(), "Divide", &Args{A: 10}, &quo)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Message != "divide by zero" {
+		t.Fatalf("expected jsonrpc2 error, got %#v", err)
+	}
+
+	err = client.Call(context.Background(), "Missing", &Args{}, &quo)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Code != jsonrpc2.E_NO_METHOD {
+		t.Fatalf("expected method not found error, got %#v", err)
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	var keys []string
+	ts := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys = append(keys, r.Header.Get(rpcserver.IdempotencyKeyHeader))
+			if len(keys) < 3 {
+				rpcserver.WriteError(w, 503, "unavailable")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer ts.Close()
+
+	client := NewClient(ts.URL + "/jsonrpc/v2")
+	client.Retry = NewRetryPolicy()
+	client.Retry.InitialBackoff = time.Millisecond
+
+	var quo Quotient
+	if err := client.Call(context.Background(), "Divide", &Args{A: 4, B: 2}, &quo); err == nil {
+		t.Fatal("non-idempotent call should not be retried")
+	}
+	if len(keys) != 1 || keys[0] != "" {
+		t.Fatalf("unexpected attempts %q", keys)
+	}
+
+	keys = nil
+	client.SetIdempotent("Divide")
+	if err := client.Call(context.Background(), "Divide", &Args{A: 4, B: 2}, &quo); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Fatalf("expected 3 attempts sharing an idempotency key, got %q", keys)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	var auth string
+	ts := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer ts.Close()
+
+	var order []string
+	client := NewClient(ts.URL + "/jsonrpc/v2")
+	client.Use(func(next CallFunc) CallFunc {
+		return func(ctx context.Context, call *Call) error {
+			order = append(order, "outer:"+call.Method)
+			return next(ctx, call)
+		}
+	}, func(next CallFunc) CallFunc {
+		return func(ctx context.Context, call *Call) error {
+			order = append(order, "inner")
+			call.Header.Set("Authorization", "Bearer token")
+			return next(ctx, call)
+		}
+	})
+
+	var quo Quotient
+	if err := client.Call(context.Background(), "Divide", &Args{A: 4, B: 2}, &quo); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" {
+		t.Fatalf("interceptor header not sent, got %q", auth)
+	}
+	if len(order) != 2 || order[0] != "outer:Divide" || order[1] != "inner" {
+		t.Fatalf("unexpected interceptor order %q", order)
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	var timeout string
+	ts := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout = r.Header.Get(rpcserver.DeadlineHeader)
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var quo Quotient
+	if err := client.Call(ctx, "Divide", &Args{A: 10, B: 3}, &quo); err != nil {
+		t.Fatal(err)
+	}
+	d, err := rpcserver.ParseTimeout(timeout)
+	if err != nil || d <= 0 || d > time.Minute {
+		t.Fatalf("unexpected deadline header %q", timeout)
+	}
+
+	if err := client.Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != "" {
+		t.Fatalf("expected no deadline header without a deadline, got %q", timeout)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	var header http.Header
+	ts := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer ts.Close()
+
+	trace := rpcserver.Trace{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	client := NewClient(ts.URL)
+	var quo Quotient
+	if err := client.Call(rpcserver.WithTrace(context.Background(), trace), "Divide", &Args{A: 10, B: 3}, &quo); err != nil {
+		t.Fatal(err)
+	}
+	sent, ok := rpcserver.ParseTrace(header)
+	if !ok || sent.TraceID != trace.TraceID || sent.SpanID == trace.SpanID || !sent.Sampled {
+		t.Fatalf("unexpected trace headers %v", header)
+	}
+}
+
+// Forwarder forwards its calls to another service.
+type Forwarder struct {
+	client *Client
+}
+
+func (f *Forwarder) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	return f.client.Call(r.Context(), "Divide", args, quo, WithHeader("Accept-Language", "de"))
+}
+
+func TestMetadataPropagation(t *testing.T) {
+	var header http.Header
+	backend := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer backend.Close()
+	forwarding := NewClient(backend.URL)
+	forwarding.ForwardMetadata = []string{rpcserver.RequestIDHeader, "x-tenant", "Accept-Language"}
+	server, err := rpcserver.NewServer(&Forwarder{forwarding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	server.PropagateHeaders = []string{rpcserver.RequestIDHeader, "X-Tenant", "Authorization", "Accept-Language"}
+	frontend := httptest.NewServer(server)
+	defer frontend.Close()
+
+	var quo Quotient
+	err = NewClient(frontend.URL).Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo,
+		WithHeader(rpcserver.RequestIDHeader, "req-1"),
+		WithHeader("X-Tenant", "acme"),
+		WithHeader("Authorization", "Bearer token"),
+		WithHeader("Accept-Language", "fr"),
+		WithHeader("X-Secret", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get(rpcserver.RequestIDHeader) != "req-1" || header.Get("X-Tenant") != "acme" {
+		t.Errorf("expected the metadata forwarded, got %v", header)
+	}
+	if header.Get("Accept-Language") != "de" || header.Get("X-Secret") != "" || header.Get("Authorization") != "" {
+		t.Errorf("expected the headers of the call and no other, got %v", header)
+	}
+}
+
+// resolverFunc watches by sending the addresses received on updates.
+type resolverFunc chan []string
+
+func (f resolverFunc) Watch(ctx context.Context, service string, update func(addrs []string)) error {
+	if service != "arith" {
+		return errors.New("unknown service " + service)
+	}
+	for {
+		select {
+		case addrs := <-f:
+			update(addrs)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	var hits [2]int
+	servers := make([]*httptest.Server, 2)
+	for i := range servers {
+		i := i
+		servers[i] = newTestServer(t, func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits[i]++
+				h.ServeHTTP(w, r)
+			})
+		})
+		defer servers[i].Close()
+	}
+	updates := make(resolverFunc, 1)
+	client := NewClient("http://arith/jsonrpc/v2")
+	client.Resolver = updates
+	defer client.Close()
+
+	updates <- []string{servers[0].Listener.Addr().String(), servers[1].Listener.Addr().String()}
+	var quo Quotient
+	for i := 0; i < 4; i++ {
+		if err := client.Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != [2]int{2, 2} {
+		t.Errorf("unexpected balancing %v", hits)
+	}
+
+	updates <- nil
+	deadline := time.Now().Add(time.Second)
+	err := client.Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo)
+	for err == nil && time.Now().Before(deadline) {
+		err = client.Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo)
+	}
+	if err == nil || err.Error() != "rpcclient: no instance of service arith" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	client.URL = "http://missing/jsonrpc/v2"
+	if err := client.Call(context.Background(), "Divide", &Args{A: 10, B: 3}, &quo); err == nil || err.Error() != "rpcclient: cannot resolve service missing: unknown service missing" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
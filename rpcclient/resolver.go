@@ -0,0 +1,189 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// Resolver
+// ----------------------------------------------------------------------------
+
+// Resolver resolves the names of services into the addresses of their healthy
+// instances. rpcdiscovery.Consul and rpcdiscovery.Etcd are resolvers, as is
+// DNSResolver.
+type Resolver interface {
+	// Watch calls update with the addresses of the instances of the
+	// service, as "host:port", at first and whenever they change, until ctx
+	// is done or it fails.
+	Watch(ctx context.Context, service string, update func(addrs []string)) error
+}
+
+// target holds the addresses of a service, updated by the watch of the
+// resolver.
+type target struct {
+	ready chan struct{} // closed at the first update
+	mu    sync.RWMutex
+	addrs []string
+	err   error // the last error of the watch
+	next  uint32
+}
+
+// resolve returns the URL of the call, its host replaced by the address of an
+// instance of the service it names when the client has a Resolver. The
+// instances are used in turn.
+func (c *Client) resolve(ctx context.Context, method string) (string, error) {
+	if c.Resolver == nil {
+		return c.URL + "/" + method, nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+	t := c.target(u.Hostname())
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.addrs) == 0 {
+		if t.err != nil {
+			return "", fmt.Errorf("rpcclient: cannot resolve service %s: %v", u.Hostname(), t.err)
+		}
+		return "", fmt.Errorf("rpcclient: no instance of service %s", u.Hostname())
+	}
+	u.Host = t.addrs[int(atomic.AddUint32(&t.next, 1)-1)%len(t.addrs)]
+	return u.String() + "/" + method, nil
+}
+
+// target returns the target of the service, watching it at first.
+func (c *Client) target(service string) *target {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.targets[service]; ok {
+		return t
+	}
+	if c.targets == nil {
+		c.targets = make(map[string]*target)
+		c.watching, c.stopWatching = context.WithCancel(context.Background())
+	}
+	t := &target{ready: make(chan struct{})}
+	c.targets[service] = t
+	go c.watch(service, t)
+	return t
+}
+
+// watch keeps the target of the service up to date until the client is
+// closed, watching it again a second after every failure.
+func (c *Client) watch(service string, t *target) {
+	var once sync.Once
+	clock := rpcserver.ClockOrSystem(c.Clock)
+	for {
+		err := c.Resolver.Watch(c.watching, service, func(addrs []string) {
+			t.mu.Lock()
+			t.addrs, t.err = addrs, nil
+			t.mu.Unlock()
+			once.Do(func() { close(t.ready) })
+		})
+		if c.watching.Err() != nil {
+			// Closed: the calls keep the last addresses.
+			err = errors.New("client closed")
+		}
+		t.mu.Lock()
+		t.err = err
+		t.mu.Unlock()
+		once.Do(func() { close(t.ready) })
+		if c.watching.Err() != nil {
+			return
+		}
+		timer := clock.NewTimer(time.Second)
+		select {
+		case <-c.watching.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// Close stops watching the services resolved by the Resolver.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopWatching != nil {
+		c.stopWatching()
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// DNSResolver
+// ----------------------------------------------------------------------------
+
+// DNSResolver resolves services with the SRV records of their names, polled
+// every Interval.
+type DNSResolver struct {
+	// Service and Proto, when set, look up _Service._Proto.name rather than
+	// the name, e.g. "rpc" and "tcp".
+	Service string
+	Proto   string
+
+	// Interval is the delay between the lookups, 30s when zero.
+	Interval time.Duration
+
+	// Resolver performs the lookups, net.DefaultResolver when nil.
+	Resolver *net.Resolver
+
+	// Clock times the lookups, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+// Watch looks up the SRV records of the service every Interval, it calls
+// update when the targets change, ordered by priority and weight.
+func (d *DNSResolver) Watch(ctx context.Context, service string, update func(addrs []string)) error {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	interval := d.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	clock := rpcserver.ClockOrSystem(d.Clock)
+	var last string
+	for {
+		_, records, err := resolver.LookupSRV(ctx, d.Service, d.Proto, service)
+		if err != nil {
+			return err
+		}
+		addrs := make([]string, len(records))
+		for i, record := range records {
+			addrs[i] = net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		}
+		sorted := append([]string(nil), addrs...)
+		sort.Strings(sorted)
+		if key := strings.Join(sorted, ","); key != last {
+			update(addrs)
+			last = key
+		}
+		timer := clock.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package rpcclient
+
+import (
+	"context"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// RetryPolicy
+// ----------------------------------------------------------------------------
+
+// RetryPolicy describes when and how often failed calls are attempted again.
+//
+// Transport errors and responses with one of RetryableStatus are retried,
+// as are JSON-RPC errors with one of RetryableCodes. Only methods marked with
+// Client.SetIdempotent are retried unless RetryNonIdempotent is set.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the second attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after every attempt.
+	Multiplier float64
+
+	// Jitter randomizes the delay by up to this fraction of it, from 0 to 1.
+	Jitter float64
+
+	// RetryableCodes lists JSON-RPC error codes worth another attempt.
+	RetryableCodes []int
+
+	// RetryableStatus lists HTTP status codes worth another attempt.
+	RetryableStatus []int
+
+	// RetryNonIdempotent allows retrying methods not marked as idempotent.
+	RetryNonIdempotent bool
+
+	// Clock times the backoff delays, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+// NewRetryPolicy returns a policy of 3 attempts with exponential backoff
+// starting at 100ms, retrying internal server errors and unavailable servers.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:     3,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      2 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		RetryableCodes:  []int{jsonrpc2.E_INTERNAL},
+		RetryableStatus: []int{429, 502, 503, 504},
+	}
+}
+
+// Retryable returns true if a call failed with err deserves another attempt.
+func (p *RetryPolicy) Retryable(err error) bool {
+	switch e := err.(type) {
+	case *jsonrpc2.Error:
+		return containsInt(p.RetryableCodes, e.Code)
+	case *StatusError:
+		return containsInt(p.RetryableStatus, e.StatusCode)
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// Backoff returns the delay to wait after the given failed attempt, counting from 1.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+			delay = float64(p.MaxBackoff)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// run calls attempt until it succeeds, fails with a non-retryable error or the
+// attempts are exhausted. It returns the error of the last attempt.
+func (p *RetryPolicy) run(ctx context.Context, idempotent bool, attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if (!idempotent && !p.RetryNonIdempotent) || !p.Retryable(err) {
+			return err
+		}
+		timer := rpcserver.ClockOrSystem(p.Clock).NewTimer(p.Backoff(i))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C():
+		}
+	}
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
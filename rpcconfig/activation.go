@@ -0,0 +1,107 @@
+package rpcconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first descriptor passed by systemd.
+const listenFDsStart = 3
+
+// ErrNotActivated is returned by NewServerFromActivation when the process
+// was not passed sockets.
+var ErrNotActivated = errors.New("rpcconfig: no socket activation, LISTEN_FDS is not set")
+
+// Activated returns the listeners of the sockets passed to the process by
+// systemd, as described by the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES
+// environment variables, by name when names lists them. The sockets are
+// stream TCP or Unix sockets. The variables are unset, the children of the
+// process do not inherit the sockets. It returns no listener without socket
+// activation.
+func Activated(names ...string) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	value, ok := os.LookupEnv("LISTEN_FDS")
+	if !ok {
+		return nil, nil
+	}
+	// The sockets are the ones of another process when LISTEN_PID is not
+	// this one, e.g. the parent of a process inheriting the environment.
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("rpcconfig: invalid LISTEN_FDS %q", value)
+	}
+	fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var listeners []net.Listener
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		if len(names) > 0 && !contains(names, name) {
+			file.Close()
+			continue
+		}
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("rpcconfig: cannot listen on the socket %d %s: %v", listenFDsStart+i, name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// NewServerFromActivation creates a Server serving the methods of receiver as
+// described by cfg on the sockets passed by systemd, whose Serve serves every
+// socket rather than the address of cfg. Systemd then starts the server at
+// its first connection and binds privileged ports for it. It returns
+// ErrNotActivated without socket activation.
+//
+// A socket unit listening on TCP and Unix sockets:
+//
+//	[Socket]
+//	ListenStream=443
+//	ListenStream=/run/orders.sock
+//
+//	[Install]
+//	WantedBy=sockets.target
+func NewServerFromActivation(receiver interface{}, cfg *Config) (*Server, error) {
+	listeners, err := Activated()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		return nil, ErrNotActivated
+	}
+	srv, err := NewServerFromConfig(receiver, cfg)
+	if err != nil {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+	srv.activated = listeners
+	return srv, nil
+}
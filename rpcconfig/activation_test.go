@@ -0,0 +1,91 @@
+//go:build !windows && !plan9
+
+package rpcconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestActivatedServer serves on the sockets passed by TestActivation.
+func TestActivatedServer(t *testing.T) {
+	if os.Getenv("RPCCONFIG_TEST_ACTIVATED") == "" {
+		t.Skip("run by TestActivation")
+	}
+	srv, err := NewServerFromActivation(new(Echo), &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Serve(context.Background())
+}
+
+func TestActivation(t *testing.T) {
+	if _, err := NewServerFromActivation(new(Echo), &Config{}); err != ErrNotActivated {
+		t.Fatalf("expected ErrNotActivated, got %v", err)
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "rpcconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "rpc.sock")
+	unix, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*os.File
+	for _, l := range []interface{ File() (*os.File, error) }{tcp.(*net.TCPListener), unix.(*net.UnixListener)} {
+		file, err := l.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		files = append(files, file)
+	}
+	// The sockets stay open in the server.
+	unix.(*net.UnixListener).SetUnlinkOnClose(false)
+	tcp.Close()
+	unix.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivatedServer$")
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), "RPCCONFIG_TEST_ACTIVATED=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=tcp:unix")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	for _, client := range []struct {
+		url    string
+		dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+	}{
+		{"http://" + tcp.Addr().String(), nil},
+		{"http://unix", func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socket)
+		}},
+	} {
+		c := &http.Client{Transport: &http.Transport{DialContext: client.dialer}}
+		res, err := c.Post(client.url+"/rpc/Say", "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"Say","params":"hi","id":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(body), `"result":"hi"`) {
+			t.Errorf("unexpected response from %s: %s", client.url, body)
+		}
+	}
+}
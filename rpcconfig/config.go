@@ -0,0 +1,296 @@
+// Package rpcconfig configures an rpcserver.Server and its HTTP listener from a
+// file and the environment, so deployments are tuned without recompiling:
+//
+//	cfg, err := rpcconfig.Load("server.json")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := cfg.ApplyEnv("RPC"); err != nil {
+//		log.Fatal(err)
+//	}
+//	srv, err := rpcconfig.NewServerFromConfig(new(Arith), cfg)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(srv.HTTP.ListenAndServe())
+//
+// Server.Serve serves until a context is done, then drains the calls in
+// flight; with Server.Handoff, restarts hand the listener of the server over
+// to the new process, dropping no call. NewServerFromActivation serves the
+// sockets of systemd socket activation.
+//
+// Files are read as JSON, or as YAML when their name ends with .yaml or .yml
+// and YAMLUnmarshal is set, e.g. to yaml.Unmarshal of gopkg.in/yaml.v3.
+package rpcconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes a server and its HTTP listener. The zero Config serves the
+// JSON-RPC 2.0 codec on :8080 without limits nor authentication, and with the
+// sole timeout of ReadHeaderTimeout.
+type Config struct {
+	// Addr is the TCP address to listen on, ":8080" when empty.
+	Addr string `json:"addr" yaml:"addr" env:"ADDR"`
+
+	// Codecs lists the Content-Types the JSON-RPC 2.0 codec is registered
+	// with, "application/json" when empty.
+	Codecs []string `json:"codecs" yaml:"codecs" env:"CODECS"`
+
+	// MaxBodyBytes limits the size of request bodies when positive.
+	MaxBodyBytes int64 `json:"maxBodyBytes" yaml:"maxBodyBytes" env:"MAX_BODY_BYTES"`
+
+	// MaxDecompressedBytes limits the size of compressed request bodies once
+	// decompressed when positive, MaxBodyBytes applies when zero.
+	MaxDecompressedBytes int64 `json:"maxDecompressedBytes" yaml:"maxDecompressedBytes" env:"MAX_DECOMPRESSED_BYTES"`
+
+	// CallTimeout bounds the duration of calls when positive.
+	CallTimeout Duration `json:"callTimeout" yaml:"callTimeout" env:"CALL_TIMEOUT"`
+
+	// ReusePort sets SO_REUSEPORT on the listener of Server.Serve, so the
+	// process replacing the server binds the address while this one
+	// drains. ShutdownTimeout bounds the draining of the calls in flight
+	// once Serve is done, 30s when zero.
+	ReusePort       bool     `json:"reusePort" yaml:"reusePort" env:"REUSE_PORT"`
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+
+	// ReadTimeout, WriteTimeout and IdleTimeout set the timeouts of the
+	// http.Server.
+	ReadTimeout  Duration `json:"readTimeout" yaml:"readTimeout" env:"READ_TIMEOUT"`
+	WriteTimeout Duration `json:"writeTimeout" yaml:"writeTimeout" env:"WRITE_TIMEOUT"`
+	IdleTimeout  Duration `json:"idleTimeout" yaml:"idleTimeout" env:"IDLE_TIMEOUT"`
+
+	// ReadHeaderTimeout bounds the time clients take to send the headers of
+	// requests, 10s when zero and none when negative, so clients trickling
+	// headers cannot hold connections open.
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT"`
+
+	// MinBodyRate is the rate in bytes per second request bodies must be
+	// sent at when positive, once BodyGracePeriod elapsed, 5s when zero.
+	MinBodyRate     int64    `json:"minBodyRate" yaml:"minBodyRate" env:"MIN_BODY_RATE"`
+	BodyGracePeriod Duration `json:"bodyGracePeriod" yaml:"bodyGracePeriod" env:"BODY_GRACE_PERIOD"`
+
+	Auth AuthConfig `json:"auth" yaml:"auth" env:"AUTH"`
+	CORS CORSConfig `json:"cors" yaml:"cors" env:"CORS"`
+
+	// TrustedProxies lists the networks of the proxies whose Forwarded and
+	// X-Forwarded-For headers tell the client address. AllowedIPs lists the
+	// networks of the clients served when set, DeniedIPs the ones refused.
+	TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies" env:"TRUSTED_PROXIES"`
+	AllowedIPs     []string `json:"allowedIPs" yaml:"allowedIPs" env:"ALLOWED_IPS"`
+	DeniedIPs      []string `json:"deniedIPs" yaml:"deniedIPs" env:"DENIED_IPS"`
+
+	// DisabledMethods lists the methods answered with 503.
+	DisabledMethods []string `json:"disabledMethods" yaml:"disabledMethods" env:"DISABLED_METHODS"`
+
+	// RateLimit is the number of calls per second each method accepts when
+	// positive, with bursts of RateBurst calls.
+	RateLimit float64 `json:"rateLimit" yaml:"rateLimit" env:"RATE_LIMIT"`
+	RateBurst int     `json:"rateBurst" yaml:"rateBurst" env:"RATE_BURST"`
+
+	// MaxDepth, MaxArrayLen, MaxStringLen and MaxKeys limit the nesting
+	// depth, the array lengths, the string lengths and the object key counts
+	// of request bodies when positive.
+	MaxDepth     int `json:"maxDepth" yaml:"maxDepth" env:"MAX_DEPTH"`
+	MaxArrayLen  int `json:"maxArrayLen" yaml:"maxArrayLen" env:"MAX_ARRAY_LEN"`
+	MaxStringLen int `json:"maxStringLen" yaml:"maxStringLen" env:"MAX_STRING_LEN"`
+	MaxKeys      int `json:"maxKeys" yaml:"maxKeys" env:"MAX_KEYS"`
+
+	// MaxConcurrentCalls and MaxConcurrentCallsPerConn limit the calls in
+	// flight, of the server and of each connection, when positive. Calls
+	// wait up to QueueTimeout for a slot.
+	MaxConcurrentCalls        int      `json:"maxConcurrentCalls" yaml:"maxConcurrentCalls" env:"MAX_CONCURRENT_CALLS"`
+	MaxConcurrentCallsPerConn int      `json:"maxConcurrentCallsPerConn" yaml:"maxConcurrentCallsPerConn" env:"MAX_CONCURRENT_CALLS_PER_CONN"`
+	QueueTimeout              Duration `json:"queueTimeout" yaml:"queueTimeout" env:"QUEUE_TIMEOUT"`
+
+	// AccessLog writes an access log to the standard error in the format,
+	// "combined" or "json", none when empty.
+	AccessLog string `json:"accessLog" yaml:"accessLog" env:"ACCESS_LOG"`
+
+	// LogLevel filters the access log: "info" logs every request, "warn"
+	// the failed ones, "error" the server errors and "off" none. It is
+	// "info" when empty.
+	LogLevel string `json:"logLevel" yaml:"logLevel" env:"LOG_LEVEL"`
+
+	// Explorer serves the API explorer page of the explorer package at the
+	// path when set, e.g. "/explorer", calling the methods at /rpc/.
+	Explorer string `json:"explorer" yaml:"explorer" env:"EXPLORER"`
+}
+
+// AuthMode tells how requests are authenticated.
+type AuthMode string
+
+const (
+	// AuthNone accepts every request.
+	AuthNone AuthMode = ""
+
+	// AuthBearer requires an "Authorization: Bearer <token>" header with one
+	// of the configured tokens.
+	AuthBearer AuthMode = "bearer"
+)
+
+// AuthConfig describes the authentication of requests.
+type AuthConfig struct {
+	Mode AuthMode `json:"mode" yaml:"mode" env:"MODE"`
+
+	// Tokens lists the accepted bearer tokens.
+	Tokens []string `json:"tokens" yaml:"tokens" env:"TOKENS"`
+}
+
+// CORSConfig describes the cross-origin requests allowed from browsers. No
+// CORS headers are written when AllowedOrigins is empty.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to call the server, "*" for
+	// any origin.
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins" env:"ALLOWED_ORIGINS"`
+
+	// AllowedHeaders lists the request headers allowed beside Content-Type.
+	AllowedHeaders []string `json:"allowedHeaders" yaml:"allowedHeaders" env:"ALLOWED_HEADERS"`
+
+	// MaxAge is how long browsers may cache the preflight response.
+	MaxAge Duration `json:"maxAge" yaml:"maxAge" env:"MAX_AGE"`
+}
+
+// Duration is a time.Duration read from a string like "1m30s", or from a
+// number of nanoseconds.
+type Duration time.Duration
+
+// UnmarshalJSON reads the duration from a string or a number.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("rpcconfig: invalid duration %s", data)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// UnmarshalText reads the duration from a string like "1m30s".
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("rpcconfig: invalid duration %q", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText writes the duration as a string like "1m30s".
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// YAMLUnmarshal decodes YAML config files when set.
+var YAMLUnmarshal func(data []byte, v interface{}) error
+
+// Load reads the Config from a JSON or YAML file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(Config)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if YAMLUnmarshal == nil {
+			return nil, fmt.Errorf("rpcconfig: cannot read %s, YAMLUnmarshal is not set", path)
+		}
+		err = YAMLUnmarshal(data, cfg)
+	default:
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rpcconfig: cannot read %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the fields of c set in the environment. Variables are
+// named after the prefix and the env tags of the fields, e.g. RPC_ADDR,
+// RPC_CALL_TIMEOUT or RPC_AUTH_TOKENS for the prefix "RPC". Lists are comma
+// separated.
+func (c *Config) ApplyEnv(prefix string) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+var typeOfDuration = reflect.TypeOf(Duration(0))
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("rpcconfig: invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == typeOfDuration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int64 || field.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
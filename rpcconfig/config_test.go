@@ -0,0 +1,124 @@
+package rpcconfig
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Echo struct{}
+
+func (e *Echo) Say(r *http.Request, args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func (e *Echo) Wait(r *http.Request, args *string) error {
+	<-r.Context().Done()
+	return r.Context().Err()
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	ioutil.WriteFile(path, []byte(`{"addr": ":9090", "maxBodyBytes": 1024, "callTimeout": "2s", "auth": {"mode": "bearer", "tokens": ["a"]}}`), 0600)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RPC_ADDR", ":9091")
+	t.Setenv("RPC_AUTH_TOKENS", "b, c")
+	t.Setenv("RPC_READ_TIMEOUT", "5s")
+	if err := cfg.ApplyEnv("RPC"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9091" || cfg.MaxBodyBytes != 1024 || cfg.CallTimeout != Duration(2*time.Second) || cfg.ReadTimeout != Duration(5*time.Second) ||
+		cfg.Auth.Mode != AuthBearer || strings.Join(cfg.Auth.Tokens, ",") != "b,c" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	t.Setenv("RPC_MAX_BODY_BYTES", "lots")
+	if err := cfg.ApplyEnv("RPC"); err == nil || !strings.Contains(err.Error(), "RPC_MAX_BODY_BYTES") {
+		t.Fatalf("expected an invalid variable error, got %v", err)
+	}
+
+	yaml := filepath.Join(t.TempDir(), "server.yaml")
+	ioutil.WriteFile(yaml, []byte("addr: :9090\n"), 0600)
+	if _, err := Load(yaml); err == nil {
+		t.Fatalf("expected YAML to require YAMLUnmarshal")
+	}
+}
+
+func post(handler http.Handler, method string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/rpc/"+method, bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "`+method+`", "id": 1, "params": "hi"}`))
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewServerFromConfig(t *testing.T) {
+	srv, err := NewServerFromConfig(new(Echo), &Config{
+		CallTimeout: Duration(10 * time.Millisecond),
+		Auth:        AuthConfig{Mode: AuthBearer, Tokens: []string{"secret"}},
+		CORS:        CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowedHeaders: []string{"Authorization"}, MaxAge: Duration(time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.HTTP.Addr != ":8080" {
+		t.Errorf("unexpected address %q", srv.HTTP.Addr)
+	}
+	if srv.HTTP.ReadHeaderTimeout != 10*time.Second || srv.HTTP.ConnContext == nil {
+		t.Errorf("expected the listener protections, got %v", srv.HTTP.ReadHeaderTimeout)
+	}
+	handler := srv.HTTP.Handler
+
+	if w := post(handler, "Say", nil); w.Code != 401 || !strings.Contains(w.Body.String(), "unauthorized") {
+		t.Errorf("expected 401 without a token, got %d %q", w.Code, w.Body.String())
+	}
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+	if w := post(handler, "Say", auth); w.Code != 200 || !strings.Contains(w.Body.String(), `"result":"hi"`) {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w := post(handler, "Wait", auth); !strings.Contains(w.Body.String(), "deadline exceeded") {
+		t.Errorf("expected the call timeout to apply, got %q", w.Body.String())
+	}
+
+	r := httptest.NewRequest("OPTIONS", "/rpc/Say", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" || w.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("unexpected preflight response %d %v", w.Code, w.Header())
+	}
+	if w := post(handler, "Say", http.Header{"Origin": {"https://evil.example.com"}, "Authorization": {"Bearer secret"}}); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers for other origins, got %v", w.Header())
+	}
+
+	srv, err = NewServerFromConfig(new(Echo), &Config{Explorer: "/explorer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.HTTP.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/explorer", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "<title>Echo explorer</title>") {
+		t.Errorf("expected the explorer, got %d", w.Code)
+	}
+	if w := post(srv.HTTP.Handler, "Say", nil); !strings.Contains(w.Body.String(), `"result":"hi"`) {
+		t.Errorf("expected the calls served beside the explorer, got %q", w.Body.String())
+	}
+
+	if _, err := NewServerFromConfig(new(Echo), &Config{Auth: AuthConfig{Mode: "magic"}}); err == nil {
+		t.Errorf("expected an unknown auth mode to fail")
+	}
+}
@@ -0,0 +1,116 @@
+package rpcconfig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ListenFDEnv is the environment variable telling a process started by
+// Handoff the descriptor of the listener it inherits.
+const ListenFDEnv = "RPC_LISTEN_FD"
+
+// defaultShutdownTimeout is the time Serve drains the calls in flight when
+// the Config sets no ShutdownTimeout.
+const defaultShutdownTimeout = 30 * time.Second
+
+// Listen returns the listener of the server: the one inherited from the
+// process which started it with Handoff, or a new one on the address of the
+// Config, with SO_REUSEPORT when ReusePort is set.
+func (s *Server) Listen() (net.Listener, error) {
+	if value, ok := os.LookupEnv(ListenFDEnv); ok {
+		os.Unsetenv(ListenFDEnv)
+		fd, err := strconv.Atoi(value)
+		if err != nil || fd < 3 {
+			return nil, fmt.Errorf("rpcconfig: invalid %s %q", ListenFDEnv, value)
+		}
+		file := os.NewFile(uintptr(fd), "listener")
+		defer file.Close()
+		l, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("rpcconfig: cannot inherit the listener: %v", err)
+		}
+		return l, nil
+	}
+	lc := net.ListenConfig{}
+	if s.reusePort {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", s.HTTP.Addr)
+}
+
+// Serve serves the server on the listener of Listen, or on the sockets of
+// NewServerFromActivation, until ctx is done, then shuts it down, draining the
+// calls in flight for up to the ShutdownTimeout of the Config. It returns the
+// error of a listener or of the shutdown.
+//
+// Restarts drop no call: the new process inherits the listener with Handoff,
+// or binds the same address with ReusePort, before ctx of the old one is
+// done. The sockets of systemd outlive the processes.
+func (s *Server) Serve(ctx context.Context) error {
+	listeners := s.activated
+	if listeners == nil {
+		l, err := s.Listen()
+		if err != nil {
+			return err
+		}
+		listeners = []net.Listener{l}
+	}
+	s.mu.Lock()
+	s.listener = listeners[0]
+	s.mu.Unlock()
+	served := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) { served <- s.HTTP.Serve(l) }(l)
+	}
+	select {
+	case err := <-served:
+		s.HTTP.Close()
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.HTTP.Shutdown(shutdown); err != nil {
+		s.HTTP.Close()
+		return fmt.Errorf("rpcconfig: shutdown: %v", err)
+	}
+	return nil
+}
+
+// Handoff starts a new process of the executable, with the arguments and the
+// environment of this one, inheriting the listener of Serve: the new process
+// accepts the connections as soon as it serves, this one then stops serving,
+// typically by cancelling the context of Serve. It is called on a signal of
+// the deployment, such as SIGUSR2, and Unix systems only support it.
+func (s *Server) Handoff() (*os.Process, error) {
+	s.mu.Lock()
+	l := s.listener
+	s.mu.Unlock()
+	filer, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("rpcconfig: no listener to hand off")
+	}
+	file, err := filer.File()
+	if err != nil {
+		return nil, fmt.Errorf("rpcconfig: %v", err)
+	}
+	defer file.Close()
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("rpcconfig: %v", err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// The first extra file is the descriptor 3 of the new process.
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.Env = append(os.Environ(), ListenFDEnv+"=3")
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("rpcconfig: cannot start the new process: %v", err)
+	}
+	return cmd.Process, nil
+}
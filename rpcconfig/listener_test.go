@@ -0,0 +1,106 @@
+//go:build !windows && !plan9
+
+package rpcconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Slow struct {
+	started, release chan struct{}
+}
+
+func (s *Slow) Work(r *http.Request, args *string, reply *string) error {
+	close(s.started)
+	<-s.release
+	*reply = "done"
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	slow := &Slow{started: make(chan struct{}), release: make(chan struct{})}
+	srv, err := NewServerFromConfig(slow, &Config{Addr: "127.0.0.1:0", ReusePort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- srv.Serve(ctx) }()
+	var addr string
+	for addr == "" {
+		srv.mu.Lock()
+		if srv.listener != nil {
+			addr = srv.listener.Addr().String()
+		}
+		srv.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	// Another process binds the address while this one serves.
+	lc := net.ListenConfig{Control: reusePort}
+	other, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot reuse the port: %v", err)
+	}
+	other.Close()
+
+	replied := make(chan string)
+	go func() {
+		res, err := http.Post("http://"+addr+"/rpc/Work", "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"Work","params":"","id":1}`))
+		if err != nil {
+			replied <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		replied <- string(body)
+	}()
+	<-slow.started
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(slow.release)
+	if body := <-replied; !strings.Contains(body, `"result":"done"`) {
+		t.Errorf("the call in flight was dropped: %s", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestListenInherited(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	file, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	t.Setenv(ListenFDEnv, strconv.Itoa(int(file.Fd())))
+
+	srv, err := NewServerFromConfig(new(Echo), &Config{Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited, err := srv.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if inherited.Addr().String() != l.Addr().String() {
+		t.Errorf("expected the inherited listener on %s, got %s", l.Addr(), inherited.Addr())
+	}
+	if _, ok := os.LookupEnv(ListenFDEnv); ok {
+		t.Errorf("expected %s to be unset", ListenFDEnv)
+	}
+}
@@ -0,0 +1,96 @@
+package rpcconfig
+
+import (
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/logsink"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Reload applies the runtime-tunable subset of cfg to the server: the call
+// timeout, the body size limit, the disabled methods, the rate limits and the
+// log level. Each takes effect atomically for the requests arriving next, the
+// other settings need a restart and are ignored.
+func (s *Server) Reload(cfg *Config) error {
+	level, err := parseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	s.RPC.SetLimits(limitsOf(cfg))
+	if s.accessLog != nil {
+		s.accessLog.setLevel(level)
+	}
+	return nil
+}
+
+// ReloadFile loads the config file, applies the environment variables of the
+// prefix and reloads the server with the result.
+func (s *Server) ReloadFile(path, prefix string) error {
+	cfg, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if err := cfg.ApplyEnv(prefix); err != nil {
+		return err
+	}
+	return s.Reload(cfg)
+}
+
+// ReloadOnSIGHUP reloads the server from the config file on every SIGHUP,
+// logging failed reloads. It returns a function stopping it.
+func (s *Server) ReloadOnSIGHUP(path, prefix string) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := s.ReloadFile(path, prefix); err != nil {
+					log.Printf("rpcconfig: reload failed: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// parseLevel returns the most verbose priority of the log level, -1 for off.
+func parseLevel(level string) (logsink.Priority, error) {
+	switch level {
+	case "", "info":
+		return logsink.Info, nil
+	case "warn":
+		return logsink.Warning, nil
+	case "error":
+		return logsink.Error, nil
+	case "off":
+		return -1, nil
+	}
+	return 0, fmt.Errorf("rpcconfig: unknown log level %q", level)
+}
+
+// levelSink passes on the entries of a priority up to its level.
+type levelSink struct {
+	next  rpcserver.AccessLogSink
+	level int32 // atomic logsink.Priority
+}
+
+func (l *levelSink) setLevel(level logsink.Priority) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *levelSink) Log(entry rpcserver.AccessLogEntry) {
+	if int32(logsink.PriorityOf(entry)) <= atomic.LoadInt32(&l.level) {
+		l.next.Log(entry)
+	}
+}
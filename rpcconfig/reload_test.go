@@ -0,0 +1,48 @@
+//go:build !windows && !plan9
+
+package rpcconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	srv, err := NewServerFromConfig(new(Echo), &Config{AccessLog: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := srv.HTTP.Handler
+	if w := post(handler, "Say", nil); w.Code != 200 {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	if err := srv.Reload(&Config{DisabledMethods: []string{"Say"}, LogLevel: "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := post(handler, "Say", nil); w.Code != 503 {
+		t.Errorf("expected the method to be disabled, got %d", w.Code)
+	}
+	if err := srv.Reload(&Config{LogLevel: "loud"}); err == nil {
+		t.Errorf("expected an unknown log level to fail")
+	}
+
+	path := filepath.Join(t.TempDir(), "server.json")
+	ioutil.WriteFile(path, []byte(`{"rateLimit": 5}`), 0600)
+	stop := srv.ReloadOnSIGHUP(path, "RPC")
+	defer stop()
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	for i := 0; srv.RPC.Limits().RateLimit != 5; i++ {
+		if i == 100 {
+			t.Fatalf("expected SIGHUP to reload the config, got %+v", srv.RPC.Limits())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w := post(handler, "Say", nil); w.Code != 200 {
+		t.Errorf("expected the method to be enabled again, got %d", w.Code)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build (linux && !mips && !mipsle && !mips64 && !mips64le) || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package rpcconfig
+
+import (
+	"syscall"
+)
+
+// reusePort sets SO_REUSEPORT on the socket, for several processes to
+// listen on the same address.
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var err error
+	if errControl := conn.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); errControl != nil {
+		return errControl
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package rpcconfig
+
+import (
+	"syscall"
+)
+
+const soReusePort = syscall.SO_REUSEPORT
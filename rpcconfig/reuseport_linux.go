@@ -0,0 +1,6 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package rpcconfig
+
+// soReusePort is SO_REUSEPORT, which the syscall package lacks on Linux.
+const soReusePort = 0xf
@@ -0,0 +1,12 @@
+//go:build (!linux || mips || mipsle || mips64 || mips64le) && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package rpcconfig
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("rpcconfig: SO_REUSEPORT is not supported on this system")
+}
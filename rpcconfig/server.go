@@ -0,0 +1,216 @@
+package rpcconfig
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/explorer"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an rpcserver.Server with the HTTP server serving it.
+type Server struct {
+	RPC *rpcserver.Server
+
+	// HTTP serves RPC, behind the authentication and the CORS handling of
+	// the Config.
+	HTTP *http.Server
+
+	accessLog *levelSink
+
+	reusePort       bool
+	shutdownTimeout time.Duration
+
+	activated []net.Listener // see NewServerFromActivation
+
+	mu       sync.Mutex
+	listener net.Listener // the listener of Serve, see Handoff
+}
+
+// NewServerFromConfig creates a Server serving the methods of receiver as
+// described by cfg.
+func NewServerFromConfig(receiver interface{}, cfg *Config) (*Server, error) {
+	switch cfg.Auth.Mode {
+	case AuthNone:
+	case AuthBearer:
+		if len(cfg.Auth.Tokens) == 0 {
+			return nil, errors.New("rpcconfig: bearer authentication requires tokens")
+		}
+	default:
+		return nil, fmt.Errorf("rpcconfig: unknown auth mode %q", cfg.Auth.Mode)
+	}
+	level, err := parseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	rpc, err := rpcserver.NewServer(receiver)
+	if err != nil {
+		return nil, err
+	}
+	codecs := cfg.Codecs
+	if len(codecs) == 0 {
+		codecs = []string{"application/json"}
+	}
+	codec := jsonrpc2.NewCodec()
+	for _, contentType := range codecs {
+		rpc.RegisterCodec(codec, contentType)
+	}
+	rpc.SetLimits(limitsOf(cfg))
+	for _, networks := range []struct {
+		list []string
+		dst  *rpcserver.Networks
+	}{
+		{cfg.TrustedProxies, &rpc.TrustedProxies},
+		{cfg.AllowedIPs, &rpc.AllowedIPs},
+		{cfg.DeniedIPs, &rpc.DeniedIPs},
+	} {
+		if len(networks.list) == 0 {
+			continue
+		}
+		if *networks.dst, err = rpcserver.ParseNetworks(networks.list...); err != nil {
+			return nil, err
+		}
+	}
+	srv := &Server{RPC: rpc}
+	switch cfg.AccessLog {
+	case "":
+	case "combined", "json":
+		format := rpcserver.AccessLogCombined
+		if cfg.AccessLog == "json" {
+			format = rpcserver.AccessLogJSON
+		}
+		srv.accessLog = &levelSink{next: rpcserver.NewAccessLogger(os.Stderr, format)}
+		srv.accessLog.setLevel(level)
+		rpc.AccessLog = srv.accessLog
+	default:
+		return nil, fmt.Errorf("rpcconfig: unknown access log format %q", cfg.AccessLog)
+	}
+
+	var handler http.Handler = rpc
+	if cfg.Explorer != "" {
+		mux := http.NewServeMux()
+		mux.Handle(cfg.Explorer, explorer.Handler(introspect.Describe(rpc.Service()), "/rpc/"))
+		mux.Handle("/", rpc)
+		handler = mux
+	}
+	if cfg.Auth.Mode == AuthBearer {
+		handler = bearerAuth(handler, cfg.Auth.Tokens)
+	}
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		handler = cors(handler, cfg.CORS)
+	}
+	addr := cfg.Addr
+	if addr == "" {
+		addr = ":8080"
+	}
+	readHeaderTimeout := time.Duration(cfg.ReadHeaderTimeout)
+	if readHeaderTimeout == 0 {
+		readHeaderTimeout = defaultReadHeaderTimeout
+	}
+	srv.reusePort = cfg.ReusePort
+	srv.shutdownTimeout = time.Duration(cfg.ShutdownTimeout)
+	if srv.shutdownTimeout <= 0 {
+		srv.shutdownTimeout = defaultShutdownTimeout
+	}
+	srv.HTTP = &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		ConnContext:       rpcserver.ConnContext,
+	}
+	return srv, nil
+}
+
+// defaultReadHeaderTimeout is the ReadHeaderTimeout of the http.Server when
+// the Config sets none.
+const defaultReadHeaderTimeout = 10 * time.Second
+
+// limitsOf returns the runtime limits described by cfg.
+func limitsOf(cfg *Config) rpcserver.Limits {
+	return rpcserver.Limits{
+		CallTimeout:          time.Duration(cfg.CallTimeout),
+		MaxBodyBytes:         cfg.MaxBodyBytes,
+		MaxDecompressedBytes: cfg.MaxDecompressedBytes,
+		DisabledMethods:      cfg.DisabledMethods,
+		RateLimit:            cfg.RateLimit,
+		RateBurst:            cfg.RateBurst,
+		MaxDepth:             cfg.MaxDepth,
+		MaxArrayLen:          cfg.MaxArrayLen,
+		MaxStringLen:         cfg.MaxStringLen,
+		MaxKeys:              cfg.MaxKeys,
+
+		MaxConcurrentCalls:        cfg.MaxConcurrentCalls,
+		MaxConcurrentCallsPerConn: cfg.MaxConcurrentCallsPerConn,
+		QueueTimeout:              time.Duration(cfg.QueueTimeout),
+
+		MinBodyRate:     cfg.MinBodyRate,
+		BodyGracePeriod: time.Duration(cfg.BodyGracePeriod),
+	}
+}
+
+// bearerAuth rejects the requests without one of the tokens with 401.
+func bearerAuth(next http.Handler, tokens []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			given := []byte(auth[7:])
+			for _, token := range tokens {
+				if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		res := rpcserver.NewErrorResponse(r, 401, errors.New("rpc: unauthorized"))
+		jsonrpc2.NewCodec().WriteErrorResponse(w, r, res)
+	})
+}
+
+// cors answers the preflight requests of the allowed origins and adds the
+// CORS headers to their requests. Preflight requests pass before
+// authentication, as browsers send them without credentials.
+func cors(next http.Handler, cfg CORSConfig) http.Handler {
+	headers := strings.Join(append([]string{"Content-Type"}, cfg.AllowedHeaders...), ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !allowedOrigin(cfg.AllowedOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(cfg.MaxAge)/time.Second)))
+		}
+		w.WriteHeader(204)
+	})
+}
+
+func allowedOrigin(allowed []string, origin string) bool {
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
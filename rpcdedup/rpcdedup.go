@@ -0,0 +1,191 @@
+// Package rpcdedup executes the mutating calls of an rpcserver.Server at most
+// once per idempotency key, answering the retries with the recorded outcome:
+//
+//	dedup := rpcdedup.New(&rpcdedup.SQLStore{DB: db}, "Deposit", "Withdraw")
+//	server.Use(dedup.Middleware())
+//
+// Calls are deduplicated by the rpcserver.IdempotencyKeyHeader, the one
+// rpcclient sends with Client.SetIdempotent, calls without it run as usual.
+// Keys are recorded in two phases: started before the method runs, then
+// completed with its reply or error. A retry finding the key started, its
+// call in progress or interrupted by a crash, fails with a conflict rather
+// than running the method again, so the outcome of a call is never doubled
+// nor contradicted. Stores shared by several servers, such as a RedisStore or
+// an SQLStore, deduplicate the calls of all of them.
+//
+// The recorded replies are those of the first caller of a key, shaped for its
+// roles: Deduplicator.Scope keeps the keys of each caller apart, without it
+// the keys must be unique across callers.
+package rpcdedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is the phase of a recorded call.
+type State string
+
+const (
+	// Started calls are running, or were interrupted before completing.
+	Started State = "started"
+
+	// Completed calls have their outcome recorded.
+	Completed State = "completed"
+)
+
+// Record is the state of the call of an idempotency key.
+type Record struct {
+	State State `json:"state"`
+
+	// Fingerprint is a hash of the method and the args of the call and of
+	// the roles of its caller, keys reused for other calls are refused.
+	Fingerprint string    `json:"fingerprint"`
+	Started     time.Time `json:"started"`
+
+	// Reply or Error are the outcome of completed calls.
+	Reply json.RawMessage `json:"reply,omitempty"`
+	Error *jsonrpc2.Error `json:"error,omitempty"`
+}
+
+// Store records the calls of the idempotency keys until they expire.
+type Store interface {
+	// Begin records the started call of the key until expires, unless the
+	// key is recorded: it then returns its record, atomically.
+	Begin(ctx context.Context, key string, record Record, expires time.Time) (*Record, error)
+
+	// Complete replaces the record of the key.
+	Complete(ctx context.Context, key string, record Record, expires time.Time) error
+
+	// Release removes the record of the key, whose method did not run.
+	Release(ctx context.Context, key string) error
+}
+
+// Deduplicator runs the calls of some methods at most once per idempotency
+// key.
+type Deduplicator struct {
+	// TTL is how long keys are recorded, 24 hours when zero. Retries after
+	// TTL run the method again.
+	TTL time.Duration
+
+	// Clock expires the keys, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	// Scope returns the caller the idempotency key of the call belongs to,
+	// e.g. the authenticated user, so that callers choosing the same key
+	// don't share a record. When nil, the keys are shared by all the callers.
+	Scope func(ctx context.Context, call *rpcserver.Call) string
+
+	store   Store
+	methods map[string]bool // all when empty
+}
+
+// New creates a Deduplicator of the calls of the methods, of every method
+// when none is given, recording the keys in the store.
+func New(store Store, methods ...string) *Deduplicator {
+	d := &Deduplicator{store: store, methods: make(map[string]bool, len(methods))}
+	for _, method := range methods {
+		d.methods[method] = true
+	}
+	return d
+}
+
+// Middleware returns the middleware deduplicating the calls.
+func (d *Deduplicator) Middleware() rpcserver.Middleware {
+	return func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			key := call.Request.Header.Get(rpcserver.IdempotencyKeyHeader)
+			if key == "" || len(d.methods) > 0 && !d.methods[call.Method] {
+				return next(ctx, call)
+			}
+			args, err := json.Marshal(call.Args)
+			if err != nil {
+				return fmt.Errorf("rpcdedup: %v", err)
+			}
+			if d.Scope != nil {
+				if scope := d.Scope(ctx, call); scope != "" {
+					key = strconv.Itoa(len(scope)) + ":" + scope + ":" + key
+				}
+			}
+			roles := strings.Join(rpcserver.RolesFromContext(ctx), ",")
+			sum := sha256.Sum256(append([]byte(call.Method+"\n"+roles+"\n"), args...))
+			now := rpcserver.ClockOrSystem(d.Clock).Now()
+			ttl := d.TTL
+			if ttl <= 0 {
+				ttl = 24 * time.Hour
+			}
+			record := Record{State: Started, Fingerprint: hex.EncodeToString(sum[:]), Started: now}
+			existing, err := d.store.Begin(ctx, key, record, now.Add(ttl))
+			if err != nil {
+				return fmt.Errorf("rpcdedup: %v", err)
+			}
+			if existing != nil {
+				return replay(call, record, existing)
+			}
+
+			callErr := next(ctx, call)
+			switch {
+			case errors.Is(callErr, rpcserver.ErrFeatureDisabled):
+				// The method did not run, retries may.
+				if err := d.store.Release(context.Background(), key); err != nil {
+					return fmt.Errorf("rpcdedup: %v", err)
+				}
+				return callErr
+			case callErr != nil && ctx.Err() != nil:
+				// The method abandoned at the deadline or at the departure
+				// of the client may still complete, its key stays started.
+				return callErr
+			}
+			record.State = Completed
+			if callErr != nil {
+				record.Error = errorOf(callErr)
+			} else if record.Reply, err = json.Marshal(call.Reply); err != nil {
+				return fmt.Errorf("rpcdedup: %v", err)
+			}
+			// The context of the call may be done, the outcome is recorded
+			// nonetheless.
+			if err := d.store.Complete(context.Background(), key, record, now.Add(ttl)); err != nil {
+				return fmt.Errorf("rpcdedup: the outcome of the call was not recorded: %v", err)
+			}
+			return callErr
+		}
+	}
+}
+
+// replay answers the call with the outcome of the existing record of its key.
+func replay(call *rpcserver.Call, record Record, existing *Record) error {
+	switch {
+	case existing.Fingerprint != record.Fingerprint:
+		return conflict("rpcdedup: idempotency key reused for another call")
+	case existing.State != Completed:
+		return conflict("rpcdedup: the call of the idempotency key is in progress or was interrupted")
+	case existing.Error != nil:
+		return existing.Error
+	}
+	call.Reply = existing.Reply
+	return nil
+}
+
+// conflict returns the error of the calls refused because their key belongs
+// to a call in progress, interrupted or with other args.
+func conflict(message string) error {
+	return jsonrpc2.NewError(jsonrpc2.E_CONFLICT, message, nil)
+}
+
+// errorOf returns the recorded form of an error of a call.
+func errorOf(err error) *jsonrpc2.Error {
+	var rpcErr *jsonrpc2.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return &jsonrpc2.Error{Code: jsonrpc2.E_SERVER, Message: err.Error()}
+}
@@ -0,0 +1,286 @@
+package rpcdedup
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type DepositArgs struct {
+	Amount int
+}
+
+type Account struct {
+	Balance int
+}
+
+func (a *Account) Deposit(r *http.Request, args *DepositArgs, reply *int) error {
+	if args.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	a.Balance += args.Amount
+	*reply = a.Balance
+	return nil
+}
+
+func deposit(t *testing.T, srv *rpcservertest.Server, key string, amount string) string {
+	t.Helper()
+	body := `{"jsonrpc": "2.0", "method": "Deposit", "id": 1, "params": {"Amount": ` + amount + `}}`
+	r, _ := http.NewRequest("POST", srv.URL+"/rpc/Deposit", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(rpcserver.IdempotencyKeyHeader, key)
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(res.Body)
+	return string(data)
+}
+
+func testStore(t *testing.T, store Store) {
+	account := new(Account)
+	srv := rpcservertest.NewServer(t, account)
+	defer srv.Close()
+	srv.RPC.Use(New(store, "Deposit").Middleware())
+
+	if res := deposit(t, srv, "a", "5"); !strings.Contains(res, `"result":5`) {
+		t.Fatalf("unexpected result %s", res)
+	}
+	if res := deposit(t, srv, "a", "5"); !strings.Contains(res, `"result":5`) || account.Balance != 5 {
+		t.Errorf("expected the retry to be answered once more, got %s and a balance of %d", res, account.Balance)
+	}
+	if res := deposit(t, srv, "a", "6"); !strings.Contains(res, `"code":-32004`) {
+		t.Errorf("expected the key reused for other args to conflict, got %s", res)
+	}
+	if res := deposit(t, srv, "b", "-1"); !strings.Contains(res, "amount must be positive") {
+		t.Errorf("unexpected result %s", res)
+	}
+	if res := deposit(t, srv, "b", "-1"); !strings.Contains(res, "amount must be positive") {
+		t.Errorf("expected the error to be answered once more, got %s", res)
+	}
+	if res := deposit(t, srv, "", "2"); !strings.Contains(res, `"result":7`) {
+		t.Errorf("expected a call without key to run, got %s", res)
+	}
+
+	// A call interrupted by a crash leaves its key started.
+	store.Begin(context.Background(), "c", Record{State: Started, Fingerprint: "?"}, time.Now().Add(time.Hour))
+	if res := deposit(t, srv, "c", "1"); !strings.Contains(res, `"code":-32004`) || account.Balance != 7 {
+		t.Errorf("expected the started key to conflict, got %s and a balance of %d", res, account.Balance)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestScope(t *testing.T) {
+	account := new(Account)
+	srv := rpcservertest.NewServer(t, account)
+	defer srv.Close()
+	srv.RPC.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			if role := call.Request.Header.Get("X-Role"); role != "" {
+				ctx = rpcserver.WithRoles(ctx, role)
+			}
+			return next(ctx, call)
+		}
+	})
+	dedup := New(NewMemoryStore(), "Deposit")
+	dedup.Scope = func(ctx context.Context, call *rpcserver.Call) string {
+		return call.Request.Header.Get("X-User")
+	}
+	srv.RPC.Use(dedup.Middleware())
+	call := func(user, role string) string {
+		body := `{"jsonrpc": "2.0", "method": "Deposit", "id": 1, "params": {"Amount": 5}}`
+		r, _ := http.NewRequest("POST", srv.URL+"/rpc/Deposit", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(rpcserver.IdempotencyKeyHeader, "a")
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-Role", role)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		return string(data)
+	}
+
+	if res := call("alice", ""); !strings.Contains(res, `"result":5`) {
+		t.Fatalf("unexpected result %s", res)
+	}
+	if res := call("bob", ""); !strings.Contains(res, `"result":10`) {
+		t.Errorf("expected the key of another caller to run, got %s", res)
+	}
+	if res := call("alice", ""); !strings.Contains(res, `"result":5`) || account.Balance != 10 {
+		t.Errorf("expected the retry to be answered once more, got %s and a balance of %d", res, account.Balance)
+	}
+	if res := call("", ""); !strings.Contains(res, `"result":15`) {
+		t.Errorf("expected an unscoped key to run, got %s", res)
+	}
+	if res := call("", "admin"); !strings.Contains(res, `"code":-32004`) || account.Balance != 15 {
+		t.Errorf("expected the key reused with other roles to conflict, got %s and a balance of %d", res, account.Balance)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	var mu sync.Mutex
+	values := make(map[string][]byte)
+	testStore(t, &RedisStore{
+		SetNX: func(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := values[key]; ok {
+				return false, nil
+			}
+			values[key] = value
+			return true, nil
+		},
+		Get: func(ctx context.Context, key string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return values[key], nil
+		},
+		Set: func(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+			mu.Lock()
+			defer mu.Unlock()
+			values[key] = value
+			return nil
+		},
+		Del: func(ctx context.Context, key string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(values, key)
+			return nil
+		},
+	})
+	if _, ok := values["rpc:idempotency:a"]; !ok {
+		t.Errorf("expected prefixed keys, got %v", values)
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	sql.Register("rpcdedup-fake", fakeDriver{rows: make(map[string]fakeRow)})
+	db, err := sql.Open("rpcdedup-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := &SQLStore{DB: db, Numbered: true}
+	if err := store.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	if q := store.query("UPDATE %s SET record = ?, expires = ? WHERE idempotency_key = ?"); q != "UPDATE rpc_idempotency SET record = $1, expires = $2 WHERE idempotency_key = $3" {
+		t.Errorf("unexpected query %q", q)
+	}
+}
+
+// fakeDriver runs the statements of SQLStore on a map.
+type fakeDriver struct {
+	rows map[string]fakeRow
+}
+
+type fakeRow struct {
+	record  string
+	expires int64
+}
+
+var fakeMu sync.Mutex
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn(d), nil
+}
+
+type fakeConn fakeDriver
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.rows, query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake: no transactions")
+}
+
+type fakeStmt struct {
+	rows  map[string]fakeRow
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+	case strings.HasPrefix(s.query, "INSERT"):
+		key := args[0].(string)
+		if _, ok := s.rows[key]; ok {
+			return nil, errors.New("fake: duplicate key")
+		}
+		s.rows[key] = fakeRow{args[1].(string), args[2].(int64)}
+	case strings.HasPrefix(s.query, "UPDATE"):
+		s.rows[args[2].(string)] = fakeRow{args[0].(string), args[1].(int64)}
+	case strings.HasPrefix(s.query, "DELETE") && len(args) == 2:
+		if row, ok := s.rows[args[0].(string)]; ok && row.expires <= args[1].(int64) {
+			delete(s.rows, args[0].(string))
+		}
+	case strings.HasPrefix(s.query, "DELETE"):
+		delete(s.rows, args[0].(string))
+	default:
+		return nil, errors.New("fake: unexpected statement " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	row, ok := s.rows[args[0].(string)]
+	return &fakeRows{record: row.record, done: !ok}, nil
+}
+
+type fakeRows struct {
+	record string
+	done   bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"record"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = []byte(r.record)
+	return nil
+}
@@ -0,0 +1,249 @@
+package rpcdedup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/internal/sqlutil"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store of a single process, its keys are lost on restart.
+type MemoryStore struct {
+	// Clock expires the keys, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu      sync.Mutex
+	records map[string]memoryRecord
+	pruned  time.Time // last removal of the expired keys
+}
+
+type memoryRecord struct {
+	Record
+	expires time.Time
+}
+
+// NewMemoryStore creates a MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]memoryRecord)}
+}
+
+// Begin records the started call of the key, unless it is recorded.
+func (s *MemoryStore) Begin(ctx context.Context, key string, record Record, expires time.Time) (*Record, error) {
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.pruned) >= time.Minute {
+		for k, r := range s.records {
+			if !r.expires.After(now) {
+				delete(s.records, k)
+			}
+		}
+		s.pruned = now
+	}
+	if existing, ok := s.records[key]; ok && existing.expires.After(now) {
+		return &existing.Record, nil
+	}
+	s.records[key] = memoryRecord{record, expires}
+	return nil, nil
+}
+
+// Complete replaces the record of the key.
+func (s *MemoryStore) Complete(ctx context.Context, key string, record Record, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryRecord{record, expires}
+	return nil
+}
+
+// Release removes the record of the key.
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// RedisStore is a Store in Redis. SetNX, Get, Set and Del run the SET key
+// value NX PX ttl, GET, SET key value PX ttl and DEL commands of a client,
+// e.g. with github.com/redis/go-redis:
+//
+//	store := &rpcdedup.RedisStore{
+//		SetNX: func(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+//			return rdb.SetNX(ctx, key, value, ttl).Result()
+//		},
+//		Get: func(ctx context.Context, key string) ([]byte, error) {
+//			value, err := rdb.Get(ctx, key).Bytes()
+//			if err == redis.Nil {
+//				return nil, nil
+//			}
+//			return value, err
+//		},
+//		Set: func(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+//			return rdb.Set(ctx, key, value, ttl).Err()
+//		},
+//		Del: func(ctx context.Context, key string) error {
+//			return rdb.Del(ctx, key).Err()
+//		},
+//	}
+//
+// Get returns a nil value for missing keys.
+type RedisStore struct {
+	SetNX func(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Get   func(ctx context.Context, key string) ([]byte, error)
+	Set   func(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del   func(ctx context.Context, key string) error
+
+	// Prefix is prepended to the idempotency keys to make the Redis keys,
+	// "rpc:idempotency:" when empty.
+	Prefix string
+
+	// Clock computes the time to live of the keys, rpcserver.SystemClock
+	// when nil.
+	Clock rpcserver.Clock
+}
+
+func (s *RedisStore) key(key string) string {
+	if s.Prefix == "" {
+		return "rpc:idempotency:" + key
+	}
+	return s.Prefix + key
+}
+
+func (s *RedisStore) ttl(expires time.Time) time.Duration {
+	ttl := expires.Sub(rpcserver.ClockOrSystem(s.Clock).Now())
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return ttl
+}
+
+// Begin records the started call of the key, unless it is recorded.
+func (s *RedisStore) Begin(ctx context.Context, key string, record Record, expires time.Time) (*Record, error) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	// The key may expire between SET NX and GET, then it is set again.
+	for attempt := 0; attempt < 3; attempt++ {
+		set, err := s.SetNX(ctx, s.key(key), value, s.ttl(expires))
+		if err != nil || set {
+			return nil, err
+		}
+		data, err := s.Get(ctx, s.key(key))
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			existing := new(Record)
+			if err := json.Unmarshal(data, existing); err != nil {
+				return nil, err
+			}
+			return existing, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot record the key %q", key)
+}
+
+// Complete replaces the record of the key.
+func (s *RedisStore) Complete(ctx context.Context, key string, record Record, expires time.Time) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, s.key(key), value, s.ttl(expires))
+}
+
+// Release removes the record of the key.
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return s.Del(ctx, s.key(key))
+}
+
+// SQLStore is a Store in an SQL database, in a table made by CreateTable:
+//
+//	CREATE TABLE rpc_idempotency (
+//		idempotency_key VARCHAR(255) PRIMARY KEY,
+//		record TEXT NOT NULL,
+//		expires BIGINT NOT NULL
+//	)
+//
+// expires is in Unix nanoseconds. The primary key makes the insertion of the
+// started calls atomic.
+type SQLStore struct {
+	DB *sql.DB
+
+	// Table is the name of the table, "rpc_idempotency" when empty.
+	Table string
+
+	// Numbered uses the $1, $2... placeholders of PostgreSQL, rather than ?.
+	Numbered bool
+
+	// Clock expires the keys, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+func (s *SQLStore) table() string {
+	if s.Table == "" {
+		return "rpc_idempotency"
+	}
+	return s.Table
+}
+
+// query formats the statement, replacing its ? placeholders when Numbered.
+func (s *SQLStore) query(format string) string {
+	return sqlutil.Query(format, s.table(), s.Numbered)
+}
+
+// CreateTable creates the table unless it exists.
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, s.query("CREATE TABLE IF NOT EXISTS %s (idempotency_key VARCHAR(255) PRIMARY KEY, record TEXT NOT NULL, expires BIGINT NOT NULL)"))
+	return err
+}
+
+// Begin records the started call of the key, unless it is recorded.
+func (s *SQLStore) Begin(ctx context.Context, key string, record Record, expires time.Time) (*Record, error) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	if _, err := s.DB.ExecContext(ctx, s.query("DELETE FROM %s WHERE idempotency_key = ? AND expires <= ?"), key, now.UnixNano()); err != nil {
+		return nil, err
+	}
+	_, insertErr := s.DB.ExecContext(ctx, s.query("INSERT INTO %s (idempotency_key, record, expires) VALUES (?, ?, ?)"), key, string(value), expires.UnixNano())
+	if insertErr == nil {
+		return nil, nil
+	}
+	// The insertion failed on the primary key, or on another error.
+	var data string
+	if err := s.DB.QueryRowContext(ctx, s.query("SELECT record FROM %s WHERE idempotency_key = ?"), key).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, insertErr
+		}
+		return nil, err
+	}
+	existing := new(Record)
+	if err := json.Unmarshal([]byte(data), existing); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// Complete replaces the record of the key.
+func (s *SQLStore) Complete(ctx context.Context, key string, record Record, expires time.Time) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, s.query("UPDATE %s SET record = ?, expires = ? WHERE idempotency_key = ?"), string(value), expires.UnixNano(), key)
+	return err
+}
+
+// Release removes the record of the key.
+func (s *SQLStore) Release(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, s.query("DELETE FROM %s WHERE idempotency_key = ?"), key)
+	return err
+}
@@ -0,0 +1,169 @@
+package rpcdiscovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Consul is a Registry in the local Consul agent, through its HTTP API. The
+// instances are services with a TTL check, their methods in the "methods"
+// meta key, comma-separated.
+type Consul struct {
+	// Addr is the URL of the agent, "http://127.0.0.1:8500" when empty.
+	Addr string
+
+	// Token is the ACL token of the requests, none when empty.
+	Token string
+
+	// DeregisterAfter, when set, is how long Consul keeps an instance whose
+	// check is critical before deregistering it, e.g. "10m".
+	DeregisterAfter string
+
+	// Client performs the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Register registers the instance as a service with a TTL check.
+func (c *Consul) Register(ctx context.Context, reg Registration) error {
+	meta := make(map[string]string, len(reg.Meta)+1)
+	for key, value := range reg.Meta {
+		meta[key] = value
+	}
+	if len(reg.Methods) > 0 {
+		meta["methods"] = strings.Join(reg.Methods, ",")
+	}
+	check := map[string]interface{}{
+		"CheckID": "service:" + reg.ID,
+		"TTL":     reg.TTL.String(),
+	}
+	if c.DeregisterAfter != "" {
+		check["DeregisterCriticalServiceAfter"] = c.DeregisterAfter
+	}
+	return c.put(ctx, "/v1/agent/service/register", map[string]interface{}{
+		"ID":      reg.ID,
+		"Name":    reg.Name,
+		"Address": reg.Address,
+		"Port":    reg.Port,
+		"Tags":    reg.Tags,
+		"Meta":    meta,
+		"Check":   check,
+	})
+}
+
+// SetHealth updates the TTL check of the instance, passing or critical.
+func (c *Consul) SetHealth(ctx context.Context, id string, err error) error {
+	update := map[string]string{"Status": "passing"}
+	if err != nil {
+		update["Status"], update["Output"] = "critical", err.Error()
+	}
+	return c.put(ctx, "/v1/agent/check/update/"+url.PathEscape("service:"+id), update)
+}
+
+// Deregister deregisters the service of the instance.
+func (c *Consul) Deregister(ctx context.Context, id string) error {
+	return c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(id), nil)
+}
+
+// put sends a PUT request to the agent, with body as JSON when not nil.
+func (c *Consul) put(ctx context.Context, path string, body interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	r, err := http.NewRequestWithContext(ctx, "PUT", c.addr()+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		r.Header.Set("X-Consul-Token", c.Token)
+	}
+	_, err = do(c.Client, r, nil)
+	return err
+}
+
+func (c *Consul) addr() string {
+	if c.Addr == "" {
+		return "http://127.0.0.1:8500"
+	}
+	return strings.TrimRight(c.Addr, "/")
+}
+
+// do performs a request of a registry, decoding the JSON response into v when
+// not nil. It returns the header of the response.
+func do(client *http.Client, r *http.Request, v interface{}) (http.Header, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("%s %s: status %d: %s", r.Method, r.URL.Path, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if v == nil {
+		io.Copy(ioutil.Discard, res.Body)
+		return res.Header, nil
+	}
+	return res.Header, json.NewDecoder(res.Body).Decode(v)
+}
+
+// Watch watches the passing instances of the service with blocking queries
+// of the health endpoint of the agent, it implements rpcclient.Resolver. The
+// address of an instance is the one of its node when it has none.
+func (c *Consul) Watch(ctx context.Context, service string, update func(addrs []string)) error {
+	index := "0"
+	var last []string
+	for first := true; ; first = false {
+		r, err := http.NewRequestWithContext(ctx, "GET", c.addr()+"/v1/health/service/"+url.PathEscape(service)+"?passing=true&wait=5m&index="+index, nil)
+		if err != nil {
+			return err
+		}
+		if c.Token != "" {
+			r.Header.Set("X-Consul-Token", c.Token)
+		}
+		var entries []struct {
+			Node    struct{ Address string }
+			Service struct {
+				Address string
+				Port    int
+			}
+		}
+		header, err := do(c.Client, r, &entries)
+		if err != nil {
+			return err
+		}
+		if next := header.Get("X-Consul-Index"); next != "" {
+			index = next
+		}
+		addrs := make([]string, len(entries))
+		for i, entry := range entries {
+			host := entry.Service.Address
+			if host == "" {
+				host = entry.Node.Address
+			}
+			addrs[i] = net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+		}
+		sort.Strings(addrs)
+		if first || strings.Join(addrs, ",") != strings.Join(last, ",") {
+			update(addrs)
+			last = addrs
+		}
+	}
+}
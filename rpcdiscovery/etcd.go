@@ -0,0 +1,281 @@
+package rpcdiscovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Etcd is a Registry in etcd, through the JSON gateway of its v3 API. An
+// instance is the key Prefix + Name + "/" + ID, with its Registration and its
+// health as a JSON EtcdValue, attached to a lease of its TTL: the key is
+// removed when the instance stops updating its health.
+type Etcd struct {
+	// Endpoint is the URL of an etcd member, "http://127.0.0.1:2379" when
+	// empty.
+	Endpoint string
+
+	// Prefix is prepended to the keys, "/services/" when empty.
+	Prefix string
+
+	// Token is the authentication token of the requests, none when empty.
+	Token string
+
+	// Client performs the requests, http.DefaultClient when nil.
+	Client *http.Client
+
+	mu        sync.Mutex
+	instances map[string]*etcdInstance
+}
+
+// EtcdValue is the value of the key of an instance.
+type EtcdValue struct {
+	Registration
+	Healthy bool   `json:"healthy"`
+	Output  string `json:"output,omitempty"`
+}
+
+type etcdInstance struct {
+	reg   Registration
+	lease string
+}
+
+// Register grants a lease of the TTL and puts the key of the instance,
+// unhealthy until its first health update.
+func (e *Etcd) Register(ctx context.Context, reg Registration) error {
+	var grant struct {
+		ID string `json:"ID"`
+	}
+	seconds := int64(reg.TTL.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	if err := e.post(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": seconds}, &grant); err != nil {
+		return err
+	}
+	if grant.ID == "" {
+		return errors.New("etcd granted no lease")
+	}
+	instance := &etcdInstance{reg: reg, lease: grant.ID}
+	if err := e.put(ctx, instance, EtcdValue{Registration: reg}); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.instances == nil {
+		e.instances = make(map[string]*etcdInstance)
+	}
+	e.instances[reg.ID] = instance
+	return nil
+}
+
+// SetHealth refreshes the lease of the instance and puts its health, it
+// registers the instance again if its lease expired.
+func (e *Etcd) SetHealth(ctx context.Context, id string, err error) error {
+	e.mu.Lock()
+	instance, ok := e.instances[id]
+	e.mu.Unlock()
+	if !ok {
+		return errors.New("instance " + id + " is not registered")
+	}
+	var keepalive struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if errKeepalive := e.post(ctx, "/v3/lease/keepalive", map[string]string{"ID": instance.lease}, &keepalive); errKeepalive != nil {
+		return errKeepalive
+	}
+	if keepalive.Result.TTL == "" || keepalive.Result.TTL == "0" {
+		// The lease expired with the key.
+		if errRegister := e.Register(ctx, instance.reg); errRegister != nil {
+			return errRegister
+		}
+		return e.SetHealth(ctx, id, err)
+	}
+	value := EtcdValue{Registration: instance.reg, Healthy: err == nil}
+	if err != nil {
+		value.Output = err.Error()
+	}
+	return e.put(ctx, instance, value)
+}
+
+// Deregister revokes the lease of the instance, removing its key.
+func (e *Etcd) Deregister(ctx context.Context, id string) error {
+	e.mu.Lock()
+	instance, ok := e.instances[id]
+	delete(e.instances, id)
+	e.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return e.post(ctx, "/v3/lease/revoke", map[string]string{"ID": instance.lease}, nil)
+}
+
+// Key returns the key of the instance of a registration.
+func (e *Etcd) Key(reg Registration) string {
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "/services/"
+	}
+	return prefix + reg.Name + "/" + reg.ID
+}
+
+// put puts the value of the key of the instance, attached to its lease.
+func (e *Etcd) put(ctx context.Context, instance *etcdInstance, value EtcdValue) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return e.post(ctx, "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.Key(instance.reg))),
+		"value": base64.StdEncoding.EncodeToString(data),
+		"lease": instance.lease,
+	}, nil)
+}
+
+// post sends a request to the JSON gateway, decoding the response into v when
+// not nil.
+func (e *Etcd) post(ctx context.Context, path string, body interface{}, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", e.endpoint()+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if e.Token != "" {
+		r.Header.Set("Authorization", e.Token)
+	}
+	_, err = do(e.Client, r, v)
+	return err
+}
+
+func (e *Etcd) endpoint() string {
+	if e.Endpoint == "" {
+		return "http://127.0.0.1:2379"
+	}
+	return strings.TrimRight(e.Endpoint, "/")
+}
+
+// Watch watches the healthy instances of the service through the keys of its
+// prefix, it implements rpcclient.Resolver. The keys are read again at every
+// change reported by a watch of the prefix.
+func (e *Etcd) Watch(ctx context.Context, service string, update func(addrs []string)) error {
+	prefix := e.Key(Registration{Name: service})
+	ranged := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd(prefix)),
+	}
+	var last []string
+	for first := true; ; first = false {
+		var res struct {
+			Header struct {
+				Revision string `json:"revision"`
+			} `json:"header"`
+			Kvs []struct {
+				Value string `json:"value"`
+			} `json:"kvs"`
+		}
+		if err := e.post(ctx, "/v3/kv/range", ranged, &res); err != nil {
+			return err
+		}
+		var addrs []string
+		for _, kv := range res.Kvs {
+			data, err := base64.StdEncoding.DecodeString(kv.Value)
+			if err != nil {
+				return err
+			}
+			var value EtcdValue
+			if err := json.Unmarshal(data, &value); err != nil {
+				return err
+			}
+			if value.Healthy {
+				addrs = append(addrs, net.JoinHostPort(value.Address, strconv.Itoa(value.Port)))
+			}
+		}
+		sort.Strings(addrs)
+		if first || strings.Join(addrs, ",") != strings.Join(last, ",") {
+			update(addrs)
+			last = addrs
+		}
+		revision, _ := strconv.ParseInt(res.Header.Revision, 10, 64)
+		if err := e.wait(ctx, ranged, revision+1); err != nil {
+			return err
+		}
+	}
+}
+
+// wait watches the range from the revision, until it changes.
+func (e *Etcd) wait(ctx context.Context, ranged map[string]string, revision int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	create := map[string]interface{}{"start_revision": revision}
+	for key, value := range ranged {
+		create[key] = value
+	}
+	data, err := json.Marshal(map[string]interface{}{"create_request": create})
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", e.endpoint()+"/v3/watch", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if e.Token != "" {
+		r.Header.Set("Authorization", e.Token)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("POST /v3/watch: status %d", res.StatusCode)
+	}
+	// The gateway streams a message at the creation of the watch, then at
+	// every change.
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var message struct {
+			Result struct {
+				Canceled bool              `json:"canceled"`
+				Events   []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			return err
+		}
+		if len(message.Result.Events) > 0 || message.Result.Canceled {
+			return nil
+		}
+	}
+}
+
+// prefixEnd returns the end of the range of the keys having the prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
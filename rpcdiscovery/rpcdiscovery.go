@@ -0,0 +1,151 @@
+// Package rpcdiscovery registers an rpcserver.Server into a service registry,
+// Consul or etcd, while it runs: its address, its methods and its health, the
+// outcome of readiness checks.
+//
+//	agent := &rpcdiscovery.Agent{
+//		Registry: &rpcdiscovery.Consul{},
+//		Server:   server,
+//		Registration: rpcdiscovery.Registration{
+//			ID:      "orders-1",
+//			Name:    "orders",
+//			Address: "10.0.0.7",
+//			Port:    8080,
+//		},
+//		Checks: map[string]rpcdiscovery.Check{"db": db.PingContext},
+//	}
+//	go agent.Run(ctx)
+//
+// The server is deregistered when ctx is done, on shutdown. The registries are
+// rpcclient resolvers too, for clients to call the healthy instances of a
+// service by its name:
+//
+//	client := rpcclient.NewClient("http://orders/rpc")
+//	client.Resolver = &rpcdiscovery.Consul{}
+//	defer client.Close()
+package rpcdiscovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Registration describes an instance of a server.
+type Registration struct {
+	// ID is unique to the instance, Name is the one of the service.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+
+	// Methods are the methods served, the ones of the Server of the Agent
+	// when nil.
+	Methods []string `json:"methods,omitempty"`
+
+	// TTL is the time the registry keeps the instance healthy without a
+	// health update, set by the Agent.
+	TTL time.Duration `json:"-"`
+}
+
+// Registry is a service registry.
+type Registry interface {
+	// Register adds the instance to the registry, or updates it.
+	Register(ctx context.Context, reg Registration) error
+
+	// SetHealth records the health of the instance of the id: healthy when
+	// err is nil, failing with the message of err otherwise. It must be
+	// updated within the TTL of the registration.
+	SetHealth(ctx context.Context, id string, err error) error
+
+	// Deregister removes the instance of the id from the registry.
+	Deregister(ctx context.Context, id string) error
+}
+
+// Check is a readiness check, failing with an error.
+type Check func(ctx context.Context) error
+
+// Agent keeps a server registered while it runs.
+type Agent struct {
+	Registry     Registry
+	Registration Registration
+
+	// Server is the registered server, whose methods are registered.
+	Server *rpcserver.Server
+
+	// Checks are the readiness checks by name, the instance is healthy
+	// when they all pass.
+	Checks map[string]Check
+
+	// Interval is the delay between the health updates, 10s when zero. The
+	// TTL of the registration is three intervals, and every check is
+	// bounded by an interval.
+	Interval time.Duration
+
+	// OnError, when set, is called with the errors of the registry after
+	// the registration, which are retried at the next interval.
+	OnError func(err error)
+
+	// Clock times the health updates, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+func (a *Agent) interval() time.Duration {
+	if a.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return a.Interval
+}
+
+// Run registers the server, updates its health every Interval until ctx is
+// done, then deregisters it. It returns the error of the registration or of
+// the deregistration, or the error of ctx.
+func (a *Agent) Run(ctx context.Context) error {
+	reg := a.Registration
+	if reg.Methods == nil && a.Server != nil {
+		reg.Methods = a.Server.Service().MethodNames()
+	}
+	reg.TTL = 3 * a.interval()
+	if err := a.Registry.Register(ctx, reg); err != nil {
+		return fmt.Errorf("rpcdiscovery: cannot register %s: %v", reg.ID, err)
+	}
+	clock := rpcserver.ClockOrSystem(a.Clock)
+	for {
+		if err := a.Registry.SetHealth(ctx, reg.ID, a.Ready(ctx)); err != nil && ctx.Err() == nil && a.OnError != nil {
+			a.OnError(fmt.Errorf("rpcdiscovery: cannot update the health of %s: %v", reg.ID, err))
+		}
+		timer := clock.NewTimer(a.interval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if err := a.Registry.Deregister(context.Background(), reg.ID); err != nil {
+				return fmt.Errorf("rpcdiscovery: cannot deregister %s: %v", reg.ID, err)
+			}
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// Ready runs the checks, it returns nil if they all pass or an error listing
+// the failed ones.
+func (a *Agent) Ready(ctx context.Context) error {
+	ctx, cancel := rpcserver.WithClockTimeout(ctx, rpcserver.ClockOrSystem(a.Clock), a.interval())
+	defer cancel()
+	var failed []string
+	for name, check := range a.Checks {
+		if err := check(ctx); err != nil {
+			failed = append(failed, name+": "+err.Error())
+		}
+	}
+	if failed == nil {
+		return nil
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("rpcdiscovery: checks failed: %s", strings.Join(failed, "; "))
+}
@@ -0,0 +1,250 @@
+package rpcdiscovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Orders struct{}
+
+func (o *Orders) Place(r *http.Request, args *string, reply *string) error {
+	return nil
+}
+
+// registry records the requests of a fake registry, answering them with
+// respond.
+type registry struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   []map[string]interface{}
+	respond  func(path string) interface{}
+}
+
+func (reg *registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := make(map[string]interface{})
+	json.NewDecoder(r.Body).Decode(&body)
+	reg.mu.Lock()
+	reg.requests = append(reg.requests, r.Method+" "+r.URL.EscapedPath())
+	reg.bodies = append(reg.bodies, body)
+	reg.mu.Unlock()
+	if reg.respond != nil {
+		json.NewEncoder(w).Encode(reg.respond(r.URL.Path))
+	}
+}
+
+// run runs an agent until it updated the health twice, then stops it.
+func run(t *testing.T, reg *registry, agent *Agent) {
+	clock := rpcservertest.NewFakeClock(time.Now())
+	agent.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- agent.Run(ctx) }()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(agent.interval())
+	for {
+		reg.mu.Lock()
+		n := len(reg.requests)
+		reg.mu.Unlock()
+		if n >= 3 && clock.Timers() > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestConsul(t *testing.T) {
+	srv := rpcservertest.NewServer(t, new(Orders))
+	defer srv.Close()
+	reg := new(registry)
+	consul := httptest.NewServer(reg)
+	defer consul.Close()
+
+	healthy := true
+	run(t, reg, &Agent{
+		Registry:     &Consul{Addr: consul.URL, DeregisterAfter: "10m"},
+		Server:       srv.RPC,
+		Registration: Registration{ID: "orders-1", Name: "orders", Address: "10.0.0.7", Port: 8080},
+		Checks: map[string]Check{"db": func(ctx context.Context) error {
+			if healthy {
+				healthy = false
+				return nil
+			}
+			return errors.New("down")
+		}},
+	})
+	expected := []string{
+		"PUT /v1/agent/service/register",
+		"PUT /v1/agent/check/update/service:orders-1",
+		"PUT /v1/agent/check/update/service:orders-1",
+		"PUT /v1/agent/service/deregister/orders-1",
+	}
+	if strings.Join(reg.requests, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected requests %v", reg.requests)
+	}
+	if meta := reg.bodies[0]["Meta"].(map[string]interface{}); meta["methods"] != "Place" {
+		t.Errorf("unexpected registration %v", reg.bodies[0])
+	}
+	if check := reg.bodies[0]["Check"].(map[string]interface{}); check["TTL"] != "30s" || check["DeregisterCriticalServiceAfter"] != "10m" {
+		t.Errorf("unexpected check %v", check)
+	}
+	if reg.bodies[1]["Status"] != "passing" || reg.bodies[2]["Status"] != "critical" || reg.bodies[2]["Output"] != "rpcdiscovery: checks failed: db: down" {
+		t.Errorf("unexpected updates %v %v", reg.bodies[1], reg.bodies[2])
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	reg := &registry{respond: func(path string) interface{} {
+		switch path {
+		case "/v3/lease/grant":
+			return map[string]string{"ID": "42", "TTL": "30"}
+		case "/v3/lease/keepalive":
+			return map[string]interface{}{"result": map[string]string{"ID": "42", "TTL": "30"}}
+		}
+		return map[string]string{}
+	}}
+	etcd := httptest.NewServer(reg)
+	defer etcd.Close()
+
+	run(t, reg, &Agent{
+		Registry:     &Etcd{Endpoint: etcd.URL},
+		Registration: Registration{ID: "orders-1", Name: "orders", Address: "10.0.0.7", Port: 8080, Methods: []string{"Place"}},
+	})
+	expected := []string{
+		"POST /v3/lease/grant",
+		"POST /v3/kv/put",
+		"POST /v3/lease/keepalive",
+		"POST /v3/kv/put",
+		"POST /v3/lease/keepalive",
+		"POST /v3/kv/put",
+		"POST /v3/lease/revoke",
+	}
+	if strings.Join(reg.requests, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected requests %v", reg.requests)
+	}
+	put := reg.bodies[3]
+	key, _ := base64.StdEncoding.DecodeString(put["key"].(string))
+	data, _ := base64.StdEncoding.DecodeString(put["value"].(string))
+	var value EtcdValue
+	json.Unmarshal(data, &value)
+	if string(key) != "/services/orders/orders-1" || put["lease"] != "42" || !value.Healthy || value.Port != 8080 {
+		t.Errorf("unexpected put %s %+v", key, value)
+	}
+}
+
+// watch runs the watch of a resolver, sending its updates.
+func watch(ctx context.Context, resolver interface {
+	Watch(ctx context.Context, service string, update func(addrs []string)) error
+}) <-chan string {
+	updates := make(chan string, 10)
+	go resolver.Watch(ctx, "orders", func(addrs []string) {
+		updates <- strings.Join(addrs, ",")
+	})
+	return updates
+}
+
+func TestConsulWatch(t *testing.T) {
+	changed := make(chan struct{})
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/orders" || r.URL.Query().Get("passing") != "true" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		entries := `[{"Node":{"Address":"10.0.0.7"},"Service":{"Port":8080}}]`
+		switch r.URL.Query().Get("index") {
+		case "0":
+			w.Header().Set("X-Consul-Index", "1")
+		case "1":
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			w.Header().Set("X-Consul-Index", "2")
+			entries = `[{"Node":{"Address":"10.0.0.7"},"Service":{"Port":8080}},{"Service":{"Address":"10.0.0.8","Port":8080}}]`
+		default:
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(entries))
+	}))
+	defer consul.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := watch(ctx, &Consul{Addr: consul.URL})
+	if addrs := <-updates; addrs != "10.0.0.7:8080" {
+		t.Fatalf("unexpected addresses %s", addrs)
+	}
+	close(changed)
+	if addrs := <-updates; addrs != "10.0.0.7:8080,10.0.0.8:8080" {
+		t.Fatalf("unexpected addresses %s", addrs)
+	}
+}
+
+func TestEtcdWatch(t *testing.T) {
+	encode := func(value EtcdValue) string {
+		data, _ := json.Marshal(value)
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	var mu sync.Mutex
+	kvs := []map[string]string{{"value": encode(EtcdValue{Registration: Registration{Address: "10.0.0.7", Port: 8080}, Healthy: true})}}
+	changed := make(chan struct{})
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			if key, _ := base64.StdEncoding.DecodeString(body["key"].(string)); string(key) != "/services/orders/" {
+				t.Errorf("unexpected key %s", key)
+			}
+			if end, _ := base64.StdEncoding.DecodeString(body["range_end"].(string)); string(end) != "/services/orders0" {
+				t.Errorf("unexpected range end %s", end)
+			}
+			mu.Lock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"header": map[string]string{"revision": "7"}, "kvs": kvs})
+			mu.Unlock()
+		case "/v3/watch":
+			if create := body["create_request"].(map[string]interface{}); create["start_revision"] != 8.0 {
+				t.Errorf("unexpected watch %v", create)
+			}
+			w.Write([]byte(`{"result":{"created":true}}` + "\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-changed:
+				w.Write([]byte(`{"result":{"events":[{"type":"PUT"}]}}` + "\n"))
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer etcd.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := watch(ctx, &Etcd{Endpoint: etcd.URL})
+	if addrs := <-updates; addrs != "10.0.0.7:8080" {
+		t.Fatalf("unexpected addresses %s", addrs)
+	}
+	mu.Lock()
+	kvs = append(kvs,
+		map[string]string{"value": encode(EtcdValue{Registration: Registration{Address: "10.0.0.8", Port: 8080}, Healthy: true})},
+		map[string]string{"value": encode(EtcdValue{Registration: Registration{Address: "10.0.0.9", Port: 8080}})})
+	mu.Unlock()
+	changed <- struct{}{}
+	if addrs := <-updates; addrs != "10.0.0.7:8080,10.0.0.8:8080" {
+		t.Fatalf("unexpected addresses %s", addrs)
+	}
+}
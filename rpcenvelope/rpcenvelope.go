@@ -0,0 +1,348 @@
+// Package rpcenvelope encrypts the payloads stored by the journals and the
+// asynchronous jobs of a server, which often hold personal data, with
+// envelope encryption: every payload is sealed with AES-256-GCM under a data
+// key, stored beside it wrapped by a key encryption key of a KeyProvider,
+// such as a KMS:
+//
+//	keys := rpcenvelope.NewKeyring()
+//	keys.Add("2024-05", key)
+//	envelope := &rpcenvelope.Envelope{Keys: keys}
+//	store := &rpcjobs.SQLStore{DB: db, Envelope: envelope}
+//	journal, err := rpcjournal.New(&rpcjournal.SealedStorage{Storage: storage, Envelope: envelope}, "Transfer")
+//
+// Every payload is bound to the key of its record, such as the id of a job,
+// which it is sealed and opened with: a payload swapped for the one of
+// another record does not open.
+//
+// Keys are rotated by making a new key encryption key the current one of the
+// KeyProvider: the payloads are then sealed with it, the ones sealed before
+// open while the keys they were sealed with are kept, and Rewrap wraps their
+// data keys with the current key so older keys can be retired.
+package rpcenvelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// KeyProvider wraps the data keys with key encryption keys, e.g. with the
+// Encrypt and Decrypt operations of a KMS. It may be called concurrently.
+type KeyProvider interface {
+	// WrapKey encrypts the data key with the current key encryption key,
+	// returning the id of that key.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts the data key wrapped with the key of the id.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KeyChecker is implemented by the KeyProviders telling whether they keep the
+// key encryption key of an id, such as a Keyring: an Envelope then stops
+// opening the payloads of a removed key with the data keys it unwrapped
+// before. The data keys unwrapped with the keys of other KeyProviders open
+// their payloads until Envelope.Flush drops them.
+type KeyChecker interface {
+	HasKey(keyID string) bool
+}
+
+// Algorithm is the algorithm of the sealed payloads.
+const Algorithm = "A256GCM"
+
+// sealedPrefix starts the JSON of every sealed payload, see sealed.
+var sealedPrefix = []byte(`{"enc":"` + Algorithm + `",`)
+
+// sealed is the JSON form of a sealed payload, a JSON value itself so the
+// stores of JSON documents keep it as is.
+type sealed struct {
+	Enc   string `json:"enc"`
+	KeyID string `json:"kid"`
+	Key   []byte `json:"key"` // the wrapped data key
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// ErrNotSealed is returned by Rewrap for the payloads which are not sealed,
+// and by Open when RequireSealed is set.
+var ErrNotSealed = errors.New("rpcenvelope: payload not sealed")
+
+// Envelope seals and opens payloads with the keys of a KeyProvider.
+type Envelope struct {
+	Keys KeyProvider
+
+	// RequireSealed has Open fail with ErrNotSealed for the payloads which
+	// are not sealed, once the ones stored before encrypting them are
+	// sealed or expired. An attacker writing to the store could otherwise
+	// substitute a payload in the clear.
+	RequireSealed bool
+
+	// KeyUses is the number of payloads sealed with a data key before a new
+	// one is generated, 1 when zero: every payload then costs a call to the
+	// KeyProvider. It must not exceed 1<<32, the bound of the random nonces
+	// of AES-GCM. A rotation of the keys applies to the next data key.
+	KeyUses int
+
+	mu      sync.Mutex
+	current *dataKey
+	opened  map[string]cipher.AEAD // unwrapped data keys, by wrapped key
+}
+
+// dataKey is the data key sealing the payloads.
+type dataKey struct {
+	aead    cipher.AEAD
+	keyID   string
+	wrapped []byte
+	uses    int
+}
+
+// maxOpened bounds the number of unwrapped data keys kept by an Envelope.
+const maxOpened = 256
+
+// Seal returns the sealed payload of the plaintext, a JSON object, bound to
+// the key of its record: it only opens with the same key.
+func (e *Envelope) Seal(ctx context.Context, plaintext, recordKey []byte) ([]byte, error) {
+	key, err := e.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&sealed{
+		Enc:   Algorithm,
+		KeyID: key.keyID,
+		Key:   key.wrapped,
+		Nonce: nonce,
+		Data:  key.aead.Seal(nil, nonce, plaintext, recordKey),
+	})
+}
+
+// dataKey returns the data key to seal a payload with, generating and
+// wrapping a new one once the current one is used up.
+func (e *Envelope) dataKey(ctx context.Context) (*dataKey, error) {
+	uses := e.KeyUses
+	if uses <= 0 {
+		uses = 1
+	}
+	e.mu.Lock()
+	if key := e.current; key != nil && key.uses < uses {
+		key.uses++
+		e.mu.Unlock()
+		return key, nil
+	}
+	e.mu.Unlock()
+
+	plain := make([]byte, 32)
+	if _, err := rand.Read(plain); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := e.Keys.WrapKey(ctx, plain)
+	if err != nil {
+		return nil, fmt.Errorf("rpcenvelope: wrapping the data key: %v", err)
+	}
+	key := &dataKey{aead: aead, keyID: keyID, wrapped: wrapped, uses: 1}
+	if uses > 1 {
+		e.mu.Lock()
+		e.current = key
+		e.mu.Unlock()
+	}
+	return key, nil
+}
+
+// Open returns the plaintext of a payload sealed with the key of its record.
+// Payloads which are not sealed are returned as is, unless RequireSealed is
+// set, so stores keep reading the payloads they stored before encrypting
+// them.
+func (e *Envelope) Open(ctx context.Context, payload, recordKey []byte) ([]byte, error) {
+	if !IsSealed(payload) {
+		if e.RequireSealed {
+			return nil, ErrNotSealed
+		}
+		return payload, nil
+	}
+	var s sealed
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, fmt.Errorf("rpcenvelope: %v", err)
+	}
+	aead, err := e.unwrap(ctx, &s)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, errors.New("rpcenvelope: invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, s.Nonce, s.Data, recordKey)
+	if err != nil {
+		return nil, fmt.Errorf("rpcenvelope: %v", err)
+	}
+	return plaintext, nil
+}
+
+// unwrap returns the data key of the sealed payload.
+func (e *Envelope) unwrap(ctx context.Context, s *sealed) (cipher.AEAD, error) {
+	cacheKey := s.KeyID + "\x00" + string(s.Key)
+	e.mu.Lock()
+	aead, ok := e.opened[cacheKey]
+	if ok {
+		if checker, checks := e.Keys.(KeyChecker); checks && !checker.HasKey(s.KeyID) {
+			delete(e.opened, cacheKey)
+			ok = false
+		}
+	}
+	e.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+	plain, err := e.Keys.UnwrapKey(ctx, s.KeyID, s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("rpcenvelope: unwrapping the data key: %v", err)
+	}
+	if aead, err = newAEAD(plain); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	if e.opened == nil || len(e.opened) >= maxOpened {
+		e.opened = make(map[string]cipher.AEAD)
+	}
+	e.opened[cacheKey] = aead
+	e.mu.Unlock()
+	return aead, nil
+}
+
+// Flush drops the data keys unwrapped by the Envelope, and the one it seals
+// with, once key encryption keys are retired: the next payloads unwrap their
+// data keys with the KeyProvider again.
+func (e *Envelope) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.opened = nil
+	e.current = nil
+}
+
+// Rewrap returns the sealed payload with its data key wrapped by the current
+// key encryption key of the KeyProvider, leaving its data, and the record key
+// it is bound to, as is. It fails with ErrNotSealed for the payloads which
+// are not sealed.
+func (e *Envelope) Rewrap(ctx context.Context, payload []byte) ([]byte, error) {
+	if !IsSealed(payload) {
+		return nil, ErrNotSealed
+	}
+	var s sealed
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, fmt.Errorf("rpcenvelope: %v", err)
+	}
+	plain, err := e.Keys.UnwrapKey(ctx, s.KeyID, s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("rpcenvelope: unwrapping the data key: %v", err)
+	}
+	keyID, wrapped, err := e.Keys.WrapKey(ctx, plain)
+	if err != nil {
+		return nil, fmt.Errorf("rpcenvelope: wrapping the data key: %v", err)
+	}
+	s.KeyID, s.Key = keyID, wrapped
+	return json.Marshal(&s)
+}
+
+// IsSealed tells whether the payload was sealed by an Envelope.
+func IsSealed(payload []byte) bool {
+	return bytes.HasPrefix(payload, sealedPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("rpcenvelope: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyring is a KeyProvider of AES-256 key encryption keys held in memory, for
+// tests and for deployments managing their keys without a KMS.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring creates an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]cipher.AEAD)}
+}
+
+// Add adds the 32 bytes key of the id and makes it the current key.
+func (k *Keyring) Add(id string, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("rpcenvelope: key %q is %d bytes, not 32", id, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	k.current = id
+	return nil
+}
+
+// Remove removes the key of the id, the payloads sealed with it no longer
+// open. The current key can't be removed.
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return fmt.Errorf("rpcenvelope: key %q is the current key", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// HasKey tells whether the keyring has the key of the id.
+func (k *Keyring) HasKey(id string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	_, ok := k.keys[id]
+	return ok
+}
+
+// WrapKey encrypts the data key with the current key.
+func (k *Keyring) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	k.mu.RLock()
+	id := k.current
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return "", nil, errors.New("rpcenvelope: no key")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return id, aead.Seal(nonce, nonce, dataKey, []byte(id)), nil
+}
+
+// UnwrapKey decrypts the data key wrapped with the key of the id.
+func (k *Keyring) UnwrapKey(ctx context.Context, id string, wrapped []byte) ([]byte, error) {
+	k.mu.RLock()
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("rpcenvelope: unknown key %q", id)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("rpcenvelope: invalid wrapped key")
+	}
+	nonce := wrapped[:aead.NonceSize()]
+	return aead.Open(nil, nonce, wrapped[len(nonce):], []byte(id))
+}
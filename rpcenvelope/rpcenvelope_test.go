@@ -0,0 +1,98 @@
+package rpcenvelope
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingKeys counts the data keys wrapped by a KeyProvider.
+type countingKeys struct {
+	KeyProvider
+	wrapped int32
+}
+
+func (k *countingKeys) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	atomic.AddInt32(&k.wrapped, 1)
+	return k.KeyProvider.WrapKey(ctx, dataKey)
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	keyring := NewKeyring()
+	if err := keyring.Add("short", []byte("key")); err == nil {
+		t.Errorf("expected a short key to fail")
+	}
+	keyring.Add("k1", bytes.Repeat([]byte{1}, 32))
+	keys := &countingKeys{KeyProvider: keyring}
+	envelope := &Envelope{Keys: keys, KeyUses: 2}
+
+	var payloads [][]byte
+	for _, plaintext := range []string{`{"card":"4111"}`, `"x"`, `"y"`} {
+		payload, err := envelope.Seal(ctx, []byte(plaintext), []byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(payload) || strings.Contains(string(payload), "4111") {
+			t.Errorf("expected the payload sealed, got %s", payload)
+		}
+		payloads = append(payloads, payload)
+	}
+	if keys.wrapped != 2 {
+		t.Errorf("expected a data key per 2 payloads, got %d", keys.wrapped)
+	}
+	if opened, err := envelope.Open(ctx, payloads[0], []byte(`{"card":"4111"}`)); err != nil || string(opened) != `{"card":"4111"}` {
+		t.Errorf("unexpected plaintext %s, %v", opened, err)
+	}
+	if _, err := envelope.Open(ctx, payloads[1], []byte(`"y"`)); err == nil {
+		t.Errorf("expected a payload bound to another record to fail")
+	}
+	if opened, err := envelope.Open(ctx, []byte(`{"card":"4242"}`), nil); err != nil || string(opened) != `{"card":"4242"}` {
+		t.Errorf("expected the payloads not sealed left as is, got %s, %v", opened, err)
+	}
+	strict := &Envelope{Keys: keyring, RequireSealed: true}
+	if _, err := strict.Open(ctx, []byte(`{"card":"4242"}`), nil); err != ErrNotSealed {
+		t.Errorf("expected ErrNotSealed, got %v", err)
+	}
+	tampered := bytes.Replace(payloads[1], []byte(`"data":"`), []byte(`"data":"AA`), 1)
+	if _, err := envelope.Open(ctx, tampered, []byte(`"x"`)); err == nil {
+		t.Errorf("expected a tampered payload to fail")
+	}
+
+	// Rotate the keys, then retire the first one.
+	keyring.Add("k2", bytes.Repeat([]byte{2}, 32))
+	if err := keyring.Remove("k2"); err == nil {
+		t.Errorf("expected the current key not to be removed")
+	}
+	rewrapped, err := envelope.Rewrap(ctx, payloads[0])
+	if err != nil || !strings.Contains(string(rewrapped), `"kid":"k2"`) {
+		t.Fatalf("expected the payload rewrapped with k2, got %s, %v", rewrapped, err)
+	}
+	direct := &Envelope{Keys: keyring}
+	if _, err := direct.Open(ctx, payloads[2], []byte(`"y"`)); err != nil {
+		t.Fatal(err)
+	}
+	keyring.Remove("k1")
+	if _, err := direct.Open(ctx, payloads[2], []byte(`"y"`)); err == nil || !strings.Contains(err.Error(), `unknown key "k1"`) {
+		t.Errorf("expected the cached data key of the retired key to be dropped, got %v", err)
+	}
+	if _, err := envelope.Open(ctx, payloads[0], []byte(`{"card":"4111"}`)); err != nil {
+		t.Errorf("expected the cached data key to open until flushed, got %v", err)
+	}
+	envelope.Flush()
+	if _, err := envelope.Open(ctx, payloads[0], []byte(`{"card":"4111"}`)); err == nil || !strings.Contains(err.Error(), `unknown key "k1"`) {
+		t.Errorf("expected the flushed data key of the retired key to be unknown, got %v", err)
+	}
+	fresh := &Envelope{Keys: keyring}
+	if opened, err := fresh.Open(ctx, rewrapped, []byte(`{"card":"4111"}`)); err != nil || string(opened) != `{"card":"4111"}` {
+		t.Errorf("unexpected plaintext %s, %v", opened, err)
+	}
+	if _, err := fresh.Open(ctx, payloads[2], []byte(`"y"`)); err == nil || !strings.Contains(err.Error(), `unknown key "k1"`) {
+		t.Errorf("expected the retired key to be unknown, got %v", err)
+	}
+	if _, err := envelope.Rewrap(ctx, []byte(`"x"`)); err != ErrNotSealed {
+		t.Errorf("expected ErrNotSealed, got %v", err)
+	}
+}
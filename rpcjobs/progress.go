@@ -0,0 +1,135 @@
+package rpcjobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"time"
+)
+
+// Progress tells how far a job is.
+type Progress struct {
+	// Percent is the part of the work done, from 0 to 100.
+	Percent float64 `json:"percent"`
+	Message string  `json:"message,omitempty"`
+
+	// ETA is the estimated time of completion, zero when unknown.
+	ETA     time.Time `json:"eta"`
+	Updated time.Time `json:"updated"`
+}
+
+// runKey is the context key of the run of a job.
+type runKey struct{}
+
+// run is a running job.
+type run struct {
+	jobs    *Jobs
+	id      string
+	started time.Time
+	cancel  context.CancelFunc
+
+	// cancelled and partial are guarded by jobs.mu.
+	cancelled bool
+	partial   json.RawMessage // see ReportPartial
+}
+
+// ReportProgress records the progress of the job of ctx, the context of the
+// request of a method running as a job. The ETA is estimated from the time
+// elapsed since the job started when zero. It returns false outside of jobs,
+// when the call is synchronous.
+func ReportProgress(ctx context.Context, percent float64, message string, eta time.Time) bool {
+	r, ok := ctx.Value(runKey{}).(*run)
+	if !ok {
+		return false
+	}
+	now := rpcserver.ClockOrSystem(r.jobs.Clock).Now()
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	if eta.IsZero() && percent > 0 {
+		elapsed := now.Sub(r.started)
+		eta = now.Add(time.Duration(float64(elapsed) * (100 - percent) / percent))
+	}
+	progress := &Progress{Percent: percent, Message: message, ETA: eta, Updated: now}
+	r.jobs.mu.Lock()
+	defer r.jobs.mu.Unlock()
+	if job, ok := r.jobs.jobs[r.id]; ok && job.Status == Running {
+		job.Progress = progress
+		r.jobs.notify(r.id)
+	}
+	return true
+}
+
+// ReportPartial records the partial result of the job of ctx, encoded as JSON:
+// the Result of the job if it is cancelled before the method returns. It
+// returns false outside of jobs, or if the result cannot be encoded.
+func ReportPartial(ctx context.Context, result interface{}) bool {
+	r, ok := ctx.Value(runKey{}).(*run)
+	if !ok {
+		return false
+	}
+	partial, err := json.Marshal(result)
+	if err != nil {
+		return false
+	}
+	r.jobs.mu.Lock()
+	defer r.jobs.mu.Unlock()
+	r.partial = partial
+	return true
+}
+
+// streamJob streams the job of the id as server-sent events: a "job" event
+// with the Job as JSON data at every change, until the job completes or the
+// client leaves. The jobs queued or run by other instances are polled every
+// PollInterval.
+func (j *Jobs) streamJob(w http.ResponseWriter, r *http.Request, id string) {
+	job, changed, err := j.watch(r.Context(), id)
+	if err != nil {
+		rpcserver.WriteError(w, 500, "rpcjobs: "+err.Error())
+		return
+	}
+	if job == nil {
+		rpcserver.WriteError(w, 404, "rpcjobs: no job "+id)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+	clock := rpcserver.ClockOrSystem(j.Clock)
+	var last []byte
+	for {
+		if data, _ := json.Marshal(job); !bytes.Equal(data, last) {
+			if _, err := fmt.Fprintf(w, "event: job\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			rc.Flush()
+			last = data
+		}
+		if job.Completed != nil {
+			return
+		}
+		var timer rpcserver.Timer
+		var poll <-chan time.Time
+		if changed == nil {
+			timer = clock.NewTimer(j.pollInterval())
+			poll = timer.C()
+		}
+		select {
+		case <-changed:
+		case <-poll:
+		case <-r.Context().Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+		if job, changed, err = j.watch(r.Context(), id); job == nil || err != nil {
+			return
+		}
+	}
+}
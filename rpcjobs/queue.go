@@ -0,0 +1,272 @@
+package rpcjobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/rpcenvelope"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Task is the call of a queued job.
+type Task struct {
+	// ID is the one of the job.
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+
+	// Header holds the headers of the call, its credentials included.
+	Header http.Header `json:"header,omitempty"`
+
+	// Attempts is the number of claims of the task, the current one
+	// included. Receipt identifies the current claim, for Extend and Ack.
+	Attempts int    `json:"-"`
+	Receipt  string `json:"-"`
+}
+
+// Queue is a work queue shared by the instances of a server. A claimed task
+// is hidden from the other claims until its visibility timeout passes, then
+// claimed again unless it was acknowledged: tasks are run at least once, by
+// any instance.
+//
+// A Queue over NATS JetStream maps Claim to a fetch from a work-queue stream
+// with an AckWait of the visibility, Extend to an in-progress ack and Ack to
+// an ack.
+type Queue interface {
+	// Push adds a task to the queue.
+	Push(ctx context.Context, task *Task) error
+
+	// Claim returns a visible task, hidden for the visibility timeout, or
+	// nil if there is none.
+	Claim(ctx context.Context, visibility time.Duration) (*Task, error)
+
+	// Extend hides the claimed task for the visibility timeout from now. It
+	// returns ErrClaimLost if the task was claimed again since.
+	Extend(ctx context.Context, task *Task, visibility time.Duration) error
+
+	// Ack removes the claimed task from the queue.
+	Ack(ctx context.Context, task *Task) error
+}
+
+// ErrClaimLost is returned by Queue.Extend for the tasks whose visibility
+// timeout passed and which were claimed again.
+var ErrClaimLost = errors.New("rpcjobs: task claimed again")
+
+// MemoryQueue is a Queue of a single process, its tasks are lost on restart.
+type MemoryQueue struct {
+	// Clock times the visibility timeouts, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu    sync.Mutex
+	tasks []*memoryTask // in the order they were pushed
+}
+
+type memoryTask struct {
+	task    Task
+	visible time.Time
+}
+
+// Push adds a task to the queue.
+func (q *MemoryQueue) Push(ctx context.Context, task *Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tasks = append(q.tasks, &memoryTask{task: *task})
+	return nil
+}
+
+// Claim returns the oldest visible task.
+func (q *MemoryQueue) Claim(ctx context.Context, visibility time.Duration) (*Task, error) {
+	now := rpcserver.ClockOrSystem(q.Clock).Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, queued := range q.tasks {
+		if queued.visible.After(now) {
+			continue
+		}
+		queued.visible = now.Add(visibility)
+		queued.task.Attempts++
+		queued.task.Receipt = strconv.Itoa(queued.task.Attempts)
+		task := queued.task
+		return &task, nil
+	}
+	return nil, nil
+}
+
+// Extend hides the claimed task for the visibility timeout from now.
+func (q *MemoryQueue) Extend(ctx context.Context, task *Task, visibility time.Duration) error {
+	now := rpcserver.ClockOrSystem(q.Clock).Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.find(task)
+	if i == -1 {
+		return ErrClaimLost
+	}
+	q.tasks[i].visible = now.Add(visibility)
+	return nil
+}
+
+// Ack removes the claimed task from the queue.
+func (q *MemoryQueue) Ack(ctx context.Context, task *Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.find(task)
+	if i == -1 {
+		return ErrClaimLost
+	}
+	q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+	return nil
+}
+
+// find returns the index of the queued task of the claim, or -1 if the task
+// was claimed again, with q.mu held.
+func (q *MemoryQueue) find(task *Task) int {
+	for i, queued := range q.tasks {
+		if queued.task.ID == task.ID && queued.task.Receipt == task.Receipt {
+			return i
+		}
+	}
+	return -1
+}
+
+// RedisQueue is a Queue in Redis: a sorted set of the task ids scored by the
+// Unix milliseconds they are visible at, and hashes of their bodies and
+// claims. Eval runs a Lua script with the EVAL command of a client, e.g. with
+// github.com/redis/go-redis:
+//
+//	queue := &rpcjobs.RedisQueue{
+//		Eval: func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//			value, err := rdb.Eval(ctx, script, keys, args...).Result()
+//			if err == redis.Nil {
+//				return nil, nil
+//			}
+//			return value, err
+//		},
+//	}
+//
+// Eval returns nil for nil replies. The scripts make the claims atomic, so
+// any number of instances claim the tasks without a leader.
+type RedisQueue struct {
+	Eval func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+	// Prefix is prepended to the names of the Redis keys, "rpc:jobs:" when
+	// empty.
+	Prefix string
+
+	// Clock times the visibility timeouts, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	// Envelope encrypts the bodies of the tasks, holding the params and the
+	// credentials of the calls, when set, bound to their id. The tasks are
+	// not rewrapped: the key encryption keys rotated out are retired once
+	// the tasks queued before are acked.
+	Envelope *rpcenvelope.Envelope
+}
+
+// The scripts of RedisQueue, with the keys of redisKeys. A claim stores its
+// receipt in the claims hash, as the claim count and a random token.
+const (
+	redisPush = `redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1`
+
+	redisClaim = `local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #ids == 0 then return nil end
+local id = ids[1]
+local claim = redis.call('HGET', KEYS[3], id)
+local attempts = 1
+if claim then attempts = tonumber(string.match(claim, '^(%d+)')) + 1 end
+redis.call('ZADD', KEYS[1], ARGV[2], id)
+redis.call('HSET', KEYS[3], id, attempts .. ':' .. ARGV[3])
+return {id, redis.call('HGET', KEYS[2], id), attempts}`
+
+	redisExtend = `if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] then return 0 end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1`
+
+	redisAck = `if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] then return 0 end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1`
+)
+
+// keys returns the keys of the visibility sorted set and of the bodies and
+// claims hashes.
+func (q *RedisQueue) keys() []string {
+	prefix := q.Prefix
+	if prefix == "" {
+		prefix = "rpc:jobs:"
+	}
+	return []string{prefix + "queue", prefix + "tasks", prefix + "claims"}
+}
+
+func (q *RedisQueue) millis(d time.Duration) int64 {
+	return rpcserver.ClockOrSystem(q.Clock).Now().Add(d).UnixNano() / int64(time.Millisecond)
+}
+
+// Push adds a task to the queue.
+func (q *RedisQueue) Push(ctx context.Context, task *Task) error {
+	data, err := marshal(ctx, q.Envelope, task, task.ID)
+	if err != nil {
+		return err
+	}
+	_, err = q.Eval(ctx, redisPush, q.keys(), task.ID, string(data), q.millis(0))
+	return err
+}
+
+// Claim returns a visible task.
+func (q *RedisQueue) Claim(ctx context.Context, visibility time.Duration) (*Task, error) {
+	var token [8]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	reply, err := q.Eval(ctx, redisClaim, q.keys(), q.millis(0), q.millis(visibility), hex.EncodeToString(token[:]))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("unexpected reply %v", reply)
+	}
+	id, _ := values[0].(string)
+	data, _ := values[1].(string)
+	attempts, _ := values[2].(int64)
+	task := new(Task)
+	if err := unmarshal(ctx, q.Envelope, []byte(data), task, id); err != nil {
+		return nil, err
+	}
+	task.Attempts = int(attempts)
+	task.Receipt = fmt.Sprintf("%d:%s", attempts, hex.EncodeToString(token[:]))
+	return task, nil
+}
+
+// Extend hides the claimed task for the visibility timeout from now.
+func (q *RedisQueue) Extend(ctx context.Context, task *Task, visibility time.Duration) error {
+	reply, err := q.Eval(ctx, redisExtend, q.keys(), task.ID, task.Receipt, q.millis(visibility))
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+// Ack removes the claimed task from the queue.
+func (q *RedisQueue) Ack(ctx context.Context, task *Task) error {
+	reply, err := q.Eval(ctx, redisAck, q.keys(), task.ID, task.Receipt)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
@@ -0,0 +1,534 @@
+// Package rpcjobs runs the calls of an rpcserver.Server asynchronously on
+// request: calls with a "Prefer: respond-async" header are answered at once
+// with a Job, whose status and result the client queries later:
+//
+//	jobs := rpcjobs.New()
+//	server.Use(jobs.Middleware())
+//	router.GET("/jobs/:id", gin.WrapH(jobs))
+//
+// Calls with an rpcserver.CallbackHeader too have their Job posted to the URL
+// once complete, signed by the Signer of the Jobs, see Jobs.Deliver. Methods
+// tell the progress of their jobs with ReportProgress, which clients follow
+// by querying the job or as a stream of server-sent events. Completed jobs
+// are kept for their Retention by the Store of the Jobs: a MemoryStore, a
+// RedisStore or an SQLStore.
+//
+// Instances sharing a Store and a Queue, such as a RedisQueue, share the
+// jobs: the asynchronous calls are queued, and run by the workers of any
+// instance:
+//
+//	jobs := &rpcjobs.Jobs{Queue: queue, Store: store}
+//	server.Use(jobs.Middleware())
+//	go jobs.Work(ctx, server, "/rpc/")
+//
+// The RedisStore, the SQLStore and the RedisQueue encrypt the jobs and the
+// queued calls with their Envelope when set, see the rpcenvelope package.
+//
+// Clients cancel their running jobs with the rpc.cancel builtin, once
+// registered with Jobs.Register:
+//
+//	{"jsonrpc": "2.0", "method": "rpc.cancel", "params": ["<job id>"], "id": 1}
+//
+// The context of the request of the method is then done, and the job
+// Cancelled with the partial result the method reported, see ReportPartial.
+package rpcjobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the status of a job.
+type Status string
+
+const (
+	// Queued jobs wait for a worker, see Jobs.Queue. Running jobs have their
+	// method running.
+	Queued  Status = "queued"
+	Running Status = "running"
+
+	// Succeeded jobs have a result, Failed ones an error.
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+
+	// Cancelled jobs were cancelled by the client, with the partial result
+	// of the method if any.
+	Cancelled Status = "cancelled"
+)
+
+// Job describes an asynchronous call.
+type Job struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	Status Status `json:"status"`
+
+	// Result or Error are the outcome of the completed jobs.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *jsonrpc2.Error `json:"error,omitempty"`
+
+	Created   time.Time  `json:"created"`
+	Completed *time.Time `json:"completed,omitempty"`
+
+	// Callback is the URL the job is posted to once complete, and Delivery
+	// the status of the posts.
+	Callback string    `json:"callback,omitempty"`
+	Delivery *Delivery `json:"delivery,omitempty"`
+
+	// Progress is the last progress reported by the method, see
+	// ReportProgress.
+	Progress *Progress `json:"progress,omitempty"`
+
+	// CancelRequested tells the worker of a queued job to cancel it, see
+	// Jobs.Cancel.
+	CancelRequested bool `json:"cancelRequested,omitempty"`
+}
+
+// Jobs runs and remembers the asynchronous calls.
+type Jobs struct {
+	// Timeout bounds the duration of the jobs, 1 hour when zero and none
+	// when negative; they run without the deadline of their request.
+	Timeout time.Duration
+
+	// MaxRunning bounds the jobs running at once on the instance without a
+	// Queue, 1000 when zero: the asynchronous calls beyond it fail with
+	// ErrTooManyJobs. A job holds its slot until its callback is delivered.
+	MaxRunning int
+
+	// Retention is how long completed jobs are remembered, 1 hour when
+	// zero.
+	Retention time.Duration
+
+	// Store keeps the completed jobs until their retention passes, a
+	// MemoryStore of at most 10000 jobs when nil. Running jobs are kept in
+	// memory.
+	Store Store
+
+	// Queue, when set, queues the asynchronous calls for the workers of
+	// Work rather than running them: every instance sharing the Queue and
+	// the Store runs them and answers for them. Tasks are claimed again
+	// once their VisibilityTimeout passes without a heartbeat of their
+	// worker, e.g. when its instance restarts; methods run at least once,
+	// and see the job id as their rpcserver.IdempotencyKeyHeader.
+	Queue Queue
+
+	// VisibilityTimeout is the time a claimed task is hidden from other
+	// workers, 30s when zero; workers extend it while they run the task.
+	// PollInterval is the delay between the claims of idle workers, 1s
+	// when zero. MaxAttempts is the number of claims of a task after which
+	// its job fails, 5 when zero.
+	VisibilityTimeout time.Duration
+	PollInterval      time.Duration
+	MaxAttempts       int
+
+	// OnError, when set, is called with the errors of the Store and of the
+	// Queue. The jobs failing to be stored are lost.
+	OnError func(err error)
+
+	// AllowCallback tells if a callback URL is accepted. When nil, the http
+	// and https URLs are, but for the ones of internal addresses when Client
+	// is nil.
+	AllowCallback func(u *url.URL) bool
+
+	// Client posts the callbacks, without following their redirects. When
+	// nil, a client refusing to dial the loopback, private and link-local
+	// addresses posts them, so the server cannot be made to post to internal
+	// services; set one to post to such addresses.
+	Client *http.Client
+
+	// Signer signs the bodies of the callbacks in their
+	// rpcserver.SignatureHeader when set.
+	Signer rpcserver.Signer
+
+	// Retry paces the attempts of the callbacks: MaxAttempts and Backoff
+	// apply, to any failed attempt. When nil, 5 attempts are made with
+	// exponential backoff starting at 1s.
+	Retry *rpcclient.RetryPolicy
+
+	// Clock times the jobs and the retries, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu       sync.Mutex
+	jobs     map[string]*Job          // the jobs until they are stored
+	runs     map[string]*run          // the running jobs
+	changed  map[string]chan struct{} // closed at every change of the job
+	defaults Store                    // the Store when nil
+	slots    chan struct{}            // the slots of the MaxRunning jobs
+}
+
+// defaultMaxJobs is the MaxJobs of the default store, defaultTimeout and
+// defaultMaxRunning the Timeout and the MaxRunning of the Jobs when zero.
+const (
+	defaultMaxJobs    = 10000
+	defaultTimeout    = time.Hour
+	defaultMaxRunning = 1000
+)
+
+// New creates an empty Jobs.
+func New() *Jobs {
+	return &Jobs{jobs: make(map[string]*Job), runs: make(map[string]*run), changed: make(map[string]chan struct{})}
+}
+
+// ErrInvalidCallback is the error of the calls with a callback URL refused
+// by Jobs.AllowCallback.
+var ErrInvalidCallback = errors.New("rpcjobs: invalid callback URL")
+
+// ErrTooManyJobs is the error of the asynchronous calls beyond the MaxRunning
+// jobs.
+var ErrTooManyJobs = errors.New("rpcjobs: too many running jobs")
+
+// ErrNoJob and ErrCompleted are the errors of Cancel for unknown and
+// completed jobs.
+var (
+	ErrNoJob     = errors.New("rpcjobs: no such job")
+	ErrCompleted = errors.New("rpcjobs: job already completed")
+)
+
+// Middleware returns the middleware running the calls preferring it
+// asynchronously, replying with their Job.
+func (j *Jobs) Middleware() rpcserver.Middleware {
+	return func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			if !respondAsync(call.Request) {
+				return next(ctx, call)
+			}
+			callback := call.Request.Header.Get(rpcserver.CallbackHeader)
+			if callback != "" && !j.allowCallback(callback) {
+				return jsonrpc2.NewError(jsonrpc2.E_BAD_PARAMS, ErrInvalidCallback.Error(), callback)
+			}
+			var id [16]byte
+			if _, err := rand.Read(id[:]); err != nil {
+				return err
+			}
+			clock := rpcserver.ClockOrSystem(j.Clock)
+			job := &Job{
+				ID:       hex.EncodeToString(id[:]),
+				Method:   call.Method,
+				Status:   Running,
+				Created:  clock.Now(),
+				Callback: callback,
+			}
+			if callback != "" {
+				job.Delivery = &Delivery{Status: DeliveryPending}
+			}
+			if j.Queue != nil {
+				return j.enqueue(ctx, call, job)
+			}
+			release, ok := j.acquire()
+			if !ok {
+				return ErrTooManyJobs
+			}
+			async := *call
+			runCtx, cancel := j.begin(ctx, job)
+			go func() {
+				defer release()
+				defer cancel()
+				err := next(runCtx, &async)
+				j.complete(job.ID, async.Reply, err)
+				if callback != "" {
+					j.Deliver(context.Background(), job.ID)
+				}
+			}()
+			call.Reply = j.snapshot(job)
+			return nil
+		}
+	}
+}
+
+// respondAsync tells if the request prefers an asynchronous response, with a
+// respond-async preference of RFC 7240.
+func respondAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			if idx := strings.Index(preference, ";"); idx != -1 {
+				preference = preference[:idx]
+			}
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (j *Jobs) allowCallback(callback string) bool {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return false
+	}
+	if j.AllowCallback != nil {
+		return j.AllowCallback(u)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && j.Client == nil && internalIP(ip) {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// acquire takes the slot of a running job, returning the function releasing
+// it, or false beyond the MaxRunning jobs.
+func (j *Jobs) acquire() (func(), bool) {
+	j.mu.Lock()
+	if j.slots == nil {
+		max := j.MaxRunning
+		if max <= 0 {
+			max = defaultMaxRunning
+		}
+		j.slots = make(chan struct{}, max)
+	}
+	slots := j.slots
+	j.mu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// begin remembers a running job, and returns the context of its call
+// detached from ctx.
+func (j *Jobs) begin(ctx context.Context, job *Job) (context.Context, context.CancelFunc) {
+	running := &run{jobs: j, id: job.ID, started: job.Created}
+	runCtx, cancel := context.WithCancel(context.WithValue(context.WithoutCancel(ctx), runKey{}, running))
+	running.cancel = cancel
+	timeout := j.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	if timeout > 0 {
+		runCtx, cancel = rpcserver.WithClockTimeout(runCtx, rpcserver.ClockOrSystem(j.Clock), timeout)
+	}
+	j.add(job, running)
+	return runCtx, cancel
+}
+
+// add remembers a new job.
+func (j *Jobs) add(job *Job, running *run) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.jobs == nil {
+		j.jobs, j.runs, j.changed = make(map[string]*Job), make(map[string]*run), make(map[string]chan struct{})
+	}
+	j.jobs[job.ID] = job
+	j.runs[job.ID] = running
+	j.changed[job.ID] = make(chan struct{})
+}
+
+// notify wakes up the watchers of the job of the id, with j.mu held.
+func (j *Jobs) notify(id string) {
+	if changed, ok := j.changed[id]; ok {
+		close(changed)
+		j.changed[id] = make(chan struct{})
+	}
+}
+
+// watch returns the job of the id and a channel closed at its next change, nil
+// once the job is stored, or nil if there is no job.
+func (j *Jobs) watch(ctx context.Context, id string) (*Job, <-chan struct{}, error) {
+	j.mu.Lock()
+	job, ok := j.jobs[id]
+	if ok {
+		defer j.mu.Unlock()
+		return j.copyOf(job), j.changed[id], nil
+	}
+	j.mu.Unlock()
+	job, err := j.store().Load(ctx, id)
+	return job, nil, err
+}
+
+// complete records the outcome of a job and stores it.
+func (j *Jobs) complete(id string, reply interface{}, err error) {
+	var result json.RawMessage
+	if err == nil && reply != nil {
+		var errEncode error
+		if result, errEncode = json.Marshal(reply); errEncode != nil {
+			err = errEncode
+		}
+	}
+	completed := rpcserver.ClockOrSystem(j.Clock).Now()
+	j.mu.Lock()
+	job, running := j.jobs[id], j.runs[id]
+	delete(j.runs, id)
+	job.Completed = &completed
+	switch {
+	case err != nil && running.cancelled:
+		job.Status, job.Result = Cancelled, running.partial
+	case err != nil:
+		job.Status = Failed
+		var rpcErr *jsonrpc2.Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &jsonrpc2.Error{Code: jsonrpc2.E_SERVER, Message: err.Error()}
+		}
+		job.Error = rpcErr
+	default:
+		job.Status, job.Result = Succeeded, result
+	}
+	stored := j.copyOf(job)
+	j.mu.Unlock()
+
+	// The job is remembered until it is stored, then forgotten.
+	j.report(j.save(context.Background(), stored))
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.notify(id)
+	delete(j.jobs, id)
+	delete(j.changed, id)
+}
+
+// save stores a job until the retention passes after its completion, or
+// after now while it is not complete.
+func (j *Jobs) save(ctx context.Context, job *Job) error {
+	retention := j.Retention
+	if retention <= 0 {
+		retention = time.Hour
+	}
+	from := rpcserver.ClockOrSystem(j.Clock).Now()
+	if job.Completed != nil {
+		from = *job.Completed
+	}
+	if err := j.store().Save(ctx, job, from.Add(retention)); err != nil {
+		return fmt.Errorf("rpcjobs: cannot store job %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// report passes a non-nil error to OnError.
+func (j *Jobs) report(err error) {
+	if err != nil && j.OnError != nil {
+		j.OnError(err)
+	}
+}
+
+// store returns the Store of the completed jobs.
+func (j *Jobs) store() Store {
+	if j.Store != nil {
+		return j.Store
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.defaults == nil {
+		j.defaults = &MemoryStore{MaxJobs: defaultMaxJobs, Clock: j.Clock}
+	}
+	return j.defaults
+}
+
+// Cancel cancels the running job of the id: the context of the request of its
+// method is done, and the job is Cancelled once the method returns. The jobs
+// of a Queue run by other instances are cancelled by their worker, at its
+// next heartbeat. It returns ErrNoJob or ErrCompleted when the job is unknown
+// or completed.
+func (j *Jobs) Cancel(id string) error {
+	j.mu.Lock()
+	running, ok := j.runs[id]
+	if ok {
+		running.cancelled = true
+		running.cancel()
+	}
+	_, remembered := j.jobs[id]
+	j.mu.Unlock()
+	switch {
+	case ok:
+		return nil
+	case remembered:
+		return ErrCompleted
+	}
+	job, err := j.store().Load(context.Background(), id)
+	switch {
+	case err != nil:
+		return fmt.Errorf("rpcjobs: %v", err)
+	case job == nil:
+		return ErrNoJob
+	case job.Completed != nil:
+		return ErrCompleted
+	}
+	job.CancelRequested = true
+	return j.save(context.Background(), job)
+}
+
+// Register registers the rpc.cancel builtin of the server, cancelling the
+// job whose id is its only param and replying with the job.
+func (j *Jobs) Register(server *rpcserver.Server) error {
+	return server.RegisterBuiltin("rpc.cancel", builtins{j}, "Cancel")
+}
+
+// builtins holds the builtins of the jobs.
+type builtins struct {
+	jobs *Jobs
+}
+
+// Cancel is the rpc.cancel builtin.
+func (b builtins) Cancel(r *http.Request, id string) (*Job, error) {
+	switch err := b.jobs.Cancel(id); err {
+	case nil:
+		return b.jobs.Get(id), nil
+	case ErrCompleted:
+		return nil, jsonrpc2.NewError(jsonrpc2.E_CONFLICT, err.Error(), b.jobs.Get(id))
+	default:
+		return nil, jsonrpc2.NewError(jsonrpc2.E_BAD_PARAMS, err.Error(), id)
+	}
+}
+
+// snapshot returns a copy of the job.
+func (j *Jobs) snapshot(job *Job) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.copyOf(job)
+}
+
+// copyOf returns a copy of the job, with j.mu held.
+func (j *Jobs) copyOf(job *Job) *Job {
+	copied := *job
+	if job.Delivery != nil {
+		delivery := *job.Delivery
+		copied.Delivery = &delivery
+	}
+	return &copied
+}
+
+// Get returns the job of the id, or nil if there is none or the Store fails.
+func (j *Jobs) Get(id string) *Job {
+	job, _, err := j.watch(context.Background(), id)
+	if err != nil {
+		j.report(fmt.Errorf("rpcjobs: cannot load job %s: %v", id, err))
+		return nil
+	}
+	return job
+}
+
+// ServeHTTP answers GET requests with the Job whose id is the last part of
+// the path, as JSON. Requests accepting text/event-stream get a stream of
+// server-sent events instead, see streamJob.
+func (j *Jobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		rpcserver.WriteError(w, 405, "rpcjobs: GET method required, received "+r.Method)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		j.streamJob(w, r, rpcserver.LastPart(r.URL.Path))
+		return
+	}
+	job, _, err := j.watch(r.Context(), rpcserver.LastPart(r.URL.Path))
+	if err != nil {
+		rpcserver.WriteError(w, 500, "rpcjobs: "+err.Error())
+		return
+	}
+	if job == nil {
+		rpcserver.WriteError(w, 404, "rpcjobs: no job "+rpcserver.LastPart(r.URL.Path))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,645 @@
+package rpcjobs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"github.com/datalinkE/rpcserver/rpcenvelope"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"github.com/datalinkE/rpcserver/rpcsign"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ReportArgs struct {
+	Name string
+}
+
+type Reports struct {
+	release chan struct{}
+}
+
+func (s *Reports) Generate(r *http.Request, args *ReportArgs, reply *string) error {
+	<-s.release
+	if args.Name == "" {
+		return errors.New("no name")
+	}
+	*reply = "report " + args.Name
+	return nil
+}
+
+func (s *Reports) Export(r *http.Request, args *ReportArgs, reply *string) error {
+	if !ReportProgress(r.Context(), 50, "exporting "+args.Name, time.Time{}) {
+		return errors.New("not a job")
+	}
+	<-s.release
+	*reply = "export " + args.Name
+	return nil
+}
+
+func (s *Reports) Scan(r *http.Request, args *ReportArgs, reply *string) error {
+	ReportPartial(r.Context(), "page 1 of "+args.Name)
+	ReportProgress(r.Context(), 50, "scanning", time.Time{})
+	select {
+	case <-s.release:
+		*reply = "scanned " + args.Name
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+// await polls the job until it completes.
+func await(t *testing.T, jobs *Jobs, id string, done func(job *Job) bool) *Job {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if job := jobs.Get(id); job != nil && done(job) {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not complete: %+v", id, jobs.Get(id))
+	return nil
+}
+
+func TestJobs(t *testing.T) {
+	reports := &Reports{release: make(chan struct{})}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	jobs := New()
+	srv.RPC.Use(jobs.Middleware())
+	async := rpcclient.WithHeader("Prefer", "respond-async")
+
+	var job Job
+	srv.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, async)
+	if job.Status != Running || job.ID == "" || job.Method != "Generate" {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	reports.release <- struct{}{}
+	completed := await(t, jobs, job.ID, func(job *Job) bool { return job.Status != Running })
+	if completed.Status != Succeeded || string(completed.Result) != `"report q3"` {
+		t.Errorf("unexpected job %+v", completed)
+	}
+
+	srv.MustCall("Generate", &ReportArgs{}, &job, async)
+	reports.release <- struct{}{}
+	if completed = await(t, jobs, job.ID, func(job *Job) bool { return job.Status != Running }); completed.Status != Failed || completed.Error.Message != "no name" {
+		t.Errorf("unexpected job %+v", completed)
+	}
+
+	w := httptest.NewRecorder()
+	jobs.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"status":"failed"`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	jobs.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/missing", nil))
+	if w.Code != 404 {
+		t.Errorf("expected an unknown job, got %d", w.Code)
+	}
+
+	go func() { reports.release <- struct{}{} }()
+	var result string
+	srv.MustCall("Generate", &ReportArgs{Name: "sync"}, &result)
+	if result != "report sync" {
+		t.Errorf("expected a synchronous call, got %q", result)
+	}
+
+}
+
+func TestMaxRunning(t *testing.T) {
+	reports := &Reports{release: make(chan struct{})}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	jobs := &Jobs{MaxRunning: 1}
+	srv.RPC.Use(jobs.Middleware())
+	async := rpcclient.WithHeader("Prefer", "respond-async")
+
+	var job Job
+	srv.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, async)
+	if err := srv.Call("Generate", &ReportArgs{Name: "q4"}, &job, async); err == nil || !strings.Contains(err.Error(), ErrTooManyJobs.Error()) {
+		t.Errorf("expected too many jobs, got %v", err)
+	}
+	reports.release <- struct{}{}
+	for i := 0; len(jobs.slots) > 0; i++ {
+		if i == 1000 {
+			t.Fatal("expected the slot of the completed job released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	srv.MustCall("Generate", &ReportArgs{Name: "q4"}, &job, async)
+	reports.release <- struct{}{}
+}
+
+func TestCallbacks(t *testing.T) {
+	key := []byte("secret")
+	attempts := 0
+	bodies := make(chan []byte, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := rpcsign.VerifyHMAC(key, body, r.Header.Get(rpcserver.SignatureHeader)); err != nil {
+			t.Error(err)
+		}
+		bodies <- body
+	}))
+	defer hook.Close()
+
+	reports := &Reports{release: make(chan struct{}, 1)}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	jobs := New()
+	jobs.Signer = rpcsign.HMAC(key)
+	jobs.Retry = &rpcclient.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 1}
+	srv.RPC.Use(jobs.Middleware())
+	async := rpcclient.WithHeader("Prefer", "respond-async")
+
+	var job Job
+	for _, callback := range []string{"file:///etc/passwd", hook.URL, "http://169.254.169.254/latest/meta-data"} {
+		if err := srv.Call("Generate", &ReportArgs{Name: "q3"}, &job, async, rpcclient.WithHeader(rpcserver.CallbackHeader, callback)); err == nil {
+			t.Errorf("expected the callback to %s refused", callback)
+		}
+	}
+
+	// The default client does not dial the internal addresses of the names
+	// either.
+	reports.release <- struct{}{}
+	srv.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, async, rpcclient.WithHeader(rpcserver.CallbackHeader, strings.Replace(hook.URL, "127.0.0.1", "localhost", 1)))
+	refused := await(t, jobs, job.ID, func(job *Job) bool { return job.Delivery.Status != DeliveryPending })
+	if refused.Delivery.Status != DeliveryFailed || !strings.Contains(refused.Delivery.LastError, "internal address") {
+		t.Errorf("expected the internal address refused, got %+v", refused.Delivery)
+	}
+
+	// Redirects are not followed.
+	redirect := httptest.NewServer(http.RedirectHandler(hook.URL, 307))
+	defer redirect.Close()
+	jobs.Client = hook.Client()
+	reports.release <- struct{}{}
+	srv.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, async, rpcclient.WithHeader(rpcserver.CallbackHeader, redirect.URL))
+	redirected := await(t, jobs, job.ID, func(job *Job) bool { return job.Delivery.Status != DeliveryPending })
+	if redirected.Delivery.Status != DeliveryFailed || !strings.Contains(redirected.Delivery.LastError, "status 307") {
+		t.Errorf("expected the redirect refused, got %+v", redirected.Delivery)
+	}
+
+	reports.release <- struct{}{}
+	srv.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, async, rpcclient.WithHeader(rpcserver.CallbackHeader, hook.URL))
+	if job.Delivery == nil || job.Delivery.Status != DeliveryPending {
+		t.Errorf("expected a pending delivery, got %+v", job.Delivery)
+	}
+	var posted Job
+	json.Unmarshal(<-bodies, &posted)
+	if posted.ID != job.ID || string(posted.Result) != `"report q3"` {
+		t.Errorf("unexpected callback %+v", posted)
+	}
+	delivered := await(t, jobs, job.ID, func(job *Job) bool { return job.Delivery.Status != DeliveryPending })
+	if delivered.Delivery.Status != DeliverySucceeded || delivered.Delivery.Attempts != 2 || delivered.Delivery.Delivered == nil {
+		t.Errorf("unexpected delivery %+v", delivered.Delivery)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	reports := &Reports{release: make(chan struct{})}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	jobs := New()
+	srv.RPC.Use(jobs.Middleware())
+	var result string
+	if err := srv.Call("Export", &ReportArgs{Name: "q3"}, &result); err == nil || !strings.Contains(err.Error(), "not a job") {
+		t.Errorf("expected no progress outside of jobs, got %v", err)
+	}
+
+	var job Job
+	srv.MustCall("Export", &ReportArgs{Name: "q3"}, &job, rpcclient.WithHeader("Prefer", "respond-async"))
+	reported := await(t, jobs, job.ID, func(job *Job) bool { return job.Progress != nil })
+	if p := reported.Progress; p.Percent != 50 || p.Message != "exporting q3" || p.ETA.Before(p.Updated) {
+		t.Errorf("unexpected progress %+v", p)
+	}
+
+	hs := httptest.NewServer(jobs)
+	defer hs.Close()
+	r, _ := http.NewRequest("GET", hs.URL+"/jobs/"+job.ID, nil)
+	r.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %v", res.StatusCode, res.Header)
+	}
+	events := bufio.NewScanner(res.Body)
+	next := func() Job {
+		var event Job
+		for events.Scan() {
+			if line := events.Text(); strings.HasPrefix(line, "data: ") {
+				json.Unmarshal([]byte(line[6:]), &event)
+				return event
+			}
+		}
+		t.Fatal("the stream ended")
+		return event
+	}
+	if event := next(); event.Status != Running || event.Progress.Percent != 50 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	reports.release <- struct{}{}
+	if event := next(); event.Status != Succeeded || string(event.Result) != `"export q3"` {
+		t.Errorf("unexpected event %+v", event)
+	}
+	for events.Scan() {
+		if events.Text() != "" {
+			t.Errorf("expected the stream to end, got %q", events.Text())
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	reports := &Reports{release: make(chan struct{})}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	jobs := New()
+	srv.RPC.Use(jobs.Middleware())
+	if err := jobs.Register(srv.RPC); err != nil {
+		t.Fatal(err)
+	}
+
+	var job Job
+	srv.MustCall("Scan", &ReportArgs{Name: "q3"}, &job, rpcclient.WithHeader("Prefer", "respond-async"))
+	await(t, jobs, job.ID, func(job *Job) bool { return job.Progress != nil })
+	var cancelling Job
+	srv.MustCall("rpc.cancel", []string{job.ID}, &cancelling)
+	if cancelling.ID != job.ID {
+		t.Errorf("unexpected reply %+v", cancelling)
+	}
+	cancelled := await(t, jobs, job.ID, func(job *Job) bool { return job.Status != Running })
+	if cancelled.Status != Cancelled || string(cancelled.Result) != `"page 1 of q3"` || cancelled.Error != nil {
+		t.Errorf("unexpected job %+v", cancelled)
+	}
+
+	if err := srv.Call("rpc.cancel", []string{job.ID}, &cancelling); err == nil || !strings.Contains(err.Error(), "already completed") {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if err := jobs.Cancel("missing"); err != ErrNoJob {
+		t.Errorf("expected ErrNoJob, got %v", err)
+	}
+	if ReportPartial(context.Background(), "page") {
+		t.Errorf("expected no partial result outside of jobs")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	queue, store := new(MemoryQueue), NewMemoryStore(0)
+	instance := func() (*Reports, *rpcservertest.Server, *Jobs) {
+		reports := &Reports{release: make(chan struct{})}
+		srv := rpcservertest.NewServer(t, reports)
+		jobs := New()
+		jobs.Queue, jobs.Store = queue, store
+		jobs.VisibilityTimeout, jobs.PollInterval = 30*time.Millisecond, time.Millisecond
+		jobs.OnError = func(err error) { t.Error(err) }
+		srv.RPC.Use(jobs.Middleware())
+		return reports, srv, jobs
+	}
+	_, front, frontJobs := instance()
+	defer front.Close()
+	reports, back, backJobs := instance()
+	defer back.Close()
+	async := rpcclient.WithHeader("Prefer", "respond-async")
+
+	var job Job
+	front.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, async)
+	if job.Status != Queued {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	// An instance claims the task and stops before completing it.
+	if claimed, _ := queue.Claim(context.Background(), 30*time.Millisecond); claimed == nil || claimed.Params == nil || claimed.Header.Get("Prefer") != "" {
+		t.Fatalf("unexpected task %+v", claimed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	worked := make(chan error)
+	go func() { worked <- backJobs.Work(ctx, back.RPC, "/rpc/") }()
+	defer func() {
+		cancel()
+		if err := <-worked; err != context.Canceled {
+			t.Errorf("unexpected error %v", err)
+		}
+	}()
+	await(t, frontJobs, job.ID, func(job *Job) bool { return job.Status == Running })
+	reports.release <- struct{}{}
+	completed := await(t, frontJobs, job.ID, func(job *Job) bool { return job.Completed != nil })
+	if completed.Status != Succeeded || string(completed.Result) != `"report q3"` {
+		t.Errorf("unexpected job %+v", completed)
+	}
+
+	front.MustCall("Scan", &ReportArgs{Name: "q4"}, &job, async)
+	await(t, frontJobs, job.ID, func(job *Job) bool { return job.Progress != nil })
+	if err := frontJobs.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	cancelled := await(t, frontJobs, job.ID, func(job *Job) bool { return job.Completed != nil })
+	if cancelled.Status != Cancelled || string(cancelled.Result) != `"page 1 of q4"` {
+		t.Errorf("unexpected job %+v", cancelled)
+	}
+	if task, _ := queue.Claim(context.Background(), time.Minute); task != nil {
+		t.Errorf("expected the tasks to be acknowledged, got %+v", task)
+	}
+}
+
+func testStore(t *testing.T, store Store, clock *rpcservertest.FakeClock) {
+	reports := &Reports{release: make(chan struct{})}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	jobs := New()
+	jobs.Store, jobs.Clock = store, clock
+	jobs.OnError = func(err error) { t.Error(err) }
+	srv.RPC.Use(jobs.Middleware())
+
+	var job Job
+	srv.MustCall("Generate", &ReportArgs{Name: "q3"}, &job, rpcclient.WithHeader("Prefer", "respond-async"))
+	reports.release <- struct{}{}
+	completed := await(t, jobs, job.ID, func(job *Job) bool { return job.Status != Running })
+	if completed.Status != Succeeded || string(completed.Result) != `"report q3"` {
+		t.Errorf("unexpected job %+v", completed)
+	}
+	if stored, err := store.Load(context.Background(), job.ID); err != nil || stored == nil || stored.Status != Succeeded {
+		t.Errorf("expected the job to be stored, got %+v, %v", stored, err)
+	}
+	if err := jobs.Cancel(job.ID); err != ErrCompleted {
+		t.Errorf("expected ErrCompleted, got %v", err)
+	}
+	clock.Advance(time.Hour)
+	if expired := jobs.Get(job.ID); expired != nil {
+		t.Errorf("expected the job to expire, got %+v", expired)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	clock := rpcservertest.NewFakeClock(time.Now())
+	testStore(t, &MemoryStore{Clock: clock}, clock)
+
+	store := NewMemoryStore(2)
+	for i := 1; i <= 3; i++ {
+		store.Save(context.Background(), &Job{ID: fmt.Sprint(i)}, time.Now().Add(time.Hour))
+	}
+	store.Save(context.Background(), &Job{ID: "3", Status: Succeeded}, time.Now().Add(time.Hour))
+	if job, _ := store.Load(context.Background(), "1"); job != nil {
+		t.Errorf("expected the oldest job to be forgotten")
+	}
+	if job, _ := store.Load(context.Background(), "3"); job == nil || job.Status != Succeeded {
+		t.Errorf("unexpected job %+v", job)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	clock := rpcservertest.NewFakeClock(time.Now())
+	var mu sync.Mutex
+	values := make(map[string][]byte)
+	expires := make(map[string]time.Time)
+	testStore(t, &RedisStore{
+		Get: func(ctx context.Context, key string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !expires[key].After(clock.Now()) {
+				return nil, nil
+			}
+			return values[key], nil
+		},
+		Set: func(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !strings.HasPrefix(key, "rpc:job:") {
+				t.Errorf("unexpected key %s", key)
+			}
+			values[key], expires[key] = value, clock.Now().Add(ttl)
+			return nil
+		},
+		Clock: clock,
+	}, clock)
+}
+
+func TestSQLStore(t *testing.T) {
+	db := sql.OpenDB(fakeDriver{rows: make(map[string]fakeRow)})
+	defer db.Close()
+	clock := rpcservertest.NewFakeClock(time.Now())
+	store := &SQLStore{DB: db, Clock: clock}
+	if err := store.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store, clock)
+}
+
+func TestSealedSQLStore(t *testing.T) {
+	rows := make(map[string]fakeRow)
+	db := sql.OpenDB(fakeDriver{rows: rows})
+	defer db.Close()
+	clock := rpcservertest.NewFakeClock(time.Now())
+	keys := rpcenvelope.NewKeyring()
+	keys.Add("k1", make([]byte, 32))
+	store := &SQLStore{DB: db, Clock: clock, Envelope: &rpcenvelope.Envelope{Keys: keys}}
+	testStore(t, store, clock)
+
+	ctx := context.Background()
+	store.Save(ctx, &Job{ID: "sealed", Result: json.RawMessage(`"secret"`)}, clock.Now().Add(time.Hour))
+	fakeMu.Lock()
+	stored := rows["sealed"].job
+	rows["plain"] = fakeRow{`{"id":"plain","status":"succeeded"}`, clock.Now().Add(time.Hour).UnixNano()}
+	fakeMu.Unlock()
+	if !rpcenvelope.IsSealed([]byte(stored)) || strings.Contains(stored, "secret") {
+		t.Errorf("expected the job sealed, got %s", stored)
+	}
+
+	if _, err := store.Load(ctx, "plain"); err != nil {
+		t.Errorf("expected the job stored before encrypting them opened, got %v", err)
+	}
+	strict := &SQLStore{DB: db, Clock: clock, Envelope: &rpcenvelope.Envelope{Keys: keys, RequireSealed: true}}
+	if _, err := strict.Load(ctx, "plain"); err != rpcenvelope.ErrNotSealed {
+		t.Errorf("expected ErrNotSealed, got %v", err)
+	}
+	fakeMu.Lock()
+	swapped := rows["sealed"]
+	rows["swapped"] = swapped
+	fakeMu.Unlock()
+	if _, err := store.Load(ctx, "swapped"); err == nil {
+		t.Errorf("expected the job of another id not to open")
+	}
+	fakeMu.Lock()
+	delete(rows, "swapped")
+	fakeMu.Unlock()
+
+	// A job saved while the jobs are rewrapped is left as is.
+	keys.Add("k2", bytes.Repeat([]byte{1}, 32))
+	store.Save(ctx, &Job{ID: "saved"}, clock.Now().Add(time.Hour))
+	var saved string
+	db2 := sql.OpenDB(fakeDriver{rows: rows, onRewrap: func(id string) {
+		if id == "saved" {
+			row := rows[id]
+			row.job, saved = `{"id":"saved","status":"failed"}`, row.job
+			rows[id] = row
+		}
+	}})
+	defer db2.Close()
+	rewrapping := &SQLStore{DB: db2, Clock: clock, Envelope: store.Envelope}
+	if n, err := rewrapping.Rewrap(ctx); err != nil || n != 2 {
+		t.Fatalf("expected the jobs rewrapped, got %d, %v", n, err)
+	}
+	fakeMu.Lock()
+	if job := rows["saved"].job; job != `{"id":"saved","status":"failed"}` || saved == "" {
+		t.Errorf("expected the job saved meanwhile left as is, got %s", job)
+	}
+	delete(rows, "saved")
+	fakeMu.Unlock()
+	keys.Remove("k1")
+	for _, id := range []string{"sealed", "plain"} {
+		if job, err := store.Load(ctx, id); err != nil || job == nil || job.ID != id {
+			t.Errorf("expected job %s opened with the rotated key, got %+v, %v", id, job, err)
+		}
+	}
+	fakeMu.Lock()
+	plain := rows["plain"].job
+	fakeMu.Unlock()
+	if !strings.Contains(plain, `"kid":"k2"`) {
+		t.Errorf("expected the job sealed with k2, got %s", plain)
+	}
+}
+
+// fakeDriver runs the statements of SQLStore on a map, calling onRewrap
+// with fakeMu held before the rows are rewrapped, when set.
+type fakeDriver struct {
+	rows     map[string]fakeRow
+	onRewrap func(id string)
+}
+
+type fakeRow struct {
+	job     string
+	expires int64
+}
+
+var fakeMu sync.Mutex
+
+func (d fakeDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return fakeConn(d), nil
+}
+
+func (d fakeDriver) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn fakeDriver
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{fakeDriver(c), query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake: no transactions")
+}
+
+type fakeStmt struct {
+	fakeDriver
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.rows[args[0].(string)] = fakeRow{args[1].(string), args[2].(int64)}
+	case strings.HasPrefix(s.query, "UPDATE") && strings.Contains(s.query, "AND job = ?"):
+		id := args[1].(string)
+		if s.onRewrap != nil {
+			s.onRewrap(id)
+		}
+		row, ok := s.rows[id]
+		if !ok || row.job != args[2].(string) {
+			return driver.RowsAffected(0), nil
+		}
+		row.job = args[0].(string)
+		s.rows[id] = row
+	case strings.HasPrefix(s.query, "UPDATE"):
+		if _, ok := s.rows[args[2].(string)]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		s.rows[args[2].(string)] = fakeRow{args[0].(string), args[1].(int64)}
+	case strings.HasPrefix(s.query, "DELETE"):
+		for id, row := range s.rows {
+			if row.expires <= args[0].(int64) {
+				delete(s.rows, id)
+			}
+		}
+	default:
+		return nil, errors.New("fake: unexpected statement " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	if strings.HasPrefix(s.query, "SELECT job_id") {
+		rows := &fakeRows{columns: []string{"job_id", "job"}}
+		for id, row := range s.rows {
+			if row.expires > args[0].(int64) {
+				rows.values = append(rows.values, []driver.Value{[]byte(id), []byte(row.job)})
+			}
+		}
+		return rows, nil
+	}
+	rows := &fakeRows{columns: []string{"job"}}
+	if row, ok := s.rows[args[0].(string)]; ok && row.expires > args[1].(int64) {
+		rows.values = [][]driver.Value{{[]byte(row.job)}}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
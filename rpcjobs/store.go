@@ -0,0 +1,343 @@
+package rpcjobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/internal/sqlutil"
+	"github.com/datalinkE/rpcserver/rpcenvelope"
+	"sync"
+	"time"
+)
+
+// Store keeps the completed jobs until they expire.
+type Store interface {
+	// Save stores the job until expires, replacing the stored job of its ID.
+	Save(ctx context.Context, job *Job, expires time.Time) error
+
+	// Load returns the stored job of the id, or nil if there is none or it
+	// expired.
+	Load(ctx context.Context, id string) (*Job, error)
+}
+
+// MemoryStore is a Store of a single process, its jobs are lost on restart.
+type MemoryStore struct {
+	// MaxJobs limits the number of jobs kept when positive, the oldest ones
+	// are forgotten first.
+	MaxJobs int
+
+	// Clock expires the jobs, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu     sync.Mutex
+	jobs   map[string]memoryJob
+	order  []string  // the ids in the order they were stored
+	pruned time.Time // last removal of the expired jobs
+}
+
+type memoryJob struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryStore creates a MemoryStore of at most maxJobs jobs, or of any
+// number if maxJobs is zero.
+func NewMemoryStore(maxJobs int) *MemoryStore {
+	return &MemoryStore{MaxJobs: maxJobs}
+}
+
+// Save stores the job until expires.
+func (s *MemoryStore) Save(ctx context.Context, job *Job, expires time.Time) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]memoryJob)
+	}
+	if now.Sub(s.pruned) >= time.Minute {
+		for id, stored := range s.jobs {
+			if !stored.expires.After(now) {
+				delete(s.jobs, id)
+			}
+		}
+		s.compact()
+		s.pruned = now
+	}
+	if _, ok := s.jobs[job.ID]; !ok {
+		for s.MaxJobs > 0 && len(s.jobs) >= s.MaxJobs {
+			delete(s.jobs, s.order[0])
+			s.order = s.order[1:]
+		}
+		s.order = append(s.order, job.ID)
+	}
+	s.jobs[job.ID] = memoryJob{data, expires}
+	return nil
+}
+
+// compact drops the ids of the forgotten jobs from the order, with s.mu held.
+func (s *MemoryStore) compact() {
+	order := s.order[:0]
+	for _, id := range s.order {
+		if _, ok := s.jobs[id]; ok {
+			order = append(order, id)
+		}
+	}
+	s.order = order
+}
+
+// Load returns the stored job of the id.
+func (s *MemoryStore) Load(ctx context.Context, id string) (*Job, error) {
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	s.mu.Lock()
+	stored, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok || !stored.expires.After(now) {
+		return nil, nil
+	}
+	job := new(Job)
+	if err := json.Unmarshal(stored.data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// RedisStore is a Store in Redis. Get and Set run the GET and SET key value
+// PX ttl commands of a client, e.g. with github.com/redis/go-redis:
+//
+//	store := &rpcjobs.RedisStore{
+//		Get: func(ctx context.Context, key string) ([]byte, error) {
+//			value, err := rdb.Get(ctx, key).Bytes()
+//			if err == redis.Nil {
+//				return nil, nil
+//			}
+//			return value, err
+//		},
+//		Set: func(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+//			return rdb.Set(ctx, key, value, ttl).Err()
+//		},
+//	}
+//
+// Get returns a nil value for missing keys. Redis expires the jobs, and its
+// maxmemory policy bounds their number.
+type RedisStore struct {
+	Get func(ctx context.Context, key string) ([]byte, error)
+	Set func(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Prefix is prepended to the job ids to make the Redis keys, "rpc:job:"
+	// when empty.
+	Prefix string
+
+	// Clock computes the time to live of the jobs, rpcserver.SystemClock
+	// when nil.
+	Clock rpcserver.Clock
+
+	// Envelope encrypts the stored jobs when set, bound to their id. The
+	// keys of the store can't be listed to rewrap the jobs: the key
+	// encryption keys rotated out are retired once the Retention of the
+	// Jobs passes, every job sealed with them being expired.
+	Envelope *rpcenvelope.Envelope
+}
+
+func (s *RedisStore) key(id string) string {
+	if s.Prefix == "" {
+		return "rpc:job:" + id
+	}
+	return s.Prefix + id
+}
+
+// Save stores the job until expires.
+func (s *RedisStore) Save(ctx context.Context, job *Job, expires time.Time) error {
+	data, err := marshal(ctx, s.Envelope, job, job.ID)
+	if err != nil {
+		return err
+	}
+	ttl := expires.Sub(rpcserver.ClockOrSystem(s.Clock).Now())
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return s.Set(ctx, s.key(job.ID), data, ttl)
+}
+
+// Load returns the stored job of the id.
+func (s *RedisStore) Load(ctx context.Context, id string) (*Job, error) {
+	data, err := s.Get(ctx, s.key(id))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	job := new(Job)
+	if err := unmarshal(ctx, s.Envelope, data, job, id); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// SQLStore is a Store in an SQL database, in a table made by CreateTable:
+//
+//	CREATE TABLE rpc_jobs (
+//		job_id VARCHAR(64) PRIMARY KEY,
+//		job TEXT NOT NULL,
+//		expires BIGINT NOT NULL
+//	)
+//
+// expires is in Unix nanoseconds. The expired jobs are deleted by Save, at
+// most once a minute. The jobs stored with an Envelope are encrypted, Rewrap
+// rotates their keys.
+type SQLStore struct {
+	DB *sql.DB
+
+	// Table is the name of the table, "rpc_jobs" when empty.
+	Table string
+
+	// Numbered uses the $1, $2... placeholders of PostgreSQL, rather than ?.
+	Numbered bool
+
+	// Clock expires the jobs, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	// Envelope encrypts the stored jobs when set, bound to their id.
+	Envelope *rpcenvelope.Envelope
+
+	mu     sync.Mutex
+	pruned time.Time // last removal of the expired jobs
+}
+
+func (s *SQLStore) table() string {
+	if s.Table == "" {
+		return "rpc_jobs"
+	}
+	return s.Table
+}
+
+// query formats the statement, replacing its ? placeholders when Numbered.
+func (s *SQLStore) query(format string) string {
+	return sqlutil.Query(format, s.table(), s.Numbered)
+}
+
+// CreateTable creates the table unless it exists.
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, s.query("CREATE TABLE IF NOT EXISTS %s (job_id VARCHAR(64) PRIMARY KEY, job TEXT NOT NULL, expires BIGINT NOT NULL)"))
+	return err
+}
+
+// Save stores the job until expires.
+func (s *SQLStore) Save(ctx context.Context, job *Job, expires time.Time) error {
+	data, err := marshal(ctx, s.Envelope, job, job.ID)
+	if err != nil {
+		return err
+	}
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	s.mu.Lock()
+	prune := now.Sub(s.pruned) >= time.Minute
+	if prune {
+		s.pruned = now
+	}
+	s.mu.Unlock()
+	if prune {
+		if _, err := s.DB.ExecContext(ctx, s.query("DELETE FROM %s WHERE expires <= ?"), now.UnixNano()); err != nil {
+			return err
+		}
+	}
+	res, err := s.DB.ExecContext(ctx, s.query("UPDATE %s SET job = ?, expires = ? WHERE job_id = ?"), string(data), expires.UnixNano(), job.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, s.query("INSERT INTO %s (job_id, job, expires) VALUES (?, ?, ?)"), job.ID, string(data), expires.UnixNano())
+	return err
+}
+
+// Load returns the stored job of the id.
+func (s *SQLStore) Load(ctx context.Context, id string) (*Job, error) {
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	var data string
+	err := s.DB.QueryRowContext(ctx, s.query("SELECT job FROM %s WHERE job_id = ? AND expires > ?"), id, now.UnixNano()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job := new(Job)
+	if err := unmarshal(ctx, s.Envelope, []byte(data), job, id); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Rewrap wraps the data keys of the stored jobs with the current key of the
+// Envelope, and encrypts the jobs stored without, so the keys rotated out may
+// be retired. The jobs saved meanwhile are left as is, sealed with the current
+// key. It returns the number of jobs updated.
+func (s *SQLStore) Rewrap(ctx context.Context) (int, error) {
+	if s.Envelope == nil {
+		return 0, nil
+	}
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	rows, err := s.DB.QueryContext(ctx, s.query("SELECT job_id, job FROM %s WHERE expires > ?"), now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	stored := make(map[string][]byte)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stored[id] = []byte(data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for id, data := range stored {
+		var rewrapped []byte
+		if rpcenvelope.IsSealed(data) {
+			rewrapped, err = s.Envelope.Rewrap(ctx, data)
+		} else {
+			rewrapped, err = s.Envelope.Seal(ctx, data, []byte(id))
+		}
+		if err != nil {
+			return n, err
+		}
+		res, err := s.DB.ExecContext(ctx, s.query("UPDATE %s SET job = ? WHERE job_id = ? AND job = ?"), string(rewrapped), id, string(data))
+		if err != nil {
+			return n, err
+		}
+		if updated, err := res.RowsAffected(); err != nil {
+			return n, err
+		} else if updated > 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// marshal returns the JSON of v, sealed by the envelope when set, bound to the
+// key of its record.
+func marshal(ctx context.Context, envelope *rpcenvelope.Envelope, v interface{}, recordKey string) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || envelope == nil {
+		return data, err
+	}
+	return envelope.Seal(ctx, data, []byte(recordKey))
+}
+
+// unmarshal decodes the JSON of data into v, opened by the envelope when set
+// with the key of its record.
+func unmarshal(ctx context.Context, envelope *rpcenvelope.Envelope, data []byte, v interface{}, recordKey string) error {
+	if envelope != nil {
+		var err error
+		if data, err = envelope.Open(ctx, data, []byte(recordKey)); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
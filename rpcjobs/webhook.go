@@ -0,0 +1,179 @@
+package rpcjobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// DeliveryStatus is the status of the callback of a job.
+type DeliveryStatus string
+
+const (
+	// DeliveryPending callbacks wait for their job to complete, or for
+	// another attempt.
+	DeliveryPending DeliveryStatus = "pending"
+
+	// DeliverySucceeded callbacks were answered with a 2xx status.
+	DeliverySucceeded DeliveryStatus = "delivered"
+
+	// DeliveryFailed callbacks failed every attempt.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Delivery describes the posts of a job to its callback URL.
+type Delivery struct {
+	Status    DeliveryStatus `json:"status"`
+	Attempts  int            `json:"attempts"`
+	LastError string         `json:"lastError,omitempty"`
+	Delivered *time.Time     `json:"delivered,omitempty"`
+}
+
+// defaultRetry paces the callbacks when Jobs.Retry is nil.
+var defaultRetry = &rpcclient.RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Deliver posts the completed job of the id to its callback URL as JSON,
+// attempting again with backoff until it is answered with a 2xx status or
+// the attempts are exhausted. The Delivery of the job tells the outcome. Jobs
+// deliver themselves on completion, Deliver posts them once more.
+func (j *Jobs) Deliver(ctx context.Context, id string) error {
+	job := j.Get(id)
+	if job == nil || job.Callback == "" {
+		return fmt.Errorf("rpcjobs: no callback for job %s", id)
+	}
+	job.Delivery = nil
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	var signature string
+	if j.Signer != nil {
+		if signature, err = j.Signer.Sign(body); err != nil {
+			return fmt.Errorf("rpcjobs: %v", err)
+		}
+	}
+	retry := j.Retry
+	if retry == nil {
+		retry = defaultRetry
+	}
+	clock := rpcserver.ClockOrSystem(j.Clock)
+	for attempt := 1; ; attempt++ {
+		err := j.post(ctx, job.Callback, body, signature)
+		j.recordDelivery(id, attempt, err, attempt >= retry.MaxAttempts)
+		if err == nil || attempt >= retry.MaxAttempts {
+			return err
+		}
+		timer := clock.NewTimer(retry.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// post performs an attempt of a callback.
+func (j *Jobs) post(ctx context.Context, callback string, body []byte, signature string) error {
+	r, err := http.NewRequestWithContext(ctx, "POST", callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		r.Header.Set(rpcserver.SignatureHeader, signature)
+	}
+	client := callbackClient
+	if j.Client != nil {
+		copied := *j.Client
+		copied.CheckRedirect = refuseRedirect
+		client = &copied
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("callback answered with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// callbackClient posts the callbacks when Jobs.Client is nil.
+var callbackClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: refuseInternal}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: refuseRedirect,
+	Timeout:       time.Minute,
+}
+
+// refuseRedirect has the redirects of the callbacks answered as is, failing
+// the attempt.
+func refuseRedirect(r *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// refuseInternal refuses to dial the internal addresses, once the host of the
+// callback is resolved.
+func refuseInternal(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return fmt.Errorf("rpcjobs: callback to the internal address %s refused", host)
+	}
+	return nil
+}
+
+// internalIP tells if the ip is a loopback, private, link-local or
+// unspecified address.
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// recordDelivery records the outcome of an attempt of the callback of a
+// stored job.
+func (j *Jobs) recordDelivery(id string, attempt int, err error, last bool) {
+	now := rpcserver.ClockOrSystem(j.Clock).Now()
+	job, errLoad := j.store().Load(context.Background(), id)
+	if errLoad != nil {
+		j.report(fmt.Errorf("rpcjobs: cannot load job %s: %v", id, errLoad))
+	}
+	if job == nil {
+		return
+	}
+	delivery := &Delivery{Status: DeliveryPending, Attempts: attempt}
+	switch {
+	case err == nil:
+		delivery.Status, delivery.Delivered = DeliverySucceeded, &now
+	case last:
+		delivery.Status, delivery.LastError = DeliveryFailed, err.Error()
+	default:
+		delivery.LastError = err.Error()
+	}
+	job.Delivery = delivery
+	j.report(j.save(context.Background(), job))
+}
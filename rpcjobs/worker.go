@@ -0,0 +1,202 @@
+package rpcjobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"net/http"
+	"time"
+)
+
+// droppedHeaders are the headers of a call not forwarded to its task: the
+// ones of the asynchronous call itself, of its body and of its deadline.
+var droppedHeaders = []string{
+	"Prefer",
+	"Content-Length",
+	"Content-Type",
+	"Content-Encoding",
+	"Accept-Encoding",
+	rpcserver.CallbackHeader,
+	rpcserver.DeadlineHeader,
+	rpcserver.IdempotencyKeyHeader,
+	rpcserver.SignatureHeader,
+	rpcserver.TimestampHeader,
+}
+
+// enqueue stores the job of the call as Queued and pushes its task.
+func (j *Jobs) enqueue(ctx context.Context, call *rpcserver.Call, job *Job) error {
+	params, err := json.Marshal(call.Args)
+	if err != nil {
+		return fmt.Errorf("rpcjobs: %v", err)
+	}
+	header := call.Request.Header.Clone()
+	for _, key := range droppedHeaders {
+		header.Del(key)
+	}
+	job.Status = Queued
+	if err := j.save(ctx, job); err != nil {
+		return err
+	}
+	task := &Task{ID: job.ID, Method: call.Method, Params: params, Header: header}
+	if err := j.Queue.Push(ctx, task); err != nil {
+		return fmt.Errorf("rpcjobs: cannot queue job %s: %v", job.ID, err)
+	}
+	call.Reply = job
+	return nil
+}
+
+func (j *Jobs) visibility() time.Duration {
+	if j.VisibilityTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return j.VisibilityTimeout
+}
+
+func (j *Jobs) pollInterval() time.Duration {
+	if j.PollInterval <= 0 {
+		return time.Second
+	}
+	return j.PollInterval
+}
+
+// Work claims the tasks of the Queue and runs them, calling their methods
+// with handler, usually an rpcserver.Server with the JSON-RPC 2.0 codec, at
+// path followed by the method name, e.g. "/rpc/". It runs a task at a time, start several workers
+// to run more. Work returns the error of ctx once it is done and the task in
+// progress completed.
+func (j *Jobs) Work(ctx context.Context, handler http.Handler, path string) error {
+	clock := rpcserver.ClockOrSystem(j.Clock)
+	for {
+		task, err := j.Queue.Claim(ctx, j.visibility())
+		if err != nil && ctx.Err() == nil {
+			j.report(fmt.Errorf("rpcjobs: cannot claim a task: %v", err))
+		}
+		if task != nil {
+			j.process(handler, path, task)
+			continue
+		}
+		timer := clock.NewTimer(j.pollInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// process runs a claimed task.
+func (j *Jobs) process(handler http.Handler, path string, task *Task) {
+	ctx := context.Background()
+	job, err := j.store().Load(ctx, task.ID)
+	if err != nil {
+		// The task is claimed again once its claim expires.
+		j.report(fmt.Errorf("rpcjobs: cannot load job %s: %v", task.ID, err))
+		return
+	}
+	if job == nil || job.Completed != nil {
+		j.ack(task)
+		return
+	}
+	maxAttempts := j.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	job.Status = Running
+	runCtx, cancel := j.begin(ctx, job)
+	defer cancel()
+	switch {
+	case job.CancelRequested:
+		j.Cancel(job.ID)
+		j.complete(job.ID, nil, context.Canceled)
+	case task.Attempts > maxAttempts:
+		j.complete(job.ID, nil, fmt.Errorf("rpcjobs: job abandoned after %d attempts", maxAttempts))
+	default:
+		j.report(j.save(ctx, j.snapshot(job)))
+		stop := j.heartbeat(task)
+		reply, err := j.call(runCtx, handler, path, task)
+		stop()
+		j.complete(job.ID, reply, err)
+	}
+	j.ack(task)
+	if job.Callback != "" {
+		j.Deliver(ctx, job.ID)
+	}
+}
+
+func (j *Jobs) ack(task *Task) {
+	if err := j.Queue.Ack(context.Background(), task); err != nil {
+		j.report(fmt.Errorf("rpcjobs: cannot acknowledge job %s: %v", task.ID, err))
+	}
+}
+
+// heartbeat extends the claim of a running task every third of the
+// visibility timeout, shares the job with the other instances, and cancels
+// it when requested. The returned function stops the heartbeat.
+func (j *Jobs) heartbeat(task *Task) (stop func()) {
+	clock := rpcserver.ClockOrSystem(j.Clock)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			timer := clock.NewTimer(j.visibility() / 3)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+			ctx := context.Background()
+			if err := j.Queue.Extend(ctx, task, j.visibility()); err != nil {
+				j.report(fmt.Errorf("rpcjobs: cannot extend job %s: %v", task.ID, err))
+			}
+			stored, err := j.store().Load(ctx, task.ID)
+			if err != nil {
+				j.report(fmt.Errorf("rpcjobs: cannot load job %s: %v", task.ID, err))
+				continue
+			}
+			if stored != nil && stored.CancelRequested {
+				j.Cancel(task.ID)
+			}
+			if job, _, _ := j.watch(ctx, task.ID); job != nil && job.Completed == nil {
+				job.CancelRequested = stored != nil && stored.CancelRequested
+				j.report(j.save(ctx, job))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// call calls the method of a task through handler.
+func (j *Jobs) call(ctx context.Context, handler http.Handler, path string, task *Task) (interface{}, error) {
+	header := make(http.Header, len(task.Header)+2)
+	for key, values := range task.Header {
+		header[key] = values
+	}
+	header.Set(rpcserver.IdempotencyKeyHeader, "job-"+task.ID)
+	header.Set(rpcserver.RequestIDHeader, fmt.Sprintf("job-%s-%d", task.ID, task.Attempts))
+	status, body, err := rpcserver.ServeCall(ctx, handler, path, task.Method, rpcserver.CallBody(task.Method, task.Params, task.Attempts), header)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *jsonrpc2.Error `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("rpcjobs: status %d", status)
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if len(res.Result) == 0 || string(res.Result) == "null" {
+		return nil, nil
+	}
+	return res.Result, nil
+}
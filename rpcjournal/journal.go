@@ -0,0 +1,239 @@
+// Package rpcjournal records the mutating calls of an rpcserver.Server to an
+// append-only journal, a write-ahead log of their method, args and outcome,
+// so the state they changed can be audited, recovered and replayed:
+//
+//	storage, err := rpcjournal.OpenFile("calls.journal")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	journal, err := rpcjournal.New(storage, "Deposit", "Withdraw")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	server.Use(journal.Middleware())
+//
+// A call is journaled before the method runs and its outcome before the reply
+// is written, a call failing to be journaled is not run, nor acknowledged.
+package rpcjournal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EntryType tells what an Entry records.
+type EntryType string
+
+const (
+	// EntryCall records a call before the method runs.
+	EntryCall EntryType = "call"
+
+	// EntryOutcome records the reply or the error of the call of the same
+	// Seq, before the reply is written.
+	EntryOutcome EntryType = "outcome"
+)
+
+// Entry is a record of the journal.
+type Entry struct {
+	// Seq numbers the calls of the journal from 1, the outcome of a call
+	// has its Seq.
+	Seq  uint64    `json:"seq"`
+	Type EntryType `json:"type"`
+	Time time.Time `json:"time"`
+
+	Method string `json:"method"`
+
+	// Args, RequestID, IdempotencyKey and Caller are set on calls, the
+	// latter being the address of the client.
+	Args           json.RawMessage `json:"args,omitempty"`
+	RequestID      string          `json:"requestId,omitempty"`
+	IdempotencyKey string          `json:"idempotencyKey,omitempty"`
+	Caller         string          `json:"caller,omitempty"`
+
+	// Reply or Error are set on outcomes.
+	Reply json.RawMessage `json:"reply,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Storage is the backend of a journal. Append must persist the entry before
+// returning, it is never called concurrently.
+type Storage interface {
+	Append(entry Entry) error
+
+	// Entries returns the entries appended, in order.
+	Entries() ([]Entry, error)
+}
+
+// Journal records the calls of some methods to a Storage.
+type Journal struct {
+	// Clock times the entries, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	storage Storage
+	methods map[string]bool // all when empty
+
+	mu  sync.Mutex // serializes appends
+	seq uint64
+}
+
+// New creates a Journal recording the calls of the methods to the storage, of
+// every method when none is given. Sequence numbers resume after the ones of
+// the entries of the storage.
+func New(storage Storage, methods ...string) (*Journal, error) {
+	entries, err := storage.Entries()
+	if err != nil {
+		return nil, fmt.Errorf("rpcjournal: %v", err)
+	}
+	j := &Journal{storage: storage, methods: make(map[string]bool, len(methods))}
+	for _, method := range methods {
+		j.methods[method] = true
+	}
+	for _, entry := range entries {
+		if entry.Seq > j.seq {
+			j.seq = entry.Seq
+		}
+	}
+	return j, nil
+}
+
+// Middleware returns the middleware journaling the calls.
+func (j *Journal) Middleware() rpcserver.Middleware {
+	return func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			if len(j.methods) > 0 && !j.methods[call.Method] || ctx.Value(replayKey{}) != nil {
+				return next(ctx, call)
+			}
+			args, err := json.Marshal(call.Args)
+			if err != nil {
+				return fmt.Errorf("rpcjournal: cannot record the args of %s: %v", call.Method, err)
+			}
+			entry := Entry{
+				Type:           EntryCall,
+				Method:         call.Method,
+				Args:           args,
+				RequestID:      call.Request.Header.Get(rpcserver.RequestIDHeader),
+				IdempotencyKey: call.Request.Header.Get(rpcserver.IdempotencyKeyHeader),
+			}
+			if caller, ok := rpcserver.CallerFromContext(ctx); ok {
+				entry.Caller = caller.Addr
+			}
+			seq, err := j.append(entry)
+			if err != nil {
+				return err
+			}
+
+			callErr := next(ctx, call)
+			outcome := Entry{Seq: seq, Type: EntryOutcome, Method: call.Method}
+			if callErr != nil {
+				outcome.Error = callErr.Error()
+			} else if call.Reply != nil {
+				if outcome.Reply, err = json.Marshal(call.Reply); err != nil {
+					return fmt.Errorf("rpcjournal: cannot record the reply of %s: %v", call.Method, err)
+				}
+			}
+			if _, err := j.append(outcome); err != nil {
+				// The method ran, but its outcome would be lost.
+				return err
+			}
+			return callErr
+		}
+	}
+}
+
+// append records the entry, numbering it if it is a call.
+func (j *Journal) append(entry Entry) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if entry.Type == EntryCall {
+		entry.Seq = j.seq + 1
+	}
+	entry.Time = rpcserver.ClockOrSystem(j.Clock).Now()
+	if err := j.storage.Append(entry); err != nil {
+		return 0, fmt.Errorf("rpcjournal: cannot record the call of %s: %v", entry.Method, err)
+	}
+	if entry.Type == EntryCall {
+		j.seq = entry.Seq
+	}
+	return entry.Seq, nil
+}
+
+// Call is a journaled call with its outcome.
+type Call struct {
+	Entry
+
+	// Completed tells if the outcome of the call was recorded, calls
+	// interrupted by a crash are not.
+	Completed bool
+}
+
+// Calls returns the calls of the journal in order, merging the outcomes
+// into the calls.
+func Calls(storage Storage) ([]Call, error) {
+	entries, err := storage.Entries()
+	if err != nil {
+		return nil, fmt.Errorf("rpcjournal: %v", err)
+	}
+	var calls []Call
+	bySeq := make(map[uint64]int)
+	for _, entry := range entries {
+		switch entry.Type {
+		case EntryCall:
+			bySeq[entry.Seq] = len(calls)
+			calls = append(calls, Call{Entry: entry})
+		case EntryOutcome:
+			if i, ok := bySeq[entry.Seq]; ok {
+				calls[i].Reply, calls[i].Error, calls[i].Completed = entry.Reply, entry.Error, true
+			}
+		}
+	}
+	return calls, nil
+}
+
+// Pending returns the calls whose outcome is missing, the ones to recover
+// after a crash.
+func Pending(calls []Call) []Call {
+	var pending []Call
+	for _, call := range calls {
+		if !call.Completed {
+			pending = append(pending, call)
+		}
+	}
+	return pending
+}
+
+// replayKey marks the contexts of replayed calls, which are not journaled
+// again.
+type replayKey struct{}
+
+// Replay calls the methods of the calls in order with their args, as JSON-RPC
+// 2.0 requests to the handler at path followed by the method name, e.g.
+// "/rpc/". Replayed calls are not journaled again. It stops at the first
+// call answered with an error, unless the call failed with the same message
+// when journaled.
+func Replay(ctx context.Context, handler http.Handler, path string, calls []Call) error {
+	ctx = context.WithValue(ctx, replayKey{}, true)
+	for _, call := range calls {
+		status, body, err := rpcserver.ServeCall(ctx, handler, path, call.Method, rpcserver.CallBody(call.Method, call.Args, call.Seq), nil)
+		if err != nil {
+			return fmt.Errorf("rpcjournal: %v", err)
+		}
+
+		var res struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return fmt.Errorf("rpcjournal: replaying call %d of %s: status %d", call.Seq, call.Method, status)
+		}
+		if res.Error != nil && (!call.Completed || res.Error.Message != call.Error) {
+			return fmt.Errorf("rpcjournal: replaying call %d of %s: %s", call.Seq, call.Method, res.Error.Message)
+		}
+	}
+	return nil
+}
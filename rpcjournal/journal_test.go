@@ -0,0 +1,159 @@
+package rpcjournal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/datalinkE/rpcserver/rpcenvelope"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type DepositArgs struct {
+	Amount int
+}
+
+type Account struct {
+	Balance int
+}
+
+func (a *Account) Deposit(r *http.Request, args *DepositArgs, reply *int) error {
+	if args.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	a.Balance += args.Amount
+	*reply = a.Balance
+	return nil
+}
+
+func (a *Account) Get(r *http.Request, args *struct{}, reply *int) error {
+	*reply = a.Balance
+	return nil
+}
+
+func TestJournal(t *testing.T) {
+	srv := rpcservertest.NewServer(t, new(Account))
+	defer srv.Close()
+	storage := new(MemoryStorage)
+	journal, err := New(storage, "Deposit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.RPC.Use(journal.Middleware())
+
+	var balance int
+	srv.MustCall("Deposit", &DepositArgs{Amount: 5}, &balance)
+	srv.MustCall("Get", &struct{}{}, &balance)
+	if err := srv.Call("Deposit", &DepositArgs{Amount: -1}, &balance); err == nil {
+		t.Fatal("expected the deposit to fail")
+	}
+	srv.MustCall("Deposit", &DepositArgs{Amount: 2}, &balance)
+
+	calls, err := Calls(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 || calls[0].Seq != 1 || string(calls[0].Args) != `{"Amount":5}` || string(calls[0].Reply) != "5" ||
+		calls[1].Error != "amount must be positive" || !calls[1].Completed || string(calls[2].Reply) != "7" {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+
+	replayed := rpcservertest.NewServer(t, new(Account))
+	defer replayed.Close()
+	replayed.RPC.Use(journal.Middleware())
+	if err := Replay(context.Background(), replayed.RPC, "/rpc/", calls); err != nil {
+		t.Fatal(err)
+	}
+	replayed.MustCall("Get", &struct{}{}, &balance)
+	if balance != 7 {
+		t.Errorf("expected the replayed balance, got %d", balance)
+	}
+	if entries, _ := storage.Entries(); len(entries) != 6 {
+		t.Errorf("expected the replayed calls not to be journaled, got %d entries", len(entries))
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.journal")
+	storage, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Append(Entry{Seq: 1, Type: EntryCall, Method: "Deposit", Args: []byte(`{"Amount":5}`)})
+	storage.Append(Entry{Seq: 1, Type: EntryOutcome, Method: "Deposit", Reply: []byte("5")})
+	storage.Append(Entry{Seq: 2, Type: EntryCall, Method: "Deposit", Args: []byte(`{"Amount":2}`)})
+	storage.Close()
+
+	// Simulate a crash in the middle of an append.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"seq":2,"type":"outc`)
+	f.Close()
+
+	if storage, err = OpenFile(path); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	journal, err := New(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.seq != 2 {
+		t.Errorf("expected the sequence to resume, got %d", journal.seq)
+	}
+	calls, err := Calls(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := Pending(calls)
+	if len(calls) != 2 || len(pending) != 1 || pending[0].Seq != 2 || string(pending[0].Args) != `{"Amount":2}` {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+}
+
+func TestSealedStorage(t *testing.T) {
+	keys := rpcenvelope.NewKeyring()
+	keys.Add("k1", make([]byte, 32))
+	plain := new(MemoryStorage)
+	plain.Append(Entry{Seq: 1, Type: EntryCall, Method: "Deposit", Args: []byte(`{"Amount":1}`)})
+	storage := &SealedStorage{Storage: plain, Envelope: &rpcenvelope.Envelope{Keys: keys}}
+	storage.Append(Entry{Seq: 2, Type: EntryCall, Method: "Deposit", Args: []byte(`{"Amount":5}`)})
+	storage.Append(Entry{Seq: 2, Type: EntryOutcome, Method: "Deposit", Reply: []byte("6")})
+	storage.Append(Entry{Seq: 3, Type: EntryCall, Method: "Deposit", Args: []byte(`{"Amount":-1}`)})
+	storage.Append(Entry{Seq: 3, Type: EntryOutcome, Method: "Deposit", Error: "amount must be positive"})
+
+	stored, _ := plain.Entries()
+	for _, entry := range stored[1:] {
+		if entry.Method != "Deposit" || !rpcenvelope.IsSealed(entry.Args) && !rpcenvelope.IsSealed(entry.Reply) && !rpcenvelope.IsSealed([]byte(entry.Error)) {
+			t.Errorf("expected the entry sealed, got %+v", entry)
+		}
+	}
+	calls, err := Calls(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 || string(calls[0].Args) != `{"Amount":1}` || string(calls[1].Args) != `{"Amount":5}` || string(calls[1].Reply) != "6" ||
+		calls[2].Error != "amount must be positive" {
+		t.Errorf("unexpected calls %+v", calls)
+	}
+
+	// The fields do not open as the ones of other entries.
+	swapped := new(MemoryStorage)
+	swapped.Append(Entry{Seq: 3, Type: EntryCall, Method: "Deposit", Args: stored[1].Args})
+	if _, err := (&SealedStorage{Storage: swapped, Envelope: storage.Envelope}).Entries(); err == nil {
+		t.Errorf("expected the args of another entry not to open")
+	}
+
+	keys.Add("k2", bytes.Repeat([]byte{1}, 32))
+	rewrapped := new(MemoryStorage)
+	if n, err := storage.RewrapTo(rewrapped); err != nil || n != 5 {
+		t.Fatalf("expected the entries rewrapped, got %d, %v", n, err)
+	}
+	keys.Remove("k1")
+	strict := &SealedStorage{Storage: rewrapped, Envelope: &rpcenvelope.Envelope{Keys: keys, RequireSealed: true}}
+	if rotated, err := Calls(strict); err != nil || len(rotated) != 3 || string(rotated[0].Args) != `{"Amount":1}` || rotated[2].Error != "amount must be positive" {
+		t.Errorf("unexpected calls %+v, %v", rotated, err)
+	}
+}
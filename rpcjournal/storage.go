@@ -0,0 +1,228 @@
+package rpcjournal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/datalinkE/rpcserver/rpcenvelope"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// MemoryStorage keeps the entries in memory, for tests and for audits which
+// need not survive restarts.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Append records the entry.
+func (s *MemoryStorage) Append(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// Entries returns a copy of the entries.
+func (s *MemoryStorage) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Entry(nil), s.entries...), nil
+}
+
+// FileStorage appends the entries to a file as JSON lines, synced to the disk
+// before Append returns.
+type FileStorage struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFile opens the journal file at path, creating it if needed. A last
+// line cut short by a crash is truncated.
+func OpenFile(path string) (*FileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(file)
+	if err == nil {
+		if end := bytes.LastIndexByte(data, '\n') + 1; end < len(data) {
+			err = file.Truncate(int64(end))
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileStorage{file: file}, nil
+}
+
+// Append writes the entry as a line and syncs the file.
+func (s *FileStorage) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Entries reads the entries of the file.
+func (s *FileStorage) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []Entry
+	scanner := bufio.NewScanner(io.NewSectionReader(s.file, 0, 1<<62))
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Close closes the file.
+func (s *FileStorage) Close() error {
+	return s.file.Close()
+}
+
+// SealedStorage encrypts the args, the replies and the errors of the entries
+// with the Envelope before appending them to the Storage, which keeps their
+// methods, times and callers in the clear. Every field is bound to the Seq of
+// its entry and to its name. Entries appended before the storage was sealed
+// are read as is, unless the Envelope requires sealed payloads. The keys of
+// the Envelope the entries were sealed with must be kept as long as the
+// journal is read, or until the journal is rewritten by RewrapTo.
+type SealedStorage struct {
+	Storage  Storage
+	Envelope *rpcenvelope.Envelope
+}
+
+// recordKey returns the key the field of the entry is bound to.
+func recordKey(entry *Entry, field string) []byte {
+	return []byte(strconv.FormatUint(entry.Seq, 10) + "/" + field)
+}
+
+// Append seals the entry and appends it.
+func (s *SealedStorage) Append(entry Entry) error {
+	if err := s.seal(context.Background(), &entry); err != nil {
+		return err
+	}
+	return s.Storage.Append(entry)
+}
+
+// seal seals the args, the reply and the error of the entry.
+func (s *SealedStorage) seal(ctx context.Context, entry *Entry) error {
+	var err error
+	if entry.Args != nil {
+		if entry.Args, err = s.Envelope.Seal(ctx, entry.Args, recordKey(entry, "args")); err != nil {
+			return err
+		}
+	}
+	if entry.Reply != nil {
+		if entry.Reply, err = s.Envelope.Seal(ctx, entry.Reply, recordKey(entry, "reply")); err != nil {
+			return err
+		}
+	}
+	if entry.Error != "" {
+		sealed, err := s.Envelope.Seal(ctx, []byte(entry.Error), recordKey(entry, "error"))
+		if err != nil {
+			return err
+		}
+		entry.Error = string(sealed)
+	}
+	return nil
+}
+
+// Entries returns the opened entries of the Storage.
+func (s *SealedStorage) Entries() ([]Entry, error) {
+	entries, err := s.Storage.Entries()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Args, err = s.open(ctx, entry.Args, recordKey(entry, "args")); err != nil {
+			return nil, err
+		}
+		if entry.Reply, err = s.open(ctx, entry.Reply, recordKey(entry, "reply")); err != nil {
+			return nil, err
+		}
+		if entry.Error != "" {
+			opened, err := s.Envelope.Open(ctx, []byte(entry.Error), recordKey(entry, "error"))
+			if err != nil {
+				return nil, err
+			}
+			entry.Error = string(opened)
+		}
+	}
+	return entries, nil
+}
+
+func (s *SealedStorage) open(ctx context.Context, payload json.RawMessage, recordKey []byte) (json.RawMessage, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	return s.Envelope.Open(ctx, payload, recordKey)
+}
+
+// RewrapTo appends the entries of the Storage to dst with the data keys of
+// their fields wrapped by the current key of the Envelope, sealing the fields
+// appended before the storage was sealed. The journal file is append-only:
+// once dst replaces the Storage, e.g. by renaming its file, the keys rotated
+// out may be retired. It returns the number of entries appended.
+func (s *SealedStorage) RewrapTo(dst Storage) (int, error) {
+	entries, err := s.Storage.Entries()
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.Background()
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Args, err = s.rewrap(ctx, entry.Args, recordKey(entry, "args")); err != nil {
+			return i, err
+		}
+		if entry.Reply, err = s.rewrap(ctx, entry.Reply, recordKey(entry, "reply")); err != nil {
+			return i, err
+		}
+		if entry.Error != "" {
+			rewrapped, err := s.rewrap(ctx, []byte(entry.Error), recordKey(entry, "error"))
+			if err != nil {
+				return i, err
+			}
+			entry.Error = string(rewrapped)
+		}
+		if err := dst.Append(*entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+func (s *SealedStorage) rewrap(ctx context.Context, payload []byte, recordKey []byte) ([]byte, error) {
+	switch {
+	case payload == nil:
+		return nil, nil
+	case rpcenvelope.IsSealed(payload):
+		return s.Envelope.Rewrap(ctx, payload)
+	default:
+		return s.Envelope.Seal(ctx, payload, recordKey)
+	}
+}
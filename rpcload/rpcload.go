@@ -0,0 +1,218 @@
+// Package rpcload hammers an RPC method at a target rate and reports latency
+// percentiles and an error breakdown, for simple capacity planning.
+package rpcload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config describes a load run.
+type Config struct {
+	// Client performs the calls.
+	Client *rpcclient.Client
+
+	// Method is the name of the called method.
+	Method string
+
+	// Args is sent as the params of every call.
+	Args interface{}
+
+	// RPS is the target rate of calls per second, 0 runs as fast as the
+	// workers can go.
+	RPS float64
+
+	// Concurrency is the number of workers, at least 1.
+	Concurrency int
+
+	// Duration bounds the run when positive.
+	Duration time.Duration
+
+	// Requests bounds the number of calls when positive.
+	Requests int
+
+	// Timeout bounds every call when positive.
+	Timeout time.Duration
+}
+
+// Report summarizes a load run.
+type Report struct {
+	Requests int
+	Errors   int
+	Elapsed  time.Duration
+
+	// Rate is the achieved number of calls per second.
+	Rate float64
+
+	Mean, P50, P95, P99, Max time.Duration
+
+	// ErrorKinds counts failed calls by kind, e.g. "rpc -32603", "http 503",
+	// "timeout" or "transport".
+	ErrorKinds map[string]int
+}
+
+// Run performs calls until Duration or Requests is reached or ctx is done.
+func Run(ctx context.Context, cfg *Config) (*Report, error) {
+	if cfg.Client == nil || cfg.Method == "" {
+		return nil, errors.New("rpcload: Client and Method are required")
+	}
+	if cfg.Duration <= 0 && cfg.Requests <= 0 {
+		return nil, errors.New("rpcload: Duration or Requests must be set")
+	}
+	workers := cfg.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	tokens := make(chan struct{})
+	go pace(ctx, cfg, tokens)
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	kinds := make(map[string]int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range tokens {
+				latency, err := call(ctx, cfg)
+				mu.Lock()
+				latencies = append(latencies, latency)
+				if err != nil {
+					kinds[errorKind(err)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return summarize(latencies, kinds, time.Since(start)), nil
+}
+
+// pace emits a token per call to perform, at the configured rate.
+func pace(ctx context.Context, cfg *Config, tokens chan<- struct{}) {
+	defer close(tokens)
+	var tick <-chan time.Time
+	if cfg.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.RPS))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for sent := 0; cfg.Requests <= 0 || sent < cfg.Requests; sent++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case tokens <- struct{}{}:
+		}
+	}
+}
+
+// call performs a single timed call.
+func call(ctx context.Context, cfg *Config) (time.Duration, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	var reply json.RawMessage
+	err := cfg.Client.Call(ctx, cfg.Method, cfg.Args, &reply)
+	return time.Since(start), err
+}
+
+// errorKind classifies a call error for the breakdown.
+func errorKind(err error) string {
+	switch e := err.(type) {
+	case *jsonrpc2.Error:
+		return fmt.Sprintf("rpc %d", e.Code)
+	case *rpcclient.StatusError:
+		return fmt.Sprintf("http %d", e.StatusCode)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "transport"
+}
+
+func summarize(latencies []time.Duration, kinds map[string]int, elapsed time.Duration) *Report {
+	report := &Report{Requests: len(latencies), Elapsed: elapsed, ErrorKinds: kinds}
+	for _, n := range kinds {
+		report.Errors += n
+	}
+	if len(latencies) == 0 {
+		return report
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	report.Rate = float64(len(latencies)) / elapsed.Seconds()
+	report.Mean = total / time.Duration(len(latencies))
+	report.P50 = percentile(latencies, 50)
+	report.P95 = percentile(latencies, 95)
+	report.P99 = percentile(latencies, 99)
+	report.Max = latencies[len(latencies)-1]
+	return report
+}
+
+// percentile returns the p-th percentile of sorted latencies, nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// WriteTo prints the report in a human readable form.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "requests: %d, errors: %d, elapsed: %v, rate: %.1f/s\n"+
+		"latency: mean %v, p50 %v, p95 %v, p99 %v, max %v\n",
+		r.Requests, r.Errors, r.Elapsed.Round(time.Millisecond), r.Rate,
+		r.Mean, r.P50, r.P95, r.P99, r.Max)
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	kinds := make([]string, 0, len(r.ErrorKinds))
+	for kind := range r.ErrorKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		n, err = fmt.Fprintf(w, "  %s: %d\n", kind, r.ErrorKinds[kind])
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
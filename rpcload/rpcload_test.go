@@ -0,0 +1,45 @@
+package rpcload
+
+import (
+	"context"
+	"errors"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	srv := rpcservertest.NewServer(t, new(Arith))
+	defer srv.Close()
+
+	report, err := Run(context.Background(), &Config{
+		Client:      srv.Client,
+		Method:      "Divide",
+		Args:        &Args{A: 1},
+		Concurrency: 4,
+		Requests:    20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 20 || report.Errors != 20 || report.ErrorKinds["rpc 400"] != 20 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.P50 <= 0 || report.P50 > report.P99 || report.P99 > report.Max {
+		t.Fatalf("inconsistent percentiles %+v", report)
+	}
+}
@@ -0,0 +1,178 @@
+// Package rpcmock serves the methods described by an introspection Document
+// with canned or randomly generated replies, so clients can be developed
+// before the Go implementation of the service exists.
+package rpcmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Server is an http.Handler answering JSON-RPC 2.0 calls of the described methods.
+//
+// A method replies with its canned reply when one is set, otherwise with a
+// value generated from the result schema: random when Random is set, zero
+// values when not.
+type Server struct {
+	Doc *introspect.Document
+
+	// Random enables random replies for methods without a canned reply.
+	Random bool
+
+	codec  *jsonrpc2.Codec
+	mu     sync.Mutex
+	canned map[string]json.RawMessage
+	errors map[string]*jsonrpc2.Error
+	rand   *rand.Rand
+}
+
+// NewServer creates a mock Server for the document.
+func NewServer(doc *introspect.Document) *Server {
+	return &Server{
+		Doc:    doc,
+		codec:  jsonrpc2.NewCodec(),
+		canned: make(map[string]json.RawMessage),
+		errors: make(map[string]*jsonrpc2.Error),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetReply sets the canned reply of a method.
+func (s *Server) SetReply(method string, reply interface{}) error {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.canned[method] = data
+	delete(s.errors, method)
+	return nil
+}
+
+// SetError makes a method fail with the error.
+func (s *Server) SetError(method string, err *jsonrpc2.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[method] = err
+	delete(s.canned, method)
+}
+
+// ServeHTTP answers a call.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		s.codec.WriteErrorResponse(w, r, rpcserver.NewErrorResponse(r, 405, fmt.Errorf("rpc: POST method required, received %s", r.Method)))
+		return
+	}
+	method := s.Doc.Method(rpcserver.LastPart(r.URL.Path))
+	if method == nil {
+		s.codec.WriteErrorResponse(w, r, rpcserver.NewErrorResponse(r, 404, fmt.Errorf("rpc: can't find method %q", rpcserver.LastPart(r.URL.Path))))
+		return
+	}
+	codecReq := s.codec.NewRequest(r)
+	if err := codecReq.Error(); err != nil {
+		codecReq.WriteError(w, 400, err)
+		return
+	}
+	var params json.RawMessage
+	if err := codecReq.ReadRequest(&params); err != nil {
+		codecReq.WriteError(w, 400, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.errors[method.Name]; err != nil {
+		codecReq.WriteError(w, 400, err)
+		return
+	}
+	if reply, ok := s.canned[method.Name]; ok {
+		codecReq.WriteResponse(w, reply)
+		return
+	}
+	codecReq.WriteResponse(w, s.generate(method.Result, 0))
+}
+
+// maxDepth stops the generation of recursive and deeply nested values.
+const maxDepth = 6
+
+// generate builds a value matching the schema.
+func (s *Server) generate(schema *introspect.Schema, depth int) interface{} {
+	nullable := schema != nil && schema.Nullable
+	schema = s.Doc.Resolve(schema)
+	if schema == nil || nullable && (depth >= maxDepth || !s.Random || s.rand.Intn(4) == 0) {
+		return nil
+	}
+
+	switch schema.Type {
+	case "null":
+		return nil
+	case "boolean":
+		return s.Random && s.rand.Intn(2) == 1
+	case "integer":
+		if s.Random {
+			return s.rand.Intn(1000)
+		}
+		return 0
+	case "number":
+		if s.Random {
+			return float64(s.rand.Intn(100000)) / 100
+		}
+		return 0
+	case "string":
+		return s.generateString(schema.Format)
+	case "array":
+		items := []interface{}{}
+		if s.Random && depth < maxDepth {
+			for i := s.rand.Intn(3) + 1; i > 0; i-- {
+				items = append(items, s.generate(schema.Items, depth+1))
+			}
+		}
+		return items
+	case "object":
+		obj := make(map[string]interface{})
+		for name, prop := range schema.Properties {
+			obj[name] = s.generate(prop, depth+1)
+		}
+		if schema.AdditionalProperties != nil && s.Random && depth < maxDepth {
+			obj[s.word()] = s.generate(schema.AdditionalProperties, depth+1)
+		}
+		return obj
+	}
+	return nil
+}
+
+func (s *Server) generateString(format string) string {
+	switch format {
+	case "date-time":
+		if s.Random {
+			return time.Now().Add(-time.Duration(s.rand.Int63n(int64(365 * 24 * time.Hour)))).UTC().Format(time.RFC3339)
+		}
+		return time.Time{}.Format(time.RFC3339)
+	case "byte":
+		return ""
+	case "decimal":
+		if s.Random {
+			return fmt.Sprintf("%d.%02d", s.rand.Intn(1000), s.rand.Intn(100))
+		}
+		return "0"
+	}
+	if s.Random {
+		return s.word()
+	}
+	return ""
+}
+
+var words = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+
+func (s *Server) word() string {
+	return words[s.rand.Intn(len(words))]
+}
@@ -0,0 +1,66 @@
+package rpcmock
+
+import (
+	"context"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/introspect"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+	At       time.Time
+	Next     *Quotient
+	Tags     []string
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := NewServer(introspect.Describe(service))
+	mock.Random = true
+	ts := httptest.NewServer(mock)
+	defer ts.Close()
+	client := rpcclient.NewClient(ts.URL + "/rpc")
+
+	for i := 0; i < 20; i++ {
+		var quo Quotient
+		if err := client.Call(context.Background(), "Divide", &Args{A: 1, B: 2}, &quo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mock.SetReply("Divide", &Quotient{Quo: 7})
+	var quo Quotient
+	if err := client.Call(context.Background(), "Divide", &Args{}, &quo); err != nil || quo.Quo != 7 {
+		t.Fatalf("expected canned reply, got %+v, %v", quo, err)
+	}
+
+	mock.SetError("Divide", &jsonrpc2.Error{Code: jsonrpc2.E_SERVER, Message: "down"})
+	err = client.Call(context.Background(), "Divide", &Args{}, &quo)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Code != jsonrpc2.E_SERVER {
+		t.Fatalf("expected canned error, got %v", err)
+	}
+
+	err = client.Call(context.Background(), "Missing", &Args{}, &quo)
+	if rpcErr, ok := err.(*jsonrpc2.Error); !ok || rpcErr.Code != jsonrpc2.E_NO_METHOD {
+		t.Fatalf("expected method not found error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package rpcoutbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"time"
+)
+
+// Publisher publishes events to a broker, such as Kafka, NATS or a webhook.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// PublisherFunc is a Publisher function.
+type PublisherFunc func(ctx context.Context, event Event) error
+
+// Publish calls f.
+func (f PublisherFunc) Publish(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Relay publishes the events of an Outbox, removing them once published.
+// Several relays may share an outbox, consumers then see more duplicates.
+type Relay struct {
+	Outbox    *Outbox
+	Publisher Publisher
+
+	// Interval is the delay between the polls of the outbox, 1s when zero.
+	// BatchSize is the number of events read per poll, 100 when zero.
+	Interval  time.Duration
+	BatchSize int
+
+	// OnError, when set, is called with the errors of the polls, which are
+	// retried at the next one.
+	OnError func(err error)
+
+	// Clock times the polls, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+// Flush publishes the pending events in the order they were emitted, until the outbox is empty or a
+// publication fails. It returns the number of events published.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	batch := r.BatchSize
+	if batch <= 0 {
+		batch = 100
+	}
+	published := 0
+	for {
+		events, err := r.Outbox.pending(ctx, batch)
+		if err != nil {
+			return published, fmt.Errorf("rpcoutbox: %v", err)
+		}
+		for _, event := range events {
+			if err := r.Publisher.Publish(ctx, event); err != nil {
+				return published, fmt.Errorf("rpcoutbox: cannot publish event %s: %v", event.ID, err)
+			}
+			if err := r.Outbox.remove(ctx, event.ID); err != nil {
+				return published, fmt.Errorf("rpcoutbox: %v", err)
+			}
+			published++
+		}
+		if len(events) < batch {
+			return published, nil
+		}
+	}
+}
+
+// Run flushes the outbox every Interval until ctx is done, it returns the
+// error of ctx.
+func (r *Relay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	clock := rpcserver.ClockOrSystem(r.Clock)
+	for {
+		if _, err := r.Flush(ctx); err != nil && ctx.Err() == nil && r.OnError != nil {
+			r.OnError(err)
+		}
+		timer := clock.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
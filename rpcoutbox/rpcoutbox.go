@@ -0,0 +1,202 @@
+// Package rpcoutbox emits the domain events of the methods of an
+// rpcserver.Server reliably, with the transactional outbox pattern: events
+// are stored in an outbox table in the transaction of the call, committed with
+// the changes of the method or not at all, then a Relay publishes them to a
+// broker.
+//
+//	outbox := &rpcoutbox.Outbox{DB: db}
+//	server.Use(outbox.Middleware())
+//	relay := &rpcoutbox.Relay{Outbox: outbox, Publisher: publisher}
+//	go relay.Run(ctx)
+//
+// Methods run their queries in the transaction of the call and emit events:
+//
+//	func (s *Orders) Place(r *http.Request, args *Order, reply *string) error {
+//		tx := rpcoutbox.Tx(r.Context())
+//		if _, err := tx.ExecContext(r.Context(), "INSERT INTO orders ...", ...); err != nil {
+//			return err
+//		}
+//		return rpcoutbox.Emit(r.Context(), "order.placed", args)
+//	}
+//
+// Events are published at least once, those of a call in the order they were
+// emitted. The events of different calls are ordered by the time they were
+// emitted, not committed: the events of a call committing after a call
+// started later may be published after the events of that call. Consumers
+// needing an order across calls rely on the versions in their payloads.
+package rpcoutbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/internal/sqlutil"
+	"time"
+)
+
+// Event is a domain event emitted by a method.
+type Event struct {
+	// ID is unique to the event, for consumers to drop duplicates.
+	ID      string          `json:"id"`
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+	Time    time.Time       `json:"time"`
+}
+
+// Outbox stores the events emitted by the calls in a table of an SQL
+// database, made by CreateTable:
+//
+//	CREATE TABLE rpc_outbox (
+//		event_id VARCHAR(64) PRIMARY KEY,
+//		topic VARCHAR(255) NOT NULL,
+//		payload TEXT NOT NULL,
+//		created BIGINT NOT NULL
+//	)
+//
+// created is the time the event was emitted in Unix nanoseconds, plus its
+// rank among the events of its call, which orders the events of a call.
+type Outbox struct {
+	DB *sql.DB
+
+	// Table is the name of the table, "rpc_outbox" when empty.
+	Table string
+
+	// Numbered uses the $1, $2... placeholders of PostgreSQL, rather than ?.
+	Numbered bool
+
+	// TxOptions are the options of the transactions of the calls.
+	TxOptions *sql.TxOptions
+
+	// Clock times the events, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+// ErrNoOutbox is returned by Emit outside of the calls of an Outbox
+// middleware.
+var ErrNoOutbox = errors.New("rpcoutbox: no outbox transaction in the context")
+
+// callKey is the context key of the call transaction.
+type callKey struct{}
+
+// call is the transaction of a call and the events it emitted.
+type call struct {
+	tx     *sql.Tx
+	clock  rpcserver.Clock
+	events []Event
+}
+
+// Tx returns the transaction of the call of ctx, nil outside of the calls of
+// an Outbox middleware.
+func Tx(ctx context.Context) *sql.Tx {
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		return c.tx
+	}
+	return nil
+}
+
+// Emit adds an event to the outbox, with the payload encoded as JSON. The
+// event is stored when the method succeeds, and dropped when it fails.
+func Emit(ctx context.Context, topic string, payload interface{}) error {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return ErrNoOutbox
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("rpcoutbox: %v", err)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	c.events = append(c.events, Event{
+		ID:      hex.EncodeToString(id[:]),
+		Topic:   topic,
+		Payload: data,
+		Time:    rpcserver.ClockOrSystem(c.clock).Now(),
+	})
+	return nil
+}
+
+// Middleware returns the middleware running every call in a transaction,
+// committed with the events of the call when the method succeeds and rolled
+// back when it fails.
+func (o *Outbox) Middleware() rpcserver.Middleware {
+	return func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, rpcCall *rpcserver.Call) error {
+			tx, err := o.DB.BeginTx(ctx, o.TxOptions)
+			if err != nil {
+				return fmt.Errorf("rpcoutbox: %v", err)
+			}
+			c := &call{tx: tx, clock: o.Clock}
+			if err := next(context.WithValue(ctx, callKey{}, c), rpcCall); err != nil {
+				tx.Rollback()
+				return err
+			}
+			for i, event := range c.events {
+				// The created column orders the events of the call as they
+				// were emitted.
+				created := event.Time.UnixNano() + int64(i)
+				if _, err := tx.ExecContext(ctx, o.query("INSERT INTO %s (event_id, topic, payload, created) VALUES (?, ?, ?, ?)"),
+					event.ID, event.Topic, string(event.Payload), created); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("rpcoutbox: %v", err)
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("rpcoutbox: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+func (o *Outbox) table() string {
+	if o.Table == "" {
+		return "rpc_outbox"
+	}
+	return o.Table
+}
+
+// query formats the statement, replacing its ? placeholders when Numbered.
+func (o *Outbox) query(format string) string {
+	return sqlutil.Query(format, o.table(), o.Numbered)
+}
+
+// CreateTable creates the table unless it exists.
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	_, err := o.DB.ExecContext(ctx, o.query("CREATE TABLE IF NOT EXISTS %s (event_id VARCHAR(64) PRIMARY KEY, topic VARCHAR(255) NOT NULL, payload TEXT NOT NULL, created BIGINT NOT NULL)"))
+	return err
+}
+
+// pending returns the oldest events of the outbox, up to limit.
+func (o *Outbox) pending(ctx context.Context, limit int) ([]Event, error) {
+	rows, err := o.DB.QueryContext(ctx, o.query("SELECT event_id, topic, payload, created FROM %s ORDER BY created, event_id LIMIT ?"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var payload string
+		var created int64
+		if err := rows.Scan(&event.ID, &event.Topic, &payload, &created); err != nil {
+			return nil, err
+		}
+		event.Payload, event.Time = json.RawMessage(payload), time.Unix(0, created).UTC()
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// remove deletes a published event from the outbox.
+func (o *Outbox) remove(ctx context.Context, id string) error {
+	_, err := o.DB.ExecContext(ctx, o.query("DELETE FROM %s WHERE event_id = ?"), id)
+	return err
+}
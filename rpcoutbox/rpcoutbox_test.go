@@ -0,0 +1,207 @@
+package rpcoutbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type OrderArgs struct {
+	Item string
+}
+
+type Orders int
+
+func (o *Orders) Place(r *http.Request, args *OrderArgs, reply *bool) error {
+	if Tx(r.Context()) == nil {
+		return errors.New("no transaction")
+	}
+	if err := Emit(r.Context(), "order.placed", args); err != nil {
+		return err
+	}
+	if args.Item == "" {
+		return errors.New("no item")
+	}
+	return Emit(r.Context(), "stock.reserved", args)
+}
+
+func TestOutbox(t *testing.T) {
+	db := openFake(t)
+	outbox := &Outbox{DB: db}
+	if err := outbox.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	srv := rpcservertest.NewServer(t, new(Orders))
+	defer srv.Close()
+	srv.RPC.Use(outbox.Middleware())
+
+	var ok bool
+	srv.MustCall("Place", &OrderArgs{Item: "book"}, &ok)
+	if err := srv.Call("Place", &OrderArgs{}, &ok); err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	srv.MustCall("Place", &OrderArgs{Item: "pen"}, &ok)
+	if err := Emit(context.Background(), "lost", nil); err != ErrNoOutbox {
+		t.Errorf("expected no outbox, got %v", err)
+	}
+
+	var published []string
+	fail := true
+	relay := &Relay{Outbox: outbox, BatchSize: 3, Publisher: PublisherFunc(func(ctx context.Context, event Event) error {
+		if fail && len(published) == 1 {
+			return errors.New("broker down")
+		}
+		published = append(published, event.Topic+" "+string(event.Payload))
+		return nil
+	})}
+	if n, err := relay.Flush(context.Background()); n != 1 || err == nil {
+		t.Errorf("expected the flush to stop at the failure, got %d %v", n, err)
+	}
+	fail = false
+	if n, err := relay.Flush(context.Background()); n != 3 || err != nil {
+		t.Errorf("expected the rest to be published, got %d %v", n, err)
+	}
+	expected := `order.placed {"Item":"book"}|stock.reserved {"Item":"book"}|order.placed {"Item":"pen"}|stock.reserved {"Item":"pen"}`
+	if strings.Join(published, "|") != expected {
+		t.Errorf("unexpected events %q", published)
+	}
+	if n, _ := relay.Flush(context.Background()); n != 0 {
+		t.Errorf("expected the published events to be removed, got %d more", n)
+	}
+}
+
+// fakeDB is a database of an outbox table, storing the rows of the inserts
+// of transactions on commit.
+type fakeDB struct {
+	mu   sync.Mutex
+	rows map[string][]driver.Value
+}
+
+func openFake(t *testing.T) *sql.DB {
+	name := "rpcoutbox-fake-" + t.Name()
+	sql.Register(name, &fakeDB{rows: make(map[string][]driver.Value)})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func (d *fakeDB) Open(name string) (driver.Conn, error) {
+	return &fakeConn{db: d}, nil
+}
+
+type fakeConn struct {
+	db      *fakeDB
+	pending [][]driver.Value // inserted by the transaction in progress
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c, query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx, c.pending = true, nil
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for _, row := range c.pending {
+		c.db.rows[row[0].(string)] = row
+	}
+	c.inTx, c.pending = false, nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.inTx, c.pending = false, nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+	case strings.HasPrefix(s.query, "INSERT") && s.conn.inTx:
+		s.conn.pending = append(s.conn.pending, args)
+	case strings.HasPrefix(s.query, "INSERT"):
+		db.rows[args[0].(string)] = args
+	case strings.HasPrefix(s.query, "DELETE"):
+		delete(db.rows, args[0].(string))
+	default:
+		return nil, errors.New("fake: unexpected statement " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var rows [][]driver.Value
+	for _, row := range db.rows {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if a, b := rows[i][3].(int64), rows[j][3].(int64); a != b {
+			return a < b
+		}
+		return rows[i][0].(string) < rows[j][0].(string)
+	})
+	if limit := int(args[0].(int64)); len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"event_id", "topic", "payload", "created"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
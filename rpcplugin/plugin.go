@@ -0,0 +1,27 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+package rpcplugin
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadPlugin opens the Go plugin of the path, and registers the methods of the
+// receiver it exports as Service under name, see rpcserver.RegisterGroup.
+func (l *Loader) LoadPlugin(name, path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("rpcplugin: %v", err)
+	}
+	symbol, err := p.Lookup("Service")
+	if err != nil {
+		return fmt.Errorf("rpcplugin: %v", err)
+	}
+	// A variable is looked up as a pointer to it, the receiver.
+	receiver := interface{}(symbol)
+	if newService, ok := symbol.(func() interface{}); ok {
+		receiver = newService()
+	}
+	return l.Server.RegisterGroup(name, receiver)
+}
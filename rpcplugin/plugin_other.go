@@ -0,0 +1,13 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+package rpcplugin
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// LoadPlugin fails: Go plugins are not supported on this platform.
+func (l *Loader) LoadPlugin(name, path string) error {
+	return fmt.Errorf("rpcplugin: cannot load %s: Go plugins are not supported on %s", path, runtime.GOOS)
+}
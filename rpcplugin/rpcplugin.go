@@ -0,0 +1,148 @@
+// Package rpcplugin extends an rpcserver.Server with services loaded at run
+// time, so that a host binary gains methods without being recompiled: from
+// compiled Go plugins, or from subprocesses speaking the stdio transport.
+//
+//	loader := &rpcplugin.Loader{Server: server}
+//	defer loader.Close()
+//	if err := loader.LoadDir("/usr/lib/myapp/plugins"); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// The methods of a plugin are served under its name, e.g. "arith.Multiply"
+// for the method Multiply of the plugin arith.so or of the executable arith.
+//
+// A Go plugin exports its receiver as the symbol Service, a variable or a
+// function returning it:
+//
+//	package main
+//
+//	var Service Arith
+//
+// and is built with go build -buildmode=plugin. Go plugins are only supported
+// on Linux, macOS and FreeBSD, with cgo.
+//
+// A subprocess serves its own rpcserver.Server on its standard input and
+// output with ServeStdio:
+//
+//	func main() {
+//		server, _ := rpcserver.NewServer(new(Arith))
+//		server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+//		rpcplugin.ServeStdio(server, "/rpc/", os.Stdin, os.Stdout)
+//	}
+//
+// and may be written in any language speaking the transport, see ServeStdio.
+package rpcplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoverTimeout bounds the listing of the methods of a subprocess.
+const discoverTimeout = 10 * time.Second
+
+// Loader registers the methods of plugins with a server.
+type Loader struct {
+	// Server serves the methods of the plugins.
+	Server *rpcserver.Server
+
+	mu        sync.Mutex
+	processes []*Process
+}
+
+// LoadProcess starts the command as a subprocess speaking the stdio
+// transport, and registers its methods under name. The subprocess runs until
+// the loader is closed.
+func (l *Loader) LoadProcess(name string, command string, args ...string) error {
+	p, err := StartProcess(command, args...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	methods, err := p.Methods(ctx)
+	if err != nil {
+		p.Close()
+		return fmt.Errorf("rpcplugin: cannot list the methods of %s: %v", name, err)
+	}
+	for _, method := range methods {
+		if err := l.Server.RegisterBuiltin(name+"."+method, remote{p, method}, "Call"); err != nil {
+			p.Close()
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.processes = append(l.processes, p)
+	return nil
+}
+
+// LoadDir loads the plugins of the directory: the Go plugins of the files
+// with the .so extension, and the subprocesses of the executable files, named
+// after their file without its extension. The other files are ignored.
+func (l *Loader) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("rpcplugin: %v", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		switch {
+		case ext == ".so":
+			err = l.LoadPlugin(name, path)
+		case info.Mode()&0111 != 0 || ext == ".exe":
+			err = l.LoadProcess(name, path)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the subprocesses of the loader. Their methods stay registered,
+// their calls then fail.
+func (l *Loader) Close() error {
+	l.mu.Lock()
+	processes := l.processes
+	l.processes = nil
+	l.mu.Unlock()
+	var first error
+	for _, p := range processes {
+		if err := p.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// remote is the receiver of a method of a subprocess.
+type remote struct {
+	p      *Process
+	method string
+}
+
+// Call calls the method of the subprocess with the raw params.
+func (m remote) Call(r *http.Request, raw json.RawMessage) (interface{}, error) {
+	result, err := m.p.Call(r.Context(), m.method, raw)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
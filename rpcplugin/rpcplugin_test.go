@@ -0,0 +1,142 @@
+package rpcplugin
+
+import (
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (t *Arith) Multiply(r *http.Request, args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Divide(r *http.Request, args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+type Host int
+
+func (h *Host) Ping(r *http.Request, args *struct{}, reply *string) error {
+	*reply = "pong"
+	return nil
+}
+
+// TestPluginProcess serves Arith over the stdio transport for TestLoadProcess.
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("RPCPLUGIN_TEST_PROCESS") == "" {
+		t.Skip("run by TestLoadProcess")
+	}
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	err = ServeStdio(server, "/rpc/", os.Stdin, os.Stdout)
+	// Exit before the testing package writes to the transport.
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestLoadProcess(t *testing.T) {
+	t.Setenv("RPCPLUGIN_TEST_PROCESS", "1")
+	srv := rpcservertest.NewServer(t, new(Host))
+	defer srv.Close()
+	loader := &Loader{Server: srv.RPC}
+	if err := loader.LoadProcess("arith", os.Args[0], "-test.run=^TestPluginProcess$"); err != nil {
+		t.Fatal(err)
+	}
+
+	var product int
+	srv.MustCall("arith.Multiply", &Args{A: 6, B: 7}, &product)
+	if product != 42 {
+		t.Errorf("expected 42, got %d", product)
+	}
+	var quotient int
+	err := srv.Call("arith.Divide", &Args{A: 1}, &quotient)
+	if err == nil || !strings.Contains(err.Error(), "divide by zero") {
+		t.Errorf("expected the error of the plugin, got %v", err)
+	}
+	var pong string
+	srv.MustCall("Ping", &struct{}{}, &pong)
+
+	if err := loader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Call("arith.Multiply", &Args{A: 6, B: 7}, &product); err == nil || !strings.Contains(err.Error(), "process exited") {
+		t.Errorf("expected the call of a closed plugin to fail, got %v", err)
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	in := strings.NewReader(`{"jsonrpc": "2.0", "method": "rpc.methods", "id": 1}` + "\n\n" +
+		`{"jsonrpc": "2.0", "method": "Multiply", "params": {"A": 2, "B": 3}, "id": 2}`)
+	var out strings.Builder
+	if err := ServeStdio(server, "/rpc/", in, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 responses, got %q", out.String())
+	}
+	got := map[string]bool{}
+	for _, line := range lines {
+		got[line] = true
+	}
+	for _, want := range []string{
+		`{"jsonrpc":"2.0","result":["Divide","Multiply"],"id":1}`,
+		`{"jsonrpc":"2.0","result":6,"id":2}`,
+	} {
+		if !got[want] {
+			t.Errorf("expected response %s, got %q", want, lines)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcplugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := rpcservertest.NewServer(t, new(Host))
+	defer srv.Close()
+	loader := &Loader{Server: srv.RPC}
+	if err := loader.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if names := srv.RPC.GroupMethodNames(); !reflect.DeepEqual(names, []string{}) {
+		t.Errorf("expected no plugin, got %v", names)
+	}
+	if err := loader.LoadPlugin("missing", filepath.Join(dir, "missing.so")); err == nil {
+		t.Error("expected an error loading a missing plugin")
+	}
+}
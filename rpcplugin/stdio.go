@@ -0,0 +1,244 @@
+package rpcplugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// MethodsMethod is the method of the stdio transport listing the methods of
+// the subprocess.
+const MethodsMethod = "rpc.methods"
+
+// request is a JSON-RPC 2.0 request of the stdio transport.
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// response is a JSON-RPC 2.0 response of the stdio transport.
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpc2.Error `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// ----------------------------------------------------------------------------
+// Process
+// ----------------------------------------------------------------------------
+
+// Process is a subprocess speaking the stdio transport: JSON-RPC 2.0 requests
+// written to its standard input, and their responses read from its standard
+// output, one per line. The calls are concurrent, the responses may come in
+// any order. Its standard error is the one of this process.
+type Process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	wmu   sync.Mutex // serializes the writes to stdin
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *response
+	err     error         // why the output ended
+	done    chan struct{} // closed once the output ended
+}
+
+// StartProcess starts the command as a subprocess speaking the stdio
+// transport. Callers should Close the process.
+func StartProcess(command string, args ...string) (*Process, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("rpcplugin: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("rpcplugin: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("rpcplugin: cannot start %s: %v", command, err)
+	}
+	p := &Process{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan *response),
+		done:    make(chan struct{}),
+	}
+	go p.read(stdout)
+	return p, nil
+}
+
+// read dispatches the responses of the output to their calls until it ends.
+func (p *Process) read(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	var err error
+	for err == nil {
+		var line []byte
+		line, err = reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var res response
+		var id uint64
+		if json.Unmarshal(line, &res) != nil || json.Unmarshal(res.ID, &id) != nil {
+			continue
+		}
+		p.mu.Lock()
+		if ch, ok := p.pending[id]; ok {
+			delete(p.pending, id)
+			ch <- &res
+		}
+		p.mu.Unlock()
+	}
+	if err == io.EOF {
+		err = errors.New("the process exited")
+	}
+	p.mu.Lock()
+	p.err = fmt.Errorf("rpcplugin: %v", err)
+	p.mu.Unlock()
+	close(p.done)
+}
+
+// Call calls the method of the subprocess with the raw params, and returns its
+// raw result. The errors of the method are *jsonrpc2.Error.
+func (p *Process) Call(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	ch := make(chan *response, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		defer p.mu.Unlock()
+		return nil, p.err
+	}
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	line, _ := json.Marshal(&request{Version: "2.0", Method: method, Params: params, ID: json.RawMessage(fmt.Sprint(id))})
+	p.wmu.Lock()
+	_, err := p.stdin.Write(append(line, '\n'))
+	p.wmu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("rpcplugin: %v", err)
+	}
+	var res *response
+	select {
+	case res = <-ch:
+	case <-p.done:
+		// The output may end right after the response.
+		select {
+		case res = <-ch:
+		default:
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return nil, p.err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return res.Result, nil
+}
+
+// Methods returns the names of the methods of the subprocess.
+func (p *Process) Methods(ctx context.Context) ([]string, error) {
+	result, err := p.Call(ctx, MethodsMethod, nil)
+	if err != nil {
+		return nil, err
+	}
+	var methods []string
+	if err := json.Unmarshal(result, &methods); err != nil {
+		return nil, fmt.Errorf("rpcplugin: invalid %s result: %v", MethodsMethod, err)
+	}
+	return methods, nil
+}
+
+// Close closes the standard input of the subprocess, and waits for it to
+// exit: the subprocess should exit at the end of its input.
+func (p *Process) Close() error {
+	p.stdin.Close()
+	<-p.done
+	return p.cmd.Wait()
+}
+
+// ----------------------------------------------------------------------------
+// ServeStdio
+// ----------------------------------------------------------------------------
+
+// ServeStdio serves the server over the stdio transport until the end of in:
+// it reads JSON-RPC 2.0 requests from in, one per line, calls them on the
+// server at path followed by their method, e.g. "/rpc/", and writes their
+// responses to out, one per line. The server needs the JSON-RPC 2.0 codec for
+// "application/json". The requests are served concurrently.
+//
+// It answers MethodsMethod itself, with the names of the methods of the
+// service and of the groups of the server. Nothing else may write to out.
+func ServeStdio(server *rpcserver.Server, path string, in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	var wmu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			wg.Add(1)
+			go func(line []byte) {
+				defer wg.Done()
+				reply := serveLine(server, path, line)
+				if len(reply) == 0 {
+					return
+				}
+				wmu.Lock()
+				defer wmu.Unlock()
+				out.Write(append(reply, '\n'))
+			}(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("rpcplugin: %v", err)
+		}
+	}
+}
+
+// serveLine returns the response to the request of the line, on a single
+// line. It is empty for notifications.
+func serveLine(server *rpcserver.Server, path string, line []byte) []byte {
+	var req request
+	if err := json.Unmarshal(line, &req); err == nil && req.Method == MethodsMethod {
+		methods := append(server.Service().MethodNames(), server.GroupMethodNames()...)
+		result, _ := json.Marshal(methods)
+		reply, _ := json.Marshal(&response{Version: "2.0", Result: result, ID: req.ID})
+		return reply
+	}
+	_, body, err := rpcserver.ServeCall(context.Background(), server, path, req.Method, line, nil)
+	if err != nil {
+		reply, _ := json.Marshal(&response{Version: "2.0", Error: &jsonrpc2.Error{Code: jsonrpc2.E_INVALID_REQ, Message: err.Error()}, ID: req.ID})
+		return reply
+	}
+	var compacted bytes.Buffer
+	if json.Compact(&compacted, body) != nil {
+		return nil
+	}
+	return compacted.Bytes()
+}
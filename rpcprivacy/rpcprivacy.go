@@ -0,0 +1,361 @@
+// Package rpcprivacy serves the data subject requests of privacy regulations
+// such as the GDPR: the export and the deletion of the data of a user. The
+// services holding user data implement ExportUserData and DeleteUserData, and
+// the rpc.privacy.export and rpc.privacy.delete builtins aggregate them:
+//
+//	privacy := &rpcprivacy.Privacy{
+//		Authorize: func(r *http.Request, user string) error {
+//			if !isDataProtectionOfficer(r) {
+//				return errors.New("not a data protection officer")
+//			}
+//			return nil
+//		},
+//		Audit:  rpcprivacy.NewAuditLogger(auditFile),
+//		Signer: signer,
+//	}
+//	privacy.Add("orders", orders)
+//	privacy.Add("profiles", profiles)
+//	privacy.Register(server)
+//
+//	{"jsonrpc": "2.0", "method": "rpc.privacy.export", "params": ["<user id>"], "id": 1}
+//	{"jsonrpc": "2.0", "method": "rpc.privacy.delete", "params": ["<user id>"], "id": 2}
+//
+// Every request is authorized by the Authorize function of the Privacy, then
+// audited service by service, and the completed ones are answered with a
+// Certificate signed by the Signer of the Privacy.
+package rpcprivacy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter is implemented by the services holding user data, returning the
+// data of the user, nil if they hold none. The data is encoded to JSON.
+type Exporter interface {
+	ExportUserData(ctx context.Context, user string) (interface{}, error)
+}
+
+// Deleter is implemented by the services holding user data, deleting the data
+// of the user. Deleting the data of a user the service holds none of
+// succeeds.
+type Deleter interface {
+	DeleteUserData(ctx context.Context, user string) error
+}
+
+// Operation is the kind of a privacy request.
+type Operation string
+
+const (
+	Export Operation = "export"
+	Delete Operation = "delete"
+)
+
+// ErrNoUser is returned for the requests without user.
+var ErrNoUser = errors.New("rpcprivacy: no user")
+
+// Privacy aggregates the data of the users held by services.
+type Privacy struct {
+	// Authorize returns an error unless the caller of the request may export
+	// or delete the data of the user, the builtins refuse the request then.
+	// It is required by Register.
+	Authorize func(r *http.Request, user string) error
+
+	// Audit receives a record per service of every request when set.
+	Audit AuditSink
+
+	// Signer signs the certificates of the completed requests when set.
+	Signer rpcserver.Signer
+
+	// Clock times the requests, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu       sync.Mutex
+	services []service
+}
+
+// service is a service added to the Privacy.
+type service struct {
+	name     string
+	exporter Exporter
+	deleter  Deleter
+}
+
+// Add adds the service of the name, implementing Exporter, Deleter or both.
+// Services are called in the order they were added.
+func (p *Privacy) Add(name string, svc interface{}) error {
+	s := service{name: name}
+	s.exporter, _ = svc.(Exporter)
+	s.deleter, _ = svc.(Deleter)
+	if s.exporter == nil && s.deleter == nil {
+		return fmt.Errorf("rpcprivacy: %T implements neither ExportUserData nor DeleteUserData", svc)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, added := range p.services {
+		if added.name == name {
+			return fmt.Errorf("rpcprivacy: service %q already added", name)
+		}
+	}
+	p.services = append(p.services, s)
+	return nil
+}
+
+// Exported is the data of a user, by service.
+type Exported struct {
+	User        string                 `json:"user"`
+	Data        map[string]interface{} `json:"data"`
+	Certificate *Certificate           `json:"certificate"`
+}
+
+// Certificate attests the completion of a request.
+type Certificate struct {
+	ID        string    `json:"id"`
+	Operation Operation `json:"operation"`
+	User      string    `json:"user"`
+	Services  []string  `json:"services"`
+	Completed time.Time `json:"completed"`
+
+	// Digest is the hex SHA-256 of the JSON of the exported data, empty for
+	// deletions.
+	Digest string `json:"digest,omitempty"`
+
+	// Signature is the signature of the Payload of the certificate by the
+	// Signer of the Privacy, if any.
+	Signature string `json:"signature,omitempty"`
+}
+
+// Payload returns the JSON of the certificate without its Signature, the
+// bytes it signs.
+func (c *Certificate) Payload() ([]byte, error) {
+	unsigned := *c
+	unsigned.Signature = ""
+	return json.Marshal(&unsigned)
+}
+
+// Export returns the data of the user held by the exporting services. It
+// fails if any of them does, joining their errors.
+func (p *Privacy) Export(ctx context.Context, user string) (*Exported, error) {
+	if user == "" {
+		return nil, ErrNoUser
+	}
+	exported := &Exported{User: user, Data: make(map[string]interface{})}
+	var names []string
+	var errs []error
+	for _, s := range p.snapshot() {
+		if s.exporter == nil {
+			continue
+		}
+		data, err := s.exporter.ExportUserData(ctx, user)
+		p.audit(ctx, Export, user, s.name, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			continue
+		}
+		if data != nil {
+			exported.Data[s.name] = data
+		}
+		names = append(names, s.name)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("rpcprivacy: export of %s failed: %w", user, errors.Join(errs...))
+	}
+	body, err := json.Marshal(exported.Data)
+	if err != nil {
+		return nil, fmt.Errorf("rpcprivacy: %v", err)
+	}
+	digest := sha256.Sum256(body)
+	exported.Certificate, err = p.certify(Export, user, names, hex.EncodeToString(digest[:]))
+	if err != nil {
+		return nil, err
+	}
+	return exported, nil
+}
+
+// Delete deletes the data of the user held by the deleting services. Every
+// service is called even when some fail, the certificate is only returned
+// when none did.
+func (p *Privacy) Delete(ctx context.Context, user string) (*Certificate, error) {
+	if user == "" {
+		return nil, ErrNoUser
+	}
+	var names []string
+	var errs []error
+	for _, s := range p.snapshot() {
+		if s.deleter == nil {
+			continue
+		}
+		err := s.deleter.DeleteUserData(ctx, user)
+		p.audit(ctx, Delete, user, s.name, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			continue
+		}
+		names = append(names, s.name)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("rpcprivacy: deletion of %s failed: %w", user, errors.Join(errs...))
+	}
+	return p.certify(Delete, user, names, "")
+}
+
+// snapshot returns the services added.
+func (p *Privacy) snapshot() []service {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.services
+}
+
+// certify returns the signed certificate of a completed request.
+func (p *Privacy) certify(op Operation, user string, services []string, digest string) (*Certificate, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	cert := &Certificate{
+		ID:        hex.EncodeToString(id[:]),
+		Operation: op,
+		User:      user,
+		Services:  services,
+		Completed: rpcserver.ClockOrSystem(p.Clock).Now().UTC(),
+		Digest:    digest,
+	}
+	if p.Signer == nil {
+		return cert, nil
+	}
+	payload, err := cert.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("rpcprivacy: %v", err)
+	}
+	if cert.Signature, err = p.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("rpcprivacy: signing the certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// AuditRecord records the call of a service for a request.
+type AuditRecord struct {
+	Time      time.Time        `json:"time"`
+	Operation Operation        `json:"operation"`
+	User      string           `json:"user"`
+	Service   string           `json:"service,omitempty"` // empty for the refused requests
+	Caller    rpcserver.Caller `json:"caller"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// AuditSink receives the records of the requests. Record may be called
+// concurrently.
+type AuditSink interface {
+	Record(record AuditRecord)
+}
+
+// AuditLogger writes the records to Writer as JSON lines, each with a single
+// Write call.
+type AuditLogger struct {
+	Writer io.Writer
+
+	mu sync.Mutex
+}
+
+// NewAuditLogger creates an AuditLogger writing to w.
+func NewAuditLogger(w io.Writer) *AuditLogger {
+	return &AuditLogger{Writer: w}
+}
+
+// Record writes the record.
+func (l *AuditLogger) Record(record AuditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Writer.Write(append(line, '\n'))
+}
+
+// audit records the call of a service.
+func (p *Privacy) audit(ctx context.Context, op Operation, user, svc string, err error) {
+	if p.Audit == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:      rpcserver.ClockOrSystem(p.Clock).Now().UTC(),
+		Operation: op,
+		User:      user,
+		Service:   svc,
+	}
+	record.Caller, _ = rpcserver.CallerFromContext(ctx)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	p.Audit.Record(record)
+}
+
+// Register registers the rpc.privacy.export and rpc.privacy.delete builtins
+// of the server, whose only param is the user. It fails without Authorize.
+func (p *Privacy) Register(server *rpcserver.Server) error {
+	if p.Authorize == nil {
+		return errors.New("rpcprivacy: Authorize is required to register the builtins")
+	}
+	if err := server.RegisterBuiltin("rpc.privacy.export", builtins{p}, "Export"); err != nil {
+		return err
+	}
+	return server.RegisterBuiltin("rpc.privacy.delete", builtins{p}, "Delete")
+}
+
+// builtins holds the builtins of the privacy.
+type builtins struct {
+	privacy *Privacy
+}
+
+// Export is the rpc.privacy.export builtin.
+func (b builtins) Export(r *http.Request, user string) (*Exported, error) {
+	user = strings.TrimSpace(user)
+	if err := b.authorize(r, Export, user); err != nil {
+		return nil, err
+	}
+	exported, err := b.privacy.Export(r.Context(), user)
+	return exported, b.error(err, user)
+}
+
+// Delete is the rpc.privacy.delete builtin.
+func (b builtins) Delete(r *http.Request, user string) (*Certificate, error) {
+	user = strings.TrimSpace(user)
+	if err := b.authorize(r, Delete, user); err != nil {
+		return nil, err
+	}
+	cert, err := b.privacy.Delete(r.Context(), user)
+	return cert, b.error(err, user)
+}
+
+// authorize returns the error of a request refused by Authorize, audited
+// without service.
+func (b builtins) authorize(r *http.Request, op Operation, user string) error {
+	err := b.privacy.Authorize(r, user)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("rpcprivacy: %s of the data of %s refused: %w", op, user, err)
+	b.privacy.audit(r.Context(), op, user, "", err)
+	return err
+}
+
+// error returns the JSON-RPC error of a failed request.
+func (b builtins) error(err error, user string) error {
+	if errors.Is(err, ErrNoUser) {
+		return jsonrpc2.NewError(jsonrpc2.E_BAD_PARAMS, err.Error(), user)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package rpcprivacy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"github.com/datalinkE/rpcserver/rpcsign"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Profiles struct {
+	names map[string]string
+}
+
+func (p *Profiles) Get(r *http.Request, user *string, reply *string) error {
+	*reply = p.names[*user]
+	return nil
+}
+
+func (p *Profiles) ExportUserData(ctx context.Context, user string) (interface{}, error) {
+	if name, ok := p.names[user]; ok {
+		return map[string]string{"name": name}, nil
+	}
+	return nil, nil
+}
+
+func (p *Profiles) DeleteUserData(ctx context.Context, user string) error {
+	delete(p.names, user)
+	return nil
+}
+
+type Orders []string
+
+func (o Orders) ExportUserData(ctx context.Context, user string) (interface{}, error) {
+	return []string(o), nil
+}
+
+type Archive struct {
+	err error
+}
+
+func (a *Archive) DeleteUserData(ctx context.Context, user string) error {
+	return a.err
+}
+
+func TestPrivacy(t *testing.T) {
+	profiles := &Profiles{names: map[string]string{"u1": "Ann"}}
+	srv := rpcservertest.NewServer(t, profiles)
+	defer srv.Close()
+	var audit bytes.Buffer
+	clock := rpcservertest.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	privacy := &Privacy{Audit: NewAuditLogger(&audit), Signer: rpcsign.HMAC([]byte("key")), Clock: clock}
+	if err := privacy.Register(srv.RPC); err == nil {
+		t.Errorf("expected the builtins to require Authorize")
+	}
+	privacy.Authorize = func(r *http.Request, user string) error {
+		if r.Header.Get("X-Officer") != "dpo" {
+			return errors.New("not a data protection officer")
+		}
+		return nil
+	}
+	officer := rpcclient.WithHeader("X-Officer", "dpo")
+	archive := new(Archive)
+	for _, s := range []struct {
+		name string
+		svc  interface{}
+	}{{"profiles", profiles}, {"orders", Orders{"o1"}}, {"archive", archive}} {
+		if err := privacy.Add(s.name, s.svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := privacy.Add("profiles", profiles); err == nil {
+		t.Errorf("expected a duplicate service to fail")
+	}
+	if err := privacy.Add("none", struct{}{}); err == nil {
+		t.Errorf("expected a service without user data to fail")
+	}
+	if err := privacy.Register(srv.RPC); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported Exported
+	err := srv.Call("rpc.privacy.export", []string{"u1"}, &exported)
+	if err == nil || !strings.Contains(err.Error(), "export of the data of u1 refused: not a data protection officer") {
+		t.Errorf("expected the anonymous export refused, got %v", err)
+	}
+	var refused Certificate
+	err = srv.Call("rpc.privacy.delete", []string{"u1"}, &refused)
+	if err == nil || !strings.Contains(err.Error(), "refused") || profiles.names["u1"] != "Ann" {
+		t.Errorf("expected the anonymous deletion refused, got %v", err)
+	}
+
+	srv.MustCall("rpc.privacy.export", []string{"u1"}, &exported, officer)
+	data, _ := json.Marshal(exported.Data)
+	if string(data) != `{"orders":["o1"],"profiles":{"name":"Ann"}}` {
+		t.Errorf("unexpected data %s", data)
+	}
+	cert := exported.Certificate
+	if cert == nil || cert.Operation != Export || cert.User != "u1" || len(cert.Services) != 2 || cert.Digest == "" || !cert.Completed.Equal(clock.Now()) {
+		t.Fatalf("unexpected certificate %+v", cert)
+	}
+	payload, _ := cert.Payload()
+	if signature, _ := rpcsign.HMAC([]byte("key")).Sign(payload); cert.Signature != signature {
+		t.Errorf("expected the certificate signed, got %q", cert.Signature)
+	}
+
+	archive.err = errors.New("archive offline")
+	var deleted Certificate
+	err = srv.Call("rpc.privacy.delete", []string{"u1"}, &deleted, officer)
+	if err == nil || !strings.Contains(err.Error(), "archive: archive offline") {
+		t.Errorf("expected the failed deletion, got %v", err)
+	}
+	if _, ok := profiles.names["u1"]; ok {
+		t.Errorf("expected the other services to delete the data")
+	}
+	archive.err = nil
+	srv.MustCall("rpc.privacy.delete", []string{"u1"}, &deleted, officer)
+	if deleted.Operation != Delete || deleted.Digest != "" || len(deleted.Services) != 2 || deleted.Signature == "" {
+		t.Errorf("unexpected certificate %+v", deleted)
+	}
+
+	rpcservertest.AssertError(t, srv.Call("rpc.privacy.export", []string{" "}, &exported, officer), -32602)
+
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 8 {
+		t.Fatalf("expected a record per service and request, got %s", audit.String())
+	}
+	if refused := records[0]; refused.Operation != Export || refused.Service != "" || !strings.Contains(refused.Error, "refused") {
+		t.Errorf("expected the refused export audited, got %+v", refused)
+	}
+	if failed := records[5]; failed.Operation != Delete || failed.Service != "archive" || failed.Error != "archive offline" || failed.Caller.Addr != "127.0.0.1" {
+		t.Errorf("unexpected record %+v", failed)
+	}
+}
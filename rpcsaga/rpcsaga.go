@@ -0,0 +1,210 @@
+// Package rpcsaga runs composite methods as sagas: sequences of steps, local
+// or calls of remote methods, each with a compensating action undoing it. When
+// a step fails, the steps done before it are compensated in reverse order:
+//
+//	func (s *Shop) PlaceOrder(r *http.Request, args *OrderArgs, reply *rpcsaga.Report) error {
+//		report, err := rpcsaga.New(
+//			rpcsaga.Step{
+//				Name:       "reserve",
+//				Do:         rpcsaga.Remote(s.stock, "Reserve", args, nil),
+//				Compensate: rpcsaga.Remote(s.stock, "Release", args, nil),
+//			},
+//			rpcsaga.Step{
+//				Name:       "charge",
+//				Do:         func(ctx context.Context) error { return s.charge(ctx, args) },
+//				Compensate: func(ctx context.Context) error { return s.refund(ctx, args) },
+//				Attempts:   3,
+//				Backoff:    100 * time.Millisecond,
+//			},
+//		).Run(r.Context())
+//		*reply = *report
+//		return err
+//	}
+//
+// The Report tells the status of every step, in the reply on success and in
+// the data of the returned *jsonrpc2.Error on failure.
+package rpcsaga
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"time"
+)
+
+// Step is a step of a saga.
+type Step struct {
+	Name string
+
+	// Do performs the step.
+	Do func(ctx context.Context) error
+
+	// Compensate undoes the step once done, when a later step fails. Steps
+	// without compensation are left done.
+	Compensate func(ctx context.Context) error
+
+	// Attempts is the number of attempts of Do, and of Compensate, 1 when
+	// zero. Backoff is the delay between them.
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Remote returns the action of a step calling the method with the client. The
+// attempts of the action share an rpcserver.IdempotencyKeyHeader, so servers
+// deduplicating calls, e.g. with the rpcdedup package, run it once. Actions
+// are made for a single run of a saga.
+func Remote(client *rpcclient.Client, method string, args interface{}, reply interface{}) func(ctx context.Context) error {
+	var key string
+	return func(ctx context.Context) error {
+		if key == "" {
+			var b [16]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				return err
+			}
+			key = hex.EncodeToString(b[:])
+		}
+		return client.Call(ctx, method, args, reply, func(call *rpcclient.Call) {
+			call.Header.Set(rpcserver.IdempotencyKeyHeader, key)
+		})
+	}
+}
+
+// Status is the status of a saga or of a step.
+type Status string
+
+const (
+	// Pending steps were not run, as an earlier step failed.
+	Pending Status = "pending"
+
+	// Done steps, and sagas, completed.
+	Done Status = "done"
+
+	// Failed steps failed, their saga was compensated. Failed sagas could not
+	// be compensated entirely.
+	Failed Status = "failed"
+
+	// Compensated steps were undone, as were sagas whose every done step was.
+	Compensated Status = "compensated"
+
+	// CompensationFailed steps were done but could not be undone.
+	CompensationFailed Status = "compensationFailed"
+)
+
+// Report describes the run of a saga.
+type Report struct {
+	Status Status       `json:"status"`
+	Steps  []StepReport `json:"steps"`
+}
+
+// StepReport describes the run of a step.
+type StepReport struct {
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// CompensationError is the error of the last attempt of compensation.
+	CompensationError string `json:"compensationError,omitempty"`
+}
+
+// Saga runs steps in order, compensating them on failure.
+type Saga struct {
+	Steps []Step
+
+	// CompensationTimeout bounds the compensation of every step, its
+	// attempts included, 30 seconds when zero, none when negative.
+	CompensationTimeout time.Duration
+
+	// Clock times the backoff delays and the compensations,
+	// rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+// defaultCompensationTimeout is the CompensationTimeout when zero.
+const defaultCompensationTimeout = 30 * time.Second
+
+// New creates a Saga of the steps.
+func New(steps ...Step) *Saga {
+	return &Saga{Steps: steps}
+}
+
+// Run runs the steps in order. When one fails, it compensates the steps done
+// in reverse order and returns a *jsonrpc2.Error of the failure holding the
+// Report in its data. Compensations run even when ctx is done, with its
+// values but without its deadline, each for up to the CompensationTimeout.
+func (s *Saga) Run(ctx context.Context) (*Report, error) {
+	report := &Report{Status: Done, Steps: make([]StepReport, len(s.Steps))}
+	for i, step := range s.Steps {
+		report.Steps[i] = StepReport{Name: step.Name, Status: Pending}
+	}
+	for i, step := range s.Steps {
+		attempts, err := s.attempt(ctx, step, step.Do)
+		report.Steps[i].Attempts = attempts
+		if err == nil {
+			report.Steps[i].Status = Done
+			continue
+		}
+		report.Steps[i].Status, report.Steps[i].Error = Failed, err.Error()
+		report.Status = s.compensate(context.WithoutCancel(ctx), report, i)
+		return report, jsonrpc2.NewError(jsonrpc2.E_SERVER, fmt.Sprintf("rpcsaga: step %s failed: %v", step.Name, err), report)
+	}
+	return report, nil
+}
+
+// compensate undoes the steps done before the failed one, it returns the
+// status of the saga.
+func (s *Saga) compensate(ctx context.Context, report *Report, failed int) Status {
+	status := Compensated
+	for i := failed - 1; i >= 0; i-- {
+		step := s.Steps[i]
+		if step.Compensate == nil {
+			continue
+		}
+		if _, err := s.attemptCompensation(ctx, step); err != nil {
+			report.Steps[i].Status, report.Steps[i].CompensationError = CompensationFailed, err.Error()
+			status = Failed
+			continue
+		}
+		report.Steps[i].Status = Compensated
+	}
+	return status
+}
+
+// attemptCompensation runs the compensation of the step within the
+// CompensationTimeout.
+func (s *Saga) attemptCompensation(ctx context.Context, step Step) (int, error) {
+	timeout := s.CompensationTimeout
+	if timeout == 0 {
+		timeout = defaultCompensationTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = rpcserver.WithClockTimeout(ctx, rpcserver.ClockOrSystem(s.Clock), timeout)
+		defer cancel()
+	}
+	return s.attempt(ctx, step, step.Compensate)
+}
+
+// attempt runs the action of the step up to its attempts, it returns the
+// number of attempts and the error of the last one.
+func (s *Saga) attempt(ctx context.Context, step Step, action func(ctx context.Context) error) (int, error) {
+	for i := 1; ; i++ {
+		err := action(ctx)
+		if err == nil || i >= step.Attempts || ctx.Err() != nil {
+			return i, err
+		}
+		if step.Backoff > 0 {
+			timer := rpcserver.ClockOrSystem(s.Clock).NewTimer(step.Backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return i, err
+			case <-timer.C():
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package rpcsaga
+
+import (
+	"context"
+	"errors"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ReserveArgs struct {
+	Item string
+}
+
+type Stock struct {
+	Reserved map[string]bool
+}
+
+func (s *Stock) Reserve(r *http.Request, args *ReserveArgs, reply *bool) error {
+	s.Reserved[args.Item] = true
+	return nil
+}
+
+func (s *Stock) Release(r *http.Request, args *ReserveArgs, reply *bool) error {
+	delete(s.Reserved, args.Item)
+	return nil
+}
+
+func TestSaga(t *testing.T) {
+	stock := &Stock{Reserved: make(map[string]bool)}
+	srv := rpcservertest.NewServer(t, stock)
+	defer srv.Close()
+
+	var log []string
+	local := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			log = append(log, name)
+			return err
+		}
+	}
+	args := &ReserveArgs{Item: "book"}
+	saga := New(
+		Step{Name: "reserve", Do: Remote(srv.Client, "Reserve", args, nil), Compensate: Remote(srv.Client, "Release", args, nil)},
+		Step{Name: "notify", Do: local("notify", nil)},
+		Step{Name: "charge", Do: local("charge", nil), Compensate: local("refund", nil)},
+		Step{Name: "ship", Do: local("ship", errors.New("no truck")), Attempts: 2},
+		Step{Name: "invoice", Do: local("invoice", nil)},
+	)
+	report, err := saga.Run(context.Background())
+	rpcErr, ok := err.(*jsonrpc2.Error)
+	if !ok || rpcErr.Data != report || !strings.Contains(rpcErr.Message, "step ship failed: no truck") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if strings.Join(log, " ") != "notify charge ship ship refund" || len(stock.Reserved) != 0 {
+		t.Errorf("unexpected run %v, reserved %v", log, stock.Reserved)
+	}
+	var statuses []string
+	for _, step := range report.Steps {
+		statuses = append(statuses, string(step.Status))
+	}
+	if report.Status != Compensated || strings.Join(statuses, " ") != "compensated done compensated failed pending" || report.Steps[3].Attempts != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	saga.Steps[3].Do = local("ship", nil)
+	saga.Steps[2].Compensate = local("refund", errors.New("bank down"))
+	if report, err = saga.Run(context.Background()); err != nil || report.Status != Done || !stock.Reserved["book"] {
+		t.Errorf("expected the saga to complete, got %+v %v", report, err)
+	}
+
+	saga.Steps[4].Do = local("invoice", errors.New("no paper"))
+	report, _ = saga.Run(context.Background())
+	if report.Status != Failed || report.Steps[2].Status != CompensationFailed || report.Steps[2].CompensationError != "bank down" {
+		t.Errorf("expected the compensation to fail, got %+v", report)
+	}
+}
+
+func TestCompensationTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	saga := New(
+		Step{Name: "hold", Do: func(ctx context.Context) error { return nil }, Compensate: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		Step{Name: "fail", Do: func(ctx context.Context) error {
+			cancel()
+			return errors.New("cancelled")
+		}},
+	)
+	saga.CompensationTimeout = 10 * time.Millisecond
+	report, _ := saga.Run(ctx)
+	if report.Status != Failed || report.Steps[0].CompensationError != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the compensation to time out, got %+v", report)
+	}
+}
@@ -0,0 +1,354 @@
+// Package rpcschedule runs the methods of an rpcserver.Server at later times,
+// once or on recurring schedules, as JSON-RPC 2.0 calls to the server so they
+// pass through its middleware, stats and access log like the calls of
+// clients:
+//
+//	scheduler := rpcschedule.New(server, "/rpc/")
+//	scheduler.Schedule("ReportGenerate", nil, rpcschedule.Daily(2, 0, nil))
+//	go scheduler.Run(ctx)
+//	router.Any("/schedule", gin.WrapH(scheduler)) // GET lists, POST schedules, DELETE cancels
+//
+// Scheduled calls carry an rpcserver.RequestIDHeader naming the job and the
+// run, "schedule-<job id>-<run>", to tell them apart in logs and audits. The
+// runs of a job never overlap: a run due while the previous one is in
+// progress is skipped.
+package rpcschedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Job describes a scheduled method call.
+type Job struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+
+	// Next is the time of the next run, zero during the last run of the
+	// schedule: the job is removed once that run completes.
+	Next time.Time `json:"next"`
+
+	// Runs counts the runs started, Skipped the runs due while the previous
+	// one was in progress, LastRun and LastError tell the time and the error
+	// of the last one completed.
+	Runs      int       `json:"runs"`
+	Skipped   int       `json:"skipped"`
+	LastRun   time.Time `json:"lastRun"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+type job struct {
+	Job
+	schedule Schedule
+	running  bool
+}
+
+// Scheduler calls the methods of a handler on schedules.
+type Scheduler struct {
+	// Clock times the runs, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	// Location is the one of the daily schedules requested with ServeHTTP,
+	// time.UTC when nil.
+	Location *time.Location
+
+	// MinInterval is the shortest interval of the Every schedules, a minute
+	// when zero, none when negative.
+	MinInterval time.Duration
+
+	handler http.Handler
+	path    string
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	lastID int
+	wake   chan struct{}
+}
+
+// New creates a Scheduler calling the methods of the handler, usually an
+// rpcserver.Server with the JSON-RPC 2.0 codec, at path followed by the method
+// name, e.g. "/rpc/".
+func New(handler http.Handler, path string) *Scheduler {
+	return &Scheduler{
+		handler: handler,
+		path:    path,
+		jobs:    make(map[string]*job),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Schedule schedules calls of the method with the params, it returns the id
+// of the job. Params are encoded as JSON.
+func (s *Scheduler) Schedule(method string, params interface{}, schedule Schedule) (string, error) {
+	if methods, ok := s.handler.(interface{ HasMethod(string) bool }); ok && !methods.HasMethod(method) {
+		return "", fmt.Errorf("rpcschedule: unknown method %s", method)
+	}
+	if d, ok := schedule.(every); ok && time.Duration(d) < s.minInterval() {
+		return "", fmt.Errorf("rpcschedule: interval %v under the minimum of %v", time.Duration(d), s.minInterval())
+	}
+	var raw json.RawMessage
+	if params != nil {
+		var err error
+		if raw, err = json.Marshal(params); err != nil {
+			return "", fmt.Errorf("rpcschedule: %v", err)
+		}
+	}
+	next := schedule.Next(rpcserver.ClockOrSystem(s.Clock).Now())
+	if next.IsZero() {
+		return "", errors.New("rpcschedule: the schedule has no run ahead")
+	}
+	s.mu.Lock()
+	s.lastID++
+	id := strconv.Itoa(s.lastID)
+	s.jobs[id] = &job{Job: Job{ID: id, Method: method, Params: raw, Next: next}, schedule: schedule}
+	s.mu.Unlock()
+	s.notify()
+	return id, nil
+}
+
+// minInterval returns the shortest interval of the Every schedules.
+func (s *Scheduler) minInterval() time.Duration {
+	if s.MinInterval == 0 {
+		return time.Minute
+	}
+	return s.MinInterval
+}
+
+// Cancel removes the job, it returns false if there is none with the id. Runs
+// in progress complete.
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	_, ok := s.jobs[id]
+	delete(s.jobs, id)
+	s.mu.Unlock()
+	s.notify()
+	return ok
+}
+
+// Jobs returns the jobs sorted by id.
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j.Job)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		x, _ := strconv.Atoi(jobs[a].ID)
+		y, _ := strconv.Atoi(jobs[b].ID)
+		return x < y
+	})
+	return jobs
+}
+
+// notify wakes up Run to reconsider the next run.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the jobs when due until ctx is done, it then waits for the runs in
+// progress and returns the error of ctx. Runs missed while the scheduler was
+// not running are skipped. The calls have the values of ctx.
+func (s *Scheduler) Run(ctx context.Context) error {
+	clock := rpcserver.ClockOrSystem(s.Clock)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		now := clock.Now()
+		due, next := s.due(now)
+		for _, run := range due {
+			wg.Add(1)
+			go func(run Job) {
+				defer wg.Done()
+				s.finish(run, s.call(ctx, run), clock.Now())
+			}(run)
+		}
+
+		var timer rpcserver.Timer
+		var fired <-chan time.Time
+		if !next.IsZero() {
+			timer = clock.NewTimer(next.Sub(now))
+			fired = timer.C()
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		case <-s.wake:
+		case <-fired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// due returns the runs of the jobs due at now, moving the jobs to their next
+// run, and the time of the earliest run ahead. The runs of the jobs whose
+// previous run is in progress are skipped.
+func (s *Scheduler) due(now time.Time) ([]Job, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Job
+	var next time.Time
+	for _, j := range s.jobs {
+		if j.Next.IsZero() {
+			continue
+		}
+		if !j.Next.After(now) {
+			if j.running {
+				j.Skipped++
+			} else {
+				j.Runs++
+				j.running = true
+				due = append(due, j.Job)
+			}
+			j.Next = j.schedule.Next(now)
+		}
+		if !j.Next.IsZero() && (next.IsZero() || j.Next.Before(next)) {
+			next = j.Next
+		}
+	}
+	return due, next
+}
+
+// finish records the outcome of a run, removing the job after its last run.
+func (s *Scheduler) finish(run Job, err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[run.ID]; ok {
+		j.running = false
+		j.LastRun, j.LastError = now, ""
+		if err != nil {
+			j.LastError = err.Error()
+		}
+		if j.Next.IsZero() {
+			delete(s.jobs, run.ID)
+		}
+	}
+}
+
+// call performs a run of a job.
+func (s *Scheduler) call(ctx context.Context, run Job) error {
+	header := http.Header{rpcserver.RequestIDHeader: {fmt.Sprintf("schedule-%s-%d", run.ID, run.Runs)}}
+	status, body, err := rpcserver.ServeCall(ctx, s.handler, s.path, run.Method, rpcserver.CallBody(run.Method, run.Params, run.Runs), header)
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return fmt.Errorf("rpcschedule: status %d", status)
+	}
+	if res.Error != nil {
+		return errors.New(res.Error.Message)
+	}
+	return nil
+}
+
+// scheduleRequest is the body of the POST requests of ServeHTTP, with one of
+// At, In, Every and Daily.
+type scheduleRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	At     time.Time       `json:"at"`    // RFC 3339 time of a single run
+	In     string          `json:"in"`    // delay of a single run, e.g. "10m"
+	Every  string          `json:"every"` // interval of the runs, e.g. "1h"
+	Daily  string          `json:"daily"` // time of the daily runs, e.g. "02:00"
+}
+
+// ServeHTTP exposes the jobs as JSON: GET lists them, POST schedules a call
+// and returns its Job, DELETE cancels the job of the id query parameter.
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.Jobs())
+	case "POST":
+		var req scheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			rpcserver.WriteError(w, 400, "rpcschedule: "+err.Error())
+			return
+		}
+		schedule, err := s.scheduleOf(&req)
+		if err != nil {
+			rpcserver.WriteError(w, 400, err.Error())
+			return
+		}
+		var params interface{}
+		if len(req.Params) > 0 {
+			params = req.Params
+		}
+		id, err := s.Schedule(req.Method, params, schedule)
+		if err != nil {
+			rpcserver.WriteError(w, 400, err.Error())
+			return
+		}
+		for _, j := range s.Jobs() {
+			if j.ID == id {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(201)
+				json.NewEncoder(w).Encode(j)
+			}
+		}
+	case "DELETE":
+		if !s.Cancel(r.URL.Query().Get("id")) {
+			rpcserver.WriteError(w, 404, "rpcschedule: no job "+r.URL.Query().Get("id"))
+			return
+		}
+		w.WriteHeader(204)
+	default:
+		rpcserver.WriteError(w, 405, "rpcschedule: GET, POST or DELETE method required, received "+r.Method)
+	}
+}
+
+// scheduleOf returns the Schedule of a request.
+func (s *Scheduler) scheduleOf(req *scheduleRequest) (Schedule, error) {
+	var schedules []Schedule
+	if !req.At.IsZero() {
+		schedules = append(schedules, At(req.At))
+	}
+	if req.In != "" {
+		d, err := time.ParseDuration(req.In)
+		if err != nil {
+			return nil, fmt.Errorf("rpcschedule: invalid delay %q", req.In)
+		}
+		schedules = append(schedules, At(rpcserver.ClockOrSystem(s.Clock).Now().Add(d)))
+	}
+	if req.Every != "" {
+		d, err := time.ParseDuration(req.Every)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("rpcschedule: invalid interval %q", req.Every)
+		}
+		schedules = append(schedules, Every(d))
+	}
+	if req.Daily != "" {
+		t, err := time.Parse("15:04", req.Daily)
+		if err != nil {
+			return nil, fmt.Errorf("rpcschedule: invalid daily time %q", req.Daily)
+		}
+		schedules = append(schedules, Daily(t.Hour(), t.Minute(), s.Location))
+	}
+	if len(schedules) != 1 {
+		return nil, errors.New("rpcschedule: one of at, in, every and daily is required")
+	}
+	return schedules[0], nil
+}
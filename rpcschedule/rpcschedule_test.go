@@ -0,0 +1,164 @@
+package rpcschedule
+
+import (
+	"context"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ReportArgs struct {
+	Name string
+}
+
+type Reports struct {
+	generated chan string
+}
+
+func (s *Reports) Generate(r *http.Request, args *ReportArgs) error {
+	s.generated <- args.Name + " " + r.Header.Get(rpcserver.RequestIDHeader)
+	return nil
+}
+
+type Exports struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *Exports) Run(r *http.Request, args *struct{}) error {
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestSchedules(t *testing.T) {
+	now := time.Date(2026, 10, 14, 1, 30, 0, 0, time.UTC)
+	if next := Daily(2, 0, nil).Next(now); !next.Equal(time.Date(2026, 10, 14, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected daily run %v", next)
+	}
+	if next := Daily(1, 0, nil).Next(now); !next.Equal(time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected daily run %v", next)
+	}
+	if next := At(now).Next(now); !next.IsZero() {
+		t.Errorf("expected no run, got %v", next)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	reports := &Reports{generated: make(chan string, 10)}
+	srv := rpcservertest.NewServer(t, reports)
+	defer srv.Close()
+	clock := rpcservertest.NewFakeClock(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+	scheduler := New(srv.RPC, "/rpc/")
+	scheduler.Clock = clock
+
+	if _, err := scheduler.Schedule("Missing", nil, Every(time.Minute)); err == nil {
+		t.Error("expected an unknown method to be refused")
+	}
+	if _, err := scheduler.Schedule("Generate", nil, Every(time.Second)); err == nil || !strings.Contains(err.Error(), "under the minimum of 1m0s") {
+		t.Errorf("expected a short interval to be refused, got %v", err)
+	}
+	once, err := scheduler.Schedule("Generate", &ReportArgs{Name: "daily"}, At(clock.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scheduler.Schedule("Generate", &ReportArgs{Name: "often"}, Every(40*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- scheduler.Run(ctx) }()
+
+	advance := func(d time.Duration) {
+		for clock.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(d)
+	}
+	advance(40 * time.Minute)
+	if got := <-reports.generated; got != "often schedule-2-1" {
+		t.Errorf("unexpected run %q", got)
+	}
+	advance(20 * time.Minute)
+	if got := <-reports.generated; got != "daily schedule-1-1" {
+		t.Errorf("unexpected run %q", got)
+	}
+	advance(20 * time.Minute)
+	if got := <-reports.generated; got != "often schedule-2-2" {
+		t.Errorf("unexpected run %q", got)
+	}
+	cancel()
+	<-done
+
+	jobs := scheduler.Jobs()
+	if len(jobs) != 1 || jobs[0].ID == once || jobs[0].Runs != 2 || jobs[0].LastRun.IsZero() {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+
+	w := httptest.NewRecorder()
+	scheduler.ServeHTTP(w, httptest.NewRequest("POST", "/schedule", strings.NewReader(`{"method": "Generate", "params": {"Name": "later"}, "in": "5m"}`)))
+	if w.Code != 201 || !strings.Contains(w.Body.String(), `"id":"3"`) || !strings.Contains(w.Body.String(), `"next":"2026-10-14T01:25:00Z"`) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	scheduler.ServeHTTP(w, httptest.NewRequest("POST", "/schedule", strings.NewReader(`{"method": "Generate", "in": "5m", "every": "1h"}`)))
+	if w.Code != 400 {
+		t.Errorf("expected two schedules to be refused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	scheduler.ServeHTTP(w, httptest.NewRequest("POST", "/schedule", strings.NewReader(`{"method": "Generate", "every": "1ms"}`)))
+	if w.Code != 400 {
+		t.Errorf("expected a short interval to be refused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	scheduler.ServeHTTP(w, httptest.NewRequest("DELETE", "/schedule?id=3", nil))
+	if w.Code != 204 || len(scheduler.Jobs()) != 1 {
+		t.Errorf("expected the job to be cancelled, got %d", w.Code)
+	}
+}
+
+func TestOverlappingRuns(t *testing.T) {
+	exports := &Exports{started: make(chan struct{}), release: make(chan struct{})}
+	srv := rpcservertest.NewServer(t, exports)
+	defer srv.Close()
+	clock := rpcservertest.NewFakeClock(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+	scheduler := New(srv.RPC, "/rpc/")
+	scheduler.Clock = clock
+	id, err := scheduler.Schedule("Run", nil, Every(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- scheduler.Run(ctx) }()
+
+	advance := func(d time.Duration) {
+		for clock.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(d)
+	}
+	advance(time.Minute)
+	<-exports.started
+	advance(time.Minute)
+	for scheduler.Jobs()[0].Skipped == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	exports.release <- struct{}{}
+	for scheduler.Jobs()[0].LastRun.IsZero() {
+		time.Sleep(time.Millisecond)
+	}
+	advance(time.Minute)
+	<-exports.started
+	exports.release <- struct{}{}
+	cancel()
+	<-done
+
+	if job := scheduler.Jobs()[0]; job.ID != id || job.Runs != 2 || job.Skipped != 1 {
+		t.Errorf("expected the overlapping run skipped, got %+v", job)
+	}
+}
@@ -0,0 +1,64 @@
+package rpcschedule
+
+import (
+	"time"
+)
+
+// Schedule tells when a job runs.
+type Schedule interface {
+	// Next returns the time of the first run after the time, or the zero
+	// time if there is none.
+	Next(after time.Time) time.Time
+}
+
+// At returns the Schedule of a single run at t.
+func At(t time.Time) Schedule {
+	return at(t)
+}
+
+type at time.Time
+
+func (s at) Next(after time.Time) time.Time {
+	if t := time.Time(s); t.After(after) {
+		return t
+	}
+	return time.Time{}
+}
+
+// Every returns the Schedule of runs every d, the first one d after the job
+// is scheduled.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (s every) Next(after time.Time) time.Time {
+	if s <= 0 {
+		return time.Time{}
+	}
+	return after.Add(time.Duration(s))
+}
+
+// Daily returns the Schedule of runs every day at the hour and the minute in
+// the location, time.UTC when nil.
+func Daily(hour, minute int, loc *time.Location) Schedule {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return daily{hour, minute, loc}
+}
+
+type daily struct {
+	hour, minute int
+	loc          *time.Location
+}
+
+func (s daily) Next(after time.Time) time.Time {
+	local := after.In(s.loc)
+	t := time.Date(local.Year(), local.Month(), local.Day(), s.hour, s.minute, 0, 0, s.loc)
+	if !t.After(after) {
+		t = time.Date(local.Year(), local.Month(), local.Day()+1, s.hour, s.minute, 0, 0, s.loc)
+	}
+	return t
+}
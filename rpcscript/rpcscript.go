@@ -0,0 +1,181 @@
+// Package rpcscript serves methods implemented by scripts of an embedded
+// language, such as Lua or Starlark, so that business rules change without
+// redeploying the server:
+//
+//	bridge := &rpcscript.Bridge{Server: server, Engine: starlarkEngine}
+//	if err := bridge.LoadFile("pricing", "/etc/myapp/pricing.star"); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// Every function of the script is then a method named after the script and
+// the function, e.g. "pricing.discount", with the params of the call as args.
+// Loading the script again, e.g. on SIGHUP, replaces its functions for the
+// next calls.
+//
+// The language is plugged in with an Engine, an adapter of its interpreter.
+package rpcscript
+
+import (
+	"context"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Engine compiles the scripts of a language.
+type Engine interface {
+	// Compile compiles the source of the script of the name.
+	Compile(name string, source []byte) (Program, error)
+}
+
+// Program is a compiled script.
+type Program interface {
+	// Functions returns the names of the functions the script defines.
+	Functions() []string
+
+	// Call calls the function with the args, the params of the call decoded
+	// from JSON: strings, float64 numbers, bools, nil,
+	// map[string]interface{} and []interface{} values. It returns the reply,
+	// converted back to JSON as by Convert. Long scripts should stop when
+	// ctx, the context of the request, is done.
+	Call(ctx context.Context, function string, args map[string]interface{}) (interface{}, error)
+}
+
+// Bridge registers the functions of the scripts of an Engine as methods of a
+// server.
+type Bridge struct {
+	// Server serves the functions of the scripts.
+	Server *rpcserver.Server
+
+	// Engine compiles the scripts.
+	Engine Engine
+
+	mu         sync.RWMutex
+	scripts    map[string]*script
+	registered map[string]bool // the methods registered with the server
+}
+
+// script is a loaded script.
+type script struct {
+	program   Program
+	functions map[string]bool
+}
+
+// Load compiles the source of the script, and serves its functions as methods
+// named name.function. A script loaded before under the name is replaced: the
+// functions it no longer defines fail with an error.
+func (b *Bridge) Load(name string, source []byte) error {
+	program, err := b.Engine.Compile(name, source)
+	if err != nil {
+		return fmt.Errorf("rpcscript: cannot compile %s: %v", name, err)
+	}
+	loaded := &script{program: program, functions: make(map[string]bool)}
+	for _, function := range program.Functions() {
+		loaded.functions[function] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.scripts == nil {
+		b.scripts = make(map[string]*script)
+		b.registered = make(map[string]bool)
+	}
+	for function := range loaded.functions {
+		method := name + "." + function
+		if b.registered[method] {
+			continue
+		}
+		if err := b.Server.RegisterBuiltin(method, scripted{b, name, function}, "Call"); err != nil {
+			return err
+		}
+		b.registered[method] = true
+	}
+	b.scripts[name] = loaded
+	return nil
+}
+
+// LoadFile loads the script of the file under name, see Load.
+func (b *Bridge) LoadFile(name, path string) error {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("rpcscript: %v", err)
+	}
+	return b.Load(name, source)
+}
+
+// Unload unloads the script of the name: its functions fail with an error.
+func (b *Bridge) Unload(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.scripts, name)
+}
+
+// Functions returns the methods of the loaded scripts in sorted order.
+func (b *Bridge) Functions() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var methods []string
+	for name, loaded := range b.scripts {
+		for function := range loaded.functions {
+			methods = append(methods, name+"."+function)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// scripted is the receiver of the method of a function of a script.
+type scripted struct {
+	bridge   *Bridge
+	script   string
+	function string
+}
+
+// Call calls the function of the script loaded last, and converts its reply.
+func (s scripted) Call(r *http.Request, args map[string]interface{}) (interface{}, error) {
+	s.bridge.mu.RLock()
+	loaded, ok := s.bridge.scripts[s.script]
+	s.bridge.mu.RUnlock()
+	if !ok || !loaded.functions[s.function] {
+		return nil, fmt.Errorf("rpcscript: script %s has no function %s", s.script, s.function)
+	}
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	reply, err := loaded.program.Call(r.Context(), s.function, args)
+	if err != nil {
+		return nil, err
+	}
+	return Convert(reply), nil
+}
+
+// Convert converts the value of a script into a value encodable as JSON: the
+// maps of other keys, such as the map[interface{}]interface{} of Lua tables,
+// into map[string]interface{} with the keys formatted by fmt, recursively
+// along with the elements of []interface{}.
+func Convert(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			converted[fmt.Sprint(key)] = Convert(value)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			converted[key] = Convert(value)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, value := range v {
+			converted[i] = Convert(value)
+		}
+		return converted
+	}
+	return v
+}
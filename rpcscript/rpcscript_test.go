@@ -0,0 +1,106 @@
+package rpcscript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type Rules int
+
+func (r *Rules) Version(req *http.Request, args *struct{}, reply *string) error {
+	*reply = "1"
+	return nil
+}
+
+// scaleEngine compiles scripts of lines "function arg factor", functions
+// replying with the arg of the name times the factor.
+type scaleEngine struct{}
+
+type scaleProgram map[string]struct {
+	arg    string
+	factor float64
+}
+
+func (scaleEngine) Compile(name string, source []byte) (Program, error) {
+	program := scaleProgram{}
+	for _, line := range strings.Split(strings.TrimSpace(string(source)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		factor, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		program[fields[0]] = struct {
+			arg    string
+			factor float64
+		}{fields[1], factor}
+	}
+	return program, nil
+}
+
+func (p scaleProgram) Functions() []string {
+	var functions []string
+	for function := range p {
+		functions = append(functions, function)
+	}
+	return functions
+}
+
+func (p scaleProgram) Call(ctx context.Context, function string, args map[string]interface{}) (interface{}, error) {
+	f := p[function]
+	x, ok := args[f.arg].(float64)
+	if !ok {
+		return nil, errors.New("no " + f.arg)
+	}
+	return map[interface{}]interface{}{"value": x * f.factor, 1: []interface{}{f.arg}}, nil
+}
+
+func TestBridge(t *testing.T) {
+	srv := rpcservertest.NewServer(t, new(Rules))
+	defer srv.Close()
+	bridge := &Bridge{Server: srv.RPC, Engine: scaleEngine{}}
+	if err := bridge.Load("pricing", []byte("discount price 0.9\nsurcharge price 1.5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.Load("broken", []byte("discount price")); err == nil || !strings.Contains(err.Error(), "cannot compile broken") {
+		t.Errorf("expected a compile error, got %v", err)
+	}
+	if functions := bridge.Functions(); !reflect.DeepEqual(functions, []string{"pricing.discount", "pricing.surcharge"}) {
+		t.Errorf("unexpected functions %v", functions)
+	}
+
+	var reply map[string]interface{}
+	srv.MustCall("pricing.discount", map[string]interface{}{"price": 100}, &reply)
+	rpcservertest.AssertReply(t, reply, map[string]interface{}{"value": 90.0, "1": []interface{}{"price"}})
+	if err := srv.Call("pricing.discount", map[string]interface{}{}, &reply); err == nil || !strings.Contains(err.Error(), "no price") {
+		t.Errorf("expected the error of the script, got %v", err)
+	}
+
+	// Reloading replaces the functions.
+	if err := bridge.Load("pricing", []byte("discount price 0.5")); err != nil {
+		t.Fatal(err)
+	}
+	srv.MustCall("pricing.discount", map[string]interface{}{"price": 100}, &reply)
+	if reply["value"] != 50.0 {
+		t.Errorf("expected the reloaded discount, got %v", reply)
+	}
+	if err := srv.Call("pricing.surcharge", map[string]interface{}{"price": 100}, &reply); err == nil || !strings.Contains(err.Error(), "no function surcharge") {
+		t.Errorf("expected the removed function to fail, got %v", err)
+	}
+
+	bridge.Unload("pricing")
+	if err := srv.Call("pricing.discount", map[string]interface{}{"price": 100}, &reply); err == nil {
+		t.Error("expected the unloaded script to fail")
+	}
+	var version string
+	srv.MustCall("Version", &struct{}{}, &version)
+}
@@ -0,0 +1,230 @@
+package rpcservertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Contract fixtures
+// ----------------------------------------------------------------------------
+
+// Fixture is a recorded request/response pair of a method.
+type Fixture struct {
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	ContentType string          `json:"contentType,omitempty"`
+	Request     json.RawMessage `json:"request"`
+	Status      int             `json:"status"`
+	Response    json.RawMessage `json:"response"`
+}
+
+// Recorder is an http.Handler passing requests to Handler and recording every
+// request/response pair. Save writes them as golden files, one per method.
+//
+// Record fixtures once with real traffic, e.g. when tests run with an
+// -update flag, and check them in; Verify then fails on drift.
+type Recorder struct {
+	Handler http.Handler
+	Dir     string
+
+	mu       sync.Mutex
+	fixtures map[string][]*Fixture
+}
+
+// NewRecorder creates a Recorder saving golden files into dir.
+func NewRecorder(h http.Handler, dir string) *Recorder {
+	return &Recorder{
+		Handler:  h,
+		Dir:      dir,
+		fixtures: make(map[string][]*Fixture),
+	}
+}
+
+// ServeHTTP serves the request with the wrapped handler and records it.
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		rpcserver.WriteError(w, 400, err.Error())
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	resp := httptest.NewRecorder()
+	rec.Handler.ServeHTTP(resp, r)
+	for key, values := range resp.Header() {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.Code)
+	w.Write(resp.Body.Bytes())
+
+	method := rpcserver.LastPart(r.URL.Path)
+	fixture := &Fixture{
+		Method:      method,
+		Path:        r.URL.Path,
+		ContentType: r.Header.Get("Content-Type"),
+		Request:     asJSON(body),
+		Status:      resp.Code,
+		Response:    asJSON(resp.Body.Bytes()),
+	}
+	rec.mu.Lock()
+	rec.fixtures[method] = append(rec.fixtures[method], fixture)
+	rec.mu.Unlock()
+}
+
+// Save writes the recorded fixtures to <Dir>/<method>.json, replacing
+// previously recorded files of the same methods.
+func (rec *Recorder) Save() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := os.MkdirAll(rec.Dir, 0755); err != nil {
+		return err
+	}
+	for method, fixtures := range rec.fixtures {
+		data, err := json.MarshalIndent(fixtures, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(rec.Dir, method+".json"), append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify replays the golden files found in dir against h and fails the test
+// on a differing status, response shape (schema drift) or response values
+// (behavior drift).
+func Verify(t testing.TB, h http.Handler, dir string) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("rpcservertest: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("rpcservertest: no fixtures in %s", dir)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("rpcservertest: %v", err)
+		}
+		var fixtures []*Fixture
+		if err := json.Unmarshal(data, &fixtures); err != nil {
+			t.Fatalf("rpcservertest: %s: %v", file, err)
+		}
+		for i, fixture := range fixtures {
+			for _, problem := range replay(h, fixture) {
+				t.Errorf("rpcservertest: %s #%d: %s", fixture.Method, i, problem)
+			}
+		}
+	}
+}
+
+// replay sends the fixture request to h and lists the differences found.
+func replay(h http.Handler, fixture *Fixture) []string {
+	body := []byte(fixture.Request)
+	var str string
+	if json.Unmarshal(fixture.Request, &str) == nil {
+		body = []byte(str) // non-JSON body recorded as a string
+	}
+	req := httptest.NewRequest("POST", fixture.Path, bytes.NewReader(body))
+	if fixture.ContentType != "" {
+		req.Header.Set("Content-Type", fixture.ContentType)
+	}
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	var problems []string
+	if resp.Code != fixture.Status {
+		problems = append(problems, fmt.Sprintf("behavior drift: status %d, recorded %d", resp.Code, fixture.Status))
+	}
+	var got, want interface{}
+	json.Unmarshal(asJSON(resp.Body.Bytes()), &got)
+	json.Unmarshal(fixture.Response, &want)
+	return append(problems, compare("$", got, want)...)
+}
+
+// compare walks two decoded JSON values and describes how got differs from
+// want. Missing members and changed types are schema drift, changed values
+// are behavior drift.
+func compare(path string, got interface{}, want interface{}) []string {
+	if kindOf(got) != kindOf(want) {
+		return []string{fmt.Sprintf("schema drift: %s is %s, recorded %s", path, kindOf(got), kindOf(want))}
+	}
+	var problems []string
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g := got.(map[string]interface{})
+		keys := make([]string, 0, len(w)+len(g))
+		for key := range w {
+			keys = append(keys, key)
+		}
+		for key := range g {
+			if _, ok := w[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			problems = append(problems, compare(path+"."+key, g[key], w[key])...)
+		}
+	case []interface{}:
+		g := got.([]interface{})
+		if len(g) != len(w) {
+			problems = append(problems, fmt.Sprintf("behavior drift: %s has %d items, recorded %d", path, len(g), len(w)))
+		}
+		for i := 0; i < len(g) && i < len(w); i++ {
+			problems = append(problems, compare(fmt.Sprintf("%s[%d]", path, i), g[i], w[i])...)
+		}
+	default:
+		if !reflect.DeepEqual(got, want) {
+			problems = append(problems, fmt.Sprintf("behavior drift: %s is %v, recorded %v", path, got, want))
+		}
+	}
+	return problems
+}
+
+// kindOf names the JSON type of a decoded value.
+func kindOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "missing"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "unknown"
+}
+
+// asJSON returns data if it is valid JSON, or data encoded as a JSON string.
+func asJSON(data []byte) json.RawMessage {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && json.Valid(trimmed) {
+		return json.RawMessage(trimmed)
+	}
+	encoded, _ := json.Marshal(strings.TrimSpace(string(data)))
+	return encoded
+}
@@ -0,0 +1,122 @@
+// Package rpcservertest provides utilities for testing RPC services served by
+// rpcserver.Server, without repeating the HTTP plumbing in every test suite.
+//
+//	srv := rpcservertest.NewServer(t, new(Arith))
+//	defer srv.Close()
+//
+//	var quo Quotient
+//	srv.MustCall("Divide", &Args{A: 10, B: 3}, &quo)
+//	rpcservertest.AssertError(t, srv.Call("Divide", &Args{A: 1}, &quo), 400)
+package rpcservertest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Path is the prefix the RPC server is mounted on.
+const Path = "/rpc"
+
+// Server is an rpcserver.Server with the JSON-RPC 2.0 codec listening on a
+// local httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	// RPC is the server under test, register extra codecs here.
+	RPC *rpcserver.Server
+
+	// Client calls the server under test.
+	Client *rpcclient.Client
+
+	// Timeout bounds every call made by Call and MustCall when positive.
+	Timeout time.Duration
+
+	t testing.TB
+}
+
+// NewServer starts a Server serving the methods of receiver. The test fails
+// if the receiver can't be registered. Callers should Close the server.
+func NewServer(t testing.TB, receiver interface{}) *Server {
+	t.Helper()
+	rpc, err := rpcserver.NewServer(receiver)
+	if err != nil {
+		t.Fatalf("rpcservertest: %v", err)
+	}
+	rpc.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+
+	ts := httptest.NewServer(rpc)
+	return &Server{
+		Server:  ts,
+		RPC:     rpc,
+		Client:  rpcclient.NewClient(ts.URL + Path),
+		Timeout: 10 * time.Second,
+		t:       t,
+	}
+}
+
+// Call invokes the method and decodes the result into reply.
+func (s *Server) Call(method string, args interface{}, reply interface{}, opts ...rpcclient.CallOption) error {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return s.Client.Call(ctx, method, args, reply, opts...)
+}
+
+// MustCall invokes the method and fails the test if the call returns an error.
+func (s *Server) MustCall(method string, args interface{}, reply interface{}, opts ...rpcclient.CallOption) {
+	s.t.Helper()
+	if err := s.Call(method, args, reply, opts...); err != nil {
+		s.t.Fatalf("rpcservertest: %s failed: %v", method, err)
+	}
+}
+
+// AssertError fails the test unless err is a JSON-RPC error with the code.
+// It returns the error for further checks of its message and data.
+func AssertError(t testing.TB, err error, code int) *jsonrpc2.Error {
+	t.Helper()
+	rpcErr, ok := err.(*jsonrpc2.Error)
+	if !ok {
+		t.Fatalf("rpcservertest: expected JSON-RPC error %d, got %#v", code, err)
+	}
+	if rpcErr.Code != code {
+		t.Fatalf("rpcservertest: expected error code %d, got %d: %s", code, rpcErr.Code, rpcErr.Message)
+	}
+	return rpcErr
+}
+
+// AssertReply fails the test unless got and want encode to the same JSON.
+func AssertReply(t testing.TB, got interface{}, want interface{}) {
+	t.Helper()
+	if !JSONEqual(got, want) {
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		t.Fatalf("rpcservertest: reply mismatch\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+}
+
+// JSONEqual returns true if a and b encode to equal JSON values.
+func JSONEqual(a interface{}, b interface{}) bool {
+	var av, bv interface{}
+	if !roundTrip(a, &av) || !roundTrip(b, &bv) {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func roundTrip(v interface{}, out *interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, out) == nil
+}
@@ -0,0 +1,110 @@
+package rpcservertest
+
+import (
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcclient"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	quo.Quo = args.A / args.B
+	quo.Rem = args.A % args.B
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t, new(Arith))
+	defer srv.Close()
+
+	var quo Quotient
+	srv.MustCall("Divide", &Args{A: 10, B: 3}, &quo)
+	AssertReply(t, quo, map[string]int{"Quo": 3, "Rem": 1})
+
+	err := AssertError(t, srv.Call("Divide", &Args{A: 1}, &quo), 400)
+	if err.Message != "divide by zero" {
+		t.Fatalf("unexpected message %q", err.Message)
+	}
+	AssertError(t, srv.Call("Divide", []string{"bad"}, &quo), jsonrpc2.E_INVALID_REQ)
+}
+
+func TestContract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := NewServer(t, new(Arith))
+	defer srv.Close()
+	rec := NewRecorder(srv.RPC, dir)
+	srv.Config.Handler = rec
+
+	var quo Quotient
+	srv.MustCall("Divide", &Args{A: 7, B: 2}, &quo)
+	srv.Call("Divide", &Args{A: 7}, &quo)
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	Verify(t, srv.RPC, dir)
+
+	problems := compare("$", map[string]interface{}{"Quo": 3.0, "Extra": true}, map[string]interface{}{"Quo": 4.0, "Rem": 1.0})
+	expected := []string{
+		"schema drift: $.Extra is boolean, recorded missing",
+		"behavior drift: $.Quo is 3, recorded 4",
+		"schema drift: $.Rem is missing, recorded number",
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("unexpected problems %q", problems)
+	}
+}
+
+type Sleeper struct{}
+
+func (s *Sleeper) Sleep(r *http.Request, args *int) error {
+	<-r.Context().Done()
+	return r.Context().Err()
+}
+
+func TestFakeClock(t *testing.T) {
+	srv := NewServer(t, new(Sleeper))
+	defer srv.Close()
+	clock := NewFakeClock(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+	srv.RPC.Clock = clock
+
+	go func() {
+		for clock.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(5 * time.Second)
+	}()
+	err := srv.Call("Sleep", 1, nil, rpcclient.WithHeader(rpcserver.DeadlineHeader, "5S"))
+	AssertError(t, err, jsonrpc2.E_DEADLINE_EXCEEDED)
+	if methods := srv.RPC.Stats().Methods; len(methods) != 1 || methods[0].Latency != 5*time.Second {
+		t.Fatalf("expected a latency of 5s on the fake clock, got %+v", methods)
+	}
+
+	timer := clock.NewTimer(time.Second)
+	if !timer.Stop() || timer.Stop() {
+		t.Fatalf("expected a pending timer to stop once")
+	}
+}
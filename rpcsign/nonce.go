@@ -0,0 +1,88 @@
+package rpcsign
+
+import (
+	"context"
+	"github.com/datalinkE/rpcserver"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers the nonces of the verified requests until they expire,
+// so a Verifier rejects the replayed ones. Implementations shared by several
+// servers, such as a RedisNonceStore, protect all of them at once.
+type NonceStore interface {
+	// Add records the nonce until expires. It returns false if the nonce is
+	// already recorded, atomically.
+	Add(ctx context.Context, nonce string, expires time.Time) (bool, error)
+}
+
+// MemoryNonceStore is a NonceStore of a single process. The zero value is
+// ready to use.
+type MemoryNonceStore struct {
+	// Clock expires the nonces, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // expiry by nonce
+	pruned time.Time            // last removal of the expired nonces
+}
+
+// NewMemoryNonceStore creates a MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+// Add records the nonce until expires.
+func (s *MemoryNonceStore) Add(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	now := rpcserver.ClockOrSystem(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nonces == nil {
+		s.nonces = make(map[string]time.Time)
+	}
+	if now.Sub(s.pruned) >= time.Minute {
+		for n, at := range s.nonces {
+			if !at.After(now) {
+				delete(s.nonces, n)
+			}
+		}
+		s.pruned = now
+	}
+	if at, ok := s.nonces[nonce]; ok && at.After(now) {
+		return false, nil
+	}
+	s.nonces[nonce] = expires
+	return true, nil
+}
+
+// RedisNonceStore is a NonceStore in Redis, shared by the servers using the
+// same Redis. SetNX runs the SET key value NX PX ttl command of a client,
+// e.g. with github.com/redis/go-redis:
+//
+//	store := &rpcsign.RedisNonceStore{SetNX: func(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+//		return rdb.SetNX(ctx, key, 1, ttl).Result()
+//	}}
+type RedisNonceStore struct {
+	SetNX func(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Prefix is prepended to the nonces to make their keys, "rpc:nonce:"
+	// when empty.
+	Prefix string
+
+	// Clock computes the time to live of the nonces, rpcserver.SystemClock
+	// when nil.
+	Clock rpcserver.Clock
+}
+
+// Add records the nonce until expires.
+func (s *RedisNonceStore) Add(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "rpc:nonce:"
+	}
+	ttl := expires.Sub(rpcserver.ClockOrSystem(s.Clock).Now())
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return s.SetNX(ctx, prefix+nonce, ttl)
+}
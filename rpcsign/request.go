@@ -0,0 +1,276 @@
+package rpcsign
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Scheme is the scheme of the Authorization header of signed requests:
+//
+//	Authorization: RPC-HMAC-SHA256 KeyId=<key id>, Signature=<hex digest>
+//
+// The signature is the HMAC-SHA256 of the canonical request, the lines
+//
+//	<method>
+//	<path>
+//	<query>
+//	<TimestampHeader>
+//	<NonceHeader>
+//	<hex SHA-256 of the body>
+//
+// joined with newlines.
+const Scheme = "RPC-HMAC-SHA256"
+
+// ErrUnsigned is returned for requests without a valid signature.
+var ErrUnsigned = errors.New("rpcsign: request not signed")
+
+// ErrReplayed is returned for the requests whose nonce was already used.
+var ErrReplayed = errors.New("rpcsign: request replayed")
+
+// SignRequest signs r with the key named keyID at now, reading its body and
+// replacing it with a copy.
+func SignRequest(r *http.Request, keyID string, key []byte, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	r.Header.Set(rpcserver.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	r.Header.Set(rpcserver.NonceHeader, hex.EncodeToString(nonce[:]))
+	signature := hmacSum(key, canonicalRequest(r, body))
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", Scheme, keyID, hex.EncodeToString(signature)))
+	return nil
+}
+
+// Transport signs the requests it sends, e.g. as the transport of the
+// HTTPClient of an rpcclient.Client.
+type Transport struct {
+	KeyID string
+	Key   []byte
+
+	// Base sends the requests, http.DefaultTransport when nil.
+	Base http.RoundTripper
+
+	// Clock times the requests, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+}
+
+// RoundTrip signs a copy of the request and sends it.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if err := SignRequest(r, t.KeyID, t.Key, rpcserver.ClockOrSystem(t.Clock).Now()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// defaultMaxBodyBytes is the MaxBodyBytes of a Verifier when zero.
+const defaultMaxBodyBytes = 1 << 20
+
+// Verifier rejects the requests not signed by one of its keys with 401.
+type Verifier struct {
+	// Keys returns the key named by the KeyId of a request, false if there is
+	// none.
+	Keys func(keyID string) ([]byte, bool)
+
+	// MaxSkew bounds the difference between the TimestampHeader of requests
+	// and the time they are received, 5 minutes when zero.
+	MaxSkew time.Duration
+
+	// MaxBodyBytes limits the size of the bodies read to be verified, 1 MiB
+	// when zero and none when negative: larger requests are rejected with
+	// 413.
+	MaxBodyBytes int64
+
+	// Nonces rejects the replayed requests when set: a nonce is accepted
+	// once for a key within the timestamp window.
+	Nonces NonceStore
+
+	// Clock times the requests, rpcserver.SystemClock when nil.
+	Clock rpcserver.Clock
+
+	// ErrorWriter writes the rejections, rpcserver.TextErrorWriter when nil.
+	ErrorWriter rpcserver.ErrorWriter
+
+	verified, rejected, replayed int64
+}
+
+// VerifierStats holds counters of the requests checked by a Verifier.
+type VerifierStats struct {
+	// Verified counts the requests passed.
+	Verified int64
+
+	// Rejected counts the requests rejected, the replayed ones included.
+	Rejected int64
+
+	// Replayed counts the requests rejected with ErrReplayed.
+	Replayed int64
+}
+
+// Stats returns a snapshot of the counters of the verifier.
+func (v *Verifier) Stats() VerifierStats {
+	return VerifierStats{
+		Verified: atomic.LoadInt64(&v.verified),
+		Rejected: atomic.LoadInt64(&v.rejected),
+		Replayed: atomic.LoadInt64(&v.replayed),
+	}
+}
+
+// StaticKeys returns a Verifier.Keys function looking up a map.
+func StaticKeys(keys map[string][]byte) func(keyID string) ([]byte, bool) {
+	return func(keyID string) ([]byte, bool) {
+		key, ok := keys[keyID]
+		return key, ok
+	}
+}
+
+// Handler returns a handler passing the signed requests to next.
+func (v *Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, err := v.verify(r); err != nil {
+			atomic.AddInt64(&v.rejected, 1)
+			if errors.Is(err, ErrReplayed) {
+				atomic.AddInt64(&v.replayed, 1)
+			}
+			res := rpcserver.NewErrorResponse(r, status, err)
+			if v.ErrorWriter != nil {
+				v.ErrorWriter.WriteErrorResponse(w, r, res)
+			} else {
+				rpcserver.TextErrorWriter{}.WriteErrorResponse(w, r, res)
+			}
+			return
+		}
+		atomic.AddInt64(&v.verified, 1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verify checks the signature of r, it returns the status and the error of
+// its rejection.
+func (v *Verifier) verify(r *http.Request) (int, error) {
+	keyID, signature, ok := parseAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return 401, ErrUnsigned
+	}
+	key, ok := v.Keys(keyID)
+	if !ok {
+		return 401, fmt.Errorf("%w: unknown key %s", ErrUnsigned, keyID)
+	}
+	if r.Header.Get(rpcserver.NonceHeader) == "" {
+		return 401, fmt.Errorf("%w: missing %s", ErrUnsigned, rpcserver.NonceHeader)
+	}
+	sent, err := strconv.ParseInt(r.Header.Get(rpcserver.TimestampHeader), 10, 64)
+	if err != nil {
+		return 401, fmt.Errorf("%w: invalid %s", ErrUnsigned, rpcserver.TimestampHeader)
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = 5 * time.Minute
+	}
+	sentAt := time.Unix(sent, 0)
+	if skew := rpcserver.ClockOrSystem(v.Clock).Now().Sub(sentAt); skew > maxSkew || skew < -maxSkew {
+		return 401, fmt.Errorf("%w: timestamp out of the %s window", ErrUnsigned, maxSkew)
+	}
+	limit := v.MaxBodyBytes
+	if limit == 0 {
+		limit = defaultMaxBodyBytes
+	}
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, limit)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return 413, fmt.Errorf("rpc: request body exceeds %d bytes", limit)
+		}
+		return 400, err
+	}
+	if !hmac.Equal(signature, hmacSum(key, canonicalRequest(r, body))) {
+		return 401, fmt.Errorf("%w: signature mismatch", ErrUnsigned)
+	}
+	if v.Nonces != nil {
+		// Requests are accepted until maxSkew after they were sent.
+		added, err := v.Nonces.Add(r.Context(), keyID+":"+r.Header.Get(rpcserver.NonceHeader), sentAt.Add(maxSkew))
+		if err != nil {
+			return 503, fmt.Errorf("rpcsign: cannot record the nonce: %v", err)
+		}
+		if !added {
+			return 401, ErrReplayed
+		}
+	}
+	return 0, nil
+}
+
+// parseAuthorization returns the key id and the signature of an
+// Authorization header of the Scheme.
+func parseAuthorization(value string) (string, []byte, bool) {
+	if !strings.HasPrefix(value, Scheme+" ") {
+		return "", nil, false
+	}
+	var keyID, signature string
+	for _, param := range strings.Split(value[len(Scheme)+1:], ",") {
+		param = strings.TrimSpace(param)
+		switch {
+		case strings.HasPrefix(param, "KeyId="):
+			keyID = param[len("KeyId="):]
+		case strings.HasPrefix(param, "Signature="):
+			signature = param[len("Signature="):]
+		}
+	}
+	digest, err := hex.DecodeString(signature)
+	if keyID == "" || err != nil || len(digest) == 0 {
+		return "", nil, false
+	}
+	return keyID, digest, true
+}
+
+// canonicalRequest returns the signed representation of r.
+func canonicalRequest(r *http.Request, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		r.Header.Get(rpcserver.TimestampHeader),
+		r.Header.Get(rpcserver.NonceHeader),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n"))
+}
+
+// readBody reads the body of r and replaces it with a copy.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
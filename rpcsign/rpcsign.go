@@ -0,0 +1,189 @@
+// Package rpcsign signs the responses of an rpcserver.Server, with
+// HMAC-SHA256 or as JSON Web Signatures with a detached payload, and verifies
+// them for their consumers:
+//
+//	server.Signer = rpcsign.HMAC(key)
+//
+//	// On the client, once the body is read:
+//	err := rpcsign.VerifyHMAC(key, body, res.Header.Get(rpcserver.SignatureHeader))
+//
+// It signs requests the same way for machine-to-machine traffic, with a
+// Transport on the client and a Verifier on the server:
+//
+//	client.HTTPClient = &http.Client{Transport: &rpcsign.Transport{KeyID: "billing", Key: key}}
+//
+//	verifier := &rpcsign.Verifier{Keys: rpcsign.StaticKeys(map[string][]byte{"billing": key})}
+//	http.Handle("/rpc/", verifier.Handler(server))
+package rpcsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"math/big"
+	"strings"
+)
+
+// ErrInvalidSignature is returned for signatures not matching the body.
+var ErrInvalidSignature = errors.New("rpcsign: invalid signature")
+
+// HMAC returns a Signer of HMAC-SHA256 signatures, "sha256=<hex digest>".
+func HMAC(key []byte) rpcserver.Signer {
+	return hmacSigner(key)
+}
+
+type hmacSigner []byte
+
+func (key hmacSigner) Sign(body []byte) (string, error) {
+	return "sha256=" + hex.EncodeToString(hmacSum(key, body)), nil
+}
+
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// VerifyHMAC checks an HMAC signature of the body.
+func VerifyHMAC(key, body []byte, signature string) error {
+	digest, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") || !hmac.Equal(digest, hmacSum(key, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// JWS is a Signer of JSON Web Signatures with a detached payload (RFC 7515,
+// appendix F), "<protected header>..<signature>". The algorithm follows the
+// Key: HS256 for a []byte, RS256 for an *rsa.PrivateKey and ES256 for an
+// *ecdsa.PrivateKey on P-256.
+type JWS struct {
+	Key interface{}
+
+	// KeyID is the kid of the protected header when set.
+	KeyID string
+}
+
+// jwsHeader is the protected header of a JWS.
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Sign returns the detached JWS of the body.
+func (j *JWS) Sign(body []byte) (string, error) {
+	alg, err := algorithm(j.Key)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(jwsHeader{Alg: alg, Kid: j.KeyID})
+	protected := encode(header)
+	input := []byte(protected + "." + encode(body))
+	var signature []byte
+	switch key := j.Key.(type) {
+	case []byte:
+		signature = hmacSum(key, input)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(input)
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return "", err
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return protected + ".." + encode(signature), nil
+}
+
+// algorithm returns the JWS algorithm of a signing or verifying key.
+func algorithm(key interface{}) (string, error) {
+	switch key := key.(type) {
+	case []byte:
+		return "HS256", nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			return "ES256", nil
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return "ES256", nil
+		}
+	}
+	return "", fmt.Errorf("rpcsign: unsupported key %T", key)
+}
+
+// VerifyJWS checks a detached JWS of the body with the key: the []byte of
+// HS256, an *rsa.PublicKey for RS256 or an *ecdsa.PublicKey for ES256. The
+// algorithm of the signature must be the one of the key.
+func VerifyJWS(signature string, body []byte, key interface{}) error {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return ErrInvalidSignature
+	}
+	rawHeader, err1 := decode(parts[0])
+	sig, err2 := decode(parts[2])
+	var header jwsHeader
+	if err1 != nil || err2 != nil || json.Unmarshal(rawHeader, &header) != nil {
+		return ErrInvalidSignature
+	}
+	alg, err := algorithm(key)
+	if err != nil {
+		return err
+	}
+	if header.Alg != alg {
+		return fmt.Errorf("%w: algorithm %s, expected %s", ErrInvalidSignature, header.Alg, alg)
+	}
+	input := []byte(parts[0] + "." + encode(body))
+	digest := sha256.Sum256(input)
+	valid := false
+	switch key := key.(type) {
+	case []byte:
+		valid = hmac.Equal(sig, hmacSum(key, input))
+	case *rsa.PrivateKey:
+		valid = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig) == nil
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PrivateKey:
+		valid = verifyES256(&key.PublicKey, digest[:], sig)
+	case *ecdsa.PublicKey:
+		valid = verifyES256(key, digest[:], sig)
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func verifyES256(key *ecdsa.PublicKey, digest, sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	return ecdsa.Verify(key, digest, r, s)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
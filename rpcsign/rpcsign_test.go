@@ -0,0 +1,239 @@
+package rpcsign
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Echo struct{}
+
+func (e *Echo) Say(r *http.Request, args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func serve(t *testing.T, signer rpcserver.Signer) *httptest.ResponseRecorder {
+	server, err := rpcserver.NewServer(new(Echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	server.Signer = signer
+	r := httptest.NewRequest("POST", "/rpc/Say", strings.NewReader(`{"jsonrpc": "2.0", "method": "Say", "id": 1, "params": "hi"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"result":"hi"`) {
+		t.Fatalf("unexpected response %s", w.Body)
+	}
+	return w
+}
+
+func TestHMAC(t *testing.T) {
+	key := []byte("secret")
+	w := serve(t, HMAC(key))
+	signature := w.Header().Get(rpcserver.SignatureHeader)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("unexpected signature %q", signature)
+	}
+	if err := VerifyHMAC(key, w.Body.Bytes(), signature); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyHMAC([]byte("other"), w.Body.Bytes(), signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature, got %v", err)
+	}
+	if err := VerifyHMAC(key, append(w.Body.Bytes(), ' '), signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature of a modified body, got %v", err)
+	}
+}
+
+func TestJWS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name          string
+		key           interface{}
+		verify, wrong interface{}
+	}{
+		{"HS256", []byte("secret"), []byte("secret"), []byte("other")},
+		{"RS256", rsaKey, &rsaKey.PublicKey, &ecKey.PublicKey},
+		{"ES256", ecKey, &ecKey.PublicKey, []byte("secret")},
+	} {
+		w := serve(t, &JWS{Key: tc.key, KeyID: "k1"})
+		signature := w.Header().Get(rpcserver.SignatureHeader)
+		if parts := strings.Split(signature, "."); len(parts) != 3 || parts[1] != "" {
+			t.Fatalf("%s: expected a detached JWS, got %q", tc.name, signature)
+		}
+		if err := VerifyJWS(signature, w.Body.Bytes(), tc.verify); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if err := VerifyJWS(signature, w.Body.Bytes(), tc.wrong); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected an invalid signature with another key, got %v", tc.name, err)
+		}
+		if err := VerifyJWS(signature, []byte(`{}`), tc.verify); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected an invalid signature of another body, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	clock := rpcservertest.NewFakeClock(now)
+	verifier := &Verifier{Keys: StaticKeys(map[string][]byte{"k1": []byte("secret")}), Clock: clock, MaxBodyBytes: 200}
+	handler := verifier.Handler(server)
+	body := `{"jsonrpc": "2.0", "method": "Say", "id": 1, "params": "hi"}`
+	request := func(keyID string, key []byte, sent time.Time, tamper func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/rpc/Say?x=1", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if key != nil {
+			if err := SignRequest(r, keyID, key, sent); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tamper != nil {
+			tamper(r)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	if w := request("k1", []byte("secret"), now, nil); w.Code != 200 || !strings.Contains(w.Body.String(), `"result":"hi"`) {
+		t.Errorf("expected a signed request to pass, got %d %s", w.Code, w.Body)
+	}
+	for _, tc := range []struct {
+		name     string
+		keyID    string
+		key      []byte
+		sent     time.Time
+		tamper   func(r *http.Request)
+		status   int
+		expected string
+	}{
+		{"unsigned", "", nil, now, nil, 401, "request not signed"},
+		{"unknown key", "k2", []byte("secret"), now, nil, 401, "unknown key k2"},
+		{"wrong key", "k1", []byte("other"), now, nil, 401, "signature mismatch"},
+		{"stale", "k1", []byte("secret"), now.Add(-10 * time.Minute), nil, 401, "timestamp out of the 5m0s window"},
+		{"modified body", "k1", []byte("secret"), now, func(r *http.Request) {
+			r.Body = ioutil.NopCloser(strings.NewReader(strings.Replace(body, "hi", "ho", 1)))
+		}, 401, "signature mismatch"},
+		{"modified query", "k1", []byte("secret"), now, func(r *http.Request) { r.URL.RawQuery = "x=2" }, 401, "signature mismatch"},
+		{"large body", "k1", []byte("secret"), now, func(r *http.Request) {
+			r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat(" ", 300)))
+		}, 413, "exceeds 200 bytes"},
+	} {
+		if w := request(tc.keyID, tc.key, tc.sent, tc.tamper); w.Code != tc.status || !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.name, tc.status, tc.expected, w.Code, w.Body)
+		}
+	}
+
+	handler = (&Verifier{Keys: verifier.Keys, Clock: clock}).Handler(server)
+	if w := request("k1", []byte("secret"), now, func(r *http.Request) {
+		r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat(" ", 2<<20)))
+	}); w.Code != 413 || !strings.Contains(w.Body.String(), "exceeds 1048576 bytes") {
+		t.Errorf("expected the bodies over 1 MiB rejected by default, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	verifier := &Verifier{Keys: StaticKeys(map[string][]byte{"k1": []byte("secret")})}
+	ts := httptest.NewServer(verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	defer ts.Close()
+	client := &http.Client{Transport: &Transport{KeyID: "k1", Key: []byte("secret")}}
+	res, err := client.Post(ts.URL+"/rpc/Say", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Errorf("expected a signed request, got %d", res.StatusCode)
+	}
+}
+
+func TestReplays(t *testing.T) {
+	server := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	clock := rpcservertest.NewFakeClock(now)
+	store := &MemoryNonceStore{Clock: clock}
+	verifier := &Verifier{Keys: StaticKeys(map[string][]byte{"k1": []byte("secret")}), Nonces: store, Clock: clock}
+	handler := verifier.Handler(server)
+
+	r := httptest.NewRequest("POST", "/rpc/Say", strings.NewReader(`{}`))
+	if err := SignRequest(r, "k1", []byte("secret"), now); err != nil {
+		t.Fatal(err)
+	}
+	send := func() *httptest.ResponseRecorder {
+		replay := r.Clone(r.Context())
+		replay.Body, _ = r.GetBody()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, replay)
+		return w
+	}
+	if w := send(); w.Code != 200 {
+		t.Fatalf("expected the first request to pass, got %d %s", w.Code, w.Body)
+	}
+	if w := send(); w.Code != 401 || !strings.Contains(w.Body.String(), "request replayed") {
+		t.Errorf("expected a replay to be rejected, got %d %s", w.Code, w.Body)
+	}
+	clock.Advance(6 * time.Minute)
+	if w := send(); w.Code != 401 || !strings.Contains(w.Body.String(), "timestamp out of") {
+		t.Errorf("expected a stale replay to be rejected, got %d %s", w.Code, w.Body)
+	}
+	if stats := verifier.Stats(); stats != (VerifierStats{Verified: 1, Rejected: 2, Replayed: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if ok, _ := store.Add(context.Background(), "k1:fresh", clock.Now().Add(time.Minute)); !ok {
+		t.Error("expected a new nonce to be added")
+	}
+	if len(store.nonces) != 1 {
+		t.Errorf("expected the expired nonces to be pruned, got %v", store.nonces)
+	}
+}
+
+func TestRedisNonceStore(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	keys := make(map[string]time.Duration)
+	store := &RedisNonceStore{
+		SetNX: func(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+			if _, ok := keys[key]; ok {
+				return false, nil
+			}
+			keys[key] = ttl
+			return true, nil
+		},
+		Clock: rpcservertest.NewFakeClock(now),
+	}
+	if ok, _ := store.Add(context.Background(), "k1:n", now.Add(time.Minute)); !ok || keys["rpc:nonce:k1:n"] != time.Minute {
+		t.Errorf("expected the nonce to be set with its ttl, got %v", keys)
+	}
+	if ok, _ := store.Add(context.Background(), "k1:n", now.Add(time.Minute)); ok {
+		t.Error("expected a replayed nonce")
+	}
+}
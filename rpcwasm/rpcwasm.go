@@ -0,0 +1,179 @@
+// Package rpcwasm serves methods implemented by WASM modules, so that users
+// supply handlers running sandboxed in the server:
+//
+//	backend := &rpcwasm.Backend{Server: server}
+//	defer backend.Close(ctx)
+//	err := backend.Register("resize", module, "thumbnail", "crop")
+//
+// serves the functions thumbnail and crop of the module as the methods
+// "resize.thumbnail" and "resize.crop". A function takes the params of the
+// call, as JSON bytes, and returns the reply, as JSON bytes too.
+//
+// The module is compiled by a WASM runtime such as wazero, through an adapter
+// implementing Module: it instantiates the compiled module with
+// Runtime.InstantiateModule, and implements the Call of its instances on the
+// ABI of the module, e.g. by copying the args into the memory allocated by an
+// exported malloc, then calling the function with their pointer and length.
+//
+// An instance runs one call at a time: every call takes an idle instance of
+// the module, or a new one, and gives it back once done. The instances of the
+// calls which fail are closed rather than reused, their state may be broken.
+package rpcwasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"sync"
+)
+
+// defaultMaxIdle is the number of idle instances kept per module when the
+// Backend sets no MaxIdle.
+const defaultMaxIdle = 4
+
+// Module is a compiled WASM module.
+type Module interface {
+	// Instantiate returns a new instance of the module.
+	Instantiate(ctx context.Context) (Instance, error)
+}
+
+// Instance is an instance of a WASM module.
+type Instance interface {
+	// Call calls the exported function with the args and returns its
+	// result. It should stop when ctx, the context of the request, is done,
+	// e.g. with the WithCloseOnContextDone option of wazero.
+	Call(ctx context.Context, function string, args []byte) ([]byte, error)
+
+	// Close releases the instance.
+	Close(ctx context.Context) error
+}
+
+// Backend registers the functions of WASM modules as methods of a server.
+type Backend struct {
+	// Server serves the functions of the modules.
+	Server *rpcserver.Server
+
+	// MaxIdle is the number of idle instances kept per module, 4 when zero.
+	MaxIdle int
+
+	mu     sync.Mutex
+	pools  []*pool
+	closed bool
+}
+
+// Register serves the functions of the module as methods named
+// name.function.
+func (b *Backend) Register(name string, module Module, functions ...string) error {
+	p := &pool{backend: b, module: module}
+	for _, function := range functions {
+		if err := b.Server.RegisterBuiltin(name+"."+function, exported{p, function}, "Call"); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pools = append(b.pools, p)
+	return nil
+}
+
+// Close closes the idle instances of the modules, and the others once their
+// calls are done.
+func (b *Backend) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	pools := b.pools
+	b.pools = nil
+	b.mu.Unlock()
+	var first error
+	for _, p := range pools {
+		p.mu.Lock()
+		idle := p.idle
+		p.idle = nil
+		p.mu.Unlock()
+		for _, instance := range idle {
+			if err := instance.Close(ctx); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (b *Backend) maxIdle() int {
+	if b.MaxIdle > 0 {
+		return b.MaxIdle
+	}
+	return defaultMaxIdle
+}
+
+// pool holds the idle instances of a module.
+type pool struct {
+	backend *Backend
+	module  Module
+
+	mu   sync.Mutex
+	idle []Instance
+}
+
+// get returns an idle instance, or a new one.
+func (p *pool) get(ctx context.Context) (Instance, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		instance := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return instance, nil
+	}
+	p.mu.Unlock()
+	instance, err := p.module.Instantiate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rpcwasm: cannot instantiate the module: %v", err)
+	}
+	return instance, nil
+}
+
+// put gives back the instance of a call, closing it if the call failed or
+// enough instances are idle.
+func (p *pool) put(ctx context.Context, instance Instance, failed bool) {
+	p.backend.mu.Lock()
+	closed := p.backend.closed
+	p.backend.mu.Unlock()
+	p.mu.Lock()
+	if !failed && !closed && len(p.idle) < p.backend.maxIdle() {
+		p.idle = append(p.idle, instance)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	instance.Close(context.WithoutCancel(ctx))
+}
+
+// exported is the receiver of the method of a function of a module.
+type exported struct {
+	pool     *pool
+	function string
+}
+
+// Call calls the function on an instance of the module with the raw params.
+func (e exported) Call(r *http.Request, raw json.RawMessage) (interface{}, error) {
+	ctx := r.Context()
+	instance, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args := []byte(raw)
+	if len(args) == 0 {
+		args = []byte("null")
+	}
+	result, err := instance.Call(ctx, e.function, args)
+	if err == nil && !json.Valid(result) {
+		err = fmt.Errorf("rpcwasm: function %s returned invalid JSON", e.function)
+	}
+	e.pool.put(ctx, instance, err != nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(result), nil
+}
@@ -0,0 +1,130 @@
+package rpcwasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Host int
+
+func (h *Host) Ping(r *http.Request, args *struct{}, reply *string) error {
+	*reply = "pong"
+	return nil
+}
+
+// fakeModule counts its instances, whose functions echo their args, fail or
+// return garbage.
+type fakeModule struct {
+	mu           sync.Mutex
+	instantiated int
+	closed       int
+	release      chan struct{} // blocks the calls of wait when set
+}
+
+func (m *fakeModule) Instantiate(ctx context.Context) (Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instantiated++
+	return &fakeInstance{module: m}, nil
+}
+
+func (m *fakeModule) counts() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.instantiated, m.closed
+}
+
+type fakeInstance struct {
+	module *fakeModule
+	calls  int
+}
+
+func (i *fakeInstance) Call(ctx context.Context, function string, args []byte) ([]byte, error) {
+	i.calls++
+	switch function {
+	case "echo":
+		return bytes.TrimSpace(args), nil
+	case "wait":
+		<-i.module.release
+		return []byte(`"done"`), nil
+	case "trap":
+		return nil, errors.New("unreachable executed")
+	}
+	return []byte("{not json"), nil
+}
+
+func (i *fakeInstance) Close(ctx context.Context) error {
+	i.module.mu.Lock()
+	defer i.module.mu.Unlock()
+	i.module.closed++
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	srv := rpcservertest.NewServer(t, new(Host))
+	defer srv.Close()
+	backend := &Backend{Server: srv.RPC, MaxIdle: 2}
+	module := &fakeModule{release: make(chan struct{})}
+	if err := backend.Register("user", module, "echo", "wait", "trap", "garbage"); err != nil {
+		t.Fatal(err)
+	}
+
+	var reply map[string]interface{}
+	for i := 0; i < 3; i++ {
+		srv.MustCall("user.echo", map[string]interface{}{"a": 1.0}, &reply)
+		rpcservertest.AssertReply(t, reply, map[string]interface{}{"a": 1.0})
+	}
+	if instantiated, _ := module.counts(); instantiated != 1 {
+		t.Errorf("expected the calls to reuse an instance, got %d instances", instantiated)
+	}
+
+	if err := srv.Call("user.trap", map[string]interface{}{}, &reply); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("expected the trap, got %v", err)
+	}
+	if err := srv.Call("user.garbage", map[string]interface{}{}, &reply); err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Errorf("expected invalid JSON, got %v", err)
+	}
+	if instantiated, closed := module.counts(); instantiated != 2 || closed != 2 {
+		t.Errorf("expected the instances of failed calls closed, got %d instances, %d closed", instantiated, closed)
+	}
+
+	// Concurrent calls run on their own instances, MaxIdle of them are kept.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var done string
+			if err := srv.Call("user.wait", map[string]interface{}{}, &done); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for {
+		if instantiated, _ := module.counts(); instantiated == 6 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(module.release)
+	wg.Wait()
+	if instantiated, closed := module.counts(); instantiated != 6 || closed != 4 {
+		t.Errorf("expected 2 idle instances, got %d instances, %d closed", instantiated, closed)
+	}
+
+	if err := backend.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, closed := module.counts(); closed != 6 {
+		t.Errorf("expected all the instances closed, got %d closed", closed)
+	}
+	var pong string
+	srv.MustCall("Ping", &struct{}{}, &pong)
+}
@@ -0,0 +1,199 @@
+package rpcserver
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sanitizer cleans the decoded args of a method in place, args points to
+// them. Sanitizers run after the sanitize tags of the args fields are
+// applied, before the validators:
+//
+//	type Signup struct {
+//		Name string   `sanitize:"trim,nocontrol,normalize,max=64"`
+//		Tags []string `sanitize:"trim,max=10"`
+//	}
+//
+// The options are:
+//   - trim removes the leading and trailing white space,
+//   - nocontrol removes the control characters but tabs and newlines,
+//   - normalize applies Normalize,
+//   - max=<n> truncates strings to n runes and slices to n items.
+//
+// The string options apply to the strings of slices, and the tags of nested
+// structs apply to their fields.
+type Sanitizer func(ctx context.Context, args interface{})
+
+// RegisterSanitizer adds a sanitizer of the args of the method, run in order
+// of registration.
+//
+// Sanitizers may be registered while the server is handling requests.
+func (s *Server) RegisterSanitizer(method string, sanitizer Sanitizer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if _, err := reg.method(method); err != nil {
+		return err
+	}
+	sanitizers := make(map[string][]Sanitizer, len(reg.sanitizers)+1)
+	for key, list := range reg.sanitizers {
+		sanitizers[key] = list
+	}
+	list := sanitizers[method]
+	sanitizers[method] = append(list[:len(list):len(list)], sanitizer)
+	reg.sanitizers = sanitizers
+	s.registry.Store(&reg)
+	return nil
+}
+
+// Normalize normalizes the unicode strings of the fields tagged
+// sanitize:"normalize" when set, e.g. to norm.NFC.String of
+// golang.org/x/text/unicode/norm. They are left as is otherwise.
+var Normalize func(s string) string
+
+// sanitize applies the sanitize tags of the args and the sanitizers of the
+// method.
+func (reg *registry) sanitize(ctx context.Context, method string, args reflect.Value) {
+	if plan := sanitizePlanOf(args.Type().Elem()); plan != nil {
+		plan.apply(args.Elem())
+	}
+	for _, sanitizer := range reg.sanitizers[method] {
+		sanitizer(ctx, args.Interface())
+	}
+}
+
+// sanitizePlan holds the sanitize tags of the fields of a struct type.
+type sanitizePlan struct {
+	fields []sanitizeField
+}
+
+type sanitizeField struct {
+	index                      int
+	trim, nocontrol, normalize bool
+	max                        int           // 0 if none
+	nested                     *sanitizePlan // plan of the struct values of the field
+}
+
+var sanitizePlans sync.Map // reflect.Type -> *sanitizePlan
+
+func sanitizePlanOf(t reflect.Type) *sanitizePlan {
+	if plan, ok := sanitizePlans.Load(t); ok {
+		return plan.(*sanitizePlan)
+	}
+	plan, _ := sanitizePlans.LoadOrStore(t, newSanitizePlan(t, make(map[reflect.Type]bool)))
+	return plan.(*sanitizePlan)
+}
+
+func newSanitizePlan(t reflect.Type, visiting map[reflect.Type]bool) *sanitizePlan {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	plan := new(sanitizePlan)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		sf := sanitizeField{index: i}
+		for _, option := range strings.Split(field.Tag.Get("sanitize"), ",") {
+			switch option = strings.TrimSpace(option); {
+			case option == "trim":
+				sf.trim = true
+			case option == "nocontrol":
+				sf.nocontrol = true
+			case option == "normalize":
+				sf.normalize = true
+			case strings.HasPrefix(option, "max="):
+				sf.max, _ = strconv.Atoi(option[len("max="):])
+			}
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		sf.nested = newSanitizePlan(ft, visiting)
+		if sf.trim || sf.nocontrol || sf.normalize || sf.max > 0 || sf.nested != nil {
+			plan.fields = append(plan.fields, sf)
+		}
+	}
+	if len(plan.fields) == 0 {
+		return nil
+	}
+	return plan
+}
+
+// apply sanitizes the fields of the struct v.
+func (plan *sanitizePlan) apply(v reflect.Value) {
+	for i := range plan.fields {
+		sf := &plan.fields[i]
+		sf.apply(v.Field(sf.index), true)
+	}
+}
+
+// apply sanitizes the value v of the field, truncating it if clamp is set.
+func (sf *sanitizeField) apply(v reflect.Value, clamp bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(sf.clean(v.String(), clamp))
+		}
+	case reflect.Slice:
+		if clamp && sf.max > 0 && v.Len() > sf.max {
+			v.Set(v.Slice(0, sf.max))
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			sf.apply(v.Index(i), false)
+		}
+	case reflect.Struct:
+		if sf.nested != nil {
+			sf.nested.apply(v)
+		}
+	}
+}
+
+// clean applies the string options to s, truncating it if clamp is set.
+func (sf *sanitizeField) clean(s string, clamp bool) string {
+	if sf.nocontrol {
+		s = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) && r != '\t' && r != '\n' {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	if sf.normalize && Normalize != nil {
+		s = Normalize(s)
+	}
+	if sf.trim {
+		s = strings.TrimSpace(s)
+	}
+	if clamp && sf.max > 0 && utf8.RuneCountInString(s) > sf.max {
+		n := 0
+		for i := range s {
+			if n == sf.max {
+				s = s[:i]
+				break
+			}
+			n++
+		}
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package rpcserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+type inProcessKey struct{}
+
+// CallBody returns the body of a JSON-RPC 2.0 request calling the method with
+// the params, already encoded, and the id, see ServeCall.
+func CallBody(method string, params json.RawMessage, id interface{}) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+		"id":      id,
+	})
+	return body
+}
+
+// ServeCall serves the JSON-RPC 2.0 request body calling the method with
+// handler, usually a Server with the JSON-RPC 2.0 codec, at path followed by
+// the method name, e.g. "/rpc/". It is how the packages running calls in
+// process, such as jobs, schedules and replays, call the methods, the calls
+// passing through the middleware, stats and access log of the server. The
+// header, nil for none, is added to the request. The calls come from the
+// loopback address 127.0.0.1 and are not subject to AllowedIPs and DeniedIPs.
+// It returns the status and the body of the response.
+func ServeCall(ctx context.Context, handler http.Handler, path, method string, body []byte, header http.Header) (int, []byte, error) {
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, inProcessKey{}, true), "POST", path+method, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = "127.0.0.1:0"
+	w := &bufferedWriter{header: make(http.Header)}
+	handler.ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.status, w.body.Bytes(), nil
+}
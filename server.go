@@ -7,10 +7,17 @@
 package rpcserver
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ----------------------------------------------------------------------------
@@ -21,33 +28,171 @@ import (
 //
 // Methods from the receiver will be extracted if these rules are satisfied:
 //
-//    - The receiver is exported (begins with an upper case letter) or local
-//      (defined in the package registering the service).
-//    - The method name is exported.
-//    - The method has three arguments: *http.Request, *args, *reply.
-//    - All three arguments are pointers.
-//    - The second and third arguments are exported or local.
-//    - The method has return type error.
+//   - The receiver is exported (begins with an upper case letter) or local
+//     (defined in the package registering the service).
+//   - The method name is exported.
+//   - The method has three arguments: *http.Request, *args, *reply.
+//   - All three arguments are pointers.
+//   - The second and third arguments are exported or local.
+//   - The method has return type error.
 //
-
+// Methods without a reply, func(r *http.Request, args *Args) error, and
+// methods returning the reply, func(r *http.Request, args *Args) (*Reply, error),
+// are also extracted. Their replies are encoded as null when missing.
+//
+// Methods taking non-pointer params after the request, possibly variadic,
+// like func(r *http.Request, a, b int) (int, error), receive by-position
+// params in order. A method with a single such param, e.g. a
+// map[string]interface{}, a slice or a primitive, receives the whole params.
+//
+// The other exported methods are skipped, RpcService.Skipped tells why. The
+// registration of a receiver without a suitable method fails with a
+// RegistrationError listing them, as does the one of a StrictService missing
+// methods.
 func NewServer(receiver interface{}) (*Server, error) {
 	service, err := NewRpcService(receiver)
 	if err != nil {
 		return nil, err
 	}
 
-	server := &Server{
-		codecs:  make(map[string]Codec),
-		service: service,
-	}
+	server := &Server{stats: new(stats)}
+	server.registry.Store(&registry{codecs: make(map[string]Codec), service: service})
 	// TODO: maybe register default json-rpc codec
 	return server, nil
 }
 
 // Server serves registered RPC service using registered codecs.
 type Server struct {
-	codecs  map[string]Codec
-	service *RpcService
+	// MaxBodyBytes limits the size of request bodies when positive, larger
+	// requests are rejected with 413. Use SetLimits to change it while
+	// serving.
+	MaxBodyBytes int64
+
+	// ErrorWriter writes transport-level errors, such as an unknown method
+	// path, when the codec of the request isn't an ErrorWriter itself. The
+	// TextErrorWriter is used when nil.
+	ErrorWriter ErrorWriter
+
+	// PathTemplate is the path of the calls, such as "/jsonrpc/v2/{method}",
+	// where {method} stands for the method name, its segments joined with
+	// dots: /jsonrpc/v2/arith/Multiply calls "arith.Multiply", see
+	// RegisterGroup. The method is the last segment of the path when it is
+	// empty. The other {name} segments are path parameters, e.g. tenant in
+	// "/tenants/{tenant}/jsonrpc/{method}", see BindMetadata.
+	PathTemplate string
+
+	// PathParams extracts the path parameters of the requests when set, such
+	// as the ones of the router mounting the server, e.g. mux.Vars of
+	// gorilla/mux. The parameters of the PathTemplate apply over them.
+	PathParams PathParamsFunc
+
+	// CallTimeout bounds the duration of calls when positive, the deadline
+	// set by the client with the DeadlineHeader applies when shorter. Use
+	// SetLimits to change it while serving.
+	CallTimeout time.Duration
+
+	// Formats sets the wire formats of times, durations and large integers in all codecs.
+	Formats Formats
+
+	// JSON is the engine JSON codecs marshal and unmarshal with, encoding/json
+	// when nil.
+	JSON JSONEngine
+
+	// StrictJSON rejects the request bodies with an object holding a key
+	// twice with 400 and an ErrDuplicateKey, rather than having the last
+	// value win as in encoding/json. The keys of the params decoded into
+	// structs are compared as the fields match them, see ErrDuplicateKey.
+	StrictJSON bool
+
+	// MaxLabelValues limits the distinct values kept per metric label, see
+	// SetMetricLabel. It is 100 when zero.
+	MaxLabelValues int
+
+	// SlowCalls detects and logs slow calls when set.
+	SlowCalls *SlowCallPolicy
+
+	// SLO reports the methods burning their error budget when set.
+	SLO *SLOPolicy
+
+	// AccessLog receives an entry per request when set, e.g. an AccessLogger
+	// or a sink of the logsink package.
+	AccessLog AccessLogSink
+
+	// Clock is the time source of deadlines, latencies and SLO windows,
+	// SystemClock when nil.
+	Clock Clock
+
+	// ServerTiming adds a Server-Timing header to the responses of calls,
+	// with the durations of their decode, handler and encode phases.
+	// Responses are then buffered to measure their encoding.
+	ServerTiming bool
+
+	// Signer signs the responses of calls when set, the signature of their
+	// body is sent in the SignatureHeader. Responses are then buffered to be
+	// signed whole.
+	Signer Signer
+
+	// TrustedProxies lists the proxies whose Forwarded and X-Forwarded-For
+	// headers tell the address of the client, see Caller.Addr. The headers
+	// are ignored when none is trusted.
+	TrustedProxies Networks
+
+	// AllowedIPs lists the client addresses served when set, DeniedIPs the
+	// ones refused. Refused requests are answered with 403.
+	AllowedIPs Networks
+	DeniedIPs  Networks
+
+	// Flags is consulted before every call when set, calls of the methods it
+	// disabled fail with ErrFeatureDisabled and the 403 status.
+	Flags FlagProvider
+
+	// Residency keeps the calls of data homed in other regions out of the
+	// server when set.
+	Residency *ResidencyPolicy
+
+	// AffinityCookie names a cookie set with the affinity key of the calls
+	// having one, besides the AffinityHeader, for load balancers with
+	// cookie stickiness.
+	AffinityCookie string
+
+	// PropagateHeaders lists the headers of the requests which the calls
+	// the methods make to other services may forward, such as
+	// RequestIDHeader, "Accept-Language" or the header of the tenant: they
+	// are the metadata of the context of the request, see
+	// MetadataFromContext, which rpcclient.Client forwards when listed in
+	// its ForwardMetadata.
+	PropagateHeaders []string
+
+	mu       sync.Mutex   // serializes registrations
+	registry atomic.Value // *registry, replaced on registration
+	limits   atomic.Value // *limits, see SetLimits
+	stats    *stats
+}
+
+// registry holds the codecs, the service and the middleware of a server. A published registry
+// is never modified, registrations publish a modified copy so requests in
+// flight keep a consistent view.
+type registry struct {
+	codecs          map[string]Codec
+	service         *RpcService
+	middleware      []Middleware
+	migrations      map[string][]Migrator        // see RegisterMigrators
+	sanitizers      map[string][]Sanitizer       // see RegisterSanitizer
+	validators      map[string][]Validator       // see RegisterValidator
+	preconditions   map[string]Precondition      // see RegisterPrecondition
+	cacheControl    map[string]string            // see SetCacheControl
+	rawContentTypes map[string][]string          // see SetRawContentTypes
+	builtins        map[string]*RpcServiceMethod // see RegisterBuiltin
+	groups          map[string]*RpcServiceMethod // see RegisterGroup
+	fallback        *RpcServiceMethod            // see SetFallbackHandler
+
+	implementations map[string]map[string]*RpcServiceMethod // see RegisterImplementation
+	routeCodecs     []routeCodecs                           // see SetRouteCodecs
+}
+
+// current returns the registry serving new requests.
+func (s *Server) current() *registry {
+	return s.registry.Load().(*registry)
 }
 
 // RegisterCodec adds a new codec to the server.
@@ -55,15 +200,72 @@ type Server struct {
 // Codecs are defined to process a given serialization scheme, e.g., JSON or
 // XML. A codec is chosen based on the "Content-Type" header from the request,
 // excluding the charset definition.
+//
+// Codecs may be registered while the server is handling requests.
 func (s *Server) RegisterCodec(codec Codec, contentType string) {
-	s.codecs[strings.ToLower(contentType)] = codec
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	precompile(codec, reg.service)
+	for _, m := range reg.builtins {
+		precompileMethod(codec, m)
+	}
+	codecs := make(map[string]Codec, len(reg.codecs)+1)
+	for key, c := range reg.codecs {
+		codecs[key] = c
+	}
+	codecs[strings.ToLower(contentType)] = codec
+	reg.codecs = codecs
+	reg.restrictRoutes()
+	s.registry.Store(&reg)
 }
 
-// HasMethod returns true if the given method is registered.
-//
-// The method uses a dotted notation as in "Service.Method".
+// RegisterService replaces the served service by the methods of receiver,
+// following the rules of NewServer, and drops its implementations. Requests in
+// flight complete with the previous service.
+func (s *Server) RegisterService(receiver interface{}) error {
+	service, err := NewRpcService(receiver)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	for _, codec := range reg.codecs {
+		precompile(codec, service)
+	}
+	reg.service, reg.implementations = service, nil
+	s.registry.Store(&reg)
+	return nil
+}
+
+// precompile prepares the plans of a codec for the types of the service.
+func precompile(codec Codec, service *RpcService) {
+	for _, name := range service.MethodNames() {
+		precompileMethod(codec, service.methods[name])
+	}
+}
+
+// precompileMethod prepares the plans of a codec for the types of a method.
+func precompileMethod(codec Codec, m *RpcServiceMethod) {
+	p, ok := codec.(Precompiler)
+	if !ok {
+		return
+	}
+	p.Precompile(m.argsType)
+	if m.replyType != nil {
+		p.Precompile(m.replyType)
+	}
+}
+
+// Service returns the RPC service served by the server.
+func (s *Server) Service() *RpcService {
+	return s.current().service
+}
+
+// HasMethod returns true if the given method or builtin is registered.
 func (s *Server) HasMethod(method string) bool {
-	if _, err := s.service.Get(method); err == nil {
+	if _, err := s.current().method(method); err == nil {
 		return true
 	}
 	return false
@@ -71,85 +273,502 @@ func (s *Server) HasMethod(method string) bool {
 
 // ServeHTTP
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		WriteError(w, 405, "rpc: POST method required, received "+r.Method)
+	clock := ClockOrSystem(s.Clock)
+	start := clock.Now()
+	atomic.AddInt64(&s.stats.requests, 1)
+	if s.AccessLog != nil {
+		recorder := &recordingWriter{ResponseWriter: w}
+		defer s.logAccess(recorder, r, start)
+		w = recorder
+	}
+	reg := s.current()
+	codec, contentType := reg.requestCodec(r)
+	switch r.Method {
+	case "POST":
+	case "OPTIONS", "HEAD":
+		s.serveProbe(w, r)
+		return
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		s.writeTransportError(w, r, codec, 405, fmt.Errorf("rpc: POST method required, received %s", r.Method))
 		return
 	}
-	contentType := r.Header.Get("Content-Type")
-	idx := strings.Index(contentType, ";")
-	if idx != -1 {
-		contentType = contentType[:idx]
+	pathMethod, templateParams := s.matchPath(r.URL.Path)
+	// Passthrough methods read the body of their Content-Types as is.
+	rawSpec := reg.rawMethod(pathMethod)
+	if codec == nil && (rawSpec == nil || !reg.acceptsRaw(pathMethod, contentType)) {
+		s.writeTransportError(w, r, nil, 415, fmt.Errorf("rpc: unrecognized Content-Type: %s", contentType))
+		return
 	}
-	var codec Codec
-	if contentType == "" && len(s.codecs) == 1 {
-		// If Content-Type is not set and only one codec has been registered,
-		// then default to that codec.
-		for _, c := range s.codecs {
-			codec = c
+	if codec != nil {
+		s.stats.countCodec(contentType)
+	}
+	var decoder CharsetDecoder
+	if rawSpec == nil {
+		var err error
+		if decoder, err = requestCharset(r); err != nil {
+			s.writeTransportError(w, r, codec, 415, err)
+			return
 		}
-	} else if codec = s.codecs[strings.ToLower(contentType)]; codec == nil {
-		WriteError(w, 415, "rpc: unrecognized Content-Type: "+contentType)
+	}
+	encodings, err := requestEncodings(r)
+	if err != nil {
+		w.Header().Set("Accept-Encoding", acceptedEncodings)
+		s.writeTransportError(w, r, codec, 415, err)
 		return
 	}
 
-	pathMethod := LastPart(r.URL.Path)
-	_, errGet := s.service.Get(pathMethod)
+	caller := newCaller(r, s.TrustedProxies)
+	if _, inProcess := r.Context().Value(inProcessKey{}).(bool); !inProcess {
+		if err := s.admitAddr(caller.Addr); err != nil {
+			s.writeTransportError(w, r, codec, 403, err)
+			return
+		}
+	}
+	pathSpec, errGet := reg.callable(pathMethod)
 	if errGet != nil {
-		WriteError(w, 404, errGet.Error())
+		s.writeTransportError(w, r, codec, 404, errGet)
+		return
+	}
+	limits := s.currentLimits()
+	if status, err := limits.admit(pathMethod, clock); status != 0 {
+		s.writeTransportError(w, r, codec, status, err)
+		return
+	}
+	release, status, err := limits.acquireCall(r.Context(), clock)
+	if err != nil {
+		s.writeTransportError(w, r, codec, status, err)
+		return
+	}
+	defer release()
+
+	info := &callInfo{
+		method: pathMethod,
+		codec:  contentType,
+		start:  start,
+		caller: caller,
+	}
+	r = r.WithContext(context.WithValue(r.Context(), callInfoKey{}, info))
+	if params := s.pathParams(r, templateParams); params != nil {
+		r = r.WithContext(WithPathParams(r.Context(), params))
+	}
+	if len(s.PropagateHeaders) > 0 {
+		r = r.WithContext(s.propagate(r))
+	}
+
+	if t, ok := ParseTrace(r.Header); ok {
+		r = r.WithContext(WithTrace(r.Context(), t.Child()))
+	}
+
+	timeout := limits.CallTimeout
+	if header := r.Header.Get(DeadlineHeader); header != "" {
+		d, err := ParseTimeout(header)
+		if err != nil {
+			s.writeTransportError(w, r, codec, 400, err)
+			return
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout > 0 {
+		ctx, cancel := WithClockTimeout(r.Context(), clock, timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	var rate *rateReader
+	if limits.MinBodyRate > 0 {
+		rate = newRateReader(w, r.Body, limits.MinBodyRate, limits.bodyGracePeriod(), clock)
+		r.Body = rate
+	}
+	var body *limitedReader
+	if limits.MaxBodyBytes > 0 {
+		body = &limitedReader{ReadCloser: r.Body, remaining: limits.MaxBodyBytes}
+		r.Body = body
+	}
+	var decompressed *decompressReader
+	var inflated *limitedReader
+	if len(encodings) > 0 {
+		decompressed = &decompressReader{ReadCloser: r.Body, encodings: encodings}
+		r.Body = decompressed
+		if max := limits.maxDecompressedBytes(); max > 0 {
+			inflated = &limitedReader{ReadCloser: r.Body, remaining: max}
+			r.Body = inflated
+		}
+	}
+	var text *textReader
+	if rawSpec == nil {
+		text = &textReader{ReadCloser: r.Body, decoder: decoder}
+		r.Body = text
+	}
+	var guard *jsonGuard
+	if rawSpec == nil && (s.StrictJSON || limits.guardsJSON()) {
+		guard = &jsonGuard{ReadCloser: r.Body, limits: limits, strict: s.StrictJSON, args: pathSpec.argsType}
+		if p, ok := codec.(ParamsMember); ok {
+			guard.params = p.ParamsMember()
+		}
+		info.guard = guard
+		r.Body = guard
+	}
+
+	// bodyFailed answers the requests whose body was rejected while the
+	// codec read it, it tells if it did.
+	bodyFailed := func() bool {
+		switch {
+		case rate != nil && rate.err != nil:
+			w.Header().Set("Connection", "close")
+			s.writeTransportError(w, r, codec, 408, rate.err)
+		case body != nil && body.exceeded:
+			s.writeTransportError(w, r, codec, 413, fmt.Errorf("rpc: request body exceeds %d bytes", limits.MaxBodyBytes))
+		case decompressed != nil && decompressed.err != nil:
+			s.writeTransportError(w, r, codec, 400, decompressed.err)
+		case inflated != nil && inflated.exceeded:
+			s.writeTransportError(w, r, codec, 413, fmt.Errorf("rpc: decompressed request body exceeds %d bytes", limits.maxDecompressedBytes()))
+		case text != nil && text.err != nil:
+			s.writeTransportError(w, r, codec, 400, text.err)
+		case guard != nil && guard.err != nil:
+			s.writeTransportError(w, r, codec, 400, guard.err)
+		default:
+			return false
+		}
+		return true
+	}
+
+	if !s.Formats.IsDefault() {
+		r = r.WithContext(WithFormats(r.Context(), s.Formats))
+	}
+	if s.JSON != nil {
+		r = r.WithContext(WithJSONEngine(r.Context(), s.JSON))
+	}
+	if len(reg.migrations) > 0 {
+		ctx, err := withMigrations(r.Context(), r.Header.Get(SchemaVersionHeader), reg.migrations)
+		if err != nil {
+			s.writeTransportError(w, r, codec, 400, err)
+			return
+		}
+		r = r.WithContext(ctx)
+	}
+
+	if rawSpec != nil {
+		s.serveRaw(w, r, reg, codec, rawSpec, info, clock, bodyFailed)
 		return
 	}
 
 	// Create a new codec request.
+	decodeStart := clock.Now()
 	codecReq := codec.NewRequest(r)
 
 	if codecReq.Error() != nil {
-		codecReq.WriteError(w, 400, codecReq.Error())
+		if bodyFailed() {
+			return
+		}
+		s.writeError(w, codecReq, 400, codecReq.Error())
 		return
 	}
 
 	// Get service method to be called.
 	methodName, errMethod := codecReq.Method()
 	if errMethod != nil {
-		codecReq.WriteError(w, 400, errMethod)
+		s.writeError(w, codecReq, 400, errMethod)
 		return
 	}
 
-	methodSpec, errGet := s.service.Get(methodName)
+	methodSpec, errGet := reg.callable(methodName)
 	if errGet != nil {
-		codecReq.WriteError(w, 400, errGet)
+		s.writeError(w, codecReq, 400, errGet)
+		return
+	}
+	if methodSpec.raw() {
+		s.writeError(w, codecReq, 400, fmt.Errorf("rpc: method %s takes the raw body, call it at its path", methodName))
 		return
 	}
 	// Decode the args.
 	args := reflect.New(methodSpec.argsType)
 	if errRead := codecReq.ReadRequest(args.Interface()); errRead != nil {
-		codecReq.WriteError(w, 400, errRead)
+		// Codecs may read the args from the body as a stream.
+		if bodyFailed() {
+			return
+		}
+		s.writeError(w, codecReq, 400, errRead)
 		return
 	}
-	// Call the service method.
-	reply := reflect.New(methodSpec.replyType)
-	errValue := methodSpec.method.Func.Call([]reflect.Value{
-		s.service.rcvr,
-		reflect.ValueOf(r),
-		args,
-		reply,
-	})
-	// Cast the result to error if needed.
-	var errResult error
-	errInter := errValue[0].Interface()
-	if errInter != nil {
-		errResult = errInter.(error)
+	if err := BindMetadata(r, args.Interface()); err != nil {
+		s.writeError(w, codecReq, 400, err)
+		return
+	}
+	reg.sanitize(r.Context(), methodName, args)
+	if err := reg.validate(r.Context(), methodName, args.Interface()); err != nil {
+		s.writeError(w, codecReq, 400, err)
+		return
+	}
+	if err := reg.checkPrecondition(r, methodName, args.Interface()); err != nil {
+		status := 400
+		if errors.Is(err, ErrPreconditionFailed) {
+			status = 412
+		}
+		s.writeError(w, codecReq, status, err)
+		return
+	}
+	s.writeAffinity(w, args.Interface())
+	// Call the service method through the middleware.
+	call := &Call{
+		Request: r,
+		Method:  methodName,
+		Args:    args.Interface(),
+		Reply:   methodSpec.newReply(),
+	}
+	invoke := reg.chain(s.invoker(reg, methodSpec))
+	called := clock.Now()
+	errResult := invoke(r.Context(), call)
+	returned := clock.Now()
+	elapsed := returned.Sub(start)
+	slow := s.SlowCalls != nil && s.SlowCalls.observe(call, elapsed, errResult)
+	s.record(methodName, info, elapsed, errResult, slow)
+	if s.SLO != nil {
+		s.SLO.observe(methodName, errResult, clock.Now())
 	}
 
 	// Encode the response.
-	if errResult == nil {
-		codecReq.WriteResponse(w, reply.Interface())
+	encode := func(w http.ResponseWriter) {
+		if errResult == nil {
+			if cacheControl := reg.cacheControlOf(methodName, methodSpec); cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			codecReq.WriteResponse(w, call.Reply)
+		} else {
+			s.writeError(w, codecReq, callStatus(errResult), errResult)
+		}
+	}
+	info.writeResponse(w, r, func(w http.ResponseWriter) {
+		if s.ServerTiming || s.Signer != nil {
+			s.writeBuffered(w, r, codec, clock, called.Sub(decodeStart), returned.Sub(called), encode)
+		} else {
+			encode(w)
+		}
+	})
+}
+
+// invoker returns the function at the end of the middleware, calling the
+// method of the call.
+func (s *Server) invoker(reg *registry, methodSpec *RpcServiceMethod) CallFunc {
+	return func(ctx context.Context, call *Call) error {
+		if err := s.checkFlags(ctx, call.Method); err != nil {
+			return err
+		}
+		if s.Residency != nil {
+			if err := s.Residency.check(ctx, call); err != nil {
+				return err
+			}
+		}
+		req := call.Request
+		if ctx != req.Context() {
+			req = req.WithContext(ctx)
+		}
+		m, err := reg.implementation(call, methodSpec)
+		if err != nil {
+			return err
+		}
+		reply, err := s.callMethod(ctx, reg.service, m, req, call.Args, call.Reply)
+		if err == nil {
+			reply = shapeReply(ctx, reply)
+		}
+		call.Reply = reply
+		return err
+	}
+}
+
+// callStatus returns the HTTP status of the error of a call.
+func callStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrDeadlineExceeded):
+		return 504
+	case errors.Is(err, ErrFeatureDisabled):
+		return 403
+	case errors.Is(err, ErrPreconditionFailed):
+		return 412
+	}
+	var regionErr *RegionError
+	if errors.As(err, &regionErr) {
+		if regionErr.Endpoint != "" {
+			return 421
+		}
+		return 403
+	}
+	return 400
+}
+
+// callResult is the outcome of a method running in its own goroutine.
+type callResult struct {
+	reply    interface{}
+	err      error
+	panicked bool
+	panic    interface{}
+}
+
+// callMethod calls the method in its own goroutine and returns as soon as ctx
+// is done, e.g. when the client disconnects or the deadline of the client
+// passes. The abandoned method keeps running with a done request context,
+// long-running methods should watch r.Context() to stop their work.
+func (s *Server) callMethod(ctx context.Context, service *RpcService, m *RpcServiceMethod, r *http.Request, args, reply interface{}) (interface{}, error) {
+	if ctx.Err() != nil {
+		return nil, s.abandon(ctx)
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- callResult{panicked: true, panic: p}
+			}
+		}()
+		reply, err := service.call(m, r, args, reply)
+		done <- callResult{reply: reply, err: err}
+	}()
+	var res callResult
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		select {
+		case res = <-done:
+		default:
+			return nil, s.abandon(ctx)
+		}
+	}
+	if res.panicked {
+		// Panic in the serving goroutine, as a method called directly would.
+		panic(res.panic)
+	}
+	if res.err != nil && ctx.Err() != nil && errors.Is(res.err, ctx.Err()) {
+		// The method gave up on the done context itself.
+		return nil, s.abandon(ctx)
+	}
+	return res.reply, res.err
+}
+
+// abandon counts a call abandoned because ctx is done and returns its error.
+func (s *Server) abandon(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		atomic.AddInt64(&s.stats.deadlineExceeded, 1)
+	} else {
+		atomic.AddInt64(&s.stats.cancelled, 1)
+	}
+	return contextError(ctx)
+}
+
+// requestCodec returns the codec chosen with WithCodec or the one of the route
+// matching the Content-Type of the request, or nil and the unrecognized media
+// type.
+func (reg *registry) requestCodec(r *http.Request) (Codec, string) {
+	if chosen, ok := r.Context().Value(codecKey{}).(chosenCodec); ok {
+		return chosen.codec, chosen.name
+	}
+	codecs := reg.codecsOf(r.URL.Path)
+	contentType := mediaType(r.Header.Get("Content-Type"))
+	if contentType == "" && len(codecs) == 1 {
+		// If Content-Type is not set and only one codec has been registered,
+		// then default to that codec.
+		for key, c := range codecs {
+			return c, key
+		}
+	}
+	return codecs[contentType], contentType
+}
+
+// writeError writes the error of a call with the codec.
+func (s *Server) writeError(w http.ResponseWriter, codecReq CodecRequest, status int, err error) {
+	s.stats.countError(status)
+	noteError(w, err)
+	codecReq.WriteError(w, status, err)
+}
+
+// writeTransportError writes an error occurring before the codec took over the
+// request, using the codec of the request or one matching the Accept header
+// when possible.
+func (s *Server) writeTransportError(w http.ResponseWriter, r *http.Request, codec Codec, status int, err error) {
+	s.stats.countError(status)
+	noteError(w, err)
+	if codec == nil {
+		codecs := s.current().codecsOf(r.URL.Path)
+		for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+			if codec = codecs[mediaType(accepted)]; codec != nil {
+				break
+			}
+		}
+	}
+	res := NewErrorResponse(r, status, err)
+	if writer, ok := codec.(ErrorWriter); ok {
+		writer.WriteErrorResponse(w, r, res)
+	} else if s.ErrorWriter != nil {
+		s.ErrorWriter.WriteErrorResponse(w, r, res)
 	} else {
-		codecReq.WriteError(w, 400, errResult)
+		TextErrorWriter{}.WriteErrorResponse(w, r, res)
+	}
+}
+
+// mediaType returns the lower case media type of a Content-Type or Accept
+// value, excluding the parameters such as charset.
+func mediaType(value string) string {
+	if idx := strings.Index(value, ";"); idx != -1 {
+		value = value[:idx]
 	}
+	return strings.ToLower(strings.TrimSpace(value))
 }
 
-func WriteError(w http.ResponseWriter, status int, msg string) {
-	w.WriteHeader(status)
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	fmt.Fprint(w, msg)
+// limitedReader fails reads past the remaining number of bytes and remembers
+// it did.
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Probe for data past the limit before failing.
+		var probe [1]byte
+		if n, _ := l.ReadCloser.Read(probe[:]); n > 0 {
+			l.exceeded = true
+			return 0, errBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+var errBodyTooLarge = errors.New("rpc: request body too large")
+
+// allowedMethods is the value of the Allow header.
+const allowedMethods = "POST, OPTIONS, HEAD"
+
+// serveProbe answers OPTIONS and HEAD requests with the allowed HTTP methods
+// and the Content-Types of the codecs of the route, without a body.
+func (s *Server) serveProbe(w http.ResponseWriter, r *http.Request) {
+	reg := s.current()
+	codecs := reg.codecsOf(r.URL.Path)
+	contentTypes := make([]string, 0, len(codecs))
+	for contentType := range codecs {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	w.Header().Set("Allow", allowedMethods)
+	w.Header().Set("Accept-Post", strings.Join(contentTypes, ", "))
+
+	pathMethod := s.pathMethod(r.URL.Path)
+	if _, err := reg.callable(pathMethod); err != nil && pathMethod != "*" {
+		w.WriteHeader(404)
+		return
+	}
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(204)
+		return
+	}
+	if len(contentTypes) > 0 {
+		w.Header().Set("Content-Type", contentTypes[0])
+	}
+	w.WriteHeader(200)
 }
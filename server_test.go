@@ -0,0 +1,2233 @@
+package rpcserver_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/jsonrpc2"
+	"github.com/datalinkE/rpcserver/rpcservertest"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (t *Arith) Multiply(r *http.Request, args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Check(r *http.Request, args *Args) error {
+	if args.B == 0 {
+		return jsonrpc2.NewError(jsonrpc2.E_BAD_PARAMS, "B must not be zero", nil)
+	}
+	return nil
+}
+
+func (t *Arith) Sum(r *http.Request, args *Args) (*int, error) {
+	if args.A < 0 {
+		return nil, nil
+	}
+	sum := args.A + args.B
+	return &sum, nil
+}
+
+func (t *Arith) Add(r *http.Request, a, b int) (int, error) {
+	return a + b, nil
+}
+
+func (t *Arith) Max(r *http.Request, first int, rest ...int) (int, error) {
+	for _, v := range rest {
+		if v > first {
+			first = v
+		}
+	}
+	return first, nil
+}
+
+func (t *Arith) Keys(r *http.Request, args map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (t *Arith) Square(r *http.Request, x float64) (float64, error) {
+	return x * x, nil
+}
+
+func (t *Arith) Count(r *http.Request, values []interface{}) (int, error) {
+	return len(values), nil
+}
+
+type Event struct {
+	At    time.Time
+	Every time.Duration `json:"every"`
+}
+
+func (t *Arith) Reschedule(r *http.Request, args *Event) (*Event, error) {
+	return &Event{At: args.At.Add(args.Every), Every: args.Every}, nil
+}
+
+type Account struct {
+	ID      int64
+	Balance uint64 `json:"balance"`
+	Count   int    `json:"count"`
+}
+
+func (t *Arith) Deposit(r *http.Request, args *Account) (*Account, error) {
+	return &Account{ID: args.ID, Balance: args.Balance + 1, Count: args.Count + 1}, nil
+}
+
+func newServer(t testing.TB) *rpcserver.Server {
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	return server
+}
+
+func serve(server http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+func TestProbeMethods(t *testing.T) {
+	server := newServer(t)
+
+	w := serve(server, "OPTIONS", "/rpc/Multiply", "")
+	if w.Code != 204 || w.Header().Get("Allow") != "POST, OPTIONS, HEAD" || w.Header().Get("Accept-Post") != "application/json" {
+		t.Fatalf("unexpected OPTIONS response %d %v", w.Code, w.Header())
+	}
+
+	w = serve(server, "HEAD", "/rpc/Multiply", "")
+	if w.Code != 200 || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected HEAD response %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = serve(server, "HEAD", "/rpc/Missing", "")
+	if w.Code != 404 {
+		t.Fatalf("expected 404 for unknown method, got %d", w.Code)
+	}
+
+	w = serve(server, "GET", "/rpc/Multiply", "")
+	if w.Code != 405 || w.Header().Get("Allow") != "POST, OPTIONS, HEAD" {
+		t.Fatalf("unexpected GET response %d %v", w.Code, w.Header())
+	}
+}
+
+func TestTransportErrorsUseCodec(t *testing.T) {
+	server := newServer(t)
+	server.MaxBodyBytes = 64
+
+	for _, tc := range []struct {
+		method, path, contentType, body string
+		status                          int
+		expected                        string
+	}{
+		{"GET", "/rpc/Multiply", "application/json", "", 405, `"code":-32600`},
+		{"POST", "/rpc/Missing", "application/json", "{}", 404, `"code":-32601`},
+		{"POST", "/rpc/Multiply", "text/xml", "<xml/>", 415, "unrecognized Content-Type"},
+		{"POST", "/rpc/Multiply", "application/json", `{"jsonrpc": "2.0", "method": "Multiply", "params": {"A": 100000000000000000000000000000000}}`, 413, `"code":-32600`},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != tc.status || !bytes.Contains(w.Body.Bytes(), []byte(tc.expected)) {
+			t.Errorf("%s %s: expected %d with %q, got %d %q", tc.method, tc.path, tc.status, tc.expected, w.Code, w.Body.String())
+		}
+		if tc.status != 415 && w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Errorf("%s %s: unexpected Content-Type %q", tc.method, tc.path, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// A client accepting JSON gets a JSON error for an unknown Content-Type.
+	r := httptest.NewRequest("POST", "/rpc/Multiply", bytes.NewBufferString("<xml/>"))
+	r.Header.Set("Content-Type", "text/xml")
+	r.Header.Set("Accept", "text/html, application/json;q=0.9")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != 415 || !bytes.Contains(w.Body.Bytes(), []byte(`"jsonrpc":"2.0"`)) {
+		t.Errorf("expected JSON encoded 415, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestErrorWriter(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(server, "POST", "/rpc/Multiply", "{}")
+	if w.Code != 415 || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("expected text/plain 415, got %d %v", w.Code, w.Header())
+	}
+
+	server.ErrorWriter = rpcserver.JSONErrorWriter{}
+	r := httptest.NewRequest("POST", "/rpc/Multiply", bytes.NewBufferString("{}"))
+	r.Header.Set(rpcserver.RequestIDHeader, "req-1")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	expected := `{"status":415,"message":"rpc: unrecognized Content-Type: ","requestId":"req-1"}` + "\n"
+	if w.Code != 415 || w.Body.String() != expected {
+		t.Fatalf("unexpected JSON error %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestReplyVariants(t *testing.T) {
+	server := newServer(t)
+	for _, tc := range []struct {
+		method, params, expected string
+	}{
+		{"Check", `{"A": 1, "B": 2}`, `{"jsonrpc":"2.0","result":null,"id":1}`},
+		{"Check", `{"A": 1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"B must not be zero"},"id":1}`},
+		{"Sum", `{"A": 1, "B": 2}`, `{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"Sum", `{"A": -1}`, `{"jsonrpc":"2.0","result":null,"id":1}`},
+	} {
+		body := `{"jsonrpc": "2.0", "method": "` + tc.method + `", "id": 1, "params": ` + tc.params + `}`
+		w := serve(server, "POST", "/rpc/"+tc.method, body)
+		if w.Code != 200 || w.Body.String() != tc.expected+"\n" {
+			t.Errorf("%s %s: unexpected response %d %q", tc.method, tc.params, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPositionalParams(t *testing.T) {
+	server := newServer(t)
+	for _, tc := range []struct {
+		method, params, expected string
+	}{
+		{"Multiply", `[3, 4]`, `"result":12`},
+		{"Multiply", `[{"A": 3, "B": 4}]`, `"result":12`},
+		{"Multiply", `[3, 4, 5]`, `"code":-32600`},
+		{"Add", `[3, 4]`, `"result":7`},
+		{"Add", `{"P0": 3, "P1": 4}`, `"result":7`},
+		{"Add", `[3, "4"]`, `"code":-32600`},
+		{"Max", `[3]`, `"result":3`},
+		{"Max", `[3, 9, 4]`, `"result":9`},
+	} {
+		body := `{"jsonrpc": "2.0", "method": "` + tc.method + `", "id": 1, "params": ` + tc.params + `}`
+		w := serve(server, "POST", "/rpc/"+tc.method, body)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s %s: expected %s, got %q", tc.method, tc.params, tc.expected, w.Body.String())
+		}
+	}
+}
+
+func TestFreeFormArgs(t *testing.T) {
+	server := newServer(t)
+	for _, tc := range []struct {
+		method, params, expected string
+	}{
+		{"Keys", `{"b": 1, "a": {"c": 2}}`, `"result":["a","b"]`},
+		{"Keys", `[{"a": 1}]`, `"result":["a"]`},
+		{"Square", `3`, `"result":9`},
+		{"Square", `[3]`, `"result":9`},
+		{"Square", `"3"`, `"code":-32600`},
+		{"Count", `[1, "two", null]`, `"result":3`},
+	} {
+		body := `{"jsonrpc": "2.0", "method": "` + tc.method + `", "id": 1, "params": ` + tc.params + `}`
+		w := serve(server, "POST", "/rpc/"+tc.method, body)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s %s: expected %s, got %q", tc.method, tc.params, tc.expected, w.Body.String())
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	server := newServer(t)
+	for _, tc := range []struct {
+		formats          rpcserver.Formats
+		params, expected string
+	}{
+		{rpcserver.Formats{}, `{"At": "2017-01-02T03:04:05Z", "every": 60000000000}`, `{"At":"2017-01-02T03:05:05Z","every":60000000000}`},
+		{rpcserver.Formats{Time: rpcserver.TimeUnix, Duration: rpcserver.DurationString}, `{"At": 1483326245, "every": "1m"}`, `{"At":1483326305,"every":"1m0s"}`},
+		{rpcserver.Formats{Time: rpcserver.TimeUnixMillis, Duration: rpcserver.DurationMillis}, `{"at": "2017-01-02T03:04:05Z", "every": 1500}`, `{"At":1483326246500,"every":1500}`},
+		{rpcserver.Formats{Duration: rpcserver.DurationMillis}, `[1483326245, "1m"]`, `{"At":"2017-01-02T03:05:05Z","every":60000}`},
+	} {
+		server.Formats = tc.formats
+		body := `{"jsonrpc": "2.0", "method": "Reschedule", "id": 1, "params": ` + tc.params + `}`
+		w := serve(server, "POST", "/rpc/Reschedule", body)
+		expected := `{"jsonrpc":"2.0","result":` + tc.expected + `,"id":1}` + "\n"
+		if w.Body.String() != expected {
+			t.Errorf("%+v: expected %q, got %q", tc.formats, expected, w.Body.String())
+		}
+	}
+}
+
+func TestInt64AsString(t *testing.T) {
+	server := newServer(t)
+	server.Formats.Int64AsString = true
+	for _, tc := range []struct {
+		params, expected string
+	}{
+		{`{"ID": "9007199254740993", "balance": 18446744073709551614, "count": 1}`, `"result":{"ID":"9007199254740993","balance":"18446744073709551615","count":2}`},
+		{`{"ID": 1, "balance": "2"}`, `"result":{"ID":"1","balance":"3","count":1}`},
+		{`{"ID": "x"}`, `"code":-32600`},
+	} {
+		body := `{"jsonrpc": "2.0", "method": "Deposit", "id": 1, "params": ` + tc.params + `}`
+		w := serve(server, "POST", "/rpc/Deposit", body)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %q", tc.params, tc.expected, w.Body.String())
+		}
+	}
+}
+
+// countingJSON is a JSONEngine counting its calls.
+type countingJSON struct {
+	marshal, unmarshal int
+}
+
+func (c *countingJSON) Marshal(v interface{}) ([]byte, error) {
+	c.marshal++
+	return json.Marshal(v)
+}
+
+func (c *countingJSON) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshal++
+	return json.Unmarshal(data, v)
+}
+
+func TestJSONEngine(t *testing.T) {
+	server := newServer(t)
+	engine := new(countingJSON)
+	server.JSON = engine
+	w := serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": {"A": 3, "B": 4}}`)
+	if w.Body.String() != `{"jsonrpc":"2.0","result":12,"id":1}`+"\n" {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+	if engine.marshal != 1 || engine.unmarshal != 1 {
+		t.Errorf("expected the engine to encode the reply and decode the args, got %+v", engine)
+	}
+}
+
+type Greeter struct{}
+
+func (g *Greeter) Hello(r *http.Request, args *string, reply *string) error {
+	*reply = "hello " + *args
+	return nil
+}
+
+func TestConcurrentRegistration(t *testing.T) {
+	server := newServer(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+			server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc { return next })
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		w := serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`)
+		if !strings.Contains(w.Body.String(), `"result":12`) {
+			t.Fatalf("unexpected response %q", w.Body.String())
+		}
+	}
+	<-done
+
+	if err := server.RegisterService(new(Greeter)); err != nil {
+		t.Fatal(err)
+	}
+	if server.HasMethod("Multiply") || !server.HasMethod("Hello") {
+		t.Errorf("expected the service to be replaced")
+	}
+	w := serve(server, "POST", "/rpc/Hello", `{"jsonrpc": "2.0", "method": "Hello", "id": 1, "params": ["world"]}`)
+	if !strings.Contains(w.Body.String(), `"result":"hello world"`) {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+}
+
+type builtins struct {
+	prefix string
+}
+
+func (b builtins) Echo(r *http.Request, message string) (string, error) {
+	return b.prefix + message, nil
+}
+
+func TestBuiltins(t *testing.T) {
+	server := newServer(t)
+	if err := server.RegisterBuiltin("rpc.echo", builtins{"echo: "}, "Echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterBuiltin("rpc.missing", builtins{}, "Missing"); err == nil {
+		t.Errorf("expected an error for a missing method")
+	}
+	for i := 0; i < 2; i++ {
+		w := serve(server, "POST", "/rpc/rpc.echo", `{"jsonrpc": "2.0", "method": "rpc.echo", "id": 1, "params": ["hi"]}`)
+		if !strings.Contains(w.Body.String(), `"result":"echo: hi"`) {
+			t.Errorf("unexpected response %q", w.Body.String())
+		}
+		if !server.HasMethod("rpc.echo") {
+			t.Errorf("expected the builtin to be registered")
+		}
+		if err := server.RegisterService(new(Greeter)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	server := newServer(t)
+	if err := server.RegisterGroup("greetings", new(Greeter)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterGroup("greetings.mock", new(MockGreeter)); err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(server.GroupMethodNames(), ","); names != "greetings.Hello,greetings.mock.Hello" {
+		t.Errorf("unexpected group methods %s", names)
+	}
+	body := `{"jsonrpc": "2.0", "method": "greetings.mock.Hello", "id": 1, "params": "world"}`
+	if w := serve(server, "POST", "/rpc/greetings.mock.Hello", body); !strings.Contains(w.Body.String(), `"result":"mock hello world"`) {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+
+	server.PathTemplate = "/jsonrpc/v2/{method}"
+	for path, expected := range map[string]string{
+		"/jsonrpc/v2/greetings/mock/Hello": `"result":"mock hello world"`,
+		"/jsonrpc/v2/greetings/Hello":      "does not end with method Name",
+		"/rpc/greetings.mock.Hello":        "can't find method",
+	} {
+		if w := serve(server, "POST", path, body); !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("%s: unexpected response %s", path, w.Body.String())
+		}
+	}
+	body = `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": {"A": 2, "B": 3}}`
+	if w := serve(server, "POST", "/jsonrpc/v2/Multiply", body); !strings.Contains(w.Body.String(), `"result":6`) {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+}
+
+func TestFallbackHandler(t *testing.T) {
+	server := newServer(t)
+	server.SetFallbackHandler(func(method string, raw json.RawMessage) (interface{}, error) {
+		if method == "Fail" {
+			return nil, errors.New("failed")
+		}
+		return map[string]string{"method": method, "params": string(raw)}, nil
+	})
+	for method, expected := range map[string]string{
+		"Plugin.Run": `"result":{"method":"Plugin.Run","params":"[1,2]"}`,
+		"Fail":       `"message":"failed"`,
+		"Multiply":   `"result":2`,
+	} {
+		body := `{"jsonrpc": "2.0", "method": "` + method + `", "id": 1, "params": [1,2]}`
+		if method == "Multiply" {
+			body = `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": {"A": 1, "B": 2}}`
+		}
+		if w := serve(server, "POST", "/rpc/"+method, body); !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("%s: unexpected response %s", method, w.Body.String())
+		}
+	}
+	server.SetFallbackHandler(nil)
+	if w := serve(server, "POST", "/rpc/Plugin.Run", `{"jsonrpc": "2.0", "method": "Plugin.Run", "id": 1}`); w.Code != 404 {
+		t.Errorf("expected 404 without fallback handler, got %d", w.Code)
+	}
+}
+
+func TestRouteCodecs(t *testing.T) {
+	server := newServer(t)
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/x-internal")
+	server.SetRouteCodecs("/public/", "application/json")
+	server.SetRouteCodecs("/public/beta/", "application/x-beta")
+	call := func(path, contentType string) int {
+		r := httptest.NewRequest("POST", path, strings.NewReader(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": {"A": 2, "B": 3}}`))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, tc := range []struct {
+		path, contentType string
+		status            int
+	}{
+		{"/rpc/Multiply", "application/json", 200},
+		{"/rpc/Multiply", "application/x-internal", 200},
+		{"/public/Multiply", "application/json", 200},
+		{"/public/Multiply", "application/x-internal", 415},
+		{"/public/beta/Multiply", "application/json", 415},
+	} {
+		if status := call(tc.path, tc.contentType); status != tc.status {
+			t.Errorf("%s %s: expected %d, got %d", tc.path, tc.contentType, tc.status, status)
+		}
+	}
+	if w := serve(server, "OPTIONS", "/public/Multiply", ""); w.Header().Get("Accept-Post") != "application/json" {
+		t.Errorf("unexpected Accept-Post of the route %q", w.Header().Get("Accept-Post"))
+	}
+	if w := serve(server, "OPTIONS", "/rpc/Multiply", ""); w.Header().Get("Accept-Post") != "application/json, application/x-internal" {
+		t.Errorf("unexpected Accept-Post %q", w.Header().Get("Accept-Post"))
+	}
+
+	// Codecs registered later join their routes.
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/x-beta")
+	if status := call("/public/beta/Multiply", "application/x-beta"); status != 200 {
+		t.Errorf("expected the codec registered later on the route, got %d", status)
+	}
+	server.SetRouteCodecs("/public/")
+	if status := call("/public/Multiply", "application/x-internal"); status != 200 {
+		t.Errorf("expected every codec once the route is unrestricted, got %d", status)
+	}
+}
+
+type MockGreeter struct{}
+
+func (g *MockGreeter) Hello(r *http.Request, args *string, reply *string) error {
+	*reply = "mock hello " + *args
+	return nil
+}
+
+type RudeGreeter struct{}
+
+func (g *RudeGreeter) Hello(r *http.Request, args *string) (string, error) {
+	return "go away", nil
+}
+
+func TestImplementations(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Greeter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	if err := server.RegisterImplementation("mock", new(MockGreeter)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterImplementation("rude", new(RudeGreeter)); err == nil {
+		t.Error("expected an error for another signature")
+	}
+	if err := server.RegisterImplementation("arith", new(Arith)); err == nil {
+		t.Error("expected an error for a missing method")
+	}
+	server.Use(rpcserver.SelectImplementation(func(r *http.Request) string {
+		return r.Header.Get("X-Implementation")
+	}))
+	for implementation, expected := range map[string]string{
+		"":        `"result":"hello world"`,
+		"mock":    `"result":"mock hello world"`,
+		"missing": `rpc: unknown implementation \"missing\"`,
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Hello", strings.NewReader(`{"jsonrpc": "2.0", "method": "Hello", "id": 1, "params": "world"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Implementation", implementation)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("%q: unexpected response %s", implementation, w.Body.String())
+		}
+	}
+}
+
+func TestContextValues(t *testing.T) {
+	server := newServer(t)
+	var method, codec string
+	var caller rpcserver.Caller
+	var start time.Time
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			method, _ = rpcserver.MethodFromContext(ctx)
+			codec, _ = rpcserver.CodecNameFromContext(ctx)
+			caller, _ = rpcserver.CallerFromContext(ctx)
+			start, _ = rpcserver.StartTimeFromContext(ctx)
+			return next(ctx, call)
+		}
+	})
+
+	before := time.Now()
+	r := httptest.NewRequest("POST", "/rpc/Multiply", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	r.Header.Set("User-Agent", "test")
+	server.TrustedProxies, _ = rpcserver.ParseNetworks("192.0.2.1", "10.0.0.2")
+	r.Header.Set(rpcserver.RequestIDHeader, "req-1")
+	server.ServeHTTP(httptest.NewRecorder(), r)
+
+	if method != "Multiply" || codec != "application/json" {
+		t.Errorf("unexpected method %q and codec %q", method, codec)
+	}
+	if caller != (rpcserver.Caller{Addr: "10.0.0.1", UserAgent: "test", RequestID: "req-1"}) {
+		t.Errorf("unexpected caller %+v", caller)
+	}
+	if start.Before(before) || start.After(time.Now()) {
+		t.Errorf("unexpected start time %v", start)
+	}
+	if _, ok := rpcserver.MethodFromContext(context.Background()); ok {
+		t.Errorf("expected no method outside of a call")
+	}
+}
+
+type Worker struct {
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func (w *Worker) Wait(r *http.Request, args *string) error {
+	close(w.started)
+	<-r.Context().Done()
+	close(w.stopped)
+	return r.Context().Err()
+}
+
+func TestCancelledCall(t *testing.T) {
+	worker := &Worker{started: make(chan struct{}), stopped: make(chan struct{})}
+	server, err := rpcserver.NewServer(worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/rpc/Wait", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Wait", "id": 1, "params": "x"}`)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	go func() {
+		<-worker.started
+		cancel()
+	}()
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	select {
+	case <-worker.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the method to see the cancellation")
+	}
+	if !strings.Contains(w.Body.String(), "cancel") {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+	if stats := server.Stats(); stats.CancelledCalls != 1 {
+		t.Errorf("expected 1 cancelled call, got %+v", stats)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	worker := &Worker{started: make(chan struct{}), stopped: make(chan struct{})}
+	server, err := rpcserver.NewServer(worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+
+	r := httptest.NewRequest("POST", "/rpc/Wait", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Wait", "id": 1, "params": "x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(rpcserver.DeadlineHeader, "10m")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"code":-32001`) {
+		t.Errorf("expected a deadline exceeded error, got %q", w.Body.String())
+	}
+	if stats := server.Stats(); stats.DeadlineExceededCalls != 1 || stats.CancelledCalls != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	r = httptest.NewRequest("POST", "/rpc/Wait", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Wait", "id": 1, "params": "x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(rpcserver.DeadlineHeader, "soon")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != 400 {
+		t.Errorf("expected 400 for an invalid deadline, got %d", w.Code)
+	}
+}
+
+func TestTimeoutFormat(t *testing.T) {
+	for d, s := range map[time.Duration]string{
+		250 * time.Millisecond: "250000u",
+		1500 * time.Nanosecond: "1500n",
+		2 * time.Hour:          "7200000m",
+		200 * time.Hour:        "720000S",
+		time.Millisecond*99999999 + time.Nanosecond: "100000S",
+	} {
+		if got := rpcserver.FormatTimeout(d); got != s {
+			t.Errorf("FormatTimeout(%v) = %q, expected %q", d, got, s)
+		}
+	}
+	for s, d := range map[string]time.Duration{
+		"250m": 250 * time.Millisecond,
+		"3S":   3 * time.Second,
+		"1H":   time.Hour,
+		"7n":   7,
+	} {
+		if got, err := rpcserver.ParseTimeout(s); err != nil || got != d {
+			t.Errorf("ParseTimeout(%q) = %v, %v, expected %v", s, got, err, d)
+		}
+	}
+	for _, s := range []string{"", "5", "-5m", "123456789m", "5x", "m"} {
+		if _, err := rpcserver.ParseTimeout(s); err == nil {
+			t.Errorf("expected ParseTimeout(%q) to fail", s)
+		}
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	server := newServer(t)
+	var trace rpcserver.Trace
+	var traced bool
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			trace, traced = rpcserver.TraceFromContext(ctx)
+			return next(ctx, call)
+		}
+	})
+
+	for _, header := range []http.Header{
+		{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+		{"B3": {"4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1"}},
+		{"X-B3-Traceid": {"4bf92f3577b34da6a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}, "X-B3-Sampled": {"1"}},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Multiply", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`))
+		r.Header = header
+		r.Header.Set("Content-Type", "application/json")
+		traced = false
+		server.ServeHTTP(httptest.NewRecorder(), r)
+		if !traced || trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.ParentSpanID != "00f067aa0ba902b7" || !trace.Sampled {
+			t.Errorf("unexpected trace %+v from %v", trace, header)
+		}
+		if len(trace.SpanID) != 16 || trace.SpanID == trace.ParentSpanID {
+			t.Errorf("expected a new span, got %+v", trace)
+		}
+	}
+
+	last := trace
+	traced = false
+	w := serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`)
+	if traced || w.Code != 200 {
+		t.Errorf("expected no trace without trace headers, got %+v", trace)
+	}
+
+	h := make(http.Header)
+	last.Inject(h)
+	if parsed, ok := rpcserver.ParseTrace(h); !ok || parsed.TraceID != last.TraceID || parsed.SpanID != last.SpanID || !parsed.Sampled {
+		t.Errorf("expected %+v to round-trip, got %+v", last, parsed)
+	}
+	h.Del(rpcserver.TraceparentHeader)
+	if parsed, ok := rpcserver.ParseTrace(h); !ok || parsed != last {
+		t.Errorf("expected %+v to round-trip through b3, got %+v", last, parsed)
+	}
+	for _, value := range []string{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"} {
+		if _, ok := rpcserver.ParseTrace(http.Header{"Traceparent": {value}}); ok {
+			t.Errorf("expected traceparent %q to be rejected", value)
+		}
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	server := newServer(t)
+	server.MaxLabelValues = 2
+	var tenant string
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			if tenant != "" {
+				rpcserver.SetMetricLabel(ctx, "tenant", tenant)
+			}
+			return next(ctx, call)
+		}
+	})
+
+	for _, tenant = range []string{"", "a", "b", "a", "c", "d"} {
+		serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`)
+	}
+	tenant = ""
+	serve(server, "POST", "/rpc/Check", `{"jsonrpc": "2.0", "method": "Check", "id": 1, "params": {"A": 1, "B": 0}}`)
+
+	counts := make(map[string]int64)
+	for _, m := range server.Stats().Methods {
+		counts[m.Method+"/"+m.Labels["tenant"]] = m.Calls
+		if m.Method == "Check" && m.Errors != 1 {
+			t.Errorf("expected the Check call to fail, got %+v", m)
+		}
+	}
+	expected := map[string]int64{"Multiply/": 1, "Multiply/a": 2, "Multiply/b": 1, "Multiply/other": 2, "Check/": 1}
+	if len(counts) != len(expected) {
+		t.Fatalf("unexpected method stats %v", counts)
+	}
+	for key, n := range expected {
+		if counts[key] != n {
+			t.Errorf("expected %d calls for %s, got %v", n, key, counts)
+		}
+	}
+	if rpcserver.SetMetricLabel(context.Background(), "tenant", "a") {
+		t.Errorf("expected no label outside of a call")
+	}
+}
+
+type Login struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func (t *Arith) Login(r *http.Request, args *Login) error {
+	time.Sleep(2 * time.Millisecond)
+	return nil
+}
+
+func TestSlowCalls(t *testing.T) {
+	server := newServer(t)
+	var logged []rpcserver.SlowCall
+	server.SlowCalls = &rpcserver.SlowCallPolicy{
+		Methods:     map[string]time.Duration{"Login": time.Millisecond},
+		SampleEvery: 2,
+		Log:         func(call rpcserver.SlowCall) { logged = append(logged, call) },
+	}
+	for i := 0; i < 3; i++ {
+		serve(server, "POST", "/rpc/Login", `{"jsonrpc": "2.0", "method": "Login", "id": 1, "params": {"user": "joe", "password": "hunter2"}}`)
+	}
+	serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`)
+
+	if len(logged) != 3 {
+		t.Fatalf("expected 3 slow calls, got %+v", logged)
+	}
+	args, _ := json.Marshal(logged[0].Args)
+	if string(args) != `{"password":"[REDACTED]","user":"joe"}` {
+		t.Errorf("unexpected logged args %s", args)
+	}
+	if logged[1].Args != nil || logged[2].Args == nil {
+		t.Errorf("expected one call out of two to be sampled, got %+v", logged)
+	}
+	for _, m := range server.Stats().Methods {
+		if (m.Method == "Login" && m.SlowCalls != 3) || (m.Method == "Multiply" && m.SlowCalls != 0) {
+			t.Errorf("unexpected slow calls %+v", m)
+		}
+	}
+}
+
+func TestSLOBurnRate(t *testing.T) {
+	server := newServer(t)
+	var alerts []rpcserver.BurnAlert
+	server.SLO = &rpcserver.SLOPolicy{
+		Objective: 0.9,
+		BurnRate:  2,
+		MinCalls:  5,
+		OnBurn:    func(alert rpcserver.BurnAlert) { alerts = append(alerts, alert) },
+	}
+	check := func(b int) {
+		serve(server, "POST", "/rpc/Check", fmt.Sprintf(`{"jsonrpc": "2.0", "method": "Check", "id": 1, "params": {"A": 1, "B": %d}}`, b))
+	}
+
+	for i := 0; i < 4; i++ {
+		check(0)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alert below MinCalls, got %+v", alerts)
+	}
+	check(0)
+	if len(alerts) != 1 || !alerts[0].Burning || alerts[0].Calls != 5 || alerts[0].BurnRate < 9.99 {
+		t.Fatalf("expected a burning alert, got %+v", alerts)
+	}
+	if burning := server.SLO.Burning(); len(burning) != 1 || burning[0] != "Check" {
+		t.Fatalf("unexpected burning methods %v", burning)
+	}
+
+	// 5 errors out of 26 calls is a burn rate under 2.
+	for i := 0; i < 21; i++ {
+		check(1)
+	}
+	if len(alerts) != 2 || alerts[1].Burning || alerts[1].Calls != 26 {
+		t.Fatalf("expected the method to stop burning, got %+v", alerts)
+	}
+	if burning := server.SLO.Burning(); len(burning) != 0 {
+		t.Fatalf("unexpected burning methods %v", burning)
+	}
+}
+
+func TestRequestStats(t *testing.T) {
+	server := newServer(t)
+	serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`)
+	serve(server, "POST", "/rpc/Check", `{"jsonrpc": "2.0", "method": "Check", "id": 1, "params": {"A": 1, "B": 0}}`)
+	serve(server, "POST", "/rpc/Missing", `{}`)
+	serve(server, "GET", "/rpc/Multiply", "")
+
+	stats := server.Stats()
+	if stats.Requests != 4 || stats.Codecs["application/json"] != 3 {
+		t.Errorf("unexpected request counts %+v", stats)
+	}
+	if len(stats.Errors) != 3 || stats.Errors[400] != 1 || stats.Errors[404] != 1 || stats.Errors[405] != 1 {
+		t.Errorf("unexpected error counts %v", stats.Errors)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	server := newServer(t)
+	var buf bytes.Buffer
+	logger := rpcserver.NewAccessLogger(&buf, rpcserver.AccessLogJSON)
+	server.AccessLog = logger
+	r := httptest.NewRequest("POST", "/rpc/Multiply", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "test")
+	r.Header.Set(rpcserver.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	var entry rpcserver.AccessLogEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("unexpected log %q: %v", buf.String(), err)
+	}
+	if entry.Method != "Multiply" || entry.Status != 200 || entry.Bytes != int64(w.Body.Len()) || entry.RemoteAddr != "192.0.2.1" ||
+		entry.UserAgent != "test" || entry.RequestID != "req-1" || entry.Duration <= 0 {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	buf.Reset()
+	serve(server, "POST", "/rpc/Check", `{"jsonrpc": "2.0", "method": "Check", "id": 1, "params": {"A": 1, "B": 0}}`)
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil || entry.Error != "B must not be zero" {
+		t.Errorf("expected the call error in %q", buf.String())
+	}
+
+	buf.Reset()
+	logger.Format = rpcserver.AccessLogCombined
+	serve(server, "POST", "/rpc/Missing", `{}`)
+	line := buf.String()
+	if !strings.HasPrefix(line, "192.0.2.1 - - [") || !strings.Contains(line, `] "POST /rpc/Missing HTTP/1.1" 404 `) || !strings.HasSuffix(line, ` "-"`+"\n") {
+		t.Errorf("unexpected combined log line %q", line)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	server := newServer(t)
+	clock := rpcservertest.NewFakeClock(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+	server.Clock = clock
+	server.MaxBodyBytes = 1000
+	if limits := server.Limits(); limits.MaxBodyBytes != 1000 {
+		t.Fatalf("expected the limits of the fields, got %+v", limits)
+	}
+	server.SetLimits(rpcserver.Limits{DisabledMethods: []string{"Check"}, RateLimit: 1, RateBurst: 2})
+
+	multiply := func() int {
+		return serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`).Code
+	}
+	if w := serve(server, "POST", "/rpc/Check", `{"jsonrpc": "2.0", "method": "Check", "id": 1, "params": {"A": 1, "B": 1}}`); w.Code != 503 {
+		t.Errorf("expected a disabled method, got %d", w.Code)
+	}
+	if a, b, c := multiply(), multiply(), multiply(); a != 200 || b != 200 || c != 429 {
+		t.Errorf("expected a burst of 2 calls, got %d %d %d", a, b, c)
+	}
+	clock.Advance(time.Second)
+	if a, b := multiply(), multiply(); a != 200 || b != 429 {
+		t.Errorf("expected a call per second, got %d %d", a, b)
+	}
+
+	server.SetLimits(rpcserver.Limits{})
+	if code := multiply(); code != 200 {
+		t.Errorf("expected no limits, got %d", code)
+	}
+}
+
+// tricklingReader reads a byte at a time, advancing the clock by a second
+// before each.
+type tricklingReader struct {
+	body  string
+	clock *rpcservertest.FakeClock
+}
+
+func (r *tricklingReader) Read(p []byte) (int, error) {
+	if r.body == "" {
+		return 0, io.EOF
+	}
+	r.clock.Advance(time.Second)
+	p[0], r.body = r.body[0], r.body[1:]
+	return 1, nil
+}
+
+func TestMinBodyRate(t *testing.T) {
+	server := newServer(t)
+	clock := rpcservertest.NewFakeClock(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+	server.Clock = clock
+	server.SetLimits(rpcserver.Limits{MinBodyRate: 10, BodyGracePeriod: 2 * time.Second})
+	body := `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`
+
+	if w := serve(server, "POST", "/rpc/Multiply", body); w.Code != 200 {
+		t.Errorf("expected a body sent at once, got %d %s", w.Code, w.Body)
+	}
+	r := httptest.NewRequest("POST", "/rpc/Multiply", &tricklingReader{body: body, clock: clock})
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != 408 || w.Header().Get("Connection") != "close" || !strings.Contains(w.Body.String(), rpcserver.ErrBodyTooSlow.Error()) {
+		t.Errorf("expected a trickled body to be refused, got %d %v %s", w.Code, w.Header(), w.Body)
+	}
+}
+
+func TestComplexityLimits(t *testing.T) {
+	server := newServer(t)
+	server.SetLimits(rpcserver.Limits{MaxDepth: 4, MaxArrayLen: 3, MaxStringLen: 7, MaxKeys: 5})
+	for _, tc := range []struct {
+		method, params, expected string
+	}{
+		{"Count", `[1, [2, [3]], "h\u00e9llo"]`, `"result":3`},
+		{"Count", `[1, [2, [3, [4]]]]`, `"message":"rpc: payload too complex: nesting depth exceeds 4"`},
+		{"Count", `[1, 2, 3, 4]`, `"message":"rpc: payload too complex: array length exceeds 3"`},
+		{"Count", `["héllo!!!"]`, `"message":"rpc: payload too complex: string length exceeds 7"`},
+		{"Keys", `{"a": 1, "b": 2, "c": "[[,,"}`, `"result":["a","b","c"]`},
+		{"Keys", `{"a": 1, "h\u00e9llo!!!": 2}`, `"message":"rpc: payload too complex: key length exceeds 7"`},
+		{"Keys", `{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6}`, `"message":"rpc: payload too complex: object key count exceeds 5"`},
+	} {
+		w := serve(server, "POST", "/rpc/"+tc.method, `{"jsonrpc": "2.0", "method": "`+tc.method+`", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	server := newServer(t)
+	body := `{"jsonrpc": "2.0", "method": "Keys", "id": 1, "params": {"a": 1, "\u0061": [2]}}`
+	if w := serve(server, "POST", "/rpc/Keys", body); !strings.Contains(w.Body.String(), `"result":["a"]`) {
+		t.Errorf("expected the last value to win, got %s", w.Body)
+	}
+	server.StrictJSON = true
+	for _, tc := range []struct {
+		method, params, expected string
+	}{
+		{"Keys", `{"a": 1, "b": {"a": 2}}`, `"result":["a","b"]`},
+		{"Keys", `{"a": 1, "\u0061": [2]}`, `"message":"rpc: duplicate key: \"a\" at params.a"`},
+		{"Keys", `{"a": 1, "A": 2}`, `"result":["A","a"]`},
+		{"Multiply", `{"A": 2, "a": 3}`, `"message":"rpc: duplicate key: \"a\" at params.a, the field of \"A\""`},
+		{"Multiply", `{"A": 2, "b": 3, "c": 4, "C": 5}`, `"result":6`},
+		{"Count", `[{"x": 1}, {"y": [{"z": 1, "z": 2}]}]`, `"message":"rpc: duplicate key: \"z\" at params[1].y[0].z"`},
+	} {
+		w := serve(server, "POST", "/rpc/"+tc.method, `{"jsonrpc": "2.0", "method": "`+tc.method+`", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+	if w := serve(server, "POST", "/rpc/Keys", `{"jsonrpc": "2.0", "id": 1, "method": "Keys", "id": 2, "params": {}}`); w.Code != 400 {
+		t.Errorf("expected a duplicate id to be rejected, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCharsets(t *testing.T) {
+	server := newServer(t)
+	utf16le := func(s string) string {
+		var b []byte
+		for _, r := range s {
+			b = append(b, byte(r), byte(r>>8))
+		}
+		return string(b)
+	}
+	body := `{"jsonrpc": "2.0", "method": "Keys", "id": 1, "params": {"caf\u00e9": 1, "KEY": 2}}`
+	for _, tc := range []struct {
+		contentType, body string
+		status            int
+		expected          string
+	}{
+		{"application/json; charset=UTF-8", body, 200, `"result":["KEY","café"]`},
+		{"application/json; charset=iso-8859-1", strings.Replace(body, `\u00e9`, "\xe9", 1), 200, `"result":["KEY","café"]`},
+		{"application/json; charset=utf-16le", utf16le(body), 200, `"result":["KEY","café"]`},
+		{"application/json", strings.Replace(body, `\u00e9`, "\xe9", 1), 400, `invalid UTF-8`},
+		{"application/json; charset=ebcdic", body, 415, `unsupported charset: ebcdic`},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Keys", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.contentType, tc.status, tc.expected, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCompressedBodies(t *testing.T) {
+	server := newServer(t)
+	server.SetLimits(rpcserver.Limits{MaxBodyBytes: 200, MaxDecompressedBytes: 1000})
+	body := `{"jsonrpc": "2.0", "method": "Count", "id": 1, "params": [1, 2, 3]}`
+	compress := func(encoding, body string) string {
+		var b bytes.Buffer
+		var zw io.WriteCloser
+		if encoding == "deflate" {
+			zw = zlib.NewWriter(&b)
+		} else {
+			zw = gzip.NewWriter(&b)
+		}
+		io.WriteString(zw, body)
+		zw.Close()
+		return b.String()
+	}
+	large := `{"jsonrpc": "2.0", "method": "Count", "id": 1, "params": [` + strings.Repeat(`"x", `, 300) + `1]}`
+	for _, tc := range []struct {
+		encoding, body string
+		status         int
+		expected       string
+	}{
+		{"gzip", compress("gzip", body), 200, `"result":3`},
+		{"deflate", compress("deflate", body), 200, `"result":3`},
+		{"deflate, gzip", compress("gzip", compress("deflate", body)), 200, `"result":3`},
+		{"identity", body, 200, `"result":3`},
+		{"gzip", body, 400, `invalid gzip request body`},
+		{"gzip", compress("gzip", large), 413, `decompressed request body exceeds 1000 bytes`},
+		{"br", body, 415, `unsupported Content-Encoding: br`},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Count", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Encoding", tc.encoding)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.encoding, tc.status, tc.expected, w.Code, w.Body)
+		}
+		if tc.status == 415 && w.Header().Get("Accept-Encoding") != "gzip, deflate" {
+			t.Errorf("expected the accepted encodings, got %q", w.Header().Get("Accept-Encoding"))
+		}
+	}
+}
+
+func (t *Arith) Rows(r *http.Request, values []interface{}) (int, error) {
+	rpcserver.SetTrailer(r.Context(), "X-Row-Count", fmt.Sprint(len(values)))
+	return len(values), nil
+}
+
+func TestTrailers(t *testing.T) {
+	server := newServer(t)
+	body := `{"jsonrpc": "2.0", "method": "Rows", "id": 1, "params": [1, 2]}`
+	w := serve(server, "POST", "/rpc/Rows", body)
+	if res := w.Result(); res.Header.Get("X-Row-Count") != "2" || len(res.Trailer) != 0 {
+		t.Errorf("expected the trailer as a header, got %v %v", res.Header, res.Trailer)
+	}
+
+	r := httptest.NewRequest("POST", "/rpc/Rows", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("TE", "trailers")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if res := w.Result(); res.Trailer.Get("X-Row-Count") != "2" || res.Header.Get("X-Row-Count") != "" {
+		t.Errorf("expected a trailer, got %v %v", res.Header, res.Trailer)
+	}
+	if rpcserver.SetTrailer(context.Background(), "X-Row-Count", "1") {
+		t.Error("expected no trailer outside of a call")
+	}
+
+	// HTTP/1.1 clients get the trailers after the chunked body.
+	hs := httptest.NewServer(server)
+	defer hs.Close()
+	r, _ = http.NewRequest("POST", hs.URL+"/rpc/Rows", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("TE", "trailers")
+	res, err := hs.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	reply, _ := io.ReadAll(res.Body)
+	if res.ProtoMajor != 1 || res.Trailer.Get("X-Row-Count") != "2" || !strings.Contains(string(reply), `"result"`) {
+		t.Errorf("expected the trailer over HTTP/1.1, got %s %v %s", res.Proto, res.Trailer, reply)
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	server := newServer(t)
+	body := `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`
+	if w := serve(server, "POST", "/rpc/Multiply", body); w.Header().Get("Server-Timing") != "" {
+		t.Errorf("expected no Server-Timing by default, got %q", w.Header().Get("Server-Timing"))
+	}
+	server.ServerTiming = true
+	w := serve(server, "POST", "/rpc/Multiply", body)
+	timing := w.Header().Get("Server-Timing")
+	for _, phase := range []string{"decode;dur=", "handler;dur=", "encode;dur="} {
+		if !strings.Contains(timing, phase) {
+			t.Errorf("expected %s in %q", phase, timing)
+		}
+	}
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"result":12`) {
+		t.Errorf("expected the response, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	server := newServer(t)
+	versions := map[int64]string{1: "7"}
+	server.RegisterPrecondition("Deposit", func(ctx context.Context, args interface{}) (string, error) {
+		return versions[args.(*Account).ID], nil
+	})
+	for _, tc := range []struct {
+		id, ifMatch, expected string
+	}{
+		{"1", "", `"result":{"ID":1`},
+		{"1", `"7"`, `"result":{"ID":1`},
+		{"1", `"5", "7"`, `"result":{"ID":1`},
+		{"1", `*`, `"result":{"ID":1`},
+		{"1", `"6"`, `"code":-32003`},
+		{"1", `W/"7"`, `"code":-32003`},
+		{"2", `*`, `the resource does not exist`},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Deposit", strings.NewReader(`{"jsonrpc": "2.0", "method": "Deposit", "id": 1, "params": {"ID": `+tc.id+`}}`))
+		r.Header.Set("Content-Type", "application/json")
+		if tc.ifMatch != "" {
+			r.Header.Set("If-Match", tc.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s %s: expected %s, got %s", tc.id, tc.ifMatch, tc.expected, w.Body)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	server := newServer(t)
+	var seen string
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			caller, _ := rpcserver.CallerFromContext(ctx)
+			seen = caller.Addr
+			return next(ctx, call)
+		}
+	})
+	call := func(remote string, headers ...string) *httptest.ResponseRecorder {
+		seen = ""
+		r := httptest.NewRequest("POST", "/rpc/Multiply", strings.NewReader(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = net.JoinHostPort(remote, "1234")
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Add(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// Without trusted proxies, the forwarding headers are ignored.
+	server.AllowedIPs, _ = rpcserver.ParseNetworks("203.0.113.0/24")
+	if w := call("192.0.2.1", "X-Forwarded-For", "203.0.113.9"); w.Code != 403 || seen != "" {
+		t.Errorf("expected a spoofed X-Forwarded-For to be denied, got %d %s", w.Code, w.Body)
+	}
+	if w := call("192.0.2.1", "Forwarded", "for=203.0.113.9"); w.Code != 403 {
+		t.Errorf("expected a spoofed Forwarded to be denied, got %d %s", w.Code, w.Body)
+	}
+	server.AllowedIPs = nil
+	call("192.0.2.1", "X-Forwarded-For", "203.0.113.9")
+	if seen != "192.0.2.1" {
+		t.Errorf("expected the remote address, got %s", seen)
+	}
+
+	var err error
+	if server.TrustedProxies, err = rpcserver.ParseNetworks("10.0.0.0/8", "2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remote   string
+		headers  []string
+		expected string
+	}{
+		{"192.0.2.1", []string{"X-Forwarded-For", "203.0.113.9"}, "192.0.2.1"},
+		{"10.0.0.1", []string{"X-Forwarded-For", "1.2.3.4, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"10.0.0.1", []string{"X-Forwarded-For", "1.2.3.4", "X-Forwarded-For", "10.0.0.3"}, "1.2.3.4"},
+		{"2001:db8::1", []string{"Forwarded", `for=198.51.100.7;proto=https, for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"10.0.0.1", []string{"Forwarded", "for=198.51.100.7", "X-Forwarded-For", "1.2.3.4"}, "198.51.100.7"},
+		{"10.0.0.1", nil, "10.0.0.1"},
+	} {
+		call(tc.remote, tc.headers...)
+		if seen != tc.expected {
+			t.Errorf("%s %v: expected %s, got %s", tc.remote, tc.headers, tc.expected, seen)
+		}
+	}
+
+	server.AllowedIPs, _ = rpcserver.ParseNetworks("203.0.113.0/24")
+	server.DeniedIPs, _ = rpcserver.ParseNetworks("203.0.113.66")
+	if w := call("10.0.0.1", "X-Forwarded-For", "203.0.113.9"); w.Code != 200 {
+		t.Errorf("expected an allowed client, got %d %s", w.Code, w.Body)
+	}
+	if w := call("10.0.0.1", "X-Forwarded-For", "203.0.113.66"); w.Code != 403 || !strings.Contains(w.Body.String(), "203.0.113.66 is not allowed") {
+		t.Errorf("expected a denied client, got %d %s", w.Code, w.Body)
+	}
+	if w := call("192.0.2.1"); w.Code != 403 {
+		t.Errorf("expected a client out of the allowed networks, got %d", w.Code)
+	}
+	if _, err := rpcserver.ParseNetworks("10.0.0.0/33"); err == nil {
+		t.Error("expected an invalid network")
+	}
+}
+
+func TestServeCallAllowedIPs(t *testing.T) {
+	server := newServer(t)
+	var seen string
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			caller, _ := rpcserver.CallerFromContext(ctx)
+			seen = caller.Addr
+			return next(ctx, call)
+		}
+	})
+	server.AllowedIPs, _ = rpcserver.ParseNetworks("203.0.113.0/24")
+	body := rpcserver.CallBody("Multiply", json.RawMessage(`[3, 4]`), 1)
+	status, reply, err := rpcserver.ServeCall(context.Background(), server, "/rpc/", "Multiply", body, nil)
+	if err != nil || status != 200 || !strings.Contains(string(reply), `"result":12`) {
+		t.Errorf("expected the in-process call to be served, got %d %s %v", status, reply, err)
+	}
+	if seen != "127.0.0.1" {
+		t.Errorf("expected the loopback address, got %q", seen)
+	}
+	if w := serve(server, "POST", "/rpc/Multiply", string(body)); w.Code != 403 {
+		t.Errorf("expected the remote call to be refused, got %d", w.Code)
+	}
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	server := newServer(t)
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			entered <- struct{}{}
+			<-unblock
+			return next(ctx, call)
+		}
+	})
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	call := func(conn net.Conn) int {
+		r := httptest.NewRequest("POST", "/rpc/Multiply", strings.NewReader(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`))
+		r.Header.Set("Content-Type", "application/json")
+		if conn != nil {
+			r = r.WithContext(rpcserver.ConnContext(r.Context(), conn))
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	start := func(conn net.Conn) chan int {
+		code := make(chan int, 1)
+		go func() { code <- call(conn) }()
+		return code
+	}
+
+	server.SetLimits(rpcserver.Limits{MaxConcurrentCalls: 2})
+	first, second := start(nil), start(nil)
+	<-entered
+	<-entered
+	if code := call(nil); code != 503 {
+		t.Errorf("expected a call over the limit to be refused, got %d", code)
+	}
+	unblock <- struct{}{}
+	unblock <- struct{}{}
+	if a, b := <-first, <-second; a != 200 || b != 200 {
+		t.Errorf("expected the calls in flight to complete, got %d %d", a, b)
+	}
+
+	server.SetLimits(rpcserver.Limits{MaxConcurrentCallsPerConn: 1})
+	first = start(connA)
+	<-entered
+	if code := call(connA); code != 429 {
+		t.Errorf("expected a second call of the connection to be refused, got %d", code)
+	}
+	second = start(connB)
+	<-entered
+	unblock <- struct{}{}
+	unblock <- struct{}{}
+	if a, b := <-first, <-second; a != 200 || b != 200 {
+		t.Errorf("expected the calls of both connections to complete, got %d %d", a, b)
+	}
+
+	// A call queued behind the limit of its connection holds no global slot.
+	server.SetLimits(rpcserver.Limits{MaxConcurrentCalls: 2, MaxConcurrentCallsPerConn: 1, QueueTimeout: time.Minute})
+	first = start(connA)
+	<-entered
+	queued := start(connA)
+	time.Sleep(10 * time.Millisecond)
+	second = start(connB)
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the call of the other connection to run")
+	}
+	unblock <- struct{}{}
+	<-entered
+	unblock <- struct{}{}
+	unblock <- struct{}{}
+	if a, b, c := <-first, <-second, <-queued; a != 200 || b != 200 || c != 200 {
+		t.Errorf("expected the calls to complete, got %d %d %d", a, b, c)
+	}
+
+	server.SetLimits(rpcserver.Limits{MaxConcurrentCalls: 1, QueueTimeout: time.Minute})
+	first = start(nil)
+	<-entered
+	second = start(nil)
+	unblock <- struct{}{}
+	<-entered
+	unblock <- struct{}{}
+	if a, b := <-first, <-second; a != 200 || b != 200 {
+		t.Errorf("expected the queued call to complete, got %d %d", a, b)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	server := newServer(t)
+	var seen rpcserver.Caller
+	server.Flags = rpcserver.FlagFunc(func(ctx context.Context, method string, caller rpcserver.Caller) bool {
+		seen = caller
+		return method != "Multiply" || caller.Addr == "10.0.0.1"
+	})
+
+	w := serve(server, "POST", "/rpc/Multiply", `{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`)
+	if !strings.Contains(w.Body.String(), `"code":-32002`) {
+		t.Errorf("expected a disabled feature, got %s", w.Body)
+	}
+	if seen.Addr != "192.0.2.1" {
+		t.Errorf("expected the caller, got %+v", seen)
+	}
+
+	r := httptest.NewRequest("POST", "/rpc/Multiply", strings.NewReader(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": [3, 4]}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Forwarded-For", "10.0.0.1")
+	server.TrustedProxies, _ = rpcserver.ParseNetworks("192.0.2.1")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"result":12`) {
+		t.Errorf("expected the feature enabled for the caller, got %s", w.Body)
+	}
+}
+
+func TestMigrators(t *testing.T) {
+	server := newServer(t)
+	// Version 1 of the args of Multiply was {"X": 3, "Y": 4}.
+	err := server.RegisterMigrators("Multiply", func(params json.RawMessage) (json.RawMessage, error) {
+		var v1 struct{ X, Y int }
+		if err := json.Unmarshal(params, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(Args{A: v1.X, B: v1.Y})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterMigrators("Unknown"); err == nil {
+		t.Error("expected an error for an unknown method")
+	}
+
+	multiply := func(params, version string) string {
+		r := httptest.NewRequest("POST", "/rpc/Multiply", strings.NewReader(`{"jsonrpc": "2.0", "method": "Multiply", "id": 1, "params": `+params+`}`))
+		r.Header.Set("Content-Type", "application/json")
+		if version != "" {
+			r.Header.Set(rpcserver.SchemaVersionHeader, version)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	for _, tc := range []struct {
+		params, version, expected string
+	}{
+		{`{"X": 3, "Y": 4}`, "", `"result":12`},
+		{`{"X": 3, "Y": 4}`, "1", `"result":12`},
+		{`{"A": 3, "B": 5}`, "2", `"result":15`},
+		{`{"A": 3, "B": 6, "_version": 2}`, "1", `"result":18`},
+		{`{"X": 3, "Y": 7, "_version": 1}`, "", `"result":21`},
+		{`{"A": 3, "B": 4}`, "3", `unknown schema version 3`},
+		{`{"A": 3, "B": 4}`, "x", `invalid X-RPC-Schema-Version`},
+	} {
+		if body := multiply(tc.params, tc.version); !strings.Contains(body, tc.expected) {
+			t.Errorf("%s version %q: expected %s, got %s", tc.params, tc.version, tc.expected, body)
+		}
+	}
+}
+
+func TestParseFieldTag(t *testing.T) {
+	for tag, expected := range map[string]rpcserver.FieldTag{
+		"":                         {},
+		"variadic":                 {Variadic: true},
+		"desc=Dividend,example=10": {Description: "Dividend", Example: "10"},
+		"desc='Divisor, not zero',example='[1, 2]'": {Description: "Divisor, not zero", Example: "[1, 2]"},
+		"example=x=y,unknown":                       {Example: "x=y"},
+		"role=admin|owner,required":                 {Roles: "admin|owner", Required: true},
+		"region":                                    {Region: true},
+	} {
+		if got := rpcserver.ParseFieldTag(tag); got != expected {
+			t.Errorf("%q: expected %+v, got %+v", tag, expected, got)
+		}
+	}
+}
+
+type Documented struct{}
+
+func (d *Documented) Describe() map[string]string {
+	return map[string]string{"": "Documented arithmetic", "Negate": "Negates A"}
+}
+
+func (d *Documented) Negate(r *http.Request, args *Args, reply *int) error {
+	*reply = -args.A
+	return nil
+}
+
+func TestDescriber(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Documented))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := service.Get("Negate")
+	if service.Description() != "Documented arithmetic" || m.Description() != "Negates A" {
+		t.Errorf("unexpected descriptions %q %q", service.Description(), m.Description())
+	}
+	if _, err := service.Get("Describe"); err == nil {
+		t.Error("expected Describe not to be a method")
+	}
+}
+
+type Filtered struct{}
+
+func (f *Filtered) ServiceName() string {
+	return "Calculator"
+}
+
+func (f *Filtered) HiddenMethods() []string {
+	return []string{"Audit"}
+}
+
+func (f *Filtered) Negate(r *http.Request, args *Args, reply *int) error {
+	*reply = -args.A
+	return nil
+}
+
+func (f *Filtered) Audit(r *http.Request, args *Args, reply *int) error {
+	return nil
+}
+
+type Exposed struct {
+	Filtered
+}
+
+func (e *Exposed) ExposedMethods() []string {
+	return []string{"Negate", "Audit"}
+}
+
+func TestMethodFilters(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Filtered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Name() != "Calculator" || strings.Join(service.MethodNames(), ",") != "Negate" {
+		t.Errorf("unexpected service %s with methods %v", service.Name(), service.MethodNames())
+	}
+	// Hidden methods stay hidden when exposed.
+	if service, err = rpcserver.NewRpcService(new(Exposed)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(service.MethodNames(), ",") != "Negate" {
+		t.Errorf("unexpected methods %v", service.MethodNames())
+	}
+
+	s := rpcservertest.NewServer(t, new(Filtered))
+	defer s.Close()
+	if err := s.Call("Audit", &Args{}, nil); err == nil {
+		t.Error("expected the hidden method not to be callable")
+	}
+}
+
+type Sloppy struct{}
+
+func (s *Sloppy) ExpectedMethods() int {
+	return 0
+}
+
+func (s *Sloppy) Negate(r *http.Request, args *Args, reply *int) error {
+	*reply = -args.A
+	return nil
+}
+
+func (s *Sloppy) NoRequest(args *Args, reply *int) error {
+	return nil
+}
+
+func (s *Sloppy) NoError(r *http.Request, args *Args, reply *int) {
+}
+
+func (s *Sloppy) ValueReply(r *http.Request, args *Args, reply int) error {
+	return nil
+}
+
+type Lenient struct {
+	Sloppy
+}
+
+func (l *Lenient) ExpectedMethods() int {
+	return 1
+}
+
+type Greedy struct {
+	Sloppy
+}
+
+func (g *Greedy) ExpectedMethods() int {
+	return 2
+}
+
+func TestRegistrationDiagnostics(t *testing.T) {
+	_, err := rpcserver.NewRpcService(new(Sloppy))
+	expected := `rpc: "Sloppy" skips exported methods: ` +
+		"NoError: it does not return an error; " +
+		"NoRequest: its first argument is *rpcserver_test.Args rather than *http.Request; " +
+		"ValueReply: its reply int is not a pointer"
+	if regErr, ok := err.(*rpcserver.RegistrationError); !ok || err.Error() != expected || regErr.Methods != 1 {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	service, err := rpcserver.NewRpcService(new(Lenient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped := service.Skipped(); len(skipped) != 3 || skipped[0].String() != "NoError: it does not return an error" {
+		t.Errorf("unexpected skipped methods %v", skipped)
+	}
+
+	if _, err := rpcserver.NewRpcService(new(Greedy)); err == nil || !strings.HasPrefix(err.Error(), `rpc: "Greedy" serves 1 methods, 2 expected: NoError: `) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+type Catalog struct{}
+
+func (c *Catalog) CacheControl() map[string]string {
+	return map[string]string{"Price": "public, max-age=300"}
+}
+
+func (c *Catalog) Price(r *http.Request, args *Args, reply *int) error {
+	if args.A < 0 {
+		return errors.New("negative")
+	}
+	*reply = args.A * 100
+	return nil
+}
+
+func (c *Catalog) Stock(r *http.Request, args *Args, reply *int) error {
+	*reply = args.B
+	return nil
+}
+
+func TestCacheControl(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Catalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	cacheControl := func(method string, a int) string {
+		w := serve(server, "POST", "/rpc/"+method, fmt.Sprintf(`{"jsonrpc": "2.0", "method": "%s", "id": 1, "params": {"A": %d, "B": 1}}`, method, a))
+		return w.Header().Get("Cache-Control")
+	}
+	if cc := cacheControl("Price", 1); cc != "public, max-age=300" {
+		t.Errorf("expected the declared Cache-Control, got %q", cc)
+	}
+	if cc := cacheControl("Price", -1); cc != "" {
+		t.Errorf("expected no Cache-Control on errors, got %q", cc)
+	}
+	if cc := cacheControl("Stock", 1); cc != "" {
+		t.Errorf("expected no Cache-Control, got %q", cc)
+	}
+	server.SetCacheControl("Price", "private, max-age=10")
+	server.SetCacheControl("Stock", "no-store")
+	if a, b := cacheControl("Price", 1), cacheControl("Stock", 1); a != "private, max-age=10" || b != "no-store" {
+		t.Errorf("expected the Cache-Control set, got %q %q", a, b)
+	}
+	server.SetCacheControl("Price", "")
+	if cc := cacheControl("Price", 1); cc != "public, max-age=300" {
+		t.Errorf("expected the declared Cache-Control, got %q", cc)
+	}
+	if err := server.SetCacheControl("Missing", "no-store"); err == nil {
+		t.Error("expected an error for an unknown method")
+	}
+}
+
+func TestCacheControlNames(t *testing.T) {
+	server := newServer(t)
+	if err := server.RegisterService(new(Greeter)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterGroup("greetings", new(Greeter)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterBuiltin("rpc.echo", builtins{"echo: "}, "Echo"); err != nil {
+		t.Fatal(err)
+	}
+	cacheControl := func(method string) string {
+		w := serve(server, "POST", "/rpc/"+method, fmt.Sprintf(`{"jsonrpc": "2.0", "method": "%s", "id": 1, "params": "world"}`, method))
+		return w.Header().Get("Cache-Control")
+	}
+	if err := server.SetCacheControl("greetings.Hello", "public, max-age=60"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetCacheControl("rpc.echo", "no-store"); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := cacheControl("greetings.Hello"), cacheControl("rpc.echo"); a != "public, max-age=60" || b != "no-store" {
+		t.Errorf("expected the Cache-Control set, got %q %q", a, b)
+	}
+	if cc := cacheControl("Hello"); cc != "" {
+		t.Errorf("expected no Cache-Control on the service method, got %q", cc)
+	}
+	if err := server.SetCacheControl("Hello", "private, max-age=10"); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := cacheControl("Hello"), cacheControl("greetings.Hello"); a != "private, max-age=10" || b != "public, max-age=60" {
+		t.Errorf("expected the Cache-Control of each name, got %q %q", a, b)
+	}
+}
+
+type Transfer struct {
+	From   string `json:"from" rpc:"required"`
+	To     string `json:"to" rpc:"required"`
+	Amount int    `json:"amount" rpc:"required"`
+	Memo   string `json:"memo"`
+	Lines  []Line `json:"lines"`
+}
+
+type Line struct {
+	ID int `json:"id" rpc:"required"`
+}
+
+func (t *Arith) Transfer(r *http.Request, args *Transfer) (int, error) {
+	return args.Amount, nil
+}
+
+func TestRequiredParams(t *testing.T) {
+	server := newServer(t)
+	for _, tc := range []struct {
+		params, expected string
+	}{
+		{`{"from": "a", "to": "b", "amount": 0}`, `"result":0`},
+		{`{"FROM": "a", "to": "b", "amount": 5, "lines": [{"id": 0}]}`, `"result":5`},
+		{`{"from": "a"}`, `{"code":-32602,"message":"rpc: invalid params: to is required, amount is required","data":[{"field":"to","message":"is required"},{"field":"amount","message":"is required"}]}`},
+		{`{"from": "a", "to": null, "amount": 1, "lines": [{"id": 1}, {}]}`, `"message":"rpc: invalid params: to is required, lines[1].id is required"`},
+		{`null`, `"message":"rpc: invalid params: from is required, to is required, amount is required"`},
+		{`["a", "b", 3]`, `"result":3`},
+		{`["a"]`, `"message":"rpc: invalid params: to is required, amount is required"`},
+		{`["a", null, 1, "memo", [{}]]`, `"message":"rpc: invalid params: to is required, lines[0].id is required"`},
+		{`[{"from": "a"}]`, `"message":"rpc: invalid params: to is required, amount is required"`},
+	} {
+		w := serve(server, "POST", "/rpc/Transfer", `{"jsonrpc": "2.0", "method": "Transfer", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+}
+
+type Search struct {
+	Text   string   `json:"text" rpc:"required"`
+	Limit  int      `json:"limit" default:"10"`
+	Sort   string   `json:"sort" default:"asc"`
+	Fields []string `json:"fields" default:"[\"id\"]"`
+	Page   *Page    `json:"page"`
+}
+
+type Page struct {
+	Size int `json:"size" default:"50"`
+}
+
+func (t *Arith) Search(r *http.Request, args *Search) (*Search, error) {
+	return args, nil
+}
+
+func TestDefaultParams(t *testing.T) {
+	server := newServer(t)
+	for _, tc := range []struct {
+		params, expected string
+	}{
+		{`{"text": "x"}`, `"result":{"text":"x","limit":10,"sort":"asc","fields":["id"],"page":null}`},
+		{`{"text": "x", "limit": 0, "SORT": null, "page": {}}`, `"result":{"text":"x","limit":0,"sort":"asc","fields":["id"],"page":{"size":50}}`},
+		{`{"limit": 1}`, `"message":"rpc: invalid params: text is required"`},
+		{`["x"]`, `"result":{"text":"x","limit":10,"sort":"asc","fields":["id"],"page":null}`},
+		{`["x", 0, null, ["name"], {}]`, `"result":{"text":"x","limit":0,"sort":"asc","fields":["name"],"page":{"size":50}}`},
+		{`[]`, `"message":"rpc: invalid params: text is required"`},
+	} {
+		w := serve(server, "POST", "/rpc/Search", `{"jsonrpc": "2.0", "method": "Search", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+}
+
+type Order string
+
+const (
+	Asc  Order = "asc"
+	Desc Order = "desc"
+)
+
+type Listing struct {
+	Order  Order    `json:"order"`
+	Size   int      `json:"size" enum:"10,50,100"`
+	Fields []string `json:"fields" enum:"id,name"`
+}
+
+func (t *Arith) List(r *http.Request, args *Listing) (Order, error) {
+	return args.Order, nil
+}
+
+func (t *Arith) Sort(r *http.Request, order Order) (Order, error) {
+	return order, nil
+}
+
+func (t *Arith) SortAll(r *http.Request, orders []Order) (int, error) {
+	return len(orders), nil
+}
+
+func TestEnumParams(t *testing.T) {
+	rpcserver.RegisterEnum(Asc, Desc)
+	server := newServer(t)
+	for _, tc := range []struct {
+		method, params, expected string
+	}{
+		{"List", `{"order": "desc", "size": 50, "fields": ["name", "id"]}`, `"result":"desc"`},
+		{"List", `{"size": 100}`, `"result":""`},
+		{"List", `{"order": "up", "size": 20, "fields": ["id", "age"]}`, `"message":"rpc: invalid params: order must be one of \"asc\", \"desc\", size must be one of 10, 50, 100, fields[1] must be one of \"id\", \"name\""`},
+		{"List", `["asc", 10]`, `"result":"asc"`},
+		{"List", `["up", 20, ["age"]]`, `"message":"rpc: invalid params: order must be one of \"asc\", \"desc\", size must be one of 10, 50, 100, fields[0] must be one of \"id\", \"name\""`},
+		{"Sort", `"desc"`, `"result":"desc"`},
+		{"Sort", `["asc"]`, `"result":"asc"`},
+		{"Sort", `"up"`, `"message":"rpc: invalid params: must be one of \"asc\", \"desc\""`},
+		{"Sort", `["up"]`, `"message":"rpc: invalid params: must be one of \"asc\", \"desc\""`},
+		{"SortAll", `["asc", "desc"]`, `"result":2`},
+		{"SortAll", `["asc", "up"]`, `"message":"rpc: invalid params: [1] must be one of \"asc\", \"desc\""`},
+	} {
+		w := serve(server, "POST", "/rpc/"+tc.method, `{"jsonrpc": "2.0", "method": "`+tc.method+`", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+}
+
+type Report struct {
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+func (t *Arith) Report(r *http.Request, args *Report) (int, error) {
+	return args.To - args.From, nil
+}
+
+func TestValidators(t *testing.T) {
+	server := newServer(t)
+	if err := server.RegisterValidator("Missing", nil); err == nil {
+		t.Error("expected an error registering a validator of an unknown method")
+	}
+	server.RegisterValidator("Report", func(ctx context.Context, args interface{}) error {
+		var errs rpcserver.ParamsError
+		if report := args.(*Report); report.From > report.To {
+			errs.Add("from", "must not be after to")
+		}
+		return errs.Err()
+	})
+	server.RegisterValidator("Report", func(ctx context.Context, args interface{}) error {
+		if report := args.(*Report); report.Email == "" && report.Phone == "" {
+			return errors.New("email or phone is required")
+		}
+		return nil
+	})
+	for _, tc := range []struct {
+		params, expected string
+	}{
+		{`{"from": 1, "to": 3, "phone": "555"}`, `"result":2`},
+		{`{"from": 3, "to": 1}`, `"message":"rpc: invalid params: from must not be after to, email or phone is required"`},
+		{`{"from": 3, "to": 1, "email": "a@b.c"}`, `"data":[{"field":"from","message":"must not be after to"}]`},
+	} {
+		w := serve(server, "POST", "/rpc/Report", `{"jsonrpc": "2.0", "method": "Report", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+}
+
+type Signup struct {
+	Name    string   `json:"name" sanitize:"trim,nocontrol,max=5"`
+	Tags    []string `json:"tags" sanitize:"trim,max=2"`
+	Contact *Contact `json:"contact"`
+}
+
+type Contact struct {
+	Email string `json:"email" sanitize:"trim"`
+}
+
+func (t *Arith) Signup(r *http.Request, args *Signup) (*Signup, error) {
+	return args, nil
+}
+
+func TestSanitizers(t *testing.T) {
+	server := newServer(t)
+	server.RegisterSanitizer("Signup", func(ctx context.Context, args interface{}) {
+		if signup := args.(*Signup); signup.Contact != nil {
+			signup.Contact.Email = strings.ToLower(signup.Contact.Email)
+		}
+	})
+	server.RegisterValidator("Signup", func(ctx context.Context, args interface{}) error {
+		if args.(*Signup).Name == "" {
+			return &rpcserver.ParamsError{Errors: []rpcserver.FieldError{{Field: "name", Message: "is blank"}}}
+		}
+		return nil
+	})
+	for _, tc := range []struct {
+		params, expected string
+	}{
+		{`{"name": "  J\u0000ohnathan ", "tags": [" a ", "b", "c"], "contact": {"email": " A@B.C "}}`,
+			`"result":{"name":"Johna","tags":["a","b"],"contact":{"email":"a@b.c"}}`},
+		{`{"name": " \t "}`, `"message":"rpc: invalid params: name is blank"`},
+	} {
+		w := serve(server, "POST", "/rpc/Signup", `{"jsonrpc": "2.0", "method": "Signup", "id": 1, "params": `+tc.params+`}`)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s: expected %s, got %s", tc.params, tc.expected, w.Body)
+		}
+	}
+}
+
+type Relay struct{}
+
+func (p *Relay) Forward(r *http.Request, req *rpcserver.RawRequest) (*rpcserver.RawResponse, error) {
+	if len(req.Body) == 0 {
+		return nil, errors.New("empty body")
+	}
+	reversed := make([]byte, len(req.Body))
+	for i, b := range req.Body {
+		reversed[len(req.Body)-1-i] = b
+	}
+	return &rpcserver.RawResponse{ContentType: req.ContentType, Status: 201, Body: reversed}, nil
+}
+
+func (p *Relay) Verify(r *http.Request, req *rpcserver.RawRequest, res *rpcserver.RawResponse) error {
+	res.Body = []byte(fmt.Sprint(len(req.Body)))
+	return nil
+}
+
+func (p *Relay) Decoded(r *http.Request, req *rpcserver.RawRequest) (string, error) {
+	return "", nil
+}
+
+func (p *Relay) Hello(r *http.Request, args *string) (string, error) {
+	return "hello " + *args, nil
+}
+
+func TestRawMethods(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Relay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped := service.Skipped(); len(skipped) != 1 || skipped[0].String() != "Decoded: it takes a *rpcserver.RawRequest without replying a *rpcserver.RawResponse" {
+		t.Errorf("unexpected skipped methods %v", skipped)
+	}
+	server, err := rpcserver.NewServer(new(Relay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	var called []string
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			called = append(called, call.Method)
+			return next(ctx, call)
+		}
+	})
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("/rpc/Verify", "text/plain; charset=latin1", "abc"); w.Code != 415 {
+		t.Errorf("expected the Content-Types of no codec refused, got %d %q", w.Code, w.Body.String())
+	}
+	if err := server.SetRawContentTypes("Hello", "text/plain"); err == nil {
+		t.Error("expected an error for a method which is not a passthrough method")
+	}
+	server.SetRawContentTypes("Forward", "application/octet-stream")
+	server.SetRawContentTypes("Verify", "Text/Plain")
+
+	w := post("/rpc/Forward", "application/octet-stream", "\xff\x00\x01")
+	if w.Code != 201 || w.Body.String() != "\x01\x00\xff" || w.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected response %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+	if w := post("/rpc/Verify", "text/plain; charset=latin1", "abc"); w.Code != 200 || w.Body.String() != "3" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w := post("/rpc/Forward", "application/octet-stream", ""); w.Code != 400 || !strings.Contains(w.Body.String(), "empty body") {
+		t.Errorf("expected the error of the method, got %d %q", w.Code, w.Body.String())
+	}
+	if w := post("/rpc/Verify", "application/octet-stream", "abc"); w.Code != 415 {
+		t.Errorf("expected the Content-Types of other methods refused, got %d", w.Code)
+	}
+	if strings.Join(called, ",") != "Forward,Verify,Forward" {
+		t.Errorf("expected the calls through the middleware, got %v", called)
+	}
+
+	if w := post("/rpc/Hello", "application/octet-stream", "abc"); w.Code != 415 {
+		t.Errorf("expected other methods to need a codec, got %d", w.Code)
+	}
+	// Passthrough methods serve the bodies of the codecs as is too.
+	if w := post("/rpc/Forward", "application/json", `[1]`); w.Code != 201 || w.Body.String() != `]1[` {
+		t.Errorf("expected the JSON body served raw, got %d %q", w.Code, w.Body.String())
+	}
+	// The codec refuses the calls of methods at the paths of others.
+	w = post("/rpc/Hello", "application/json", `{"jsonrpc": "2.0", "method": "Forward", "params": ["abc"], "id": 1}`)
+	if !strings.Contains(w.Body.String(), "does not end with method Name 'Forward'") || len(called) != 4 {
+		t.Errorf("expected passthrough methods to need their path, got %q", w.Body.String())
+	}
+}
+
+type DeviceArgs struct {
+	DeviceID  string    `header:"X-Device-Id" json:"-"`
+	Session   *string   `cookie:"session" json:"-"`
+	Retries   int       `header:"X-Retries" json:"-"`
+	Languages []string  `header:"Accept-Language" json:"-"`
+	Since     time.Time `header:"X-Since" json:"-"`
+	Tenant    string    `header:"X-Tenant"`
+	Token     string    `cookie:"token"`
+	Page      int
+}
+
+type Devices struct{}
+
+func (d *Devices) List(r *http.Request, args *DeviceArgs) (*DeviceArgs, error) {
+	return args, nil
+}
+
+func TestBindMetadata(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Devices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	var bound *DeviceArgs
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			bound = call.Args.(*DeviceArgs)
+			return next(ctx, call)
+		}
+	})
+	call := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/rpc/List", strings.NewReader(`{"jsonrpc": "2.0", "method": "List", "params": {"Page": 2, "Tenant": "forged", "Token": "forged"}, "id": 1}`))
+		r.Header = header
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call(http.Header{
+		"X-Device-Id":     {"d-1"},
+		"Cookie":          {"session=s-1; other=x"},
+		"X-Retries":       {"3"},
+		"Accept-Language": {"fr", "en"},
+		"X-Since":         {"2020-01-02T03:04:05Z"},
+		"X-Tenant":        {"acme"},
+	})
+	if w.Code != 200 || bound == nil {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	since := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if bound.DeviceID != "d-1" || bound.Session == nil || *bound.Session != "s-1" || bound.Retries != 3 ||
+		strings.Join(bound.Languages, ",") != "fr,en" || !bound.Since.Equal(since) || bound.Tenant != "acme" || bound.Page != 2 {
+		t.Errorf("unexpected args %+v", bound)
+	}
+
+	bound = nil
+	if w := call(http.Header{}); w.Code != 200 || bound.DeviceID != "" || bound.Session != nil || bound.Page != 2 {
+		t.Errorf("expected the absent metadata to leave the args, got %d %+v", w.Code, bound)
+	}
+	if bound.Tenant != "" || bound.Token != "" {
+		t.Errorf("expected the params not to set the metadata fields, got %q %q", bound.Tenant, bound.Token)
+	}
+	if w := call(http.Header{"X-Retries": {"many"}}); !strings.Contains(w.Body.String(), "invalid header X-Retries") {
+		t.Errorf("expected invalid metadata to fail, got %s", w.Body.String())
+	}
+}
+
+type TenantArgs struct {
+	Tenant string `path:"tenant" json:"-"`
+	Region string `path:"region" json:"-"`
+	Shard  int    `path:"shard" json:"-"`
+	Zone   string `path:"zone"`
+	Name   string
+}
+
+func (d *Devices) Locate(r *http.Request, args *TenantArgs) (string, error) {
+	return fmt.Sprintf("%s/%s/%d/%s%s", args.Tenant, args.Region, args.Shard, args.Name, args.Zone), nil
+}
+
+func TestPathParams(t *testing.T) {
+	server, err := rpcserver.NewServer(new(Devices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	server.PathTemplate = "/tenants/{tenant}/jsonrpc/{method}"
+	server.PathParams = func(r *http.Request) map[string]string {
+		return map[string]string{"region": r.Header.Get("X-Region"), "tenant": "extracted"}
+	}
+	// A router mounting the server passes its own parameters in the context.
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeHTTP(w, r.WithContext(rpcserver.WithPathParams(r.Context(), map[string]string{"shard": "7"})))
+	})
+	body := `{"jsonrpc": "2.0", "method": "Locate", "id": 1, "params": {"Name": "n", "Zone": "/forged"}}`
+	for path, expected := range map[string]string{
+		"/tenants/acme/jsonrpc/Locate": `"result":"acme/eu/7/n"`,
+		"/tenants//jsonrpc/Locate":     "can't find method",
+		"/tenant/acme/jsonrpc/Locate":  "can't find method",
+	} {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Region", "eu")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("%s: unexpected response %s", path, w.Body.String())
+		}
+	}
+}
+
+type Ledger struct {
+	Owner    string
+	Balance  int    `json:",omitempty" rpc:"role=admin|owner"`
+	Internal string `json:",omitempty" rpc:"role=admin"`
+}
+
+type Accounts struct {
+	ledger *Ledger
+}
+
+func (a *Accounts) Get(r *http.Request, args *string) (*Ledger, error) {
+	return a.ledger, nil
+}
+
+func (a *Accounts) List(r *http.Request, args *string, reply *map[string][]Ledger) error {
+	*reply = map[string][]Ledger{"all": {*a.ledger}}
+	return nil
+}
+
+type Node struct {
+	Name     string
+	Secret   string  `json:",omitempty" rpc:"role=admin"`
+	Children []*Node `json:",omitempty"`
+}
+
+var tree = &Node{Name: "root", Secret: "s0", Children: []*Node{{Name: "child", Secret: "s1"}}}
+
+func (a *Accounts) Tree(r *http.Request, args *string) (*Node, error) {
+	return tree, nil
+}
+
+type hidden struct {
+	Hidden string `json:",omitempty" rpc:"role=admin"`
+}
+
+type Profile struct {
+	hidden
+	Name string
+}
+
+func (a *Accounts) Profile(r *http.Request, args *string) (Profile, error) {
+	return Profile{hidden{"h"}, "n"}, nil
+}
+
+func TestReplyVisibility(t *testing.T) {
+	ledger := &Ledger{Owner: "ann", Balance: 10, Internal: "risk"}
+	server, err := rpcserver.NewServer(&Accounts{ledger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	server.Use(func(next rpcserver.CallFunc) rpcserver.CallFunc {
+		return func(ctx context.Context, call *rpcserver.Call) error {
+			if role := call.Request.Header.Get("X-Role"); role != "" {
+				ctx = rpcserver.WithRoles(ctx, strings.Split(role, ",")...)
+			}
+			return next(ctx, call)
+		}
+	})
+	for _, tc := range []struct {
+		method, role, expected string
+	}{
+		{"Get", "", `"result":{"Owner":"ann"}`},
+		{"Get", "owner", `"result":{"Owner":"ann","Balance":10}`},
+		{"Get", "guest,admin", `"result":{"Owner":"ann","Balance":10,"Internal":"risk"}`},
+		{"List", "", `"result":{"all":[{"Owner":"ann"}]}`},
+		{"List", "owner", `"result":{"all":[{"Owner":"ann","Balance":10}]}`},
+		{"Tree", "", `"result":{"Name":"root","Children":[{"Name":"child"}]}`},
+		{"Tree", "admin", `"result":{"Name":"root","Secret":"s0","Children":[{"Name":"child","Secret":"s1"}]}`},
+		{"Profile", "", `"result":{"Name":"n"}`},
+		{"Profile", "admin", `"result":{"Hidden":"h","Name":"n"}`},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/"+tc.method, strings.NewReader(`{"jsonrpc": "2.0", "method": "`+tc.method+`", "params": "x", "id": 1}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Role", tc.role)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%s as %q: unexpected response %s", tc.method, tc.role, w.Body.String())
+		}
+	}
+	if ledger.Balance != 10 || ledger.Internal != "risk" || tree.Children[0].Secret != "s1" {
+		t.Errorf("expected the reply of the method left as is, got %+v", ledger)
+	}
+}
+
+type CustomerArgs struct {
+	ID     string
+	Region string `rpc:"region"`
+}
+
+type Customers struct{}
+
+func (c *Customers) Get(r *http.Request, args *CustomerArgs, reply *string) error {
+	*reply = args.ID
+	return nil
+}
+
+func TestResidency(t *testing.T) {
+	server, err := rpcserver.NewServer(&Customers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterCodec(jsonrpc2.NewCodec(), "application/json")
+	server.Residency = &rpcserver.ResidencyPolicy{
+		Region:    "us",
+		Endpoints: map[string]string{"eu": "https://eu.example.com/rpc"},
+		CallerRegion: func(ctx context.Context) string {
+			return rpcserver.MetadataFromContext(ctx).Get("X-Region")
+		},
+		AllowCrossRegion: func(caller, home string) bool {
+			return caller == "us"
+		},
+	}
+	server.PropagateHeaders = []string{"X-Region"}
+	for _, tc := range []struct {
+		region, caller, expected string
+	}{
+		{"", "", `"result":"c1"`},
+		{"us", "us", `"result":"c1"`},
+		{"eu", "", `"code":-32005,"message":"rpc: data homed in another region: eu, call https://eu.example.com/rpc","data":{"region":"eu","endpoint":"https://eu.example.com/rpc"}`},
+		{"eu", "us", `"data":{"region":"eu","endpoint":"https://eu.example.com/rpc","caller":"us"}`},
+		{"eu", "eu", `"data":{"region":"eu","endpoint":"https://eu.example.com/rpc","caller":"eu"}`},
+		{"eu", "apac", `"data":{"region":"eu","caller":"apac"}`},
+		{"apac", "", `"data":{"region":"apac"}`},
+	} {
+		r := httptest.NewRequest("POST", "/rpc/Get", strings.NewReader(`{"jsonrpc": "2.0", "method": "Get", "params": {"ID": "c1", "Region": "`+tc.region+`"}, "id": 1}`))
+		r.Header.Set("Content-Type", "application/json")
+		if tc.caller != "" {
+			r.Header.Set("X-Region", tc.caller)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), tc.expected) {
+			t.Errorf("%q from %q: unexpected response %s", tc.region, tc.caller, w.Body.String())
+		}
+	}
+	if region := rpcserver.RegionOf(&CustomerArgs{Region: "eu"}); region != "eu" {
+		t.Errorf("expected the region of the args, got %q", region)
+	}
+}
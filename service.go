@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"unicode"
 	"unicode/utf8"
 )
@@ -29,14 +30,34 @@ type RpcService struct {
 	rcvr     reflect.Value                // receiver of methods for the service
 	rcvrType reflect.Type                 // type of the receiver
 	methods  map[string]*RpcServiceMethod // registered methods
+	desc     string                       // description, see Describer
+	skipped  []SkippedMethod              // exported methods not served, see Skipped
 }
 
 type RpcServiceMethod struct {
 	method    reflect.Method // receiver method
+	rcvr      reflect.Value  // receiver of a builtin, invalid for the methods of the service
 	argsType  reflect.Type   // type of the request argument
-	replyType reflect.Type   // type of the response argument
+	replyType reflect.Type   // type of the response argument, nil if there is no reply
+	replyMode replyMode      // how the method delivers the reply
+	spread    bool           // args is a struct of the method parameters, see positionalArgs
+	byValue   bool           // args is passed by value, not as a pointer
+	numIn     int            // number of ins of the method, the receiver included
+	variadic  bool           // the method is variadic
+	desc      string         // description, see Describer
+
+	cacheControl string // Cache-Control of the replies, see CacheController
 }
 
+// replyMode tells how a method delivers its reply.
+type replyMode int
+
+const (
+	replyArg      replyMode = iota // func(r, args, reply *Reply) error
+	replyNone                      // func(r, args) error
+	replyReturned                  // func(r, args) (Reply, error)
+)
+
 // NewRpcService creates a RpcService object with assotiated RpcServiceMethods.
 func NewRpcService(rcvr interface{}) (*RpcService, error) {
 	// Setup service.
@@ -46,54 +67,402 @@ func NewRpcService(rcvr interface{}) (*RpcService, error) {
 		methods:  make(map[string]*RpcServiceMethod),
 	}
 	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	if !IsExported(s.name) {
+	if n, ok := rcvr.(ServiceNamer); ok {
+		if s.name = n.ServiceName(); s.name == "" {
+			return nil, fmt.Errorf("rpc: empty service name of type %s", s.rcvrType)
+		}
+	} else if !IsExported(s.name) {
 		return nil, fmt.Errorf("rpc: type %q is not exported", s.name)
 	}
 	// Setup methods.
 	for i := 0; i < s.rcvrType.NumMethod(); i++ {
 		method := s.rcvrType.Method(i)
-		mtype := method.Type
-		// Method must be exported.
-		if method.PkgPath != "" {
+		m, reason := newRpcServiceMethod(method)
+		if m == nil {
+			if !isReceiverHook(s.rcvrType, method.Name) {
+				s.skipped = append(s.skipped, SkippedMethod{Name: method.Name, Reason: reason})
+			}
 			continue
 		}
-		// Method needs four ins: receiver, *http.Request, *args, *reply.
-		if mtype.NumIn() != 4 {
-			continue
+		m.numIn, m.variadic = m.method.Type.NumIn(), m.method.Type.IsVariadic()
+		s.methods[m.method.Name] = m
+	}
+	if err := s.filterMethods(rcvr); err != nil {
+		return nil, err
+	}
+	if len(s.methods) == 0 {
+		return nil, &RegistrationError{Service: s.name, Skipped: s.skipped}
+	}
+	if strict, ok := rcvr.(StrictService); ok {
+		expected := strict.ExpectedMethods()
+		if expected <= 0 && len(s.skipped) > 0 || len(s.methods) < expected {
+			return nil, &RegistrationError{Service: s.name, Methods: len(s.methods), Expected: expected, Skipped: s.skipped}
 		}
-		// First argument must be a pointer and must be http.Request.
-		reqType := mtype.In(1)
-		if reqType.Kind() != reflect.Ptr || reqType.Elem() != TypeOfRequest {
-			continue
+	}
+	if d, ok := rcvr.(Describer); ok {
+		descriptions := d.Describe()
+		s.desc = descriptions[""]
+		for name, m := range s.methods {
+			m.desc = descriptions[name]
 		}
-		// Second argument must be a pointer and must be exported.
-		args := mtype.In(2)
-		if args.Kind() != reflect.Ptr || !IsExportedOrBuiltin(args) {
-			continue
+	}
+	if c, ok := rcvr.(CacheController); ok {
+		for name, value := range c.CacheControl() {
+			if m := s.methods[name]; m != nil {
+				m.cacheControl = value
+			}
+		}
+	}
+	return s, nil
+}
+
+// ServiceNamer is implemented by service receivers named otherwise than their
+// type. ServiceName returns the name of the service.
+type ServiceNamer interface {
+	ServiceName() string
+}
+
+// MethodHider is implemented by service receivers having exported methods of
+// the signature of RPC methods which are not to be called remotely, such as
+// helpers. HiddenMethods returns their names, the service does not serve them.
+type MethodHider interface {
+	HiddenMethods() []string
+}
+
+// MethodExposer is implemented by service receivers serving a subset of their
+// methods. ExposedMethods returns the names of the served ones, the other
+// methods are hidden.
+type MethodExposer interface {
+	ExposedMethods() []string
+}
+
+// StrictService is implemented by service receivers whose registration fails
+// with a RegistrationError, rather than skipping their unsuitable methods
+// silently, when the service serves fewer methods than ExpectedMethods, or
+// when it skips any of their exported methods if ExpectedMethods returns 0.
+// The hidden methods are not expected, see MethodHider and MethodExposer.
+type StrictService interface {
+	ExpectedMethods() int
+}
+
+// SkippedMethod is an exported method of a service receiver which is not
+// served, for the Reason its signature is unsuitable.
+type SkippedMethod struct {
+	Name   string
+	Reason string
+}
+
+func (m SkippedMethod) String() string {
+	return m.Name + ": " + m.Reason
+}
+
+// RegistrationError is returned when no method of a receiver is suitable, or
+// when a StrictService serves fewer methods than expected. Skipped lists the
+// exported methods of the receiver which are not served, and why.
+type RegistrationError struct {
+	Service string
+
+	// Methods is the number of methods served, Expected the number of
+	// methods expected by a StrictService, 0 for all of them.
+	Methods  int
+	Expected int
+
+	Skipped []SkippedMethod
+}
+
+func (e *RegistrationError) Error() string {
+	var msg string
+	switch {
+	case e.Methods == 0:
+		msg = fmt.Sprintf("rpc: %q has no exported methods of suitable type", e.Service)
+	case e.Expected > 0:
+		msg = fmt.Sprintf("rpc: %q serves %d methods, %d expected", e.Service, e.Methods, e.Expected)
+	default:
+		msg = fmt.Sprintf("rpc: %q skips exported methods", e.Service)
+	}
+	for i, m := range e.Skipped {
+		if i == 0 {
+			msg += ": "
+		} else {
+			msg += "; "
+		}
+		msg += m.String()
+	}
+	return msg
+}
+
+// receiverHooks are the methods of the interfaces implemented by receivers to
+// configure their service, which are not RPC methods.
+var receiverHooks = map[string]reflect.Type{
+	"CacheControl":    reflect.TypeOf((*CacheController)(nil)).Elem(),
+	"Describe":        reflect.TypeOf((*Describer)(nil)).Elem(),
+	"ExpectedMethods": reflect.TypeOf((*StrictService)(nil)).Elem(),
+	"ExposedMethods":  reflect.TypeOf((*MethodExposer)(nil)).Elem(),
+	"HiddenMethods":   reflect.TypeOf((*MethodHider)(nil)).Elem(),
+	"ServiceName":     reflect.TypeOf((*ServiceNamer)(nil)).Elem(),
+}
+
+// isReceiverHook returns true if the method of the name is one of the
+// receiverHooks implemented by the receiver type.
+func isReceiverHook(rcvrType reflect.Type, name string) bool {
+	hook, ok := receiverHooks[name]
+	return ok && rcvrType.Implements(hook)
+}
+
+// filterMethods removes the methods hidden by the receiver from the service,
+// and from its skipped methods, see MethodHider and MethodExposer.
+func (service *RpcService) filterMethods(rcvr interface{}) error {
+	if e, ok := rcvr.(MethodExposer); ok {
+		exposed := make(map[string]*RpcServiceMethod)
+		names := make(map[string]bool)
+		for _, name := range e.ExposedMethods() {
+			m := service.methods[name]
+			if m == nil {
+				for _, skipped := range service.skipped {
+					if skipped.Name == name {
+						return fmt.Errorf("rpc: exposed method %q of %q is not of suitable type: %s", name, service.name, skipped.Reason)
+					}
+				}
+				return fmt.Errorf("rpc: %q has no exposed method %q of suitable type", service.name, name)
+			}
+			exposed[name], names[name] = m, true
+		}
+		service.methods = exposed
+		service.keepSkipped(func(name string) bool { return names[name] })
+	}
+	if h, ok := rcvr.(MethodHider); ok {
+		hidden := make(map[string]bool)
+		for _, name := range h.HiddenMethods() {
+			delete(service.methods, name)
+			hidden[name] = true
+		}
+		service.keepSkipped(func(name string) bool { return !hidden[name] })
+	}
+	return nil
+}
+
+// keepSkipped keeps the skipped methods whose name is kept.
+func (service *RpcService) keepSkipped(keep func(name string) bool) {
+	skipped := service.skipped[:0]
+	for _, m := range service.skipped {
+		if keep(m.Name) {
+			skipped = append(skipped, m)
 		}
+	}
+	service.skipped = skipped
+}
+
+// newRpcServiceMethod returns the RpcServiceMethod of a receiver method, or
+// nil and the reason why if the method doesn't have one of the signatures:
+//
+//	func (t *T) Method(r *http.Request, args *Args, reply *Reply) error
+//	func (t *T) Method(r *http.Request, args *Args) error
+//	func (t *T) Method(r *http.Request, args *Args) (*Reply, error)
+//
+// or the signature of a method taking its params positionally, see
+// positionalArgs.
+func newRpcServiceMethod(method reflect.Method) (*RpcServiceMethod, string) {
+	mtype := method.Type
+	// Method must be exported.
+	if method.PkgPath != "" {
+		return nil, "it is not exported"
+	}
+	if mtype.NumIn() >= 3 && mtype.In(2).Kind() != reflect.Ptr {
+		return newPositionalMethod(method)
+	}
+	// Method needs three or four ins: receiver, *http.Request, *args and *reply.
+	if mtype.NumIn() != 3 && mtype.NumIn() != 4 {
+		return nil, fmt.Sprintf("it takes %d arguments rather than the request, the args and an optional reply", mtype.NumIn()-1)
+	}
+	// First argument must be a pointer and must be http.Request.
+	reqType := mtype.In(1)
+	if reqType.Kind() != reflect.Ptr || reqType.Elem() != TypeOfRequest {
+		return nil, fmt.Sprintf("its first argument is %s rather than *http.Request", reqType)
+	}
+	// Second argument must be a pointer and must be exported.
+	args := mtype.In(2)
+	if !IsExportedOrBuiltin(args) {
+		return nil, fmt.Sprintf("its args type %s is not exported", args)
+	}
+	// Last out must be error.
+	if mtype.NumOut() == 0 || mtype.Out(mtype.NumOut()-1) != TypeOfError {
+		return nil, "it does not return an error"
+	}
+	m := &RpcServiceMethod{
+		method:   method,
+		argsType: args.Elem(),
+	}
+	switch {
+	case mtype.NumIn() == 4 && mtype.NumOut() == 1:
 		// Third argument must be a pointer and must be exported.
 		reply := mtype.In(3)
-		if reply.Kind() != reflect.Ptr || !IsExportedOrBuiltin(reply) {
-			continue
+		if reply.Kind() != reflect.Ptr {
+			return nil, fmt.Sprintf("its reply %s is not a pointer", reply)
 		}
-		// Method needs one out: error.
-		if mtype.NumOut() != 1 {
-			continue
+		if !IsExportedOrBuiltin(reply) {
+			return nil, fmt.Sprintf("its reply type %s is not exported", reply)
 		}
-		if returnType := mtype.Out(0); returnType != TypeOfError {
-			continue
+		m.replyType, m.replyMode = reply.Elem(), replyArg
+	case mtype.NumIn() == 3 && mtype.NumOut() == 1:
+		m.replyMode = replyNone
+	case mtype.NumIn() == 3 && mtype.NumOut() == 2:
+		// Returned reply must be exported.
+		reply := mtype.Out(0)
+		if !IsExportedOrBuiltin(reply) {
+			return nil, fmt.Sprintf("its reply type %s is not exported", reply)
 		}
-		s.methods[method.Name] = &RpcServiceMethod{
-			method:    method,
-			argsType:  args.Elem(),
-			replyType: reply.Elem(),
+		m.replyType, m.replyMode = reply, replyReturned
+	case mtype.NumIn() == 4:
+		return nil, "it both takes and returns a reply"
+	default:
+		return nil, fmt.Sprintf("it returns %d values rather than an optional reply and an error", mtype.NumOut())
+	}
+	if reason := rawReason(m); reason != "" {
+		return nil, reason
+	}
+	return m, ""
+}
+
+// newPositionalMethod returns the RpcServiceMethod of a method taking any
+// number of non-pointer params after the request, or nil and the reason why if
+// the method is unsuitable:
+//
+//	func (t *T) Method(r *http.Request, a int, b string, rest ...float64) error
+//	func (t *T) Method(r *http.Request, a int, b string) (Reply, error)
+//
+// The params are decoded into a struct built by positionalArgs. A single param,
+// such as a map[string]interface{}, a slice or a primitive, receives the whole
+// params instead:
+//
+//	func (t *T) Method(r *http.Request, args map[string]interface{}) error
+func newPositionalMethod(method reflect.Method) (*RpcServiceMethod, string) {
+	mtype := method.Type
+	reqType := mtype.In(1)
+	if reqType.Kind() != reflect.Ptr || reqType.Elem() != TypeOfRequest {
+		return nil, fmt.Sprintf("its first argument is %s rather than *http.Request", reqType)
+	}
+	params := make([]reflect.Type, 0, mtype.NumIn()-2)
+	for i := 2; i < mtype.NumIn(); i++ {
+		if !IsExportedOrBuiltin(mtype.In(i)) {
+			return nil, fmt.Sprintf("its param %d of type %s is not exported", i-2, mtype.In(i))
 		}
+		params = append(params, mtype.In(i))
 	}
-	if len(s.methods) == 0 {
-		return nil, fmt.Errorf("rpc: %q has no exported methods of suitable type",
-			s.name)
+	if mtype.NumOut() == 0 || mtype.Out(mtype.NumOut()-1) != TypeOfError {
+		return nil, "it does not return an error"
 	}
-	return s, nil
+	if mtype.NumOut() > 2 {
+		return nil, fmt.Sprintf("it returns %d values rather than an optional reply and an error", mtype.NumOut())
+	}
+	m := &RpcServiceMethod{
+		method:    method,
+		replyMode: replyNone,
+	}
+	if len(params) == 1 {
+		m.argsType, m.byValue = params[0], true
+	} else {
+		m.argsType, m.spread = positionalArgs(params, mtype.IsVariadic()), true
+	}
+	if mtype.NumOut() == 2 {
+		if !IsExportedOrBuiltin(mtype.Out(0)) {
+			return nil, fmt.Sprintf("its reply type %s is not exported", mtype.Out(0))
+		}
+		m.replyType, m.replyMode = mtype.Out(0), replyReturned
+	}
+	return m, ""
+}
+
+// positionalArgs returns a struct type with a field per param, named P0, P1
+// and so on. Codecs decoding by-position params fill the fields in order; the
+// last field of a variadic method is tagged rpc:"variadic" and collects the
+// remaining params.
+func positionalArgs(params []reflect.Type, variadic bool) reflect.Type {
+	fields := make([]reflect.StructField, len(params))
+	for i, param := range params {
+		fields[i] = reflect.StructField{Name: fmt.Sprintf("P%d", i), Type: param}
+	}
+	if variadic {
+		fields[len(fields)-1].Tag = `rpc:"variadic"`
+	}
+	return reflect.StructOf(fields)
+}
+
+// newReply returns a pointer to a new reply of the method, or nil if the
+// method has no reply.
+func (m *RpcServiceMethod) newReply() interface{} {
+	if m.replyMode != replyArg {
+		return nil
+	}
+	return reflect.New(m.replyType).Interface()
+}
+
+// call invokes the method with the request, the args and the reply and returns
+// the reply to encode.
+func (service *RpcService) call(m *RpcServiceMethod, r *http.Request, args interface{}, reply interface{}) (interface{}, error) {
+	in := make([]reflect.Value, 2, m.numIn)
+	in[0], in[1] = service.rcvr, reflect.ValueOf(r)
+	if m.rcvr.IsValid() {
+		in[0] = m.rcvr
+	}
+	switch {
+	case m.spread:
+		fields := reflect.ValueOf(args).Elem()
+		for i := 0; i < fields.NumField(); i++ {
+			in = append(in, fields.Field(i))
+		}
+	case m.byValue:
+		in = append(in, reflect.ValueOf(args).Elem())
+	default:
+		in = append(in, reflect.ValueOf(args))
+	}
+	if m.replyMode == replyArg {
+		in = append(in, reflect.ValueOf(reply))
+	}
+	var out []reflect.Value
+	if m.variadic {
+		out = m.method.Func.CallSlice(in)
+	} else {
+		out = m.method.Func.Call(in)
+	}
+	// Cast the result to error if needed.
+	if errInter := out[len(out)-1].Interface(); errInter != nil {
+		return nil, errInter.(error)
+	}
+	if m.replyMode == replyReturned {
+		result := out[0]
+		if (result.Kind() == reflect.Ptr || result.Kind() == reflect.Interface) && result.IsNil() {
+			return nil, nil
+		}
+		return result.Interface(), nil
+	}
+	return reply, nil
+}
+
+// Name returns the name of the receiver type.
+func (service *RpcService) Name() string {
+	return service.name
+}
+
+// Description returns the description of the service, see Describer.
+func (service *RpcService) Description() string {
+	return service.desc
+}
+
+// Skipped returns the exported methods of the receiver which are not served
+// because of their signature, sorted by name. The hidden methods are not
+// listed.
+func (service *RpcService) Skipped() []SkippedMethod {
+	return append([]SkippedMethod(nil), service.skipped...)
+}
+
+// MethodNames returns the names of the registered methods in sorted order.
+func (service *RpcService) MethodNames() []string {
+	names := make([]string, 0, len(service.methods))
+	for name := range service.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // get returns a registered object given a method name.
@@ -106,6 +475,32 @@ func (service *RpcService) Get(method string) (*RpcServiceMethod, error) {
 	return serviceMethod, nil
 }
 
+// Name returns the method name.
+func (m *RpcServiceMethod) Name() string {
+	return m.method.Name
+}
+
+// Description returns the description of the method, see Describer.
+func (m *RpcServiceMethod) Description() string {
+	return m.desc
+}
+
+// CacheControl returns the Cache-Control header of the successful replies of
+// the method declared by the receiver, see CacheController.
+func (m *RpcServiceMethod) CacheControl() string {
+	return m.cacheControl
+}
+
+// ArgsType returns the type the request params are decoded into.
+func (m *RpcServiceMethod) ArgsType() reflect.Type {
+	return m.argsType
+}
+
+// ReplyType returns the type of the method result, nil if the method has none.
+func (m *RpcServiceMethod) ReplyType() reflect.Type {
+	return m.replyType
+}
+
 // isExported returns true of a string is an exported (upper case) name.
 func IsExported(name string) bool {
 	rune, _ := utf8.DecodeRuneInString(name)
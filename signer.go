@@ -0,0 +1,9 @@
+package rpcserver
+
+// Signer signs the encoded responses of a server, so their consumers can
+// verify them across intermediaries; see the rpcsign package for HMAC and
+// JWS signers.
+type Signer interface {
+	// Sign returns the value of the SignatureHeader of a response body.
+	Sign(body []byte) (string, error)
+}
@@ -0,0 +1,151 @@
+package rpcserver
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SLOPolicy tracks the success ratio of each method over a sliding window and
+// reports the methods burning their error budget too fast, e.g. to roll back a
+// feature flag automatically.
+//
+// The burn rate is the ratio of failed calls over the window divided by the
+// error budget, 1 - Objective: at a burn rate of 1 the budget lasts exactly the
+// SLO period.
+type SLOPolicy struct {
+	// Objective is the target ratio of successful calls, e.g. 0.999.
+	Objective float64
+
+	// Window is the sliding window the ratio is computed over, an hour when
+	// zero.
+	Window time.Duration
+
+	// BurnRate is the burn rate at which a method starts burning, 1 when
+	// zero.
+	BurnRate float64
+
+	// MinCalls is the number of calls in the window below which a method
+	// isn't reported, 1 when zero.
+	MinCalls int64
+
+	// OnBurn is called when a method starts burning and again when it stops,
+	// possibly concurrently.
+	OnBurn func(alert BurnAlert)
+
+	mu      sync.Mutex
+	methods map[string]*sloWindow
+}
+
+// BurnAlert describes a method crossing the burn rate of the SLOPolicy.
+type BurnAlert struct {
+	Method string
+
+	// Burning tells if the method started or stopped burning.
+	Burning bool
+
+	// BurnRate is the burn rate over the window, computed from Calls and
+	// Errors.
+	BurnRate float64
+	Calls    int64
+	Errors   int64
+}
+
+// sloBuckets is the number of buckets the window is divided into.
+const sloBuckets = 10
+
+// sloWindow counts the calls of a method in the buckets of the window.
+type sloWindow struct {
+	buckets [sloBuckets]sloBucket
+	burning bool
+}
+
+type sloBucket struct {
+	index  int64 // of the bucket since the epoch
+	calls  int64
+	errors int64
+}
+
+// observe counts a call of the method done at now, failed when err isn't nil.
+func (p *SLOPolicy) observe(method string, err error, now time.Time) {
+	window := p.Window
+	if window <= 0 {
+		window = time.Hour
+	}
+	size := int64(window / sloBuckets)
+	if size <= 0 {
+		size = 1
+	}
+	index := now.UnixNano() / size
+
+	p.mu.Lock()
+	if p.methods == nil {
+		p.methods = make(map[string]*sloWindow)
+	}
+	w := p.methods[method]
+	if w == nil {
+		w = new(sloWindow)
+		p.methods[method] = w
+	}
+	b := &w.buckets[index%sloBuckets]
+	if b.index != index {
+		*b = sloBucket{index: index}
+	}
+	b.calls++
+	if err != nil {
+		b.errors++
+	}
+	alert := BurnAlert{Method: method}
+	for _, b := range w.buckets {
+		if b.index > index-sloBuckets {
+			alert.Calls += b.calls
+			alert.Errors += b.errors
+		}
+	}
+	alert.BurnRate = p.burnRate(alert.Calls, alert.Errors)
+	alert.Burning = p.burns(alert)
+	changed := alert.Burning != w.burning
+	w.burning = alert.Burning
+	p.mu.Unlock()
+
+	if changed && p.OnBurn != nil {
+		p.OnBurn(alert)
+	}
+}
+
+func (p *SLOPolicy) burnRate(calls, errors int64) float64 {
+	if calls == 0 {
+		return 0
+	}
+	budget := 1 - p.Objective
+	if budget <= 0 {
+		budget = 1e-9 // no budget, any error burns
+	}
+	return float64(errors) / float64(calls) / budget
+}
+
+func (p *SLOPolicy) burns(alert BurnAlert) bool {
+	threshold, min := p.BurnRate, p.MinCalls
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if min <= 0 {
+		min = 1
+	}
+	return alert.Calls >= min && alert.Errors > 0 && alert.BurnRate >= threshold
+}
+
+// Burning returns the sorted names of the methods burning their error budget
+// as of their last call.
+func (p *SLOPolicy) Burning() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var methods []string
+	for method, w := range p.methods {
+		if w.burning {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,128 @@
+package rpcserver
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SlowCallPolicy detects calls taking longer than a threshold. Slow calls are
+// counted in MethodStats.SlowCalls and logged, with their args for a sample of
+// them.
+type SlowCallPolicy struct {
+	// Threshold applies to the methods missing from Methods, no threshold
+	// when zero.
+	Threshold time.Duration
+
+	// Methods sets the thresholds of single methods.
+	Methods map[string]time.Duration
+
+	// SampleEvery logs the args of one slow call out of SampleEvery, of
+	// every slow call when 0 or 1.
+	SampleEvery int
+
+	// Redact returns the args as logged. When nil, Redact is RedactArgs.
+	Redact func(args interface{}) interface{}
+
+	// Log logs the slow calls, possibly concurrently. When nil, they're
+	// logged with the log package.
+	Log func(call SlowCall)
+
+	sampled int64 // slow calls seen, atomic
+}
+
+// SlowCall describes a call which took longer than its threshold.
+type SlowCall struct {
+	Method   string
+	Duration time.Duration
+
+	// Args are the redacted args of the call, nil when the call isn't part
+	// of the sample.
+	Args interface{}
+
+	Err error
+}
+
+// threshold returns the threshold of the method, 0 for none.
+func (p *SlowCallPolicy) threshold(method string) time.Duration {
+	if d, ok := p.Methods[method]; ok {
+		return d
+	}
+	return p.Threshold
+}
+
+// observe logs the call if it is slow and tells if it was.
+func (p *SlowCallPolicy) observe(call *Call, elapsed time.Duration, err error) bool {
+	threshold := p.threshold(call.Method)
+	if threshold <= 0 || elapsed < threshold {
+		return false
+	}
+	slow := SlowCall{Method: call.Method, Duration: elapsed, Err: err}
+	if n := atomic.AddInt64(&p.sampled, 1); p.SampleEvery <= 1 || n%int64(p.SampleEvery) == 1 {
+		redact := p.Redact
+		if redact == nil {
+			redact = RedactArgs
+		}
+		slow.Args = redact(call.Args)
+	}
+	if p.Log != nil {
+		p.Log(slow)
+	} else if slow.Args != nil {
+		args, _ := json.Marshal(slow.Args)
+		log.Printf("rpc: slow call %s took %v, args %s", slow.Method, slow.Duration, args)
+	} else {
+		log.Printf("rpc: slow call %s took %v", slow.Method, slow.Duration)
+	}
+	return true
+}
+
+// RedactedValue replaces the values of secret members redacted by RedactArgs.
+const RedactedValue = "[REDACTED]"
+
+// secretNames are the parts of member names RedactArgs redacts.
+var secretNames = []string{"password", "passwd", "secret", "token", "authorization", "apikey", "api_key", "credential"}
+
+// RedactArgs returns the JSON form of args, as a generic value, with the
+// members whose names contain password, secret, token and such replaced by
+// RedactedValue, at any depth.
+func RedactArgs(args interface{}) interface{} {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+	return redact(generic)
+}
+
+func redact(x interface{}) interface{} {
+	switch v := x.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if secretName(key) {
+				v[key] = RedactedValue
+			} else {
+				v[key] = redact(value)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return x
+}
+
+func secretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range secretNames {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package rpcserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrBodyTooSlow is the error answering the requests whose body is sent
+// slower than Limits.MinBodyRate, with 408.
+var ErrBodyTooSlow = errors.New("rpc: request body sent too slowly")
+
+// defaultBodyGracePeriod is the grace period of the minimum body rate when
+// Limits.BodyGracePeriod is zero.
+const defaultBodyGracePeriod = 5 * time.Second
+
+// bodyGracePeriod returns the time request bodies are read before their rate
+// is enforced.
+func (l *limits) bodyGracePeriod() time.Duration {
+	if l.BodyGracePeriod > 0 {
+		return l.BodyGracePeriod
+	}
+	return defaultBodyGracePeriod
+}
+
+// rateReader fails the reads of a body sent slower than a rate in bytes per
+// second once a grace period elapsed, and remembers it did. It extends the
+// read deadline of the connection as bytes arrive, so a client trickling the
+// body is cut off while the server waits for it rather than after.
+type rateReader struct {
+	io.ReadCloser
+	rate     int64
+	deadline time.Time // before which the rate is not enforced
+	read     int64
+	clock    Clock
+	rc       *http.ResponseController // nil when the clock is not the system one
+	err      error
+}
+
+func newRateReader(w http.ResponseWriter, body io.ReadCloser, rate int64, grace time.Duration, clock Clock) *rateReader {
+	b := &rateReader{ReadCloser: body, rate: rate, deadline: clock.Now().Add(grace), clock: clock}
+	if clock == SystemClock {
+		b.rc = http.NewResponseController(w)
+	}
+	return b
+}
+
+// due returns the time by which n bytes must have been received.
+func (b *rateReader) due(n int64) time.Time {
+	return b.deadline.Add(time.Duration(n) * time.Second / time.Duration(b.rate))
+}
+
+func (b *rateReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.rc != nil {
+		// Writers without deadlines, such as in tests, only get the checks
+		// between reads.
+		b.rc.SetReadDeadline(b.due(b.read + 1))
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if errors.Is(err, os.ErrDeadlineExceeded) || (err == nil && b.clock.Now().After(b.due(b.read))) {
+		b.err = ErrBodyTooSlow
+		return n, b.err
+	}
+	if err == io.EOF && b.rc != nil {
+		// The handler may write for longer than it took to read.
+		b.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
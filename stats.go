@@ -0,0 +1,234 @@
+package rpcserver
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds counters of the calls served by a server.
+type Stats struct {
+	// Requests counts the HTTP requests served.
+	Requests int64
+
+	// Errors counts the error responses by HTTP status, from transport-level
+	// errors to failed calls.
+	Errors map[int]int64
+
+	// Codecs counts the requests handled by each codec, by Content-Type.
+	Codecs map[string]int64
+
+	// CancelledCalls counts the calls abandoned because their request
+	// context was done before the method returned, typically when the client
+	// disconnected.
+	CancelledCalls int64
+
+	// DeadlineExceededCalls counts the calls abandoned because their deadline
+	// passed, see ErrDeadlineExceeded.
+	DeadlineExceededCalls int64
+
+	// Methods holds the counters of the called methods, one entry per method
+	// and set of metric labels, sorted by method then labels.
+	Methods []MethodStats
+}
+
+// MethodStats holds the counters of the calls of a method carrying the same
+// metric labels.
+type MethodStats struct {
+	Method string
+
+	// Labels are the metric labels set on the calls with SetMetricLabel, nil
+	// for calls without labels.
+	Labels map[string]string
+
+	// Calls counts the calls which reached the middleware, Errors the ones
+	// that failed.
+	Calls  int64
+	Errors int64
+
+	// SlowCalls counts the calls detected by the Server.SlowCalls policy.
+	SlowCalls int64
+
+	// Latency is the total time spent serving the calls, from the start of
+	// the request to the end of the middleware.
+	Latency time.Duration
+}
+
+// OtherLabelValue replaces the values of a metric label past the
+// Server.MaxLabelValues first distinct ones.
+const OtherLabelValue = "other"
+
+// defaultMaxLabelValues is the number of distinct values kept per metric label
+// when Server.MaxLabelValues is zero.
+const defaultMaxLabelValues = 100
+
+// maxCallLabels is the number of metric labels a single call may set.
+const maxCallLabels = 8
+
+// stats holds the live counters of a server.
+type stats struct {
+	requests         int64 // atomic
+	cancelled        int64 // atomic
+	deadlineExceeded int64 // atomic
+
+	mu      sync.Mutex // guards the maps
+	errors  map[int]int64
+	codecs  map[string]int64
+	methods map[string]*MethodStats    // by method and labels
+	values  map[string]map[string]bool // distinct values by label
+}
+
+// countError counts an error response with the HTTP status.
+func (st *stats) countError(status int) {
+	st.mu.Lock()
+	if st.errors == nil {
+		st.errors = make(map[int]int64)
+	}
+	st.errors[status]++
+	st.mu.Unlock()
+}
+
+// countCodec counts a request handled by the codec of the Content-Type.
+func (st *stats) countCodec(contentType string) {
+	st.mu.Lock()
+	if st.codecs == nil {
+		st.codecs = make(map[string]int64)
+	}
+	st.codecs[contentType]++
+	st.mu.Unlock()
+}
+
+// SetMetricLabel attaches a metric label, e.g. the tenant or the plan, to the
+// current call so its counters are kept apart from calls with other values.
+// The first value set for a key is kept; calls set at most 8 labels. It
+// returns false when the label isn't set, or ctx belongs to no call.
+//
+// Each server keeps Server.MaxLabelValues distinct values per label key, later
+// values are counted as OtherLabelValue.
+func SetMetricLabel(ctx context.Context, key, value string) bool {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return false
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if _, ok := info.labels[key]; ok || len(info.labels) >= maxCallLabels {
+		return false
+	}
+	if info.labels == nil {
+		info.labels = make(map[string]string)
+	}
+	info.labels[key] = value
+	return true
+}
+
+// record counts a call of the method.
+func (s *Server) record(method string, info *callInfo, elapsed time.Duration, err error, slow bool) {
+	var labels map[string]string
+	info.mu.Lock()
+	if len(info.labels) > 0 {
+		labels = make(map[string]string, len(info.labels))
+		for key, value := range info.labels {
+			labels[key] = value
+		}
+	}
+	info.mu.Unlock()
+
+	st := s.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	limit := s.MaxLabelValues
+	if limit <= 0 {
+		limit = defaultMaxLabelValues
+	}
+	keys := make([]string, 0, len(labels))
+	for key, value := range labels {
+		if st.values == nil {
+			st.values = make(map[string]map[string]bool)
+		}
+		seen := st.values[key]
+		if seen == nil {
+			seen = make(map[string]bool)
+			st.values[key] = seen
+		}
+		if !seen[value] {
+			if len(seen) < limit {
+				seen[value] = true
+			} else {
+				labels[key] = OtherLabelValue
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	id := method
+	for _, key := range keys {
+		id += "\x00" + key + "=" + labels[key]
+	}
+
+	m := st.methods[id]
+	if m == nil {
+		if st.methods == nil {
+			st.methods = make(map[string]*MethodStats)
+		}
+		m = &MethodStats{Method: method, Labels: labels}
+		st.methods[id] = m
+	}
+	m.Calls++
+	if err != nil {
+		m.Errors++
+	}
+	if slow {
+		m.SlowCalls++
+	}
+	m.Latency += elapsed
+}
+
+// Stats returns a snapshot of the counters of the server.
+func (s *Server) Stats() Stats {
+	st := s.stats
+	snapshot := Stats{
+		Requests:              atomic.LoadInt64(&st.requests),
+		Errors:                make(map[int]int64),
+		Codecs:                make(map[string]int64),
+		CancelledCalls:        atomic.LoadInt64(&st.cancelled),
+		DeadlineExceededCalls: atomic.LoadInt64(&st.deadlineExceeded),
+	}
+	st.mu.Lock()
+	for status, n := range st.errors {
+		snapshot.Errors[status] = n
+	}
+	for contentType, n := range st.codecs {
+		snapshot.Codecs[contentType] = n
+	}
+	ids := make([]string, 0, len(st.methods))
+	for id := range st.methods {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		m := *st.methods[id]
+		if m.Labels != nil {
+			labels := make(map[string]string, len(m.Labels))
+			for key, value := range m.Labels {
+				labels[key] = value
+			}
+			m.Labels = labels
+		}
+		snapshot.Methods = append(snapshot.Methods, m)
+	}
+	st.mu.Unlock()
+	return snapshot
+}
+
+// PublishExpvar publishes the Stats of the server as the expvar variable name,
+// served by expvar.Handler as JSON. Like expvar.Publish, it panics if the name
+// is already published.
+func (s *Server) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.Stats()
+	}))
+}
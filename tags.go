@@ -0,0 +1,100 @@
+package rpcserver
+
+import (
+	"strings"
+)
+
+// FieldTag holds the options of the rpc tag of an args or reply struct field:
+//
+//	type Args struct {
+//		A int `rpc:"desc=Dividend,example=10"`
+//		B int `rpc:"desc='Divisor, not zero',example=3,required"`
+//	}
+//
+// Options are separated by commas, values holding commas are quoted with
+// single quotes.
+type FieldTag struct {
+	// Description documents the field, desc=<text>.
+	Description string
+
+	// Example is a sample value of the field as JSON, or as text for
+	// strings, example=<value>.
+	Example string
+
+	// Required fields must be present in the params, required. A zero
+	// value is present, a null one is not. See ParamsRules.
+	Required bool
+
+	// Variadic marks the last field of the args of variadic methods, see
+	// positionalArgs.
+	Variadic bool
+
+	// Affinity fields make the affinity key of the calls, affinity. See
+	// AffinityOf.
+	Affinity bool
+
+	// Region fields hold the home region of the data of the calls, region.
+	// See ResidencyPolicy.
+	Region bool
+
+	// Roles restricts the field of replies to the callers having one of the
+	// roles separated by bars, role=<role>|<role>. See WithRoles.
+	Roles string
+}
+
+// ParseFieldTag parses the value of an rpc tag, unknown options are ignored.
+func ParseFieldTag(tag string) FieldTag {
+	var t FieldTag
+	for tag != "" {
+		var option string
+		option, tag = nextOption(tag)
+		key, value := option, ""
+		if idx := strings.Index(option, "="); idx != -1 {
+			key, value = option[:idx], option[idx+1:]
+		}
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		switch strings.TrimSpace(key) {
+		case "desc":
+			t.Description = value
+		case "example":
+			t.Example = value
+		case "required":
+			t.Required = true
+		case "variadic":
+			t.Variadic = true
+		case "affinity":
+			t.Affinity = true
+		case "region":
+			t.Region = true
+		case "role":
+			t.Roles = value
+		}
+	}
+	return t
+}
+
+// nextOption splits the first option of a tag from the rest, skipping the
+// commas between single quotes.
+func nextOption(tag string) (string, string) {
+	quoted := false
+	for i := 0; i < len(tag); i++ {
+		switch tag[i] {
+		case '\'':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return tag[:i], tag[i+1:]
+			}
+		}
+	}
+	return tag, ""
+}
+
+// Describer is implemented by service receivers documenting their methods.
+// Describe returns the description of the methods by name and of the service
+// under the empty name.
+type Describer interface {
+	Describe() map[string]string
+}
@@ -0,0 +1,157 @@
+package rpcserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Trace identifies a call within a distributed trace. The server continues the
+// trace of requests carrying a W3C traceparent or a B3 header, so it takes
+// part in traces without a tracing SDK; requests without one have no Trace.
+type Trace struct {
+	// TraceID is the 32 lower case hex digits identifying the trace.
+	TraceID string
+
+	// SpanID is the 16 lower case hex digits identifying the call.
+	SpanID string
+
+	// ParentSpanID identifies the span of the caller, it is empty for the
+	// root span.
+	ParentSpanID string
+
+	// Sampled tells if the caller records the trace.
+	Sampled bool
+}
+
+// ParseTrace reads the trace context of a request from its headers, preferring
+// the W3C traceparent to the B3 headers.
+func ParseTrace(h http.Header) (Trace, bool) {
+	if t, ok := parseTraceparent(h.Get(TraceparentHeader)); ok {
+		return t, true
+	}
+	if t, ok := parseB3(h.Get(B3Header)); ok {
+		return t, true
+	}
+	t := Trace{
+		TraceID:      padTraceID(strings.ToLower(h.Get("X-B3-TraceId"))),
+		SpanID:       strings.ToLower(h.Get("X-B3-SpanId")),
+		ParentSpanID: strings.ToLower(h.Get("X-B3-ParentSpanId")),
+		Sampled:      h.Get("X-B3-Sampled") == "1" || h.Get("X-B3-Sampled") == "true" || h.Get("X-B3-Flags") == "1",
+	}
+	if !validID(t.TraceID, 32) || !validID(t.SpanID, 16) || (t.ParentSpanID != "" && !validID(t.ParentSpanID, 16)) {
+		return Trace{}, false
+	}
+	return t, true
+}
+
+// parseTraceparent reads a W3C traceparent header. Versions after 00 are read
+// as 00, as the specification requires.
+func parseTraceparent(value string) (Trace, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return Trace{}, false
+	}
+	if !validID(parts[1], 32) || !validID(parts[2], 16) || len(parts[3]) != 2 {
+		return Trace{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return Trace{}, false
+	}
+	return Trace{TraceID: parts[1], SpanID: parts[2], Sampled: flags[0]&1 == 1}, true
+}
+
+// parseB3 reads a single B3 header. A header carrying the sampling decision
+// alone is no trace context.
+func parseB3(value string) (Trace, bool) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(value)), "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return Trace{}, false
+	}
+	t := Trace{TraceID: padTraceID(parts[0]), SpanID: parts[1]}
+	if len(parts) > 2 {
+		t.Sampled = parts[2] == "1" || parts[2] == "d"
+	}
+	if len(parts) > 3 {
+		t.ParentSpanID = parts[3]
+		if !validID(t.ParentSpanID, 16) {
+			return Trace{}, false
+		}
+	}
+	if !validID(t.TraceID, 32) || !validID(t.SpanID, 16) {
+		return Trace{}, false
+	}
+	return t, true
+}
+
+// padTraceID widens the 64-bit trace ids B3 allows to 128 bits.
+func padTraceID(id string) string {
+	if len(id) == 16 {
+		return "0000000000000000" + id
+	}
+	return id
+}
+
+// validID tells if id is made of n lower case hex digits, not all zero.
+func validID(id string, n int) bool {
+	if len(id) != n || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Child returns a new span of the trace with t as its parent.
+func (t Trace) Child() Trace {
+	return Trace{
+		TraceID:      t.TraceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: t.SpanID,
+		Sampled:      t.Sampled,
+	}
+}
+
+// Inject sets the traceparent and the b3 headers of a request made by the span.
+func (t Trace) Inject(h http.Header) {
+	flags, sampled := "00", "0"
+	if t.Sampled {
+		flags, sampled = "01", "1"
+	}
+	h.Set(TraceparentHeader, "00-"+t.TraceID+"-"+t.SpanID+"-"+flags)
+	b3 := t.TraceID + "-" + t.SpanID + "-" + sampled
+	if t.ParentSpanID != "" {
+		b3 += "-" + t.ParentSpanID
+	}
+	h.Set(B3Header, b3)
+}
+
+// newSpanID returns a random span id.
+func newSpanID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	if id[0] == 0 {
+		id[0] = 1 // never all zero
+	}
+	return hex.EncodeToString(id)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx carrying the trace.
+func WithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFromContext returns the trace of the call, set by the server when the
+// request carries a trace context.
+func TraceFromContext(ctx context.Context) (Trace, bool) {
+	t, ok := ctx.Value(traceKey{}).(Trace)
+	return t, ok
+}
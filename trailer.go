@@ -0,0 +1,105 @@
+package rpcserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// SetTrailer sets a response trailer of the current call, for the metadata
+// known once the work is done such as a row count. Trailers are sent after
+// the body to the clients accepting them with a "TE: trailers" request
+// header, e.g. over HTTP/2, and as headers of the response otherwise, as the
+// response is written once the method returns. It returns false when ctx
+// belongs to no call.
+func SetTrailer(ctx context.Context, key, value string) bool {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return false
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.trailers == nil {
+		info.trailers = make(http.Header)
+	}
+	info.trailers.Set(key, value)
+	return true
+}
+
+// acceptsTrailers tells if the client sent "TE: trailers".
+func acceptsTrailers(r *http.Request) bool {
+	for _, value := range r.Header.Values("TE") {
+		for _, coding := range strings.Split(value, ",") {
+			if idx := strings.Index(coding, ";"); idx != -1 {
+				coding = coding[:idx]
+			}
+			if strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeResponse writes the response of the call with write, along with the
+// trailers set by the call. The trailers sent after the body are declared
+// before it, and the body is sent without Content-Length so HTTP/1.1 chunks
+// it, the trailers being dropped otherwise.
+func (info *callInfo) writeResponse(w http.ResponseWriter, r *http.Request, write func(w http.ResponseWriter)) {
+	info.mu.Lock()
+	trailers := info.trailers
+	info.trailers = nil
+	info.mu.Unlock()
+	if len(trailers) == 0 {
+		write(w)
+		return
+	}
+	if !acceptsTrailers(r) {
+		for key, values := range trailers {
+			w.Header()[key] = values
+		}
+		write(w)
+		return
+	}
+	for key := range trailers {
+		w.Header().Add("Trailer", key)
+	}
+	write(&trailerWriter{ResponseWriter: w})
+	for key, values := range trailers {
+		w.Header()[key] = values
+	}
+}
+
+// trailerWriter drops the Content-Length of the response, followed by
+// trailers.
+type trailerWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *trailerWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trailerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response if the ResponseWriter supports it.
+func (w *trailerWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the ResponseWriter, for http.ResponseController.
+func (w *trailerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,227 @@
+// Package tsgen generates TypeScript declarations and a fetch-based client for
+// an rpcserver.RpcService, so frontends get types matching the Go structs.
+package tsgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/datalinkE/rpcserver"
+	"github.com/datalinkE/rpcserver/decimal"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	typeOfTime       = reflect.TypeOf(time.Time{})
+	typeOfDecimal    = reflect.TypeOf(decimal.Decimal{})
+	typeOfRawMessage = reflect.TypeOf(json.RawMessage{})
+	typeOfMarshaler  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// Generate writes TypeScript interfaces for the args and reply types of every
+// method of the service, followed by a client class calling the methods over
+// JSON-RPC 2.0 with fetch.
+func Generate(w io.Writer, service *rpcserver.RpcService) error {
+	g := &generator{names: make(map[reflect.Type]string), used: make(map[string]bool)}
+
+	var methods bytes.Buffer
+	for _, name := range service.MethodNames() {
+		m, err := service.Get(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&methods, "\n  %s(args: %s): Promise<%s> {\n", name, g.typeName(m.ArgsType()), g.typeName(m.ReplyType()))
+		fmt.Fprintf(&methods, "    return this.call(%q, args);\n  }\n", name)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by tsgen; DO NOT EDIT.\n")
+	out.Write(g.decls.Bytes())
+	fmt.Fprintf(&out, clientHeader, service.Name())
+	out.Write(methods.Bytes())
+	fmt.Fprintf(&out, "}\n")
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+const clientHeader = `
+export class RpcError extends Error {
+  constructor(public code: number, message: string, public data?: unknown) {
+    super(message);
+  }
+}
+
+export class %sClient {
+  private id = 0;
+
+  constructor(private url: string, private fetchFn: typeof fetch = fetch) {
+    this.url = url.replace(/\/+$/, "");
+  }
+
+  private async call<T>(method: string, params: unknown): Promise<T> {
+    const resp = await this.fetchFn(this.url + "/" + method, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ jsonrpc: "2.0", method, params, id: ++this.id }),
+    });
+    const body = await resp.json().catch(() => null);
+    if (!body || body.jsonrpc !== "2.0") {
+      throw new RpcError(resp.status, resp.statusText);
+    }
+    if (body.error) {
+      throw new RpcError(body.error.code, body.error.message, body.error.data);
+    }
+    return body.result as T;
+  }
+`
+
+// generator accumulates declarations of the named struct types it meets.
+type generator struct {
+	decls bytes.Buffer
+	names map[reflect.Type]string
+	used  map[string]bool
+}
+
+// typeName returns the TypeScript type expression for t, declaring interfaces
+// for the named struct types.
+func (g *generator) typeName(t reflect.Type) string {
+	if t == nil {
+		return "null"
+	}
+	if t.Implements(typeOfMarshaler) || reflect.PtrTo(t).Implements(typeOfMarshaler) {
+		if t == typeOfTime || t == typeOfDecimal {
+			return "string"
+		}
+		return "unknown"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Ptr:
+		return g.typeName(t.Elem()) + " | null"
+	case reflect.Slice, reflect.Array:
+		if t == typeOfRawMessage {
+			return "unknown"
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string" // base64 encoded by encoding/json
+		}
+		return "Array<" + g.typeName(t.Elem()) + ">"
+	case reflect.Map:
+		return "Record<string, " + g.typeName(t.Elem()) + ">"
+	case reflect.Struct:
+		return g.structName(t)
+	}
+	return "unknown"
+}
+
+// structName declares an interface for a struct type once and returns its name.
+// Anonymous structs are declared inline.
+func (g *generator) structName(t reflect.Type) string {
+	if t.Name() == "" {
+		var body bytes.Buffer
+		g.fields(&body, t, " ")
+		return "{" + body.String() + " }"
+	}
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	for i := 2; g.used[name]; i++ {
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+	g.names[t] = name
+	g.used[name] = true
+
+	var body bytes.Buffer
+	g.fields(&body, t, "\n  ")
+	fmt.Fprintf(&g.decls, "\nexport interface %s {%s\n}\n", name, body.String())
+	return name
+}
+
+// fields writes the members of a struct following the encoding/json rules:
+// json tags rename and hide fields, embedded structs are flattened.
+func (g *generator) fields(w *bytes.Buffer, t reflect.Type, sep string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx != -1 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		ft := field.Type
+		if field.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(w, ft, sep)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		optional := ""
+		if strings.Contains(opts, "omitempty") {
+			optional = "?"
+		}
+		tsType := g.typeName(field.Type)
+		if strings.Contains(opts, "string") {
+			tsType = "string"
+		} else if enum := rpcserver.EnumOf(field); enum != nil {
+			tsType = enumType(field.Type, enum)
+		}
+		fmt.Fprintf(w, "%s%s%s: %s;", sep, quoteName(name), optional, tsType)
+	}
+}
+
+// enumType returns the union of the enum values of a field of type t, or an
+// array of them for slices.
+func enumType(t reflect.Type, enum []interface{}) string {
+	literals := make([]string, len(enum))
+	for i, v := range enum {
+		data, _ := json.Marshal(v)
+		literals[i] = string(data)
+	}
+	union := strings.Join(literals, " | ")
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		union = "Array<" + union + ">"
+	}
+	if nullable {
+		union += " | null"
+	}
+	return union
+}
+
+// quoteName quotes member names that are not valid identifiers.
+func quoteName(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Sprintf("%q", name)
+		}
+	}
+	return name
+}
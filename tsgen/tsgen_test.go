@@ -0,0 +1,59 @@
+package tsgen
+
+import (
+	"bytes"
+	"github.com/datalinkE/rpcserver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Base struct {
+	ID int64 `json:"id"`
+}
+
+type Args struct {
+	Base
+	A, B    int
+	Comment string    `json:"comment,omitempty"`
+	At      time.Time `json:"at"`
+	Tags    []string
+	Sort    string   `json:"sort" enum:"asc,desc"`
+	Scopes  []string `json:"scopes" enum:"read,write"`
+	Secret  string   `json:"-"`
+}
+
+type Quotient struct {
+	Quo, Rem int
+	Next     *Quotient
+}
+
+type Arith int
+
+func (t *Arith) Divide(r *http.Request, args *Args, quo *Quotient) error {
+	return nil
+}
+
+func TestGenerate(t *testing.T) {
+	service, err := rpcserver.NewRpcService(new(Arith))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Generate(&buf, service); err != nil {
+		t.Fatal(err)
+	}
+
+	code := buf.String()
+	for _, expected := range []string{
+		"export interface Args {\n  id: number;\n  A: number;\n  B: number;\n  comment?: string;\n  at: string;\n  Tags: Array<string>;\n  sort: \"asc\" | \"desc\";\n  scopes: Array<\"read\" | \"write\">;\n}",
+		"export interface Quotient {\n  Quo: number;\n  Rem: number;\n  Next: Quotient | null;\n}",
+		"export class ArithClient {",
+		"Divide(args: Args): Promise<Quotient> {",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("generated code misses %q:\n%s", expected, code)
+		}
+	}
+}
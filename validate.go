@@ -0,0 +1,79 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+)
+
+// Validator checks the decoded args of a method as a whole, for the rules
+// spanning several fields such as "from <= to" or "either email or phone".
+// args points to the decoded args. Validators list the invalid fields with a
+// ParamsError, any other error is reported as invalid params too:
+//
+//	server.RegisterValidator("Report", func(ctx context.Context, args interface{}) error {
+//		a := args.(*ReportArgs)
+//		var errs rpcserver.ParamsError
+//		if a.From.After(a.To) {
+//			errs.Add("from", "must not be after to")
+//		}
+//		if a.Email == "" && a.Phone == "" {
+//			errs.Add("email", "or phone is required")
+//		}
+//		return errs.Err()
+//	})
+type Validator func(ctx context.Context, args interface{}) error
+
+// RegisterValidator adds a validator of the args of the method. The
+// validators run in order after the args are decoded and sanitized, before
+// the middleware, and all their errors are answered at once.
+//
+// Validators may be registered while the server is handling requests.
+func (s *Server) RegisterValidator(method string, v Validator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := *s.current()
+	if _, err := reg.method(method); err != nil {
+		return err
+	}
+	validators := make(map[string][]Validator, len(reg.validators)+1)
+	for key, list := range reg.validators {
+		validators[key] = list
+	}
+	list := validators[method]
+	validators[method] = append(list[:len(list):len(list)], v)
+	reg.validators = validators
+	s.registry.Store(&reg)
+	return nil
+}
+
+// Add adds an invalid field to e.
+func (e *ParamsError) Add(field, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: message})
+}
+
+// Err returns e, or nil if it lists no field.
+func (e *ParamsError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validate runs the validators of the method on the args and returns all
+// their errors as a ParamsError, nil if none. The errors other than
+// ParamsErrors are listed without a field.
+func (reg *registry) validate(ctx context.Context, method string, args interface{}) error {
+	var all ParamsError
+	for _, v := range reg.validators[method] {
+		err := v(ctx, args)
+		var invalid *ParamsError
+		switch {
+		case err == nil:
+		case errors.As(err, &invalid):
+			all.Errors = append(all.Errors, invalid.Errors...)
+		default:
+			all.Add("", err.Error())
+		}
+	}
+	return all.Err()
+}
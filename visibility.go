@@ -0,0 +1,219 @@
+package rpcserver
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+type rolesKey struct{}
+
+// WithRoles returns a copy of ctx carrying the roles of the caller, e.g. set
+// by a middleware from the claims of its token. The fields of the replies
+// tagged with roles, rpc:"role=admin" or rpc:"role=admin|support", are only
+// sent to the callers having one of them, they are zero for the others:
+//
+//	type Account struct {
+//		ID       string
+//		Balance  int    `json:",omitempty" rpc:"role=admin|owner"`
+//		Internal string `json:",omitempty" rpc:"role=admin"`
+//	}
+//
+// Tagging them omitempty leaves them out of the JSON replies. The fields are
+// zeroed on copies of the replies, the values returned by the methods are
+// left as is. The values of interface types are not inspected.
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, roles)
+}
+
+// RolesFromContext returns the roles of the caller, see WithRoles.
+func RolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey{}).([]string)
+	return roles
+}
+
+// visibilityPlan holds the fields restricted to roles within a type. The plans
+// of recursive types refer to themselves.
+type visibilityPlan struct {
+	fields     []visibilityField // of structs
+	elem       *visibilityPlan   // of pointers, slices, arrays and maps
+	restricted bool              // whether the values of the type may hold restricted fields
+}
+
+type visibilityField struct {
+	index    int
+	exported bool            // unexported fields are embedded structs
+	roles    []string        // nil if the field is not restricted
+	nested   *visibilityPlan // plan of the value of the field
+}
+
+var visibilityPlans sync.Map // reflect.Type -> *visibilityPlan
+
+func visibilityPlanOf(t reflect.Type) *visibilityPlan {
+	if plan, ok := visibilityPlans.Load(t); ok {
+		return plan.(*visibilityPlan)
+	}
+	plans := make(map[reflect.Type]*visibilityPlan)
+	buildVisibilityPlan(t, plans)
+	// Tell the plans which may hold restricted fields, the ones of recursive
+	// types depending on each other, then keep the fields to shape.
+	for changed := true; changed; {
+		changed = false
+		for _, plan := range plans {
+			if plan != nil && !plan.restricted && plan.restricts() {
+				plan.restricted, changed = true, true
+			}
+		}
+	}
+	for _, plan := range plans {
+		if plan == nil {
+			continue
+		}
+		fields := plan.fields[:0]
+		for _, vf := range plan.fields {
+			if vf.roles != nil || vf.nested != nil && vf.nested.restricted {
+				fields = append(fields, vf)
+			}
+		}
+		plan.fields = fields
+	}
+	for typ, plan := range plans {
+		visibilityPlans.LoadOrStore(typ, plan)
+	}
+	plan, _ := visibilityPlans.Load(t)
+	return plan.(*visibilityPlan)
+}
+
+// buildVisibilityPlan returns the plan of t, adding it and the plans of the
+// types it holds to plans before filling them, so recursive references share
+// them. It returns nil for the types holding no struct.
+func buildVisibilityPlan(t reflect.Type, plans map[reflect.Type]*visibilityPlan) *visibilityPlan {
+	if plan, ok := plans[t]; ok {
+		return plan
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		plan := new(visibilityPlan)
+		plans[t] = plan
+		plan.elem = buildVisibilityPlan(t.Elem(), plans)
+		return plan
+	case reflect.Struct:
+		plan := new(visibilityPlan)
+		plans[t] = plan
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			// The fields of embedded structs are promoted by encoding/json,
+			// the structs being exported or not.
+			if field.PkgPath != "" && !field.Anonymous {
+				continue
+			}
+			vf := visibilityField{index: i, exported: field.PkgPath == "", nested: buildVisibilityPlan(field.Type, plans)}
+			if roles := ParseFieldTag(field.Tag.Get("rpc")).Roles; roles != "" {
+				vf.roles = strings.Split(roles, "|")
+			}
+			plan.fields = append(plan.fields, vf)
+		}
+		return plan
+	}
+	plans[t] = nil
+	return nil
+}
+
+// restricts tells whether the plan has restricted fields or nested plans that
+// may hold some.
+func (plan *visibilityPlan) restricts() bool {
+	if plan.elem != nil && plan.elem.restricted {
+		return true
+	}
+	for _, vf := range plan.fields {
+		if vf.roles != nil || vf.nested != nil && vf.nested.restricted {
+			return true
+		}
+	}
+	return false
+}
+
+// shapeReply returns the reply with the fields restricted to roles the caller
+// of ctx lacks zeroed, see WithRoles.
+func shapeReply(ctx context.Context, reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	v := reflect.ValueOf(reply)
+	plan := visibilityPlanOf(v.Type())
+	if plan == nil || !plan.restricted {
+		return reply
+	}
+	return plan.shape(v, RolesFromContext(ctx)).Interface()
+}
+
+// shape returns a copy of v with the restricted fields zeroed.
+func (plan *visibilityPlan) shape(v reflect.Value, roles []string) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		shaped := reflect.New(v.Type().Elem())
+		shaped.Elem().Set(plan.elem.shape(v.Elem(), roles))
+		return shaped
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		shaped := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			shaped.Index(i).Set(plan.elem.shape(v.Index(i), roles))
+		}
+		return shaped
+	case reflect.Array:
+		shaped := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			shaped.Index(i).Set(plan.elem.shape(v.Index(i), roles))
+		}
+		return shaped
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		shaped := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			shaped.SetMapIndex(iter.Key(), plan.elem.shape(iter.Value(), roles))
+		}
+		return shaped
+	case reflect.Struct:
+		shaped := reflect.New(v.Type()).Elem()
+		shaped.Set(v)
+		for _, vf := range plan.fields {
+			field := shaped.Field(vf.index)
+			if !vf.exported {
+				// Embedded structs are set through their address, shaped
+				// being a copy.
+				field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+			}
+			switch {
+			case vf.roles != nil && !hasRole(roles, vf.roles):
+				field.Set(reflect.Zero(field.Type()))
+			case vf.nested != nil:
+				field.Set(vf.nested.shape(field, roles))
+			}
+		}
+		return shaped
+	}
+	return v
+}
+
+// hasRole returns true if roles holds one of the allowed roles.
+func hasRole(roles, allowed []string) bool {
+	for _, role := range roles {
+		for _, a := range allowed {
+			if role == a {
+				return true
+			}
+		}
+	}
+	return false
+}